@@ -0,0 +1,148 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/ftl/tetra-pei/tetra"
+	"github.com/spf13/cobra"
+
+	"github.com/ftl/tetra-cli/pkg/cli"
+	"github.com/ftl/tetra-cli/pkg/messaging"
+)
+
+var addressBookCmd = &cobra.Command{
+	Use:   "addressbook",
+	Short: "List the entries of the address book",
+	Long: `List the entries of the address book (see --address-book).
+
+The address book is a YAML or JSON file that gives names to radios (ISSI) and talkgroups (GTSI), e.g.:
+
+  entries:
+    - name: Engine 1
+      issi: "1234567"
+      unit: FF Musterstadt
+      role: engine
+      aliases: [E1]
+    - name: Operations
+      gtsi: "2620001"
+
+The names, aliases, units and roles can be used instead of the number wherever a destination or a talkgroup is
+expected, e.g. "tetra-cli send E1 hello". Talkgroups from the address book are always addressed as group.
+The listen command shows the name next to the number of the sender.`,
+	Run: runAddressBook,
+}
+
+var addressBookImportCmd = &cobra.Command{
+	Use:   "import [<file>|-]",
+	Short: "Import talkgroups into the address book",
+	Long: `Import talkgroups into the address book.
+
+The talkgroups are read from the output of "tetra-cli talkgroups" in the text or CSV format, from the given file or
+from the standard input. Talkgroups whose GTSI is already in the address book are skipped.
+
+The address book is written anew, so comments and the formatting of the file are lost. Example:
+
+  tetra-cli talkgroups --output csv | tetra-cli addressbook import`,
+	Args: cobra.MaximumNArgs(1),
+	Run:  runAddressBookImport,
+}
+
+func init() {
+	addressBookCmd.AddCommand(addressBookImportCmd)
+	rootCmd.AddCommand(addressBookCmd)
+}
+
+func runAddressBook(cmd *cobra.Command, args []string) {
+	addressBook, err := cli.LoadAddressBook()
+	if err != nil {
+		fatal(err)
+	}
+
+	printer := cli.NewListPrinter(addressHeader...)
+	defer printer.Close()
+	for _, entry := range addressBook.Entries {
+		printer.Print(addressRecord{entry})
+	}
+}
+
+func runAddressBookImport(cmd *cobra.Command, args []string) {
+	if cli.DefaultTetraFlags.AddressBook == "" {
+		fatalf("the address book is disabled, use --address-book to define its file")
+	}
+	addressBook, err := cli.LoadAddressBook()
+	if err != nil {
+		fatal(err)
+	}
+
+	var input io.Reader = os.Stdin
+	if len(args) > 0 && args[0] != "-" {
+		file, err := os.Open(args[0])
+		if err != nil {
+			fatalf("cannot open the talkgroups: %v", err)
+		}
+		defer file.Close()
+		input = file
+	}
+
+	added, err := addressBook.ImportTalkgroups(input)
+	if err != nil {
+		fatal(err)
+	}
+	err = addressBook.Save(cli.DefaultTetraFlags.AddressBook)
+	if err != nil {
+		fatal(err)
+	}
+	log.Printf("%d talkgroups added to %s", added, cli.DefaultTetraFlags.AddressBook)
+}
+
+var addressHeader = []string{"name", "issi", "gtsi", "unit", "role", "aliases"}
+
+// addressRecord prints an entry of the address book in all output formats.
+type addressRecord struct {
+	messaging.AddressEntry
+}
+
+func (r addressRecord) String() string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "%s: %s", r.Name, r.Identity())
+	if r.IsGroup() {
+		builder.WriteString(" (group)")
+	}
+	var details []string
+	if r.Unit != "" {
+		details = append(details, "unit: "+r.Unit)
+	}
+	if r.Role != "" {
+		details = append(details, "role: "+r.Role)
+	}
+	if len(r.Aliases) > 0 {
+		details = append(details, "aliases: "+strings.Join(r.Aliases, ", "))
+	}
+	if len(details) > 0 {
+		fmt.Fprintf(&builder, "\n  %s", strings.Join(details, "; "))
+	}
+	builder.WriteString("\n")
+	return builder.String()
+}
+
+func (r addressRecord) CSV() []string {
+	return []string{r.Name, r.ISSI, r.GTSI, r.Unit, r.Role, strings.Join(r.Aliases, " ")}
+}
+
+// resolveDestination resolves the given destination through the address book and validates the result. Talkgroups
+// from the address book are always addressed as group.
+func resolveDestination(addressBook *messaging.AddressBook, destination tetra.Identity, group bool) (tetra.Identity, bool, error) {
+	destination, group, err := addressBook.ResolveDestination(destination, group)
+	if err != nil {
+		return "", false, err
+	}
+	err = messaging.ValidateDestination(destination, group)
+	if err != nil {
+		return "", false, err
+	}
+	return destination, group, nil
+}
@@ -3,6 +3,7 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"strconv"
 
 	"github.com/ftl/tetra-pei/ctrl"
 	"github.com/spf13/cobra"
@@ -35,5 +36,17 @@ func runGetBatteryCharge(ctx context.Context, pei radio.PEI, cmd *cobra.Command,
 		fatal(err)
 	}
 
-	fmt.Printf("%d\n", batteryCharge)
+	cli.NewPrinter("battery_charge").Print(batteryChargeRecord{BatteryCharge: batteryCharge})
+}
+
+type batteryChargeRecord struct {
+	BatteryCharge int `json:"battery_charge"`
+}
+
+func (r batteryChargeRecord) String() string {
+	return fmt.Sprintf("%d\n", r.BatteryCharge)
+}
+
+func (r batteryChargeRecord) CSV() []string {
+	return []string{strconv.Itoa(r.BatteryCharge)}
 }
@@ -0,0 +1,456 @@
+package cmd
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ftl/tetra-pei/tetra"
+	"github.com/spf13/cobra"
+
+	"github.com/ftl/tetra-cli/pkg/cli"
+	"github.com/ftl/tetra-cli/pkg/messaging"
+	"github.com/ftl/tetra-cli/pkg/radio"
+)
+
+const defaultBatchInterval = 1 * time.Second
+
+// sendBatch are the messages to send with --batch, read from the batch file before the radio is opened.
+var sendBatch []batchRow
+
+// batchRow is a message of a batch file.
+type batchRow struct {
+	Row     int
+	Message messaging.TextMessage
+}
+
+// batchEntry is a message of a batch file in JSON format. Fields that are not set take the value of the corresponding
+// flag of the send command.
+type batchEntry struct {
+	Destination string `json:"destination"`
+	Text        string `json:"text"`
+	Immediate   *bool  `json:"immediate"`
+	AckReceive  *bool  `json:"ack_receive"`
+	AckConsume  *bool  `json:"ack_consume"`
+	Simple      *bool  `json:"simple"`
+	Group       *bool  `json:"group"`
+	Encoding    string `json:"encoding"`
+}
+
+var batchColumns = []string{"destination", "text", "immediate", "ack_receive", "ack_consume", "simple", "group", "encoding"}
+
+// readBatchFile reads the messages of a batch file. Files with the extension .json contain an array of JSON objects,
+// all other files are read as CSV with a header line. The given message provides the defaults for all messages.
+func readBatchFile(filename string, defaults messaging.TextMessage, addressBook *messaging.AddressBook) ([]batchRow, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open batch file: %w", err)
+	}
+	defer file.Close()
+
+	var entries []batchEntry
+	if strings.EqualFold(filepath.Ext(filename), ".json") {
+		entries, err = readBatchJSON(file)
+	} else {
+		entries, err = readBatchCSV(file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read batch file %s: %w", filename, err)
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("the batch file %s contains no messages", filename)
+	}
+
+	result := make([]batchRow, 0, len(entries))
+	for i, entry := range entries {
+		message, err := entry.textMessage(defaults, addressBook)
+		if err != nil {
+			return nil, fmt.Errorf("invalid message #%d in batch file %s: %w", i+1, filename, err)
+		}
+		result = append(result, batchRow{Row: i + 1, Message: message})
+	}
+	return result, nil
+}
+
+func readBatchJSON(r io.Reader) ([]batchEntry, error) {
+	var result []batchEntry
+	err := json.NewDecoder(r).Decode(&result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func readBatchCSV(r io.Reader) ([]batchEntry, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	header := records[0]
+	for i, column := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(column))
+		if !isBatchColumn(header[i]) {
+			return nil, fmt.Errorf("unknown column %q, use %s", column, strings.Join(batchColumns, ", "))
+		}
+	}
+
+	result := make([]batchEntry, 0, len(records)-1)
+	for i, record := range records[1:] {
+		var entry batchEntry
+		for j, value := range record {
+			err := entry.set(header[j], value)
+			if err != nil {
+				return nil, fmt.Errorf("message #%d: %w", i+1, err)
+			}
+		}
+		result = append(result, entry)
+	}
+	return result, nil
+}
+
+func isBatchColumn(name string) bool {
+	for _, column := range batchColumns {
+		if name == column {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *batchEntry) set(column string, value string) error {
+	value = strings.TrimSpace(value)
+	switch column {
+	case "destination":
+		e.Destination = value
+		return nil
+	case "text":
+		e.Text = value
+		return nil
+	case "encoding":
+		e.Encoding = value
+		return nil
+	}
+
+	if value == "" {
+		return nil
+	}
+	flag, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("invalid value for %s: %q", column, value)
+	}
+	switch column {
+	case "immediate":
+		e.Immediate = &flag
+	case "ack_receive":
+		e.AckReceive = &flag
+	case "ack_consume":
+		e.AckConsume = &flag
+	case "simple":
+		e.Simple = &flag
+	case "group":
+		e.Group = &flag
+	}
+	return nil
+}
+
+func (e batchEntry) textMessage(defaults messaging.TextMessage, addressBook *messaging.AddressBook) (messaging.TextMessage, error) {
+	result := defaults
+	result.Destination = tetra.Identity(strings.TrimSpace(e.Destination))
+	result.Text = e.Text
+	if result.Text == "" {
+		return result, fmt.Errorf("the text is missing")
+	}
+	if e.Encoding != "" {
+		encoding, err := messaging.ParseEncoding(e.Encoding)
+		if err != nil {
+			return result, err
+		}
+		result.Encoding = encoding
+	}
+	if e.Immediate != nil {
+		result.Immediate = *e.Immediate
+	}
+	if e.AckReceive != nil {
+		result.AckReceive = *e.AckReceive
+	}
+	if e.AckConsume != nil {
+		result.AckConsume = *e.AckConsume
+	}
+	if e.Simple != nil {
+		result.Simple = *e.Simple
+	}
+	if e.Group != nil {
+		result.Group = *e.Group
+	}
+	var err error
+	result.Destination, result.Group, err = resolveDestination(addressBook, result.Destination, result.Group)
+	if err != nil {
+		return result, err
+	}
+	if result.GroupReportsRequested() {
+		warnGroupReports(&result)
+	}
+	if result.Simple && sendFlags.retry {
+		return result, fmt.Errorf("simple text messages cannot be retried, they provide no delivery reports")
+	}
+	err = messaging.ValidateText(result.Text, result.Encoding)
+	if err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// sendBatchFatal handles errors that prevent opening the radio. With --retry, all messages are queued for another attempt.
+func sendBatchFatal(err error) {
+	if !sendFlags.retry {
+		fatal(err)
+	}
+	now := time.Now()
+	for _, row := range sendBatch {
+		_, queueErr := queueSendAttempt(row.Message, now, 0, err)
+		if queueErr != nil {
+			fatal(fmt.Errorf("%w\ncannot queue the message #%d: %w", err, row.Row, queueErr))
+		}
+	}
+	os.Exit(0)
+}
+
+func runSendBatch(ctx context.Context, pei radio.PEI, cmd *cobra.Command, args []string) {
+	initCtx, cancel := context.WithTimeout(ctx, cli.DefaultTetraFlags.CommandTimeout)
+	err := pei.ATs(initCtx,
+		"ATZ",
+		"ATE0",
+		"AT+CSCS=8859-1",
+	)
+	cancel()
+	if err != nil {
+		sendBatchFatal(fmt.Errorf("cannot initialize radio: %v", err))
+		return
+	}
+
+	messageStore, err := cli.OpenMessageStore()
+	if err != nil {
+		fatal(err)
+	}
+	outbox, err := cli.OpenOutbox()
+	if err != nil {
+		fatal(err)
+	}
+	sender, err := messaging.NewSender(pei)
+	if err != nil {
+		sendBatchFatal(fmt.Errorf("cannot initialize radio: %v", err))
+		return
+	}
+	sender.WithStore(messageStore).WithOutbox(outbox)
+
+	results := make([]*batchResult, len(sendBatch))
+	var lastAttempt time.Time
+	for i, row := range sendBatch {
+		if !lastAttempt.IsZero() {
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Until(lastAttempt.Add(sendFlags.batchInterval))):
+			}
+		}
+		if ctx.Err() != nil {
+			results[i] = &batchResult{Row: row.Row, Destination: string(row.Message.Destination), State: batchNotSent, Error: ctx.Err().Error()}
+			continue
+		}
+		lastAttempt = time.Now()
+		results[i] = sendBatchRow(ctx, sender, row)
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, cli.DefaultTetraFlags.CommandTimeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, result := range results {
+		if result.delivery == nil {
+			continue
+		}
+		wg.Go(func() {
+			result.wait(waitCtx)
+		})
+	}
+	wg.Wait()
+
+	printer := cli.NewListPrinter(batchHeader...)
+	failed := 0
+	counts := make(map[string]int)
+	var states []string
+	for _, result := range results {
+		printer.Print(result)
+		if counts[result.State] == 0 {
+			states = append(states, result.State)
+		}
+		counts[result.State]++
+		if result.Failed() {
+			failed++
+		}
+	}
+	printer.Close()
+
+	summary := make([]string, len(states))
+	for i, state := range states {
+		summary[i] = fmt.Sprintf("%d %s", counts[state], state)
+	}
+	log.Printf("%d messages: %s", len(results), strings.Join(summary, ", "))
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+func sendBatchRow(ctx context.Context, sender *messaging.Sender, row batchRow) *batchResult {
+	result := &batchResult{
+		Row:         row.Row,
+		Destination: string(row.Message.Destination),
+	}
+
+	sendCtx, cancel := context.WithTimeout(ctx, cli.DefaultTetraFlags.CommandTimeout)
+	defer cancel()
+	attempt := time.Now()
+	delivery, err := sender.SendText(sendCtx, row.Message)
+	if err != nil {
+		result.State = batchNotSent
+		result.Error = err.Error()
+		if sendFlags.retry {
+			entry, queueErr := queueSendAttempt(row.Message, attempt, 0, err)
+			if queueErr != nil {
+				result.Error = fmt.Sprintf("%v; cannot queue the message: %v", err, queueErr)
+				return result
+			}
+			result.setQueueEntry(entry)
+		}
+		return result
+	}
+
+	result.MessageReference = int(delivery.MessageReference)
+	result.Parts = delivery.Parts
+	result.State = string(messaging.Sent)
+	if sendFlags.retry {
+		entry, err := queueSendAttempt(row.Message, attempt, delivery.MessageReference, nil)
+		if err != nil {
+			log.Printf("cannot queue the message #%d: %v", row.Row, err)
+		} else {
+			result.queueEntry = &entry
+			result.QueueID = entry.ID
+		}
+	}
+	if delivery.ReceivedRequested || delivery.ConsumedRequested {
+		result.delivery = delivery
+	}
+	return result
+}
+
+// Result states of batch messages that do not correspond to a delivery state.
+const (
+	batchNotSent = "not sent"
+	batchQueued  = "queued"
+)
+
+var batchHeader = []string{"row", "destination", "message_reference", "parts", "state", "delivery_status", "queue_id", "error"}
+
+// batchResult is the result of sending a message of a batch. It is printed in all output formats.
+type batchResult struct {
+	Row              int    `json:"row"`
+	Destination      string `json:"destination"`
+	MessageReference int    `json:"message_reference,omitempty"`
+	Parts            int    `json:"parts,omitempty"`
+	State            string `json:"state"`
+	DeliveryStatus   string `json:"delivery_status,omitempty"`
+	QueueID          string `json:"queue_id,omitempty"`
+	Error            string `json:"error,omitempty"`
+
+	delivery   *messaging.Delivery
+	queueEntry *messaging.QueueEntry
+}
+
+// wait for the requested delivery reports of the sent message.
+func (r *batchResult) wait(ctx context.Context) {
+	target := messaging.Received
+	if r.delivery.ConsumedRequested {
+		target = messaging.Consumed
+	}
+	err := r.delivery.Wait(ctx, target)
+	state, deliveryStatus := r.delivery.State()
+	r.State = string(state)
+	if state == messaging.Failed {
+		r.DeliveryStatus = fmt.Sprintf("0x%02x", byte(deliveryStatus))
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+
+	if r.queueEntry == nil || errors.Is(err, context.DeadlineExceeded) {
+		return
+	}
+	settleQueueEntry(r.queueEntry, r.delivery, err)
+	if r.queueEntry.State == messaging.QueueWaiting {
+		r.State = batchQueued
+	}
+}
+
+func (r *batchResult) setQueueEntry(entry messaging.QueueEntry) {
+	r.queueEntry = &entry
+	r.QueueID = entry.ID
+	if entry.State == messaging.QueueWaiting {
+		r.State = batchQueued
+	}
+}
+
+// Failed indicates that the message was not delivered and will not be retried.
+func (r *batchResult) Failed() bool {
+	return r.State == batchNotSent || r.State == string(messaging.Failed)
+}
+
+func (r *batchResult) String() string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "#%d %s", r.Row, r.Destination)
+	if r.MessageReference != 0 {
+		fmt.Fprintf(&builder, " #%d", r.MessageReference)
+	}
+	fmt.Fprintf(&builder, " %s", r.State)
+	if r.QueueID != "" {
+		fmt.Fprintf(&builder, " (queue %s)", r.QueueID)
+	}
+	if r.Error != "" {
+		fmt.Fprintf(&builder, ": %s", strings.ReplaceAll(r.Error, "\n", " "))
+	}
+	builder.WriteString("\n")
+	return builder.String()
+}
+
+func (r *batchResult) CSV() []string {
+	var messageReference, parts string
+	if r.MessageReference != 0 {
+		messageReference = strconv.Itoa(r.MessageReference)
+	}
+	if r.Parts != 0 {
+		parts = strconv.Itoa(r.Parts)
+	}
+	return []string{
+		strconv.Itoa(r.Row),
+		r.Destination,
+		messageReference,
+		parts,
+		r.State,
+		r.DeliveryStatus,
+		r.QueueID,
+		r.Error,
+	}
+}
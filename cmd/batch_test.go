@@ -0,0 +1,226 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ftl/tetra-pei/sds"
+
+	"github.com/ftl/tetra-cli/pkg/fakepei"
+	"github.com/ftl/tetra-cli/pkg/messaging"
+)
+
+func TestReadBatchCSV(t *testing.T) {
+	entries, err := readBatchCSV(strings.NewReader(" Destination ,TEXT,ack_receive,group\n1234567,hello,true,\n2620010,\"hi, all\",0,1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %+v", entries)
+	}
+	if entries[0].Destination != "1234567" || entries[0].Text != "hello" || entries[0].AckReceive == nil || !*entries[0].AckReceive || entries[0].Group != nil {
+		t.Errorf("unexpected first entry %+v", entries[0])
+	}
+	if entries[1].Text != "hi, all" || entries[1].AckReceive == nil || *entries[1].AckReceive || entries[1].Group == nil || !*entries[1].Group {
+		t.Errorf("unexpected second entry %+v", entries[1])
+	}
+
+	entries, err = readBatchCSV(strings.NewReader(""))
+	if err != nil || len(entries) != 0 {
+		t.Errorf("an empty file should have no entries, got %+v %v", entries, err)
+	}
+
+	for name, content := range map[string]string{
+		"unknown column": "destination,text,priority\n1234567,hello,1\n",
+		"invalid bool":   "destination,text,immediate\n1234567,hello,maybe\n",
+		"missing column": "destination,text\n1234567\n",
+	} {
+		if _, err := readBatchCSV(strings.NewReader(content)); err == nil {
+			t.Errorf("%s should be rejected", name)
+		}
+	}
+}
+
+func TestReadBatchJSON(t *testing.T) {
+	entries, err := readBatchJSON(strings.NewReader(`[{"destination": "1234567", "text": "hello", "ack_consume": true}, {"destination": "2345678", "text": "hi", "encoding": "ISO8859-15"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].AckConsume == nil || !*entries[0].AckConsume || entries[0].Immediate != nil || entries[1].Encoding != "ISO8859-15" {
+		t.Errorf("unexpected entries %+v", entries)
+	}
+
+	for _, content := range []string{`{"destination": "1234567"}`, `[{"destination": 1234567}]`, `[`} {
+		if _, err := readBatchJSON(strings.NewReader(content)); err == nil {
+			t.Errorf("%s should be rejected", content)
+		}
+	}
+}
+
+func TestBatchEntry_Set(t *testing.T) {
+	var entry batchEntry
+	for _, value := range []string{"1", "t", "TRUE", " true "} {
+		if err := entry.set("immediate", value); err != nil || entry.Immediate == nil || !*entry.Immediate {
+			t.Errorf("%q should be true: %v", value, err)
+		}
+	}
+	for _, value := range []string{"0", "f", "False"} {
+		if err := entry.set("simple", value); err != nil || entry.Simple == nil || *entry.Simple {
+			t.Errorf("%q should be false: %v", value, err)
+		}
+	}
+
+	entry = batchEntry{}
+	if err := entry.set("ack_receive", " "); err != nil || entry.AckReceive != nil {
+		t.Errorf("an empty value should keep the default: %v", err)
+	}
+	if err := entry.set("ack_consume", "yes"); err == nil {
+		t.Error("yes is not a valid value")
+	}
+}
+
+func TestBatchEntry_TextMessage(t *testing.T) {
+	useTestFlags(t)
+	addressBook := &messaging.AddressBook{}
+	defaults := messaging.TextMessage{Encoding: sds.ISO8859_1, AckReceive: true, Immediate: true}
+	no, yes := false, true
+
+	message, err := batchEntry{Destination: " 1234567 ", Text: "hello"}.textMessage(defaults, addressBook)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.Destination != "1234567" || !message.AckReceive || !message.Immediate || message.Encoding != sds.ISO8859_1 {
+		t.Errorf("the message should use the defaults, got %+v", message)
+	}
+
+	message, err = batchEntry{Destination: "1234567", Text: "hello", AckReceive: &no, AckConsume: &yes, Immediate: &no, Encoding: "ISO8859-15"}.textMessage(defaults, addressBook)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.AckReceive || !message.AckConsume || message.Immediate || message.Encoding != sds.ISO8859_15 {
+		t.Errorf("the row should override the defaults, got %+v", message)
+	}
+
+	for name, entry := range map[string]batchEntry{
+		"missing text":        {Destination: "1234567"},
+		"invalid destination": {Destination: "12a4567", Text: "hello"},
+		"invalid encoding":    {Destination: "1234567", Text: "hello", Encoding: "EBCDIC"},
+		"invalid text":        {Destination: "1234567", Text: "€"},
+	} {
+		if _, err := entry.textMessage(defaults, addressBook); err == nil {
+			t.Errorf("%s should be rejected", name)
+		}
+	}
+}
+
+func TestBatchEntry_TextMessageWarnsAboutGroupReports(t *testing.T) {
+	useTestFlags(t)
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	yes := true
+
+	message, err := batchEntry{Destination: "2620010", Text: "hello", Group: &yes}.textMessage(messaging.TextMessage{Encoding: sds.ISO8859_1, AckReceive: true}, &messaging.AddressBook{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.AckReceive || message.AckConsume {
+		t.Errorf("the delivery reports should not be requested for a group, got %+v", message)
+	}
+	if !strings.Contains(logged.String(), "warning: 2620010 is a group") {
+		t.Errorf("the warning is missing: %q", logged.String())
+	}
+}
+
+func TestBatchEntry_TextMessageRejectsRetryingSimpleMessages(t *testing.T) {
+	useTestFlags(t)
+	previous := sendFlags.retry
+	t.Cleanup(func() { sendFlags.retry = previous })
+	yes := true
+	entry := batchEntry{Destination: "1234567", Text: "hello", Simple: &yes}
+	defaults := messaging.TextMessage{Encoding: sds.ISO8859_1}
+
+	sendFlags.retry = false
+	if _, err := entry.textMessage(defaults, &messaging.AddressBook{}); err != nil {
+		t.Errorf("simple messages can be sent without --retry: %v", err)
+	}
+	sendFlags.retry = true
+	if _, err := entry.textMessage(defaults, &messaging.AddressBook{}); err == nil {
+		t.Error("simple messages cannot be retried")
+	}
+}
+
+func TestReadBatchFile(t *testing.T) {
+	useTestFlags(t)
+	dir := t.TempDir()
+	csvFile := filepath.Join(dir, "batch.csv")
+	os.WriteFile(csvFile, []byte("destination,text\n1234567,hello\n2345678,hi\n"), 0644)
+	jsonFile := filepath.Join(dir, "batch.JSON")
+	os.WriteFile(jsonFile, []byte(`[{"destination": "1234567", "text": "hello"}]`), 0644)
+	emptyFile := filepath.Join(dir, "empty.csv")
+	os.WriteFile(emptyFile, []byte("destination,text\n"), 0644)
+	invalidFile := filepath.Join(dir, "invalid.csv")
+	os.WriteFile(invalidFile, []byte("destination,text\n1234567,hello\n2345678,\n"), 0644)
+	defaults := messaging.TextMessage{Encoding: sds.ISO8859_1}
+
+	rows, err := readBatchFile(csvFile, defaults, &messaging.AddressBook{})
+	if err != nil || len(rows) != 2 || rows[1].Row != 2 || rows[1].Message.Destination != "2345678" {
+		t.Errorf("unexpected rows %+v %v", rows, err)
+	}
+	rows, err = readBatchFile(jsonFile, defaults, &messaging.AddressBook{})
+	if err != nil || len(rows) != 1 || rows[0].Message.Text != "hello" {
+		t.Errorf("unexpected rows %+v %v", rows, err)
+	}
+	if _, err := readBatchFile(emptyFile, defaults, &messaging.AddressBook{}); err == nil {
+		t.Error("a batch without messages should be rejected")
+	}
+	if _, err := readBatchFile(invalidFile, defaults, &messaging.AddressBook{}); err == nil || !strings.Contains(err.Error(), "message #2") {
+		t.Errorf("the invalid message should be reported, got %v", err)
+	}
+}
+
+func TestRunSendBatch_ExitsIfAMessageIsNotSent(t *testing.T) {
+	if os.Getenv(exitEnv) == "" {
+		status, output := runExitingTest(t)
+		if status != 1 {
+			t.Errorf("expected exit status 1, got %d:\n%s", status, output)
+		}
+		if !strings.Contains(output, "#1 1234567 #1 sent") || !strings.Contains(output, "#2 2345678 not sent: cannot send SDS text message: no coverage") {
+			t.Errorf("unexpected results:\n%s", output)
+		}
+		if !strings.Contains(output, "2 messages: 1 sent, 1 not sent") {
+			t.Errorf("the summary is missing:\n%s", output)
+		}
+		return
+	}
+
+	useTestFlags(t)
+	previous := sendBatch
+	t.Cleanup(func() { sendBatch = previous })
+	sendBatch = []batchRow{
+		{Row: 1, Message: messaging.TextMessage{Destination: "1234567", Text: "hello", Encoding: sds.ISO8859_1}},
+		{Row: 2, Message: messaging.TextMessage{Destination: "2345678", Text: "hello", Encoding: sds.ISO8859_1}},
+	}
+	sendFlags.batchInterval = 0
+	transfer := sds.NewTextMessageTransfer(1, false, sds.NoReportRequested, sds.ISO8859_1, "hello")
+	pei := fakepei.New(
+		fakepei.Expect("ATZ"),
+		fakepei.Expect("ATE0"),
+		fakepei.Expect("AT+CSCS=8859-1"),
+		fakepei.Expect("AT+CTSDS=12,0,0,0,1"),
+		fakepei.Expect("AT+CMGS=?", "+CMGS: (0-99999999),(0-2047)"),
+		fakepei.Expect(sds.SendMessage("1234567", transfer), "+CMGS: 0,1"),
+		fakepei.Expect("AT+CTSDS=12,0,0,0,1"),
+		fakepei.Expect(sds.SendMessage("2345678", transfer)).Fail(errors.New("no coverage")),
+	)
+
+	runSendBatch(testContext(t), pei, sendCmd, nil)
+
+	t.Error("runSendBatch should exit the process")
+}
@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ftl/tetra-cli/pkg/cli"
+	"github.com/ftl/tetra-cli/pkg/fakepei"
+)
+
+// useTestFlags points all files of the default TETRA flags into a temporary directory, so that the commands do not
+// touch the files of the user. The previous flags are restored when the test is done.
+func useTestFlags(t *testing.T) string {
+	t.Helper()
+	previous := cli.DefaultTetraFlags
+	t.Cleanup(func() { cli.DefaultTetraFlags = previous })
+
+	dir := t.TempDir()
+	cli.DefaultTetraFlags.CommandTimeout = 2 * time.Second
+	cli.DefaultTetraFlags.Output = string(cli.TextOutput)
+	cli.DefaultTetraFlags.MessageStore = filepath.Join(dir, "messages.jsonl")
+	cli.DefaultTetraFlags.Outbox = filepath.Join(dir, "outbox.jsonl")
+	cli.DefaultTetraFlags.Queue = filepath.Join(dir, "queue.jsonl")
+	cli.DefaultTetraFlags.Templates = filepath.Join(dir, "templates.yaml")
+	cli.DefaultTetraFlags.AddressBook = filepath.Join(dir, "addressbook.yaml")
+	cli.DefaultTetraFlags.StatusCatalog = filepath.Join(dir, "statuses.yaml")
+	return dir
+}
+
+// captureOutput returns everything the given function writes to stdout.
+func captureOutput(t *testing.T, f func()) string {
+	t.Helper()
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = writer
+	defer func() { os.Stdout = stdout }()
+
+	output := make(chan string)
+	go func() {
+		var buffer bytes.Buffer
+		io.Copy(&buffer, reader)
+		output <- buffer.String()
+	}()
+
+	f()
+	writer.Close()
+	return <-output
+}
+
+// testContext returns a context with the command timeout of the test flags.
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), cli.DefaultTetraFlags.CommandTimeout)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+// verifyScript fails the test if the fake PEI did not follow its script.
+func verifyScript(t *testing.T, pei *fakepei.PEI) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	pei.WaitForIndications(ctx)
+	if err := pei.Verify(); err != nil {
+		t.Errorf("%v\nrequests: %q", err, pei.Requests())
+	}
+}
+
+// exitEnv is set if a test runs in a separate process, because the tested function exits the process.
+const exitEnv = "TETRA_CLI_TEST_EXIT"
+
+// runExitingTest runs the current test in a separate process and returns its exit status and its output.
+func runExitingTest(t *testing.T) (int, string) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], "-test.run=^"+t.Name()+"$")
+	cmd.Env = append(os.Environ(), exitEnv+"=1")
+	output, err := cmd.CombinedOutput()
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return 0, string(output)
+	case errors.As(err, &exitErr):
+		return exitErr.ExitCode(), string(output)
+	default:
+		t.Fatal(err)
+		return 0, ""
+	}
+}
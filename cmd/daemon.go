@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"context"
+	"log"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ftl/tetra-cli/pkg/cli"
+	"github.com/ftl/tetra-cli/pkg/daemon"
+	"github.com/ftl/tetra-cli/pkg/events"
+	"github.com/ftl/tetra-cli/pkg/messaging"
+	"github.com/ftl/tetra-cli/pkg/radio"
+)
+
+var daemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Short: "Share the radio with other tetra-cli commands through a local socket",
+	Long: `Share the radio with other tetra-cli commands through a local socket.
+
+The daemon opens the radio and listens on the socket defined with --daemon-socket. While the daemon is running,
+all other commands use the daemon to access the radio instead of opening the device themselves. This allows
+to listen for incoming messages and to send messages at the same time. Use --no-daemon to open the device
+directly.
+
+The daemon matches all received delivery reports with the messages in the outbox.`,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		// the daemon must not connect to itself
+		cli.DefaultTetraFlags.NoDaemon = true
+	},
+	Run: cli.RunWithRadio(runDaemon, events.ActivateSignalling, fatal),
+}
+
+func init() {
+	cli.InitReconnectFlags(daemonCmd)
+
+	rootCmd.AddCommand(daemonCmd)
+}
+
+func runDaemon(ctx context.Context, radio *radio.Radio, cmd *cobra.Command, args []string) {
+	listener, err := daemon.Listen(cli.DefaultTetraFlags.DaemonSocket)
+	if err != nil {
+		fatalf("cannot listen on the daemon socket: %v", err)
+	}
+
+	// stop serving when the connection to the radio is lost for good
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		radio.WaitUntilClosed(ctx)
+		cancel()
+	}()
+
+	messageStore, err := cli.OpenMessageStore()
+	if err != nil {
+		fatal(err)
+	}
+	outbox, err := cli.OpenOutbox()
+	if err != nil {
+		fatal(err)
+	}
+	if outbox != nil {
+		err = messaging.TrackDeliveryReports(radio, outbox, messageStore)
+		if err != nil {
+			fatalf("cannot initialize radio: %v", err)
+		}
+	}
+
+	log.Printf("daemon listening on %s", cli.DefaultTetraFlags.DaemonSocket)
+	server := daemon.NewServer(radio)
+	err = server.Serve(ctx, listener)
+	if err != nil {
+		fatal(err)
+	}
+}
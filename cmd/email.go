@@ -0,0 +1,176 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/ftl/tetra-pei/sds"
+	"github.com/ftl/tetra-pei/tetra"
+	"github.com/spf13/cobra"
+
+	"github.com/ftl/tetra-cli/pkg/cli"
+	"github.com/ftl/tetra-cli/pkg/events"
+	"github.com/ftl/tetra-cli/pkg/mailgateway"
+	"github.com/ftl/tetra-cli/pkg/messaging"
+	"github.com/ftl/tetra-cli/pkg/radio"
+)
+
+var emailFlags = struct {
+	config string
+}{}
+
+var emailCmd = &cobra.Command{
+	Use:   "email",
+	Short: "Forward text messages as emails and send received emails as text messages",
+	Long: `Forward text messages as emails and send received emails as text messages.
+
+The gateway is configured in a YAML or JSON file (see --config), e.g.:
+
+  listen: localhost:2525
+  domain: tetra.example.com
+  clients: [127.0.0.1, 192.168.1.0/24]
+  allow: [dispatch@example.com, "@fire.example.com"]
+  smtp:
+    server: mail.example.com:587
+    username: tetra
+    password: s3cr3t
+    from: TETRA <tetra@example.com>
+  forward:
+    to: [ops@example.com]
+
+The embedded SMTP receiver accepts emails from the allowed clients and senders to <destination>@<domain>. Without
+clients, only the loopback interface may connect. The envelope sender is not proof of identity, so allow only clients
+that check their senders. The destination is an ISSI, a GSSI or a name or alias from the address book, e.g.
+1234567@tetra.example.com or engine-1@tetra.example.com.
+The subject and the text of the email are sent as text message, concatenated if the text is too long for a single
+message.
+
+The received text messages are sent as emails to the recipients given in forward.to, through the SMTP server. Replies
+to these emails are sent back to the source of the text message.
+
+See the README for all options.`,
+	PreRun: prepareEmail,
+	Run:    cli.RunWithRadio(runEmail, listener, fatal),
+}
+
+func init() {
+	emailCmd.Flags().StringVar(&emailFlags.config, "config", mailgateway.DefaultConfigPath(), "file that configures the email gateway")
+	cli.InitReconnectFlags(emailCmd)
+
+	rootCmd.AddCommand(emailCmd)
+}
+
+// The configuration of the email command, prepared before the radio is opened.
+var (
+	emailConfig        mailgateway.Config
+	emailAddressBook   *messaging.AddressBook
+	emailStatusCatalog *messaging.StatusCatalog
+)
+
+func prepareEmail(cmd *cobra.Command, args []string) {
+	var err error
+	emailConfig, err = mailgateway.LoadConfig(emailFlags.config)
+	if err != nil {
+		fatal(err)
+	}
+	emailAddressBook, err = cli.LoadAddressBook()
+	if err != nil {
+		fatal(err)
+	}
+	emailStatusCatalog, err = cli.LoadStatusCatalog()
+	if err != nil {
+		fatal(err)
+	}
+}
+
+func runEmail(ctx context.Context, radio *radio.Radio, cmd *cobra.Command, args []string) {
+	messageStore, err := cli.OpenMessageStore()
+	if err != nil {
+		fatal(err)
+	}
+	if messageStore != nil {
+		unsubscribe := listener.SubscribeDurable(messageStore.Record)
+		defer unsubscribe()
+	}
+	outbox, err := cli.OpenOutbox()
+	if err != nil {
+		fatal(err)
+	}
+	sender, err := messaging.NewSender(radio)
+	if err != nil {
+		fatalf("cannot initialize radio: %v", err)
+	}
+	sender.WithStore(messageStore).WithOutbox(outbox)
+
+	if len(emailConfig.Forward.To) > 0 {
+		forwarder := mailgateway.NewForwarder(emailConfig)
+		go forwarder.Run(ctx)
+		unsubscribe := listener.Subscribe(func(event events.Event) {
+			forwarder.Handle(listenRecord{event, emailAddressBook, emailStatusCatalog}.record())
+		})
+		defer unsubscribe()
+	}
+
+	if emailConfig.Listen != "" {
+		gateway := &emailGateway{sender: sender, addressBook: emailAddressBook}
+		receiver := mailgateway.NewReceiver(emailConfig, gateway.check, gateway.deliver)
+		go func() {
+			err := receiver.Run(ctx)
+			if err != nil {
+				fatal(err)
+			}
+		}()
+	}
+
+	radio.WaitUntilClosed(ctx)
+	if ctx.Err() == nil {
+		fatalf("the connection to the radio is lost")
+	}
+}
+
+// emailGateway sends the received emails as text messages the same way as the send command.
+type emailGateway struct {
+	sender      *messaging.Sender
+	addressBook *messaging.AddressBook
+}
+
+// check checks that the given recipient of an email is a valid destination.
+func (g *emailGateway) check(recipient string) error {
+	_, _, err := resolveDestination(g.addressBook, tetra.Identity(recipient), false)
+	return err
+}
+
+// deliver sends the given email as text message to all its recipients. It fails only if the text message could not
+// be sent to any recipient, otherwise another attempt would send duplicates to the other recipients.
+func (g *emailGateway) deliver(ctx context.Context, email mailgateway.Mail) error {
+	text, err := emailConfig.TextMessage(email, sds.ISO8859_1)
+	if err != nil {
+		return err
+	}
+
+	var failures []string
+	for _, recipient := range email.Recipients {
+		entry := batchEntry{Destination: recipient, Text: text}
+		message, err := entry.textMessage(messaging.TextMessage{Encoding: sds.ISO8859_1}, g.addressBook)
+		if err == nil {
+			sendCtx, cancel := context.WithTimeout(ctx, cli.DefaultTetraFlags.CommandTimeout)
+			var delivery *messaging.Delivery
+			delivery, err = g.sender.SendText(sendCtx, message)
+			cancel()
+			if err == nil {
+				log.Printf("sent the email from %s to %s (%d parts)", email.From, message.Destination, delivery.Parts)
+			}
+		}
+		if err != nil {
+			log.Printf("cannot send the email from %s to %s: %v", email.From, recipient, err)
+			failures = append(failures, fmt.Sprintf("%s: %v", recipient, err))
+		}
+	}
+	if len(failures) > 0 && len(failures) == len(email.Recipients) {
+		return errors.New(strings.Join(failures, "; "))
+	}
+	return nil
+}
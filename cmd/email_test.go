@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/ftl/tetra-pei/sds"
+
+	"github.com/ftl/tetra-cli/pkg/fakepei"
+	"github.com/ftl/tetra-cli/pkg/mailgateway"
+	"github.com/ftl/tetra-cli/pkg/messaging"
+)
+
+func useTestEmailConfig(t *testing.T, config mailgateway.Config) {
+	t.Helper()
+	previous := emailConfig
+	t.Cleanup(func() { emailConfig = previous })
+	emailConfig = config
+}
+
+func TestEmailGateway_Check(t *testing.T) {
+	addressBook := &messaging.AddressBook{Entries: []messaging.AddressEntry{
+		{Name: "Engine 1", ISSI: "1234567", Aliases: []string{"engine-1"}},
+		{Name: "Fire Ops", GTSI: "2620010000001"},
+	}}
+	gateway := &emailGateway{addressBook: addressBook}
+
+	for _, recipient := range []string{"1234567", "engine-1", "Engine 1", "fire ops", "2345678"} {
+		if err := gateway.check(recipient); err != nil {
+			t.Errorf("%s should be a valid recipient: %v", recipient, err)
+		}
+	}
+	for _, recipient := range []string{"engine-2", "12a4567", ""} {
+		if err := gateway.check(recipient); err == nil {
+			t.Errorf("%s should be rejected", recipient)
+		}
+	}
+}
+
+func TestEmailGateway_DeliversLongEmailsAsConcatenatedMessage(t *testing.T) {
+	useTestFlags(t)
+	useTestEmailConfig(t, mailgateway.Config{Text: mailgateway.DefaultText})
+	email := mailgateway.Mail{
+		From:       "dispatch@example.com",
+		Subject:    "Fire",
+		Body:       strings.Repeat("Building 4, second floor. ", 10),
+		Recipients: []string{"engine-1"},
+	}
+	text, err := emailConfig.TextMessage(email, sds.ISO8859_1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pdus := sds.NewConcatenatedMessageTransfer(1, sds.NoReportRequested, sds.ISO8859_1, messaging.MaxPDUBits, text)
+	if len(pdus) < 2 {
+		t.Fatalf("the text should need more than one part, got %d", len(pdus))
+	}
+	script := []fakepei.Exchange{
+		fakepei.Expect("AT+CTSDS=12,0,0,0,1"),
+		fakepei.Expect("AT+CMGS=?", "+CMGS: (0-99999999),(0-2047)"),
+	}
+	for i, pdu := range pdus {
+		script = append(script, fakepei.Expect(sds.SendMessage("1234567", pdu), fmt.Sprintf("+CMGS: 0,%d", i+1)).
+			Then(fmt.Sprintf("+CMGS: 0,%d,4", i+1)))
+	}
+	pei := fakepei.New(script...)
+	sender, err := messaging.NewSender(pei)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addressBook := &messaging.AddressBook{Entries: []messaging.AddressEntry{
+		{Name: "Engine 1", ISSI: "1234567", Aliases: []string{"engine-1"}},
+	}}
+	gateway := &emailGateway{sender: sender, addressBook: addressBook}
+
+	err = gateway.deliver(testContext(t), email)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifyScript(t, pei)
+}
+
+func TestEmailGateway_FailsOnlyIfNoRecipientGetsTheMessage(t *testing.T) {
+	useTestFlags(t)
+	useTestEmailConfig(t, mailgateway.Config{Text: "{{body}}"})
+	transfer := sds.NewTextMessageTransfer(1, false, sds.NoReportRequested, sds.ISO8859_1, "hello")
+	pei := fakepei.New(
+		fakepei.Expect("AT+CTSDS=12,0,0,0,1"),
+		fakepei.Expect("AT+CMGS=?", "+CMGS: (0-99999999),(0-2047)"),
+		fakepei.Expect(sds.SendMessage("1234567", transfer), "+CMGS: 0,1"),
+	)
+	pei.OnUnexpected = func(request string) ([]string, error) {
+		return nil, errors.New("no coverage")
+	}
+	sender, err := messaging.NewSender(pei)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gateway := &emailGateway{sender: sender, addressBook: &messaging.AddressBook{}}
+
+	err = gateway.deliver(testContext(t), mailgateway.Mail{Body: "hello", Recipients: []string{"1234567", "2345678"}})
+	if err != nil {
+		t.Errorf("the email was sent to one recipient, it must not fail: %v", err)
+	}
+	verifyScript(t, pei)
+
+	err = gateway.deliver(testContext(t), mailgateway.Mail{Body: "hello", Recipients: []string{"2345678"}})
+	if err == nil {
+		t.Error("the email was not sent to any recipient, it must fail")
+	}
+}
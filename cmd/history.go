@@ -0,0 +1,162 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ftl/tetra-cli/pkg/cli"
+	"github.com/ftl/tetra-cli/pkg/store"
+)
+
+var historyFlags = struct {
+	issi        string
+	from        string
+	to          string
+	messageType string
+	direction   string
+	search      string
+	limit       int
+}{}
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Show the recorded incoming and outgoing text and status messages",
+	Long: `Show the recorded incoming and outgoing text and status messages.
+
+The commands listen, serve, send and status record all messages in the message store, see the --message-store flag.
+Use --output csv or --output json to export the selected messages.
+
+The time range is given with --from and --to, either as absolute time (2006-01-02, 2006-01-02 15:04, or RFC 3339)
+or as duration relative to now (e.g. 24h).`,
+	Run: runHistory,
+}
+
+func init() {
+	historyCmd.Flags().StringVar(&historyFlags.issi, "issi", "", "only messages from or to the given ISSI or ITSI")
+	historyCmd.Flags().StringVar(&historyFlags.from, "from", "", "only messages at or after the given time")
+	historyCmd.Flags().StringVar(&historyFlags.to, "to", "", "only messages before the given time")
+	historyCmd.Flags().StringVar(&historyFlags.messageType, "type", "", "only messages of the given type: text, status")
+	historyCmd.Flags().StringVar(&historyFlags.direction, "direction", "", "only messages in the given direction: in, out")
+	historyCmd.Flags().StringVar(&historyFlags.search, "search", "", "only text messages that contain the given text (ignoring case)")
+	historyCmd.Flags().IntVar(&historyFlags.limit, "limit", 0, "only the given number of the latest messages (0 for all)")
+
+	rootCmd.AddCommand(historyCmd)
+}
+
+func runHistory(cmd *cobra.Command, args []string) {
+	if cli.DefaultTetraFlags.MessageStore == "" {
+		fatalf("no message store defined, use the --message-store flag")
+	}
+
+	query := store.Query{
+		ISSI:  strings.TrimSpace(historyFlags.issi),
+		Text:  historyFlags.search,
+		Limit: historyFlags.limit,
+	}
+	var err error
+	query.From, err = parseHistoryTime(historyFlags.from)
+	if err != nil {
+		fatal(err)
+	}
+	query.To, err = parseHistoryTime(historyFlags.to)
+	if err != nil {
+		fatal(err)
+	}
+	switch t := store.MessageType(strings.ToLower(historyFlags.messageType)); t {
+	case "", store.TextMessage, store.StatusMessage:
+		query.Type = t
+	default:
+		fatalf("unknown message type %q, use one of text, status", historyFlags.messageType)
+	}
+	switch d := store.Direction(strings.ToLower(historyFlags.direction)); d {
+	case "", store.Incoming, store.Outgoing:
+		query.Direction = d
+	default:
+		fatalf("unknown direction %q, use one of in, out", historyFlags.direction)
+	}
+
+	messageStore, err := cli.OpenMessageStore()
+	if err != nil {
+		fatal(err)
+	}
+	messages, err := messageStore.Query(query)
+	if err != nil {
+		fatal(err)
+	}
+
+	printer := cli.NewListPrinter(historyHeader...)
+	defer printer.Close()
+	for _, message := range messages {
+		printer.Print(historyRecord{message})
+	}
+}
+
+// parseHistoryTime parses an absolute time or a duration relative to now.
+func parseHistoryTime(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if duration, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-duration.Abs()), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02T15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, use 2006-01-02, 2006-01-02 15:04, RFC 3339, or a duration like 24h", value)
+}
+
+var historyHeader = []string{"id", "time", "direction", "type", "source", "destination", "message_reference", "state", "text", "status"}
+
+// historyRecord prints a recorded message in all output formats.
+type historyRecord struct {
+	store.Message
+}
+
+func (r historyRecord) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.Message)
+}
+
+func (r historyRecord) String() string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "%s %-3s %-6s %s -> %s", r.Time.Local().Format("2006-01-02 15:04:05"), strings.ToUpper(string(r.Direction)), strings.ToUpper(string(r.Type)), historyParty(r.Source), historyParty(r.Destination))
+	var details []string
+	if r.MessageReference != 0 {
+		details = append(details, fmt.Sprintf("ref %d", r.MessageReference))
+	}
+	if r.State != "" {
+		details = append(details, r.State)
+	}
+	if len(details) > 0 {
+		fmt.Fprintf(&builder, " [%s]", strings.Join(details, ", "))
+	}
+	switch r.Type {
+	case store.StatusMessage:
+		fmt.Fprintf(&builder, ": %s\n", r.Status)
+	default:
+		fmt.Fprintf(&builder, ": %s\n", r.Text)
+	}
+	return builder.String()
+}
+
+func (r historyRecord) CSV() []string {
+	var messageReference string
+	if r.MessageReference != 0 {
+		messageReference = strconv.Itoa(r.MessageReference)
+	}
+	return []string{r.ID, r.Time.Format(time.RFC3339Nano), string(r.Direction), string(r.Type), r.Source, r.Destination, messageReference, r.State, r.Text, r.Status}
+}
+
+func historyParty(issi string) string {
+	if issi == "" {
+		return "-"
+	}
+	return issi
+}
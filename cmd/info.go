@@ -35,6 +35,18 @@ func runInfo(ctx context.Context, pei radio.PEI, cmd *cobra.Command, args []stri
 	if err != nil {
 		log.Printf("cannot read radio device information: %v", err)
 	} else {
-		fmt.Printf("%v\n", strings.Join(info, "\n"))
+		cli.NewPrinter("info").Print(infoRecord{Info: info})
 	}
 }
+
+type infoRecord struct {
+	Info []string `json:"info"`
+}
+
+func (r infoRecord) String() string {
+	return fmt.Sprintf("%v\n", strings.Join(r.Info, "\n"))
+}
+
+func (r infoRecord) CSV() []string {
+	return []string{strings.Join(r.Info, "\n")}
+}
@@ -5,6 +5,8 @@ import (
 
 	"github.com/ftl/tetra-pei/serial"
 	"github.com/spf13/cobra"
+
+	"github.com/ftl/tetra-cli/pkg/cli"
 )
 
 var listDevicesCmd = &cobra.Command{
@@ -23,12 +25,31 @@ func runListDevices(*cobra.Command, []string) {
 		fatal(err)
 	}
 
-	if len(devices) == 0 {
+	printer := cli.NewListPrinter("description", "filename")
+	defer printer.Close()
+
+	if len(devices) == 0 && printer.Format() == cli.TextOutput {
 		fmt.Printf("no active serial devices found\n")
 		return
 	}
 
 	for _, device := range devices {
-		fmt.Printf("%s: %s\n", device.Description, device.Filename)
+		printer.Print(deviceRecord{
+			Description: device.Description,
+			Filename:    device.Filename,
+		})
 	}
 }
+
+type deviceRecord struct {
+	Description string `json:"description"`
+	Filename    string `json:"filename"`
+}
+
+func (r deviceRecord) String() string {
+	return fmt.Sprintf("%s: %s\n", r.Description, r.Filename)
+}
+
+func (r deviceRecord) CSV() []string {
+	return []string{r.Description, r.Filename}
+}
@@ -2,138 +2,277 @@ package cmd
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
-	"log"
-	"strconv"
 	"strings"
+	"time"
 
-	"github.com/ftl/tetra-pei/ctrl"
-	"github.com/ftl/tetra-pei/sds"
+	"github.com/ftl/tetra-pei/tetra"
 	"github.com/spf13/cobra"
 
 	"github.com/ftl/tetra-cli/pkg/cli"
+	"github.com/ftl/tetra-cli/pkg/events"
+	"github.com/ftl/tetra-cli/pkg/hooks"
+	"github.com/ftl/tetra-cli/pkg/messaging"
 	"github.com/ftl/tetra-cli/pkg/radio"
+	"github.com/ftl/tetra-cli/pkg/rules"
+	"github.com/ftl/tetra-cli/pkg/webhooks"
 )
 
 var listenFlags = struct {
+	rules           string
+	exec            []string
+	execConcurrency int
+	execTimeout     time.Duration
+	webhooks        string
+	webhookQueue    string
 }{}
 
+const (
+	defaultExecConcurrency = 4
+	defaultExecTimeout     = 30 * time.Second
+)
+
 var listenCmd = &cobra.Command{
 	Use:   "listen",
 	Short: "Listen for incoming text and status messages",
-	Run:   cli.RunWithRadio(runListen, initRadio, fatal),
+	Long: `Listen for incoming text and status messages.
+
+All received delivery reports are matched with the messages in the outbox.
+
+The rules defined in the rules file (see --rules) respond to incoming events automatically, e.g.:
+
+  rules:
+    - name: acknowledge alarms
+      status: alarm
+      reply_status: acknowledged
+      cooldown: 1m
+    - name: position
+      text: "(?i)^POS\\?$"
+      reply: "POS {{position}} ({{satellites}} satellites)"
+    - name: talkgroup requests
+      source: dispatch
+      text: "^TG (?P<talkgroup>.+)$"
+      exec: ["notify-send", "{{source_name}} requests talkgroup {{talkgroup}}"]
+
+A rule matches events by their event type, source, text, opta (regular expressions) or status. It responds with a
+text message (reply), a status (reply_status), a talkgroup switch (talkgroup) or an external command (exec), see the
+README for all options. The first matching rule wins, unless it has "continue: true".
+
+With --exec, a shell command runs for each event of the given type, or for all events without a type, e.g.:
+
+  tetra-cli listen --exec 'message=notify-send "$TETRA_SOURCE_NAME" "$TETRA_TEXT"' --exec 'status=./on-status.sh'
+
+The command gets the fields of the event in the environment variables TETRA_EVENT, TETRA_TIME, TETRA_SOURCE,
+TETRA_SOURCE_NAME, TETRA_ITSI, TETRA_OPTA, TETRA_TEXT, TETRA_STATUS, TETRA_STATUS_NAME, TETRA_STATUS_DESCRIPTION,
+TETRA_UNKNOWN_STATUS and TETRA_AI_MODE, and the event as JSON on stdin. Its output goes to stderr. At most
+--exec-concurrency commands run at the same time, each is stopped after --exec-timeout. Failures are logged.
+
+The webhooks defined in the webhooks file (see --webhooks) receive the events as JSON in HTTP POST requests, e.g.:
+
+  webhooks:
+    - url: https://ims.example.com/tetra
+      secret: s3cr3t
+      events: [message, status]
+
+With a secret, the requests are signed in the X-Tetra-Signature header: "sha256=" followed by the hex encoded
+HMAC-SHA256 of the request body. Failed deliveries are kept in the --webhook-queue file and retried with an increasing
+delay, also after a restart, until they expire.`,
+	PreRun: prepareListen,
+	Run:    cli.RunWithRadio(runListen, listener, fatal),
 }
 
 func init() {
+	listenCmd.Flags().StringArrayVar(&listenFlags.exec, "exec", nil, "run a shell command for each event, as <type>=<command> or <command> for all events (repeatable)")
+	listenCmd.Flags().IntVar(&listenFlags.execConcurrency, "exec-concurrency", defaultExecConcurrency, "maximum number of --exec commands that run at the same time")
+	listenCmd.Flags().DurationVar(&listenFlags.execTimeout, "exec-timeout", defaultExecTimeout, "maximum time for an --exec command")
+	listenCmd.Flags().StringVar(&listenFlags.rules, "rules", rules.DefaultPath(), "file that defines the rules for automatic responses (empty to disable)")
+	listenCmd.Flags().StringVar(&listenFlags.webhooks, "webhooks", webhooks.DefaultConfigPath(), "file that defines the webhooks that receive the events (empty to disable)")
+	listenCmd.Flags().StringVar(&listenFlags.webhookQueue, "webhook-queue", webhooks.DefaultQueuePath(), "file that keeps the webhook deliveries for retries (empty to retry only in memory)")
+	cli.InitReconnectFlags(listenCmd)
+
 	rootCmd.AddCommand(listenCmd)
 }
 
-var initRadio radio.InitializerFunc = func(ctx context.Context, pei radio.PEI) error {
-	// activate the signalling
-	err := pei.ATs(ctx,
-		"AT+CTSP=2,0,0",   // call signaling
-		"AT+CTSP=2,2,20",  // status
-		"AT+CTSP=1,3,2",   // simple text messaging
-		"AT+CTSP=1,3,9",   // simple immediate text messaging
-		"AT+CTSP=1,3,130", // text messaging
-		"AT+CTSP=1,3,137", // immediate text messaging
-		"AT+CTSP=1,3,138", // message with UDH
-	)
+// listener provides the events received from the radio.
+var listener = events.NewListener()
+
+// The configuration of the listen command, prepared before the radio is opened.
+var (
+	listenAddressBook   *messaging.AddressBook
+	listenStatusCatalog *messaging.StatusCatalog
+	listenRules         *rules.Rules
+	listenHooks         []hooks.Hook
+	listenWebhooks      webhooks.Config
+)
+
+func prepareListen(cmd *cobra.Command, args []string) {
+	var err error
+	listenAddressBook, err = cli.LoadAddressBook()
 	if err != nil {
-		return fmt.Errorf("cannot activate signalling: %w", err)
+		fatal(err)
 	}
-
-	// initialize the SDS stack with callbacks for the different message types
-	stack := sds.NewStack().WithMessageCallback(func(m sds.Message) {
-		var opta, sanitizedText, itsi string
-		opta, sanitizedText = sds.SplitLeadingOPTA(m.Text())
-		sanitizedText, itsi = sds.SplitTrailingITSI(sanitizedText)
-		fmt.Printf("MESSAGE\nISSI:%s\n", m.Source)
-		if itsi != "" {
-			fmt.Printf("ITSI:%s\n", itsi)
-		}
-		if opta != "" {
-			fmt.Printf("OPTA:%s\n", opta)
-		}
-		fmt.Printf("TEXT:%s\n", sanitizedText)
-		fmt.Println("--")
-	}).WithStatusCallback(func(m sds.StatusMessage) {
-		fmt.Printf("STATUS\nISSI:%s\nSTATUS:%4x\n--\n", m.Source, m.Value)
-	}).WithResponseCallback(func(responses []string) error {
-		for _, response := range responses {
-			_, err := pei.AT(ctx, response)
-			if err != nil {
-				log.Printf("cannot send response command %s:\n%v", response, err)
-				return err
-			}
+	listenStatusCatalog, err = cli.LoadStatusCatalog()
+	if err != nil {
+		fatal(err)
+	}
+	listenRules, err = loadRules(listenAddressBook, listenStatusCatalog)
+	if err != nil {
+		fatal(err)
+	}
+	listenHooks = make([]hooks.Hook, 0, len(listenFlags.exec))
+	for _, spec := range listenFlags.exec {
+		hook, err := hooks.Parse(spec)
+		if err != nil {
+			fatal(err)
 		}
-		return nil
-	})
-
-	// setup a function to decode SDS message parts
-	var decodeMessagePart = func(lines []string) {
-		if len(lines) == 2 {
-			part, err := sds.ParseIncomingMessage(lines[0], lines[1])
-			if err != nil {
-				log.Printf("cannot decode message part: %v", err)
-				return
-			}
-			stack.Put(part)
+		listenHooks = append(listenHooks, hook)
+	}
+	if listenFlags.webhooks != "" {
+		listenWebhooks, err = webhooks.LoadConfig(listenFlags.webhooks)
+		if err != nil {
+			fatal(err)
 		}
 	}
+}
+
+func runListen(ctx context.Context, radio *radio.Radio, cmd *cobra.Command, args []string) {
+	printer := cli.NewListPrinter(events.RecordHeader...)
+	defer printer.Close()
 
-	// enable the indiciation for SDS message parts and use the decode to process them
-	err = pei.AddIndication("+CTSDSR: 12,", 1, decodeMessagePart)
+	messageStore, err := cli.OpenMessageStore()
 	if err != nil {
-		return fmt.Errorf("cannot activate message indication (12): %w", err)
+		fatal(err)
+	}
+	if messageStore != nil {
+		unsubscribe := listener.SubscribeDurable(messageStore.Record)
+		defer unsubscribe()
 	}
-	err = pei.AddIndication("+CTSDSR: 13,", 1, decodeMessagePart)
+	outbox, err := cli.OpenOutbox()
 	if err != nil {
-		return fmt.Errorf("cannot activate message indication (13): %w", err)
+		fatal(err)
 	}
+	if listenRules.Len() > 0 {
+		// the sender also tracks the delivery reports of all messages in the outbox
+		sender, err := messaging.NewSender(radio)
+		if err != nil {
+			fatalf("cannot initialize radio: %v", err)
+		}
+		sender.WithStore(messageStore).WithOutbox(outbox)
 
-	// enable indications for several voice and talkgroup events
-	err = pei.AddIndication("+CTXG:", 0, func(lines []string) {
-		parts := strings.Split(lines[0][6:], ",")
-		switch len(parts) {
-		case 4:
-			fmt.Print("VOICE TX\n--\n")
-		case 6:
-			fmt.Printf("VOICE RX\nITSI: %s\n--\n", parts[5])
+		engine := rules.NewEngine(listenRules, sender, radio, cli.DefaultTetraFlags.CommandTimeout)
+		go engine.Run(ctx)
+		unsubscribe := listener.Subscribe(engine.Handle)
+		defer unsubscribe()
+	} else if outbox != nil {
+		err = messaging.TrackDeliveryReports(radio, outbox, messageStore)
+		if err != nil {
+			fatalf("cannot initialize radio: %v", err)
 		}
-	})
-	if err != nil {
-		return fmt.Errorf("cannot activate voice indication: %w", err)
 	}
 
-	err = pei.AddIndication("+CDTXC:", 0, func(lines []string) {
-		fmt.Printf("TALKGROUP IDLE\n--\n")
-	})
-	if err != nil {
-		return fmt.Errorf("cannot activate talkgroup idle indication: %w", err)
+	var hookRunner *hooks.Runner
+	if len(listenHooks) > 0 {
+		hookRunner = hooks.NewRunner(listenHooks, listenFlags.execConcurrency, listenFlags.execTimeout)
+		go hookRunner.Run(ctx)
 	}
 
-	err = pei.AddIndication("+CTCR:", 0, func(lines []string) {
-		fmt.Printf("TALKGROUP INACTIVE\n--\n")
-	})
-	if err != nil {
-		return fmt.Errorf("cannot activate talkgroup inactive indication: %w", err)
+	var dispatcher *webhooks.Dispatcher
+	if len(listenWebhooks.Webhooks) > 0 {
+		dispatcher, err = webhooks.NewDispatcher(listenWebhooks, listenFlags.webhookQueue)
+		if err != nil {
+			fatal(err)
+		}
+		go dispatcher.Run(ctx, webhooks.DefaultRetryInterval)
 	}
 
-	err = pei.AddIndication("+CTOM: ", 0, func(lines []string) {
-		aiMode, err := strconv.Atoi(lines[0][7:])
-		if err != nil {
-			return
+	unsubscribe := listener.Subscribe(func(event events.Event) {
+		record := listenRecord{event, listenAddressBook, listenStatusCatalog}
+		printer.Print(record)
+		if hookRunner != nil {
+			hookRunner.Handle(record.record())
+		}
+		if dispatcher != nil {
+			dispatcher.Handle(record.record())
 		}
-		fmt.Printf("AI MODE: %s\n--\n", ctrl.AIMode(aiMode).String())
 	})
-	if err != nil {
-		return fmt.Errorf("cannot activate CTOM indication")
+	defer unsubscribe()
+
+	radio.WaitUntilClosed(ctx)
+	if ctx.Err() == nil {
+		// complete the output, e.g. the JSON array, before exiting
+		unsubscribe()
+		printer.Close()
+		fatalf("the connection to the radio is lost")
 	}
+}
 
-	return nil
+// loadRules loads the rules defined through the --rules flag. If the flag is empty, there are no rules.
+func loadRules(addressBook *messaging.AddressBook, statusCatalog *messaging.StatusCatalog) (*rules.Rules, error) {
+	if listenFlags.rules == "" {
+		return &rules.Rules{}, nil
+	}
+	return rules.Load(listenFlags.rules, addressBook, statusCatalog)
 }
 
-func runListen(ctx context.Context, radio *radio.Radio, cmd *cobra.Command, args []string) {
-	<-ctx.Done()
+// listenRecord prints an event in all output formats. The sources are shown with their names from the address book,
+// the statuses with their names from the status catalog.
+type listenRecord struct {
+	events.Event
+	addressBook   *messaging.AddressBook
+	statusCatalog *messaging.StatusCatalog
+}
+
+func (r listenRecord) record() events.Record {
+	result := events.NewRecord(r.Event)
+	result.SourceName = r.addressBook.Name(tetra.Identity(result.Source))
+	if e, ok := r.Event.(events.StatusMessage); ok {
+		definition, _ := r.statusCatalog.Lookup(e.Status)
+		result.StatusName = definition.Name
+		result.StatusDescription = definition.Description
+		result.UnknownStatus = r.statusCatalog.Unknown(e.Status)
+	}
+	return result
+}
+
+func (r listenRecord) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.record())
+}
+
+func (r listenRecord) CSV() []string {
+	return r.record().CSV()
+}
+
+// String returns the text representation of the event.
+func (r listenRecord) String() string {
+	switch e := r.Event.(type) {
+	case events.TextMessage:
+		var builder strings.Builder
+		fmt.Fprintf(&builder, "MESSAGE\nISSI:%s\n", r.addressBook.Label(e.Source))
+		if e.ITSI != "" {
+			fmt.Fprintf(&builder, "ITSI:%s\n", e.ITSI)
+		}
+		if e.OPTA != "" {
+			fmt.Fprintf(&builder, "OPTA:%s\n", e.OPTA)
+		}
+		fmt.Fprintf(&builder, "TEXT:%s\n--\n", e.Text)
+		return builder.String()
+	case events.StatusMessage:
+		if r.statusCatalog.Empty() {
+			return fmt.Sprintf("STATUS\nISSI:%s\nSTATUS:%4x\n--\n", r.addressBook.Label(e.Source), e.Status)
+		}
+		return fmt.Sprintf("STATUS\nISSI:%s\nSTATUS:%s\n--\n", r.addressBook.Label(e.Source), r.statusCatalog.Label(e.Status))
+	case events.VoiceTx:
+		return "VOICE TX\n--\n"
+	case events.VoiceRx:
+		return fmt.Sprintf("VOICE RX\nITSI: %s\n--\n", r.addressBook.Label(tetra.Identity(e.ITSI)))
+	case events.TalkgroupIdle:
+		return "TALKGROUP IDLE\n--\n"
+	case events.TalkgroupInactive:
+		return "TALKGROUP INACTIVE\n--\n"
+	case events.AIModeChanged:
+		return fmt.Sprintf("AI MODE: %s\n--\n", e.AIMode.String())
+	default:
+		return ""
+	}
 }
@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ftl/tetra-pei/sds"
+
+	"github.com/ftl/tetra-cli/pkg/cli"
+	"github.com/ftl/tetra-cli/pkg/events"
+	"github.com/ftl/tetra-cli/pkg/fakepei"
+	"github.com/ftl/tetra-cli/pkg/messaging"
+	"github.com/ftl/tetra-cli/pkg/radio"
+)
+
+func TestListener_Initialize(t *testing.T) {
+	useTestFlags(t)
+	pei := fakepei.New(
+		fakepei.Expect("AT+CTSP=2,0,0"),
+		fakepei.Expect("AT+CTSP=2,2,20"),
+		fakepei.Expect("AT+CTSP=1,3,2"),
+		fakepei.Expect("AT+CTSP=1,3,9"),
+		fakepei.Expect("AT+CTSP=1,3,130"),
+		fakepei.Expect("AT+CTSP=1,3,137"),
+		fakepei.Expect("AT+CTSP=1,3,138"),
+	)
+	received := make(chan events.Event, 10)
+	unsubscribe := listener.Subscribe(func(event events.Event) {
+		received <- event
+	})
+	defer unsubscribe()
+
+	err := listener.Initialize(testContext(t), pei)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifyScript(t, pei)
+
+	transfer := sds.NewTextMessageTransfer(1, false, sds.NoReportRequested, sds.ISO8859_1, "hello")
+	indications := [][]string{
+		{"+CTSDSR: 13,1234567,0,2345678,0,16", "8005"},
+		incomingSDS("1234567", "2345678", transfer),
+		{"+CTOM: 1"},
+	}
+	for _, lines := range indications {
+		err = pei.Indicate(lines...)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	catalog := &messaging.StatusCatalog{Statuses: []messaging.StatusDefinition{{Status: "8005", Name: "on scene", Value: 0x8005}}}
+	expected := []string{
+		"STATUS\nISSI:1234567\nSTATUS:8005 (on scene)\n--\n",
+		"MESSAGE\nISSI:1234567\nTEXT:hello\n--\n",
+		"AI MODE: DMO\n--\n",
+	}
+	for _, text := range expected {
+		select {
+		case event := <-received:
+			record := listenRecord{event, &messaging.AddressBook{}, catalog}
+			if record.String() != text {
+				t.Errorf("unexpected event:\n%s\nexpected:\n%s", record.String(), text)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("missing event:\n%s", text)
+		}
+	}
+}
+
+func TestListenRecord_StatusWithoutCatalog(t *testing.T) {
+	event := events.StatusMessage{Source: "1234567", Status: 0x8005}
+	for _, catalog := range []*messaging.StatusCatalog{nil, {}} {
+		record := listenRecord{event, &messaging.AddressBook{}, catalog}
+		expected := "STATUS\nISSI:1234567\nSTATUS:8005\n--\n"
+		if record.String() != expected {
+			t.Errorf("unexpected text:\n%s\nexpected:\n%s", record.String(), expected)
+		}
+	}
+}
+
+func TestRunListen_ClosesTheJSONOutputWhenTheRadioIsLost(t *testing.T) {
+	if os.Getenv(exitEnv) == "" {
+		status, output := runExitingTest(t)
+		if status != 1 {
+			t.Errorf("expected exit status 1, got %d:\n%s", status, output)
+		}
+		if !strings.Contains(output, "[\n  {\n    \"type\": \"status\"") || !strings.HasSuffix(output, "\n]\nthe connection to the radio is lost\n") {
+			t.Errorf("the JSON array should be closed:\n%s", output)
+		}
+		return
+	}
+
+	useTestFlags(t)
+	cli.DefaultTetraFlags.Output = string(cli.JSONOutput)
+	listenAddressBook = &messaging.AddressBook{}
+	listenStatusCatalog = &messaging.StatusCatalog{}
+	pei := fakepei.New()
+	pei.OnUnexpected = func(request string) ([]string, error) {
+		// accept the initialization
+		return nil, nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r, err := radio.Open(ctx, pei, listener)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		pei.Indicate("+CTSDSR: 13,1234567,0,2345678,0,16", "8005")
+		pei.Disconnect()
+	}()
+
+	runListen(ctx, r, listenCmd, nil)
+
+	t.Error("runListen should exit the process")
+}
@@ -0,0 +1,259 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/ftl/tetra-pei/ctrl"
+	"github.com/ftl/tetra-pei/sds"
+	"github.com/spf13/cobra"
+
+	"github.com/ftl/tetra-cli/pkg/cli"
+	"github.com/ftl/tetra-cli/pkg/events"
+	"github.com/ftl/tetra-cli/pkg/messaging"
+	"github.com/ftl/tetra-cli/pkg/mqttbridge"
+	"github.com/ftl/tetra-cli/pkg/radio"
+)
+
+var mqttFlags = struct {
+	broker       string
+	clientID     string
+	username     string
+	password     string
+	topicPrefix  string
+	qos          int
+	cleanSession bool
+}{}
+
+var mqttCmd = &cobra.Command{
+	Use:   "mqtt",
+	Short: "Bridge the received events and commands to an MQTT broker",
+	Long: `Bridge the received events and commands to an MQTT broker.
+
+The received events are published as JSON, in the same format as "tetra-cli listen --output json", to the topics
+<prefix>/<issi>/<type>, e.g. tetra/1234567/message or tetra/1234567/status. Events without a source are published
+to <prefix>/radio/<type>, e.g. tetra/radio/ai-mode.
+
+Commands are JSON objects sent to these topics:
+  <prefix>/command/send           {"destination": "1234567", "text": "hello", "ack_consume": true}
+  <prefix>/command/status         {"destination": "1234567", "status": "8005"}
+  <prefix>/command/set-talkgroup  {"mode": "TMO", "talkgroup": "1234567890"}
+
+The fields correspond to the arguments and flags of the send, status and set-talkgroup commands. Destinations,
+talkgroups and statuses may be given by their names from the address book and the status catalog. The result of each
+command is published to <prefix>/command/<command>/result, with the "id" of the command, if given.
+
+The bridge reconnects automatically if the connection to the broker is lost. The retained topic <prefix>/bridge
+shows if the bridge is online or offline.`,
+	Run: cli.RunWithRadio(runMQTT, listener, fatal),
+}
+
+func init() {
+	mqttCmd.Flags().StringVar(&mqttFlags.broker, "broker", mqttbridge.DefaultBroker, "the URL of the MQTT broker, e.g. tcp://localhost:1883 or ssl://broker:8883")
+	mqttCmd.Flags().StringVar(&mqttFlags.clientID, "client-id", mqttbridge.DefaultClientID, "the client ID for the MQTT broker")
+	mqttCmd.Flags().StringVar(&mqttFlags.username, "username", "", "the username for the MQTT broker")
+	mqttCmd.Flags().StringVar(&mqttFlags.password, "password", "", "the password for the MQTT broker")
+	mqttCmd.Flags().StringVar(&mqttFlags.topicPrefix, "topic-prefix", mqttbridge.DefaultTopicPrefix, "the first level of all topics")
+	mqttCmd.Flags().IntVar(&mqttFlags.qos, "qos", mqttbridge.DefaultQoS, "the quality of service for events and commands: 0, 1 or 2")
+	mqttCmd.Flags().BoolVar(&mqttFlags.cleanSession, "clean-session", false, "discard commands that were sent to the broker while the bridge was not connected")
+	cli.InitReconnectFlags(mqttCmd)
+
+	rootCmd.AddCommand(mqttCmd)
+}
+
+func runMQTT(ctx context.Context, radio *radio.Radio, cmd *cobra.Command, args []string) {
+	if mqttFlags.qos < 0 || mqttFlags.qos > 2 {
+		fatalf("invalid QoS %d, use 0, 1 or 2", mqttFlags.qos)
+	}
+	bridge, err := mqttbridge.NewBridge(mqttbridge.Config{
+		Broker:       mqttFlags.broker,
+		ClientID:     mqttFlags.clientID,
+		Username:     mqttFlags.username,
+		Password:     mqttFlags.password,
+		TopicPrefix:  mqttFlags.topicPrefix,
+		QoS:          byte(mqttFlags.qos),
+		CleanSession: mqttFlags.cleanSession,
+	})
+	if err != nil {
+		fatal(err)
+	}
+
+	addressBook, err := cli.LoadAddressBook()
+	if err != nil {
+		fatal(err)
+	}
+	statusCatalog, err := cli.LoadStatusCatalog()
+	if err != nil {
+		fatal(err)
+	}
+	messageStore, err := cli.OpenMessageStore()
+	if err != nil {
+		fatal(err)
+	}
+	if messageStore != nil {
+		unsubscribe := listener.SubscribeDurable(messageStore.Record)
+		defer unsubscribe()
+	}
+	outbox, err := cli.OpenOutbox()
+	if err != nil {
+		fatal(err)
+	}
+	sender, err := messaging.NewSender(radio)
+	if err != nil {
+		fatalf("cannot initialize radio: %v", err)
+	}
+	sender.WithStore(messageStore).WithOutbox(outbox)
+
+	commands := &mqttCommands{
+		pei:           radio,
+		sender:        sender,
+		addressBook:   addressBook,
+		statusCatalog: statusCatalog,
+	}
+	bridge.HandleCommand("send", commands.send)
+	bridge.HandleCommand("status", commands.status)
+	bridge.HandleCommand("set-talkgroup", commands.setTalkgroup)
+
+	unsubscribe := listener.Subscribe(func(event events.Event) {
+		bridge.Publish(listenRecord{event, addressBook, statusCatalog}.record())
+	})
+	defer unsubscribe()
+
+	bridgeCtx, stopBridge := context.WithCancel(ctx)
+	bridgeDone := make(chan struct{})
+	go func() {
+		defer close(bridgeDone)
+		err := bridge.Run(bridgeCtx)
+		if err != nil {
+			fatal(err)
+		}
+	}()
+
+	radio.WaitUntilClosed(ctx)
+	stopBridge()
+	<-bridgeDone
+	if ctx.Err() == nil {
+		fatalf("the connection to the radio is lost")
+	}
+}
+
+// mqttCommands executes the commands received through MQTT the same way as the corresponding CLI commands.
+type mqttCommands struct {
+	pei           radio.PEI
+	sender        *messaging.Sender
+	addressBook   *messaging.AddressBook
+	statusCatalog *messaging.StatusCatalog
+}
+
+// mqttSendResult is the result of the send command.
+type mqttSendResult struct {
+	Destination      string `json:"destination"`
+	MessageReference int    `json:"message_reference"`
+	Parts            int    `json:"parts"`
+	State            string `json:"state"`
+	DeliveryStatus   string `json:"delivery_status,omitempty"`
+}
+
+// send sends a text message, defined like a message of a batch file. If delivery reports are requested, it waits
+// for them until the command timeout expires.
+func (c *mqttCommands) send(ctx context.Context, payload []byte) (any, error) {
+	var command batchEntry
+	err := json.Unmarshal(payload, &command)
+	if err != nil {
+		return nil, fmt.Errorf("invalid send command: %w", err)
+	}
+	message, err := command.textMessage(messaging.TextMessage{Encoding: sds.ISO8859_1}, c.addressBook)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cli.DefaultTetraFlags.CommandTimeout)
+	defer cancel()
+	delivery, err := c.sender.SendText(ctx, message)
+	if err != nil {
+		return nil, err
+	}
+	if delivery.ConsumedRequested {
+		err = delivery.Wait(ctx, messaging.Consumed)
+	} else if delivery.ReceivedRequested {
+		err = delivery.Wait(ctx, messaging.Received)
+	}
+
+	state, deliveryStatus := delivery.State()
+	result := mqttSendResult{
+		Destination:      string(delivery.Destination),
+		MessageReference: int(delivery.MessageReference),
+		Parts:            delivery.Parts,
+		State:            string(state),
+	}
+	if state != messaging.Sent {
+		result.DeliveryStatus = fmt.Sprintf("0x%02x", byte(deliveryStatus))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("the message #%d to %s is %s: %w", result.MessageReference, result.Destination, result.State, err)
+	}
+	return result, nil
+}
+
+type mqttStatusCommand struct {
+	Destination string `json:"destination"`
+	Status      string `json:"status"`
+	Group       bool   `json:"group"`
+}
+
+// status sends a status message.
+func (c *mqttCommands) status(ctx context.Context, payload []byte) (any, error) {
+	var command mqttStatusCommand
+	err := json.Unmarshal(payload, &command)
+	if err != nil {
+		return nil, fmt.Errorf("invalid status command: %w", err)
+	}
+	message, err := statusMessage(c.addressBook, c.statusCatalog, command.Destination, command.Status, command.Group)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cli.DefaultTetraFlags.CommandTimeout)
+	defer cancel()
+	err = c.sender.SendStatus(ctx, message)
+	if err != nil {
+		return nil, err
+	}
+	return mqttStatusCommand{
+		Destination: string(message.Destination),
+		Status:      c.statusCatalog.Label(message.Status),
+		Group:       message.Group,
+	}, nil
+}
+
+type mqttTalkgroupCommand struct {
+	Mode      string `json:"mode"`
+	Talkgroup string `json:"talkgroup,omitempty"`
+}
+
+// setTalkgroup switches the operating mode and the talkgroup.
+func (c *mqttCommands) setTalkgroup(ctx context.Context, payload []byte) (any, error) {
+	var command mqttTalkgroupCommand
+	err := json.Unmarshal(payload, &command)
+	if err != nil {
+		return nil, fmt.Errorf("invalid set-talkgroup command: %w", err)
+	}
+	aiMode, err := ctrl.AIModeByName(strings.TrimSpace(command.Mode))
+	if err != nil {
+		return nil, fmt.Errorf("invalid AI mode %q, use TMO or DMO", command.Mode)
+	}
+	talkgroup, err := resolveTalkgroup(c.addressBook, strings.TrimSpace(command.Talkgroup))
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cli.DefaultTetraFlags.CommandTimeout)
+	defer cancel()
+	gtsi, err := switchTalkgroup(ctx, c.pei, aiMode, talkgroup)
+	if err != nil {
+		return nil, err
+	}
+	return mqttTalkgroupCommand{Mode: aiMode.String(), Talkgroup: gtsi}, nil
+}
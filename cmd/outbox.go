@@ -0,0 +1,149 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ftl/tetra-cli/pkg/cli"
+	"github.com/ftl/tetra-cli/pkg/messaging"
+)
+
+var outboxFlags = struct {
+	destination string
+	pending     bool
+	limit       int
+}{}
+
+var outboxCmd = &cobra.Command{
+	Use:   "outbox",
+	Short: "Show the delivery state of the outgoing text messages",
+	Long: `Show the delivery state of the outgoing text messages.
+
+The commands send and serve register all outgoing text messages in the outbox, see the --outbox flag.
+Any running listen, serve or daemon command matches the received delivery reports with the messages
+in the outbox, even if the sending command already ended. The state of a message is one of:
+
+  sent      the radio accepted the message
+  received  the destination reported the reception of the message
+  consumed  the destination reported that the message was read
+  failed    the destination or the network reported that the message could not be delivered
+  expired   the requested delivery reports were not received within 24 hours`,
+	Run: runOutbox,
+}
+
+func init() {
+	outboxCmd.Flags().StringVar(&outboxFlags.destination, "destination", "", "only messages to the given ISSI")
+	outboxCmd.Flags().BoolVar(&outboxFlags.pending, "pending", false, "only messages that still wait for delivery reports")
+	outboxCmd.Flags().IntVar(&outboxFlags.limit, "limit", 0, "only the given number of the latest messages (0 for all)")
+
+	rootCmd.AddCommand(outboxCmd)
+}
+
+func runOutbox(cmd *cobra.Command, args []string) {
+	outbox, err := cli.OpenOutbox()
+	if err != nil {
+		fatal(err)
+	}
+	if outbox == nil {
+		fatalf("no outbox defined, use the --outbox flag")
+	}
+
+	entries, err := outbox.Entries()
+	if err != nil {
+		fatal(err)
+	}
+
+	destination := strings.TrimSpace(outboxFlags.destination)
+	selected := make([]messaging.OutboxEntry, 0, len(entries))
+	for _, entry := range entries {
+		if destination != "" && entry.Destination != destination {
+			continue
+		}
+		if outboxFlags.pending && !entry.Pending() {
+			continue
+		}
+		selected = append(selected, entry)
+	}
+	if outboxFlags.limit > 0 && len(selected) > outboxFlags.limit {
+		selected = selected[len(selected)-outboxFlags.limit:]
+	}
+
+	printer := cli.NewListPrinter(outboxHeader...)
+	defer printer.Close()
+	for _, entry := range selected {
+		printer.Print(outboxRecord{entry})
+	}
+}
+
+var outboxHeader = []string{"time", "destination", "message_reference", "state", "delivery_status", "parts", "part_states", "received_requested", "consumed_requested", "expires", "text"}
+
+// outboxRecord prints an outbox entry in all output formats.
+type outboxRecord struct {
+	messaging.OutboxEntry
+}
+
+func (r outboxRecord) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.OutboxEntry)
+}
+
+func (r outboxRecord) String() string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "%s %s #%d %s", r.Time.Local().Format("2006-01-02 15:04:05"), r.Destination, r.MessageReference, r.State)
+	if r.DeliveryStatus != "" {
+		fmt.Fprintf(&builder, " (%s)", r.DeliveryStatus)
+	}
+	if r.Parts > 1 {
+		fmt.Fprintf(&builder, " [%s]", formatPartStates(r.PartStates, r.Parts))
+	}
+	fmt.Fprintf(&builder, ": %s\n", r.Text)
+	return builder.String()
+}
+
+// formatPartStates summarizes the states of the parts of a concatenated message, e.g. "6 parts: 4 received, 2 sent".
+func formatPartStates(partStates []messaging.DeliveryState, parts int) string {
+	if len(partStates) == 0 {
+		return fmt.Sprintf("%d parts", parts)
+	}
+	counts := make(map[messaging.DeliveryState]int)
+	var states []string
+	for _, state := range partStates {
+		if counts[state] == 0 {
+			states = append(states, string(state))
+		}
+		counts[state]++
+	}
+	summary := make([]string, len(states))
+	for i, state := range states {
+		summary[i] = fmt.Sprintf("%d %s", counts[messaging.DeliveryState(state)], state)
+	}
+	return fmt.Sprintf("%d parts: %s", parts, strings.Join(summary, ", "))
+}
+
+func (r outboxRecord) CSV() []string {
+	return []string{
+		r.Time.Format(time.RFC3339Nano),
+		r.Destination,
+		strconv.Itoa(r.MessageReference),
+		string(r.State),
+		r.DeliveryStatus,
+		strconv.Itoa(r.Parts),
+		joinPartStates(r.PartStates),
+		strconv.FormatBool(r.ReceivedRequested),
+		strconv.FormatBool(r.ConsumedRequested),
+		r.Expires.Format(time.RFC3339Nano),
+		r.Text,
+	}
+}
+
+func joinPartStates(partStates []messaging.DeliveryState) string {
+	result := make([]string, len(partStates))
+	for i, state := range partStates {
+		result[i] = string(state)
+	}
+	return strings.Join(result, " ")
+}
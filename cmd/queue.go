@@ -0,0 +1,175 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ftl/tetra-cli/pkg/cli"
+	"github.com/ftl/tetra-cli/pkg/events"
+	"github.com/ftl/tetra-cli/pkg/messaging"
+	"github.com/ftl/tetra-cli/pkg/radio"
+)
+
+var queueFlags = struct {
+	all      bool
+	interval time.Duration
+}{}
+
+const defaultQueueInterval = 5 * time.Second
+
+var queueCmd = &cobra.Command{
+	Use:   "queue",
+	Short: "Show the messages in the outgoing queue",
+	Long: `Show the messages in the outgoing queue.
+
+"tetra-cli send --retry" keeps messages in the outgoing queue until they are delivered. Use "tetra-cli queue run"
+to send the queued messages; "tetra-cli serve" processes the queue too. Only one command should process the queue
+at a time.`,
+	Run: runQueue,
+}
+
+var queueRunCmd = &cobra.Command{
+	Use:   "run",
+	Short: "Send the messages in the outgoing queue until they are delivered",
+	Run:   cli.RunWithRadio(runQueueRun, events.ActivateSignalling, fatal),
+}
+
+var queueCancelCmd = &cobra.Command{
+	Use:   "cancel <id>...",
+	Short: "Remove messages from the outgoing queue",
+	Run:   runQueueCancel,
+}
+
+func init() {
+	queueCmd.Flags().BoolVar(&queueFlags.all, "all", false, "show also the messages that are not processed anymore (delivered, failed, expired, canceled)")
+	queueRunCmd.Flags().DurationVar(&queueFlags.interval, "interval", defaultQueueInterval, "the interval to check the queue")
+	cli.InitReconnectFlags(queueRunCmd)
+
+	queueCmd.AddCommand(queueRunCmd)
+	queueCmd.AddCommand(queueCancelCmd)
+	rootCmd.AddCommand(queueCmd)
+}
+
+func openQueue() *messaging.Queue {
+	queue, err := cli.OpenQueue()
+	if err != nil {
+		fatal(err)
+	}
+	if queue == nil {
+		fatalf("no outgoing queue defined, use the --queue flag")
+	}
+	return queue
+}
+
+func runQueue(cmd *cobra.Command, args []string) {
+	entries, err := openQueue().Entries()
+	if err != nil {
+		fatal(err)
+	}
+
+	printer := cli.NewListPrinter(queueHeader...)
+	defer printer.Close()
+	for _, entry := range entries {
+		if !queueFlags.all && !entry.State.Active() {
+			continue
+		}
+		printer.Print(queueRecord{entry})
+	}
+}
+
+func runQueueRun(ctx context.Context, radio *radio.Radio, cmd *cobra.Command, args []string) {
+	queue := openQueue()
+	messageStore, err := cli.OpenMessageStore()
+	if err != nil {
+		fatal(err)
+	}
+	outbox, err := cli.OpenOutbox()
+	if err != nil {
+		fatal(err)
+	}
+	sender, err := messaging.NewSender(radio)
+	if err != nil {
+		fatalf("cannot initialize radio: %v", err)
+	}
+	sender.WithStore(messageStore).WithOutbox(outbox)
+
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		radio.WaitUntilClosed(ctx)
+		cancel()
+	}()
+
+	log.Printf("processing the outgoing queue %s", cli.DefaultTetraFlags.Queue)
+	queue.Run(runCtx, sender, queueFlags.interval, cli.DefaultTetraFlags.CommandTimeout)
+
+	if ctx.Err() == nil {
+		fatalf("the connection to the radio is lost")
+	}
+}
+
+func runQueueCancel(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		fatalf("tetra-cli queue cancel <id>...")
+	}
+	queue := openQueue()
+	for _, id := range args {
+		_, err := queue.Cancel(strings.TrimSpace(id))
+		if err != nil {
+			fatal(err)
+		}
+	}
+}
+
+var queueHeader = []string{"id", "created", "destination", "state", "attempts", "next_attempt", "expires", "message_reference", "last_error", "text"}
+
+// queueRecord prints a queue entry in all output formats.
+type queueRecord struct {
+	messaging.QueueEntry
+}
+
+func (r queueRecord) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.QueueEntry)
+}
+
+func (r queueRecord) String() string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "%s %s %s, %d attempts", r.ID, r.Destination, r.State, r.Attempts)
+	if r.State == messaging.QueueWaiting {
+		fmt.Fprintf(&builder, ", next at %s", r.NextAttempt.Local().Format("2006-01-02 15:04:05"))
+	}
+	if r.LastError != "" {
+		fmt.Fprintf(&builder, " (%s)", r.LastError)
+	}
+	fmt.Fprintf(&builder, ": %s\n", r.Text)
+	return builder.String()
+}
+
+func (r queueRecord) CSV() []string {
+	var nextAttempt, messageReference string
+	if !r.NextAttempt.IsZero() {
+		nextAttempt = r.NextAttempt.Format(time.RFC3339Nano)
+	}
+	if r.MessageReference != 0 {
+		messageReference = strconv.Itoa(r.MessageReference)
+	}
+	return []string{
+		r.ID,
+		r.Created.Format(time.RFC3339Nano),
+		r.Destination,
+		string(r.State),
+		strconv.Itoa(r.Attempts),
+		nextAttempt,
+		r.Expires.Format(time.RFC3339Nano),
+		messageReference,
+		r.LastError,
+		r.Text,
+	}
+}
@@ -23,6 +23,12 @@ const defaultCommandTimeout = 5 * time.Second
 var rootCmd = &cobra.Command{
 	Use:   "tetra-cli",
 	Short: "Control a TETRA radio terminal through its PEI.",
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		err := cli.ValidateOutputFlag()
+		if err != nil {
+			fatal(err)
+		}
+	},
 }
 
 func init() {
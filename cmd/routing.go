@@ -2,8 +2,8 @@ package cmd
 
 import (
 	"context"
-	"fmt"
 	"log"
+	"strconv"
 	"strings"
 
 	"github.com/spf13/cobra"
@@ -34,7 +34,41 @@ func runRouting(ctx context.Context, pei radio.PEI, cmd *cobra.Command, args []s
 	routing, err := pei.AT(ctx, "AT+CTSP?")
 	if err != nil {
 		log.Printf("cannot read routing settings: %v", err)
-	} else {
-		fmt.Printf("%v\n", strings.Join(routing, "\n"))
+		return
 	}
+
+	printer := cli.NewListPrinter("service_profile", "service_layer1", "service_layer2", "line")
+	defer printer.Close()
+	for _, line := range routing {
+		printer.Print(parseRoutingRecord(line))
+	}
+}
+
+// routingRecord is one routing setting as reported by AT+CTSP? (see [PEI] 6.14.1).
+type routingRecord struct {
+	ServiceProfile int    `json:"service_profile"`
+	ServiceLayer1  int    `json:"service_layer1"`
+	ServiceLayer2  int    `json:"service_layer2"`
+	Line           string `json:"line"`
+}
+
+func parseRoutingRecord(line string) routingRecord {
+	result := routingRecord{Line: line}
+	fields := strings.Split(strings.TrimSpace(strings.TrimPrefix(line, "+CTSP:")), ",")
+	values := []*int{&result.ServiceProfile, &result.ServiceLayer1, &result.ServiceLayer2}
+	for i, field := range fields {
+		if i >= len(values) {
+			break
+		}
+		*values[i], _ = strconv.Atoi(strings.TrimSpace(field))
+	}
+	return result
+}
+
+func (r routingRecord) String() string {
+	return r.Line + "\n"
+}
+
+func (r routingRecord) CSV() []string {
+	return []string{strconv.Itoa(r.ServiceProfile), strconv.Itoa(r.ServiceLayer1), strconv.Itoa(r.ServiceLayer2), r.Line}
 }
@@ -2,16 +2,20 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
-	"math/rand"
+	"os"
 	"strings"
+	"time"
 
 	"github.com/ftl/tetra-pei/sds"
 	"github.com/ftl/tetra-pei/tetra"
 	"github.com/spf13/cobra"
 
 	"github.com/ftl/tetra-cli/pkg/cli"
+	"github.com/ftl/tetra-cli/pkg/messaging"
 	"github.com/ftl/tetra-cli/pkg/radio"
 )
 
@@ -22,178 +26,379 @@ var sendFlags = struct {
 	ackConsume       bool
 	simple           bool
 	encoding         string
+	retry            bool
+	retryBackoff     time.Duration
+	retryMaxBackoff  time.Duration
+	retryMaxAge      time.Duration
+	batch            string
+	batchInterval    time.Duration
+	file             string
+	template         string
+	vars             []string
+	varsJSON         string
+	preview          bool
+	group            bool
 }{}
 
 var sendCmd = &cobra.Command{
-	Use:   "send <destination ISSI> <text>",
+	Use:   "send <destination> <text>|-",
 	Short: "Send an SDS text message",
-	Run:   cli.RunWithPEIAndTimeout(runSend, fatal),
+	Long: `Send an SDS text message.
+
+Use "-" as text to read the text from the standard input, or --file to read it from a file. Line breaks are preserved.
+The text is checked against the chosen --encoding before the radio is opened.
+
+With --template, the text is taken from a template (see --templates and "tetra-cli templates"). Placeholders like
+{{code}} are filled with the values given with --var, --vars, or with the environment variable of the same name,
+in this order. Use --preview to check the resulting text and how many parts it needs without sending it.
+
+With --retry, the message is kept in the outgoing queue (see --queue) if it cannot be sent, or if the destination
+reports that the message could not be delivered for a temporary reason, e.g. because the destination is not reachable.
+A running "tetra-cli queue run" or "tetra-cli serve" command sends the message again after an increasing delay,
+until it is delivered or reaches its maximum age.
+
+With --group, the destination is a group: either the GSSI or GTSI, or the name of a talkgroup, which is looked up in
+the talkgroups of the current operating mode. Groups do not send delivery reports, so --ack-receive and --ack-consume
+are ignored for groups.
+
+The destination may also be a name, alias, unit or role from the address book (see "tetra-cli addressbook").
+Talkgroups from the address book are always addressed as group.
+
+With --batch, the messages are read from a CSV or JSON file and sent one after the other, see the README for the
+file format. The flags define the defaults for all messages of the batch.`,
+	PreRun: prepareSend,
+	Run:    runSendCommand,
 }
 
 func init() {
-	sendCmd.Flags().IntVar(&sendFlags.messageReference, "message-reference", 0, "the message reference used for delivery reports")
+	sendCmd.Flags().IntVar(&sendFlags.messageReference, "message-reference", 0, "the message reference used for delivery reports (0 to choose the next free reference for the destination)")
 	sendCmd.Flags().BoolVar(&sendFlags.immediate, "immediate", false, "immediately show the message at the receiver")
 	sendCmd.Flags().BoolVar(&sendFlags.ackReceive, "ack-receive", false, "request acknowledgment for receiving the message")
 	sendCmd.Flags().BoolVar(&sendFlags.ackConsume, "ack-consume", false, "request acknowledgment for consuming the message")
+	sendCmd.Flags().BoolVar(&sendFlags.group, "group", false, "the destination is a group, given as GSSI, GTSI or the name of a talkgroup")
 	sendCmd.Flags().BoolVar(&sendFlags.simple, "simple", false, "use the simple text messaging protocol (no delivery reports possible)")
 	sendCmd.Flags().StringVar(&sendFlags.encoding, "encoding", "ISO8859-1", "the text encoding")
+	sendCmd.Flags().BoolVar(&sendFlags.retry, "retry", false, "keep the message in the outgoing queue and retry if it cannot be sent or delivered")
+	sendCmd.Flags().DurationVar(&sendFlags.retryBackoff, "retry-backoff", messaging.DefaultRetryBackoff, "time to wait before the first retry, doubled with every attempt")
+	sendCmd.Flags().DurationVar(&sendFlags.retryMaxBackoff, "retry-max-backoff", messaging.DefaultRetryMaxBackoff, "maximum time to wait between two attempts")
+	sendCmd.Flags().DurationVar(&sendFlags.retryMaxAge, "retry-max-age", messaging.DefaultRetryMaxAge, "maximum time to retry sending the message")
+	sendCmd.Flags().StringVar(&sendFlags.file, "file", "", "read the text from the given file")
+	sendCmd.Flags().StringVar(&sendFlags.template, "template", "", "fill the text from the given template, see --templates")
+	sendCmd.Flags().StringArrayVar(&sendFlags.vars, "var", nil, "a value for a placeholder of the template, as name=value (repeatable)")
+	sendCmd.Flags().StringVar(&sendFlags.varsJSON, "vars", "", "values for the placeholders of the template as JSON object, or the name of a file with a JSON object (- for stdin)")
+	sendCmd.Flags().BoolVar(&sendFlags.preview, "preview", false, "only show the text, its length and the number of parts, do not send the message")
+	sendCmd.Flags().StringVar(&sendFlags.batch, "batch", "", "send the messages defined in the given CSV or JSON file")
+	sendCmd.Flags().DurationVar(&sendFlags.batchInterval, "batch-interval", defaultBatchInterval, "minimum time between two messages of a batch")
 
 	rootCmd.AddCommand(sendCmd)
 }
 
-func runSend(ctx context.Context, pei radio.PEI, cmd *cobra.Command, args []string) {
-	if len(args) < 2 {
-		fatalf("tetra-cli send <destination ISSI> <text>")
-	}
+// sendMessage is the message to send, prepared from the command line before the radio is opened.
+var sendMessage messaging.TextMessage
 
-	destISSI := tetra.Identity(args[0])
-	if sendFlags.messageReference == 0 {
-		sendFlags.messageReference = (rand.Int() + 1) & 0xFF
+func prepareSend(cmd *cobra.Command, args []string) {
+	batch := sendFlags.batch != ""
+	if batch && len(args) > 0 {
+		fatalf("tetra-cli send --batch <file> takes no further arguments")
+	}
+	if batch && (sendFlags.file != "" || sendFlags.template != "") {
+		fatalf("--file and --template cannot be used with --batch")
+	}
+	if sendFlags.file != "" && sendFlags.template != "" {
+		fatalf("--file and --template cannot be used together")
+	}
+	textFromArgs := !batch && sendFlags.file == "" && sendFlags.template == ""
+	if textFromArgs && len(args) < 2 {
+		fatalf("tetra-cli send <destination> <text>|-")
 	}
-	if sendFlags.messageReference < 1 || sendFlags.messageReference > 255 {
+	if !batch && !textFromArgs && (len(args) > 1 || len(args) == 0 && !sendFlags.preview) {
+		fatalf("tetra-cli send --file <file>|--template <name> <destination>")
+	}
+
+	if sendFlags.messageReference < 0 || sendFlags.messageReference > messaging.MaxMessageReference {
 		fatalf("the message reference must be 1-255, but got %d", sendFlags.messageReference)
 	}
-	messageReference := sds.MessageReference(sendFlags.messageReference)
+	if batch && sendFlags.messageReference != 0 {
+		fatalf("the message reference cannot be used with --batch, each message gets the next free reference")
+	}
+	if sendFlags.retry && sendFlags.simple {
+		fatalf("simple text messages cannot be retried, they provide no delivery reports")
+	}
+
+	encoding, err := messaging.ParseEncoding(sendFlags.encoding)
+	if err != nil {
+		fatal(err)
+	}
+	sendMessage = messaging.TextMessage{
+		MessageReference: sds.MessageReference(sendFlags.messageReference),
+		Encoding:         encoding,
+		Immediate:        sendFlags.immediate,
+		AckReceive:       sendFlags.ackReceive,
+		AckConsume:       sendFlags.ackConsume,
+		Simple:           sendFlags.simple,
+		Group:            sendFlags.group,
+	}
+	addressBook, err := cli.LoadAddressBook()
+	if err != nil {
+		fatal(err)
+	}
+	if batch {
+		sendBatch, err = readBatchFile(sendFlags.batch, sendMessage, addressBook)
+		if err != nil {
+			fatal(err)
+		}
+		return
+	}
+	if len(args) > 0 {
+		sendMessage.Destination, sendMessage.Group, err = resolveDestination(addressBook, tetra.Identity(strings.TrimSpace(args[0])), sendMessage.Group)
+		args = args[1:]
+		if err != nil {
+			fatal(err)
+		}
+	}
+	if sendMessage.GroupReportsRequested() {
+		warnGroupReports(&sendMessage)
+	}
+	if sendFlags.template != "" {
+		sendMessage.Text, err = renderSendTemplate(sendFlags.template)
+	} else {
+		sendMessage.Text, err = readSendText(args)
+	}
+	if err != nil {
+		fatal(err)
+	}
+	err = messaging.ValidateText(sendMessage.Text, sendMessage.Encoding)
+	if err != nil {
+		fatal(err)
+	}
+}
+
+// warnGroupReports warns that delivery reports are requested for a group, which does not send delivery reports, and
+// removes the request from the message.
+func warnGroupReports(message *messaging.TextMessage) {
+	log.Printf("warning: %s is a group, groups do not send delivery reports; the message is sent without requesting delivery reports", message.Destination)
+	message.AckReceive = false
+	message.AckConsume = false
+}
+
+// readSendText returns the text of the message: the content of the file given with --file, the standard input if the
+// text is "-", or the given arguments joined with blanks. Line breaks in files and the standard input are preserved,
+// only trailing line breaks are removed.
+func readSendText(args []string) (string, error) {
+	var text []byte
+	var err error
+	switch {
+	case sendFlags.file != "":
+		text, err = os.ReadFile(sendFlags.file)
+		if err != nil {
+			return "", fmt.Errorf("cannot read the text: %w", err)
+		}
+	case len(args) == 1 && args[0] == "-":
+		text, err = io.ReadAll(os.Stdin)
+		if err != nil {
+			return "", fmt.Errorf("cannot read the text from stdin: %w", err)
+		}
+	default:
+		return strings.Join(args, " "), nil
+	}
+
+	result := strings.TrimRight(string(text), "\r\n")
+	if result == "" {
+		return "", fmt.Errorf("the text is empty")
+	}
+	return result, nil
+}
+
+func runSendCommand(cmd *cobra.Command, args []string) {
+	if sendFlags.preview {
+		printSendPreview()
+		return
+	}
+	if sendFlags.batch != "" {
+		cli.RunWithPEI(runSendBatch, sendBatchFatal)(cmd, args)
+		return
+	}
+	cli.RunWithPEIAndTimeout(runSend, sendFatal)(cmd, args)
+}
+
+// sendFatal handles errors that prevent opening the radio. With --retry, the message is queued for another attempt.
+func sendFatal(err error) {
+	queueOrFatal(err)
+	os.Exit(0)
+}
+
+// queueOrFatal handles errors that prevent sending the message. With --retry, the message is queued for another attempt.
+func queueOrFatal(err error) {
+	if !sendFlags.retry {
+		fatal(err)
+	}
+	_, queueErr := queueSendAttempt(sendMessage, time.Now(), 0, err)
+	if queueErr != nil {
+		fatal(fmt.Errorf("%w\ncannot queue the message: %w", err, queueErr))
+	}
+}
 
-	encoding, ok := sds.EncodingByName[strings.ToUpper(strings.TrimSpace(sendFlags.encoding))]
-	if !ok {
-		fatalf("unexpected encoding: %s", sendFlags.encoding)
+// queueSendAttempt adds the message to the outgoing queue with the result of the first attempt to send it.
+func queueSendAttempt(message messaging.TextMessage, attempt time.Time, messageReference sds.MessageReference, err error) (messaging.QueueEntry, error) {
+	queue, queueErr := cli.OpenQueue()
+	if queueErr != nil {
+		return messaging.QueueEntry{}, queueErr
 	}
-	messageText := strings.Join(args[1:], " ")
-	deliveryReport := sds.NoReportRequested
-	if sendFlags.ackReceive {
-		deliveryReport |= sds.MessageReceivedReportRequested
+	if queue == nil {
+		return messaging.QueueEntry{}, fmt.Errorf("--retry requires an outgoing queue, use the --queue flag")
 	}
-	if sendFlags.ackConsume {
-		deliveryReport |= sds.MessageConsumedReportRequested
+
+	entry := messaging.NewQueueEntry(message)
+	entry.Backoff = sendFlags.retryBackoff
+	entry.MaxBackoff = sendFlags.retryMaxBackoff
+	entry.Created = attempt
+	entry.Expires = attempt.Add(sendFlags.retryMaxAge)
+	entry.Attempted(attempt, messageReference, err)
+	entry, queueErr = queue.Add(entry, sendFlags.retryMaxAge)
+	if queueErr != nil {
+		return entry, queueErr
 	}
 
+	if err != nil {
+		log.Printf("cannot send the message to %s: %v", entry.Destination, err)
+		logQueueEntry(entry)
+	}
+	return entry, nil
+}
+
+func logQueueEntry(entry messaging.QueueEntry) {
+	switch entry.State {
+	case messaging.QueueWaiting:
+		log.Printf("the message is queued as %s, next attempt at %s", entry.ID, entry.NextAttempt.Format(time.TimeOnly))
+	case messaging.QueueExpired:
+		log.Printf("the message %s expired", entry.ID)
+	}
+}
+
+func runSend(ctx context.Context, pei radio.PEI, cmd *cobra.Command, args []string) {
+	message := sendMessage
+
 	err := pei.ATs(ctx,
 		"ATZ",
 		"ATE0",
 		"AT+CSCS=8859-1",
-		sds.SwitchToSDSTL,
 	)
 	if err != nil {
-		fatalf("cannot initialize radio: %v", err)
+		queueOrFatal(fmt.Errorf("cannot initialize radio: %v", err))
+		return
 	}
 
-	maxPDUBits, err := sds.RequestMaxMessagePDUBits(ctx, pei)
+	messageStore, err := cli.OpenMessageStore()
 	if err != nil {
-		fatalf("cannot find out how long an SDS text message may be: %v", err)
+		fatal(err)
 	}
-	maxPDUBits = 668 // this is the value that seems to work in practice. grateful for any hint how this is supposed to work
-
-	var pdu sds.Encoder
-	var sdsTransfer sds.SDSTransfer
-	if sendFlags.simple {
-		pdu = sds.NewSimpleTextMessage(sendFlags.immediate, encoding, messageText)
-	} else {
-		sdsTransfer = sds.NewTextMessageTransfer(messageReference, sendFlags.immediate, deliveryReport, encoding, messageText)
-		pdu = sdsTransfer
+	outbox, err := cli.OpenOutbox()
+	if err != nil {
+		fatal(err)
 	}
-
-	_, pduBits := pdu.Encode([]byte{}, 0)
-	if pduBits <= maxPDUBits {
-		err = sendSingleTextMessage(ctx, pei, destISSI, messageReference, pdu, sdsTransfer.ReceivedReportRequested(), sdsTransfer.ConsumedReportRequested())
-	} else {
-		err = sendConcatenatedTextMessage(ctx, pei, destISSI, messageReference, encoding, maxPDUBits, messageText)
+	sender, err := messaging.NewSender(pei)
+	if err != nil {
+		queueOrFatal(fmt.Errorf("cannot initialize radio: %v", err))
+		return
 	}
+	sender.WithStore(messageStore).WithOutbox(outbox)
 
+	attempt := time.Now()
+	delivery, err := sender.SendText(ctx, message)
 	if err != nil {
-		fatal(err)
+		queueOrFatal(err)
+		return
 	}
-}
 
-func sendSingleTextMessage(ctx context.Context, pei radio.PEI, destISSI tetra.Identity, messageReference sds.MessageReference, pdu sds.Encoder, waitForReceived bool, waitForConsumed bool) error {
-	messageReceived := make(chan struct{})
-	messageConsumed := make(chan struct{})
-	pei.AddIndication("+CTSDSR: 12,", 1, func(lines []string) {
-		if len(lines) == 2 {
-			part, err := sds.ParseIncomingMessage(lines[0], lines[1])
-			if err != nil {
-				log.Printf("cannot decode message part: %v", err)
-			}
-
-			switch report := part.Payload.(type) {
-			case sds.SDSReport:
-				if report.MessageReference != messageReference {
-					return
-				}
-				switch report.DeliveryStatus {
-				case sds.ReceiptAckByDestination:
-					close(messageReceived)
-				case sds.ConsumedByDestination:
-					close(messageConsumed)
-				default:
-					log.Printf("unexpected delivery report: 0x%x", report.DeliveryStatus)
-				}
-
-			case sds.SDSShortReport:
-				if report.MessageReference != messageReference {
-					return
-				}
-				switch report.ReportType {
-				case sds.MessageReceivedShort:
-					close(messageReceived)
-				case sds.MessageConsumedShort:
-					close(messageConsumed)
-				default:
-					log.Printf("unexpected short delivery report: 0x%x", report.ReportType)
-				}
-			}
+	var queueEntry *messaging.QueueEntry
+	if sendFlags.retry {
+		entry, err := queueSendAttempt(message, attempt, delivery.MessageReference, nil)
+		if err != nil {
+			fatal(err)
 		}
-	})
-
-	request := sds.SendMessage(destISSI, pdu)
-	_, err := pei.AT(ctx, request)
-	if err != nil {
-		return fmt.Errorf("cannot send SDS text message: %v", err)
+		queueEntry = &entry
 	}
 
-	if waitForReceived {
-		select {
-		case <-messageReceived:
-			log.Printf("message received")
-		case <-ctx.Done():
-			return ctx.Err()
+	if delivery.ReceivedRequested {
+		err = delivery.Wait(ctx, messaging.Received)
+		if err != nil {
+			handleDeliveryWaitError(err, delivery, outbox, queueEntry)
+			return
 		}
+		logDeliveryState(delivery, messaging.Received)
 	}
-	if waitForConsumed {
-		select {
-		case <-messageConsumed:
-			log.Printf("message consumed")
-		case <-ctx.Done():
-			return ctx.Err()
+	if delivery.ConsumedRequested {
+		err = delivery.Wait(ctx, messaging.Consumed)
+		if err != nil {
+			handleDeliveryWaitError(err, delivery, outbox, queueEntry)
+			return
 		}
+		logDeliveryState(delivery, messaging.Consumed)
 	}
 
-	return nil
+	if queueEntry != nil {
+		settleQueueEntry(queueEntry, delivery, nil)
+	}
 }
 
-func sendConcatenatedTextMessage(ctx context.Context, pei radio.PEI, destISSI tetra.Identity, messageReference sds.MessageReference, encoding sds.TextEncoding, maxPDUBits int, messageText string) error {
-	partConfirmation := make(chan string, 1)
-	pei.AddIndication("+CMGS: 0,", 0, func(lines []string) {
-		if len(lines) != 1 {
-			return
-		}
-		partConfirmation <- lines[0]
-	})
+func logDeliveryState(delivery *messaging.Delivery, state messaging.DeliveryState) {
+	if delivery.Parts > 1 {
+		log.Printf("message %s (all %d parts)", state, delivery.Parts)
+		return
+	}
+	log.Printf("message %s", state)
+}
 
-	pdus := sds.NewConcatenatedMessageTransfer(messageReference, sds.NoReportRequested, encoding, maxPDUBits, messageText)
-	for i, pdu := range pdus {
-		request := sds.SendMessage(destISSI, pdu)
-		_, err := pei.AT(ctx, request)
-		if err != nil {
-			return fmt.Errorf("cannot send SDS text message part #%d: %v", i+1, err)
-		}
-		if i < len(pdus)-1 {
-			select {
-			case <-partConfirmation:
-			case <-ctx.Done():
-				return ctx.Err()
-			}
+// handleDeliveryWaitError schedules another attempt for a queued message that could not be delivered for a temporary
+// reason. Any other error is fatal.
+func handleDeliveryWaitError(err error, delivery *messaging.Delivery, outbox *messaging.Outbox, queueEntry *messaging.QueueEntry) {
+	if queueEntry != nil && settleQueueEntry(queueEntry, delivery, err) {
+		return
+	}
+	fatal(deliveryWaitError(err, outbox))
+}
+
+// settleQueueEntry updates a queued message with the result of waiting for its delivery reports. It returns true if
+// another attempt is scheduled. Without a result, the message stays in the queue to be tracked by the queue worker.
+func settleQueueEntry(entry *messaging.QueueEntry, delivery *messaging.Delivery, err error) bool {
+	if err == nil {
+		if entry.State == messaging.QueueSent {
+			entry.State = messaging.QueueDelivered
+			updateQueueEntry(*entry)
 		}
+		return false
+	}
+
+	state, deliveryStatus := delivery.State()
+	switch {
+	case state == messaging.Failed && messaging.Retryable(deliveryStatus):
+		log.Print(err)
+		entry.Retry(time.Now(), err.Error())
+		updateQueueEntry(*entry)
+		logQueueEntry(*entry)
+		return true
+	case state == messaging.Failed:
+		entry.State = messaging.QueueFailed
+		entry.LastError = err.Error()
+		updateQueueEntry(*entry)
+	}
+	return false
+}
+
+func updateQueueEntry(entry messaging.QueueEntry) {
+	queue, err := cli.OpenQueue()
+	if err == nil && queue != nil {
+		err = queue.Update(entry)
+	}
+	if err != nil {
+		log.Printf("cannot update the outgoing queue: %v", err)
+	}
+}
+
+// deliveryWaitError explains where to find the delivery state if the delivery report did not arrive in time.
+func deliveryWaitError(err error, outbox *messaging.Outbox) error {
+	if !errors.Is(err, context.DeadlineExceeded) || outbox == nil {
+		return err
 	}
-	return nil
+	return fmt.Errorf("%w\na running listen, serve or daemon command keeps tracking the delivery state in the outbox (see tetra-cli outbox)", err)
 }
@@ -0,0 +1,163 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ftl/tetra-pei/sds"
+	"github.com/ftl/tetra-pei/tetra"
+
+	"github.com/ftl/tetra-cli/pkg/cli"
+	"github.com/ftl/tetra-cli/pkg/fakepei"
+	"github.com/ftl/tetra-cli/pkg/messaging"
+	"github.com/ftl/tetra-cli/pkg/store"
+)
+
+// incomingSDS returns the lines of an indication for the given SDS-TL PDU.
+func incomingSDS(source tetra.Identity, destination tetra.Identity, pdu sds.Encoder) []string {
+	bytes, bits := pdu.Encode(make([]byte, 0, 256), 0)
+	return []string{
+		fmt.Sprintf("+CTSDSR: %s,%s,0,%s,0,%d", sds.SDSTLService, source, destination, bits),
+		tetra.BinaryToHex(bytes),
+	}
+}
+
+func TestRunSend(t *testing.T) {
+	useTestFlags(t)
+	sendMessage = messaging.TextMessage{
+		Destination: "1234567",
+		Text:        "hello",
+		Encoding:    sds.ISO8859_1,
+	}
+	transfer := sds.NewTextMessageTransfer(1, false, sds.NoReportRequested, sds.ISO8859_1, "hello")
+	pei := fakepei.New(
+		fakepei.Expect("ATZ"),
+		fakepei.Expect("ATE0"),
+		fakepei.Expect("AT+CSCS=8859-1"),
+		fakepei.Expect("AT+CTSDS=12,0,0,0,1"),
+		fakepei.Expect("AT+CMGS=?", "+CMGS: (0-99999999),(0-2047)"),
+		fakepei.Expect(sds.SendMessage("1234567", transfer), "+CMGS: 0,1"),
+	)
+
+	runSend(testContext(t), pei, sendCmd, nil)
+
+	verifyScript(t, pei)
+	messageStore, _ := store.Open(cli.DefaultTetraFlags.MessageStore)
+	messages, err := messageStore.Messages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 || messages[0].Destination != "1234567" || messages[0].Text != "hello" {
+		t.Errorf("the message was not recorded: %+v", messages)
+	}
+}
+
+func TestRunSend_WaitsForTheDeliveryReports(t *testing.T) {
+	useTestFlags(t)
+	sendMessage = messaging.TextMessage{
+		Destination: "1234567",
+		Text:        "hello",
+		Encoding:    sds.ISO8859_1,
+		AckReceive:  true,
+		AckConsume:  true,
+	}
+	reports := sds.MessageReceivedReportRequested | sds.MessageConsumedReportRequested
+	transfer := sds.NewTextMessageTransfer(1, false, reports, sds.ISO8859_1, "hello")
+	pei := fakepei.New(
+		fakepei.Expect("ATZ"),
+		fakepei.Expect("ATE0"),
+		fakepei.Expect("AT+CSCS=8859-1"),
+		fakepei.Expect("AT+CTSDS=12,0,0,0,1"),
+		fakepei.Expect("AT+CMGS=?", "+CMGS: (0-99999999),(0-2047)"),
+		fakepei.Expect(sds.SendMessage("1234567", transfer), "+CMGS: 0,1").
+			Then(incomingSDS("1234567", "2345678", sds.NewSDSReport(transfer, false, sds.ReceiptAckByDestination))...).
+			Then(incomingSDS("1234567", "2345678", sds.NewSDSReport(transfer, false, sds.ConsumedByDestination))...),
+	)
+
+	runSend(testContext(t), pei, sendCmd, nil)
+
+	verifyScript(t, pei)
+	outbox, _ := messaging.OpenOutbox(cli.DefaultTetraFlags.Outbox)
+	entry, ok, err := outbox.Entry("1234567", 1)
+	if err != nil || !ok {
+		t.Fatalf("the message is not in the outbox: %v", err)
+	}
+	if entry.State != messaging.Consumed {
+		t.Errorf("expected the message to be consumed, got %s", entry.State)
+	}
+}
+
+// useStdin replaces the standard input with the given content while the test runs.
+func useStdin(t *testing.T, content string) {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "stdin")
+	err := os.WriteFile(filename, []byte(content), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdin := os.Stdin
+	os.Stdin = file
+	t.Cleanup(func() {
+		os.Stdin = stdin
+		file.Close()
+	})
+}
+
+func TestReadSendText(t *testing.T) {
+	previous := sendFlags.file
+	t.Cleanup(func() { sendFlags.file = previous })
+	filename := filepath.Join(t.TempDir(), "text.txt")
+
+	tests := []struct {
+		name     string
+		content  string
+		expected string
+	}{
+		{"single line", "hello\n", "hello"},
+		{"line breaks", "Fire\nBuilding 4\n\nsecond floor", "Fire\nBuilding 4\n\nsecond floor"},
+		{"trailing line breaks", "  hello\r\n\r\n\n", "  hello"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sendFlags.file = ""
+			useStdin(t, tt.content)
+			got, err := readSendText([]string{"-"})
+			if err != nil || got != tt.expected {
+				t.Errorf("stdin: expected %q, got %q %v", tt.expected, got, err)
+			}
+
+			os.WriteFile(filename, []byte(tt.content), 0600)
+			sendFlags.file = filename
+			got, err = readSendText(nil)
+			if err != nil || got != tt.expected {
+				t.Errorf("file: expected %q, got %q %v", tt.expected, got, err)
+			}
+		})
+	}
+
+	sendFlags.file = ""
+	got, err := readSendText([]string{"on", "my", "way"})
+	if err != nil || got != "on my way" {
+		t.Errorf("expected the arguments, got %q %v", got, err)
+	}
+
+	useStdin(t, "\n\r\n")
+	if _, err := readSendText([]string{"-"}); err == nil {
+		t.Error("an empty text from stdin should be rejected")
+	}
+	os.WriteFile(filename, nil, 0600)
+	sendFlags.file = filename
+	if _, err := readSendText(nil); err == nil {
+		t.Error("an empty file should be rejected")
+	}
+	sendFlags.file = filepath.Join(t.TempDir(), "missing.txt")
+	if _, err := readSendText(nil); err == nil {
+		t.Error("a missing file should be rejected")
+	}
+}
@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ftl/tetra-cli/pkg/cli"
+	"github.com/ftl/tetra-cli/pkg/httpapi"
+	"github.com/ftl/tetra-cli/pkg/messaging"
+	"github.com/ftl/tetra-cli/pkg/radio"
+)
+
+var serveFlags = struct {
+	address        string
+	token          string
+	allowedOrigins []string
+	queueInterval  time.Duration
+}{}
+
+const defaultServeAddress = "localhost:8080"
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Provide an HTTP API to send text and status messages and to stream the received events",
+	Long: `Provide an HTTP API to send text and status messages and to stream the received events.
+
+Endpoints:
+  POST /api/v1/messages              send a text message
+  GET  /api/v1/messages/{reference}  get the delivery state of a text message
+  POST /api/v1/statuses              send a status message
+  GET  /api/v1/events                stream the received events as Server-Sent Events
+  GET  /api/v1/events/ws             stream the received events through a WebSocket
+
+The POST endpoints require the content type application/json. With --token, all requests must provide the token in
+the header "Authorization: Bearer <token>"; the event streams also accept it in the query parameter "token".
+The WebSocket accepts connections from web pages of the same origin and of the origins given with --allow-origin.
+
+Messages in the outgoing queue (see --queue and "tetra-cli queue") are sent again until they are delivered.
+
+See the README for the request and response formats.`,
+	Run: cli.RunWithRadio(runServe, listener, fatal),
+}
+
+func init() {
+	serveCmd.Flags().StringVar(&serveFlags.address, "address", defaultServeAddress, "the address to listen on for HTTP requests")
+	serveCmd.Flags().StringVar(&serveFlags.token, "token", "", "require this bearer token for all requests")
+	serveCmd.Flags().StringSliceVar(&serveFlags.allowedOrigins, "allow-origin", nil, "allow WebSocket connections from web pages of this origin, e.g. https://dashboard.example.com (\"*\" allows all)")
+	serveCmd.Flags().DurationVar(&serveFlags.queueInterval, "queue-interval", defaultQueueInterval, "the interval to check the outgoing queue")
+	cli.InitReconnectFlags(serveCmd)
+
+	rootCmd.AddCommand(serveCmd)
+}
+
+func runServe(ctx context.Context, radio *radio.Radio, cmd *cobra.Command, args []string) {
+	hub := httpapi.NewHub(serveFlags.allowedOrigins...)
+	unsubscribe := listener.Subscribe(hub.Publish)
+	defer unsubscribe()
+
+	messageStore, err := cli.OpenMessageStore()
+	if err != nil {
+		fatal(err)
+	}
+	outbox, err := cli.OpenOutbox()
+	if err != nil {
+		fatal(err)
+	}
+	if messageStore != nil {
+		unsubscribe := listener.SubscribeDurable(messageStore.Record)
+		defer unsubscribe()
+	}
+
+	sender, err := messaging.NewSender(radio)
+	if err != nil {
+		fatalf("cannot initialize radio: %v", err)
+	}
+	sender.WithStore(messageStore).WithOutbox(outbox)
+
+	queue, err := cli.OpenQueue()
+	if err != nil {
+		fatal(err)
+	}
+	if queue != nil {
+		go queue.Run(ctx, sender, serveFlags.queueInterval, cli.DefaultTetraFlags.CommandTimeout)
+	}
+
+	server := &http.Server{
+		Addr:    serveFlags.address,
+		Handler: httpapi.NewServer(sender, hub, cli.DefaultTetraFlags.CommandTimeout).WithToken(serveFlags.token),
+	}
+	go func() {
+		radio.WaitUntilClosed(ctx)
+		server.Close()
+	}()
+
+	log.Printf("HTTP API listening on %s", serveFlags.address)
+	err = server.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fatal(err)
+	}
+	if ctx.Err() == nil {
+		fatalf("the connection to the radio is lost")
+	}
+}
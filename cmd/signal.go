@@ -3,6 +3,7 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/ftl/tetra-pei/ctrl"
@@ -42,12 +43,21 @@ func runTraceSignal(ctx context.Context, pei radio.PEI, cmd *cobra.Command, args
 		fatalf("cannot initilize radio: %v", err)
 	}
 
-	scanSignalAndPosition(ctx, pei)
+	printer := cli.NewListPrinter("time", "lat", "lon", "satellites", "signal_dbm")
+	defer printer.Close()
+
+	scanSignalAndPosition(ctx, pei, printer)
 
 	if traceSignalFlags.scanCount == 1 {
 		return
 	}
 
+	disconnected := make(chan struct{})
+	go func() {
+		pei.WaitUntilClosed(ctx)
+		close(disconnected)
+	}()
+
 	closed := make(chan struct{})
 	go func() {
 		defer close(closed)
@@ -60,8 +70,10 @@ func runTraceSignal(ctx context.Context, pei radio.PEI, cmd *cobra.Command, args
 			select {
 			case <-ctx.Done():
 				return
+			case <-disconnected:
+				return
 			case <-scanTicker.C:
-				scanSignalAndPosition(ctx, pei)
+				scanSignalAndPosition(ctx, pei, printer)
 				scanCount++
 				if traceSignalFlags.scanCount > 0 && scanCount >= traceSignalFlags.scanCount {
 					return
@@ -71,9 +83,14 @@ func runTraceSignal(ctx context.Context, pei radio.PEI, cmd *cobra.Command, args
 	}()
 
 	<-closed
+	if ctx.Err() == nil && pei.Closed() {
+		// complete the output, e.g. the JSON array, before exiting
+		printer.Close()
+		fatalf("the connection to the radio is lost")
+	}
 }
 
-func scanSignalAndPosition(ctx context.Context, pei radio.PEI) {
+func scanSignalAndPosition(ctx context.Context, pei radio.PEI, printer *cli.Printer) {
 	lat, lon, sats, timestamp, err := ctrl.RequestGPSPosition(ctx, pei)
 	if err != nil {
 		lat = 0
@@ -81,12 +98,39 @@ func scanSignalAndPosition(ctx context.Context, pei radio.PEI) {
 		sats = 0
 		timestamp = time.Now().UTC()
 	}
-	timeStr := timestamp.Format(time.RFC3339)
 
 	dbm, err := ctrl.RequestSignalStrength(ctx, pei)
 	if err != nil {
 		dbm = 0
 	}
 
-	fmt.Printf("[%s] lat: %f lon: %f satellites: %d signal: %d dBm\n", timeStr, lat, lon, sats, dbm)
+	printer.Print(signalRecord{
+		Time:       timestamp,
+		Lat:        lat,
+		Lon:        lon,
+		Satellites: sats,
+		SignalDBM:  dbm,
+	})
+}
+
+type signalRecord struct {
+	Time       time.Time `json:"time"`
+	Lat        float64   `json:"lat"`
+	Lon        float64   `json:"lon"`
+	Satellites int       `json:"satellites"`
+	SignalDBM  int       `json:"signal_dbm"`
+}
+
+func (r signalRecord) String() string {
+	return fmt.Sprintf("[%s] lat: %f lon: %f satellites: %d signal: %d dBm\n", r.Time.Format(time.RFC3339), r.Lat, r.Lon, r.Satellites, r.SignalDBM)
+}
+
+func (r signalRecord) CSV() []string {
+	return []string{
+		r.Time.Format(time.RFC3339),
+		strconv.FormatFloat(r.Lat, 'f', -1, 64),
+		strconv.FormatFloat(r.Lon, 'f', -1, 64),
+		strconv.Itoa(r.Satellites),
+		strconv.Itoa(r.SignalDBM),
+	}
 }
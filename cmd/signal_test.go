@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ftl/tetra-cli/pkg/cli"
+	"github.com/ftl/tetra-cli/pkg/fakepei"
+)
+
+func TestRunTraceSignal_ClosesTheJSONOutputWhenTheRadioIsLost(t *testing.T) {
+	if os.Getenv(exitEnv) == "" {
+		status, output := runExitingTest(t)
+		if status != 1 {
+			t.Errorf("expected exit status 1, got %d:\n%s", status, output)
+		}
+		if !strings.HasPrefix(output, "[\n  {") || !strings.HasSuffix(output, "\n]\nthe connection to the radio is lost\n") {
+			t.Errorf("the JSON array should be closed:\n%s", output)
+		}
+		return
+	}
+
+	useTestFlags(t)
+	cli.DefaultTetraFlags.Output = string(cli.JSONOutput)
+	traceSignalFlags.scanInterval = 10 * time.Millisecond
+	traceSignalFlags.scanCount = 0
+	pei := fakepei.New(
+		fakepei.Expect("ATZ"),
+		fakepei.Expect("ATE0"),
+		fakepei.Expect("AT+CSCS=8859-1"),
+	)
+	pei.OnUnexpected = func(request string) ([]string, error) {
+		return nil, errors.New("no GPS")
+	}
+	time.AfterFunc(100*time.Millisecond, pei.Disconnect)
+
+	runTraceSignal(testContext(t), pei, traceSignalCmd, nil)
+
+	t.Error("runTraceSignal should exit the process")
+}
@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ftl/tetra-cli/pkg/simulator"
+)
+
+var simulateFlags = struct {
+	issi             string
+	scenarioFilename string
+	interval         time.Duration
+}{}
+
+const defaultSimulateInterval = 1 * time.Minute
+
+var simulateCmd = &cobra.Command{
+	Use:   "simulate",
+	Short: "Simulate a TETRA radio terminal on a pseudo terminal",
+	Long: `Simulate a TETRA radio terminal on a pseudo terminal.
+
+The simulated radio terminal answers the AT commands used by tetra-cli, accepts SDS messages and
+emits unsolicited indications for incoming messages, status messages, voice and talkgroup events.
+Use the printed device name with the --device flag of the other commands.
+
+Without a scenario file, every kind of indication is emitted once in the given interval.`,
+	Run: runSimulate,
+}
+
+func init() {
+	simulateCmd.Flags().StringVar(&simulateFlags.issi, "issi", simulator.DefaultISSI, "the ISSI of the simulated radio terminal")
+	simulateCmd.Flags().StringVar(&simulateFlags.scenarioFilename, "scenario", "", "JSON file that defines the scheduled indications")
+	simulateCmd.Flags().DurationVar(&simulateFlags.interval, "interval", defaultSimulateInterval, "interval of the default scenario")
+
+	rootCmd.AddCommand(simulateCmd)
+}
+
+func runSimulate(cmd *cobra.Command, args []string) {
+	scenario := simulator.DefaultScenario(simulateFlags.interval)
+	if simulateFlags.scenarioFilename != "" {
+		scenarioFile, err := os.Open(simulateFlags.scenarioFilename)
+		if err != nil {
+			fatalf("cannot open scenario file: %v", err)
+		}
+		scenario, err = simulator.ReadScenario(scenarioFile)
+		scenarioFile.Close()
+		if err != nil {
+			fatalf("cannot read scenario file: %v", err)
+		}
+	}
+
+	pty, err := simulator.OpenPTY()
+	if err != nil {
+		fatalf("cannot open pseudo terminal: %v", err)
+	}
+	defer pty.Close()
+
+	fmt.Printf("simulated radio terminal available at %s\n", pty.Name())
+
+	sim := simulator.New(simulator.Config{
+		ISSI:     simulateFlags.issi,
+		Scenario: scenario,
+	})
+	err = sim.Serve(cmd.Context(), pty)
+	if err != nil {
+		fatal(err)
+	}
+}
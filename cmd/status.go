@@ -2,56 +2,99 @@ package cmd
 
 import (
 	"context"
+	"log"
+	"strings"
 
-	"github.com/ftl/tetra-pei/sds"
 	"github.com/ftl/tetra-pei/tetra"
 	"github.com/spf13/cobra"
 
 	"github.com/ftl/tetra-cli/pkg/cli"
+	"github.com/ftl/tetra-cli/pkg/messaging"
 	"github.com/ftl/tetra-cli/pkg/radio"
+	"github.com/ftl/tetra-cli/pkg/store"
 )
 
 var statusFlags = struct {
+	group bool
 }{}
 
 var statusCmd = &cobra.Command{
-	Use:   "status <destination ISSI> <hexstatus>",
+	Use:   "status <destination> <status>",
 	Short: "Send a status message",
-	Run:   cli.RunWithPEIAndTimeout(runStatus, fatal),
+	Long: `Send a status message.
+
+With --group, the destination is a group: either the GSSI or GTSI, or the name of a talkgroup, which is looked up in
+the talkgroups of the current operating mode.
+
+The destination may also be a name, alias, unit or role from the address book (see "tetra-cli addressbook").
+
+The status is given as hex value, e.g. 8005, or by its name or alias from the status catalog (see "tetra-cli statuses").`,
+	Run: cli.RunWithPEIAndTimeout(runStatus, fatal),
 }
 
 func init() {
+	statusCmd.Flags().BoolVar(&statusFlags.group, "group", false, "the destination is a group, given as GSSI, GTSI or the name of a talkgroup")
+
 	rootCmd.AddCommand(statusCmd)
 }
 
 func runStatus(ctx context.Context, pei radio.PEI, cmd *cobra.Command, args []string) {
 	if len(args) < 2 {
-		fatalf("tetra-cli status <destination ISSI> <hexstatus>")
+		fatalf("tetra-cli status <destination> <status>")
 	}
 
-	destISSI := tetra.Identity(args[0])
-	statusBytes, err := tetra.HexToBinary(args[1])
+	addressBook, err := cli.LoadAddressBook()
+	if err != nil {
+		fatal(err)
+	}
+	statusCatalog, err := cli.LoadStatusCatalog()
 	if err != nil {
-		fatalf("wrong status format: %v", err)
+		fatal(err)
 	}
-	status, err := sds.ParseStatus(statusBytes)
+	message, err := statusMessage(addressBook, statusCatalog, args[0], args[1], statusFlags.group)
 	if err != nil {
-		fatalf("not a valid status: %v", err)
+		fatal(err)
 	}
 
 	err = pei.ATs(ctx,
 		"ATZ",
 		"ATE0",
-		"AT+CTSP=2,2,20", // status
-		sds.SwitchToStatus,
 	)
 	if err != nil {
 		fatalf("cannot initialize radio: %v", err)
 	}
 
-	request := sds.SendMessage(destISSI, status.(sds.Encoder))
-	_, err = pei.AT(ctx, request)
+	messageStore, err := cli.OpenMessageStore()
+	if err != nil {
+		fatal(err)
+	}
+	sender, err := messaging.NewSender(pei)
 	if err != nil {
-		fatalf("cannot send status message: %v", err)
+		fatalf("cannot initialize radio: %v", err)
+	}
+	sender.WithStore(messageStore)
+
+	err = sender.SendStatus(ctx, message)
+	if err != nil {
+		fatal(err)
+	}
+}
+
+// statusMessage prepares a status message to the given destination, which may also be a name from the address book.
+// The status is given as hex value or by its name from the status catalog.
+func statusMessage(addressBook *messaging.AddressBook, statusCatalog *messaging.StatusCatalog, destination string, status string, group bool) (messaging.StatusMessage, error) {
+	var result messaging.StatusMessage
+	var err error
+	result.Destination, result.Group, err = resolveDestination(addressBook, tetra.Identity(strings.TrimSpace(destination)), group)
+	if err != nil {
+		return result, err
+	}
+	result.Status, err = statusCatalog.Parse(status)
+	if err != nil {
+		return result, err
+	}
+	if statusCatalog.Unknown(result.Status) {
+		log.Printf("warning: the status %s is not defined in the status catalog", store.FormatStatus(result.Status))
 	}
+	return result, nil
 }
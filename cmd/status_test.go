@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"os"
+	"testing"
+
+	"github.com/ftl/tetra-pei/sds"
+
+	"github.com/ftl/tetra-cli/pkg/cli"
+	"github.com/ftl/tetra-cli/pkg/fakepei"
+	"github.com/ftl/tetra-cli/pkg/store"
+)
+
+func TestRunStatus(t *testing.T) {
+	useTestFlags(t)
+	err := os.WriteFile(cli.DefaultTetraFlags.StatusCatalog, []byte("statuses:\n  - status: \"8005\"\n    name: on scene\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pei := fakepei.New(
+		fakepei.Expect("ATZ"),
+		fakepei.Expect("ATE0"),
+		fakepei.Expect("AT+CTSP=2,2,20"),
+		fakepei.Expect("AT+CTSDS=13,0"),
+		fakepei.Expect(sds.SendMessage("1234567", sds.Status(0x8005)), "+CMGS: 0"),
+	)
+
+	runStatus(testContext(t), pei, statusCmd, []string{"1234567", "on scene"})
+
+	verifyScript(t, pei)
+	messageStore, _ := store.Open(cli.DefaultTetraFlags.MessageStore)
+	messages, err := messageStore.Messages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 || messages[0].Status != "8005" {
+		t.Errorf("the status was not recorded: %+v", messages)
+	}
+}
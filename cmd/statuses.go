@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ftl/tetra-cli/pkg/cli"
+	"github.com/ftl/tetra-cli/pkg/messaging"
+	"github.com/ftl/tetra-cli/pkg/store"
+)
+
+var statusesCmd = &cobra.Command{
+	Use:   "statuses",
+	Short: "List the statuses of the status catalog",
+	Long: `List the statuses defined in the status catalog (see --status-catalog).
+
+The status catalog is a YAML or JSON file that gives names and descriptions to status values, e.g.:
+
+  statuses:
+    - status: "8003"
+      name: available
+      description: Available on the radio
+      aliases: ["1"]
+    - status: "8005"
+      name: on-scene
+      description: Arrived at the scene
+      aliases: ["3"]
+
+Use the names and aliases instead of the hex value with "tetra-cli status", e.g. "tetra-cli status 1234567 on-scene".
+The listen command shows the name and the description of received statuses and flags statuses that are not in the
+catalog as unknown.`,
+	Run: runStatuses,
+}
+
+func init() {
+	rootCmd.AddCommand(statusesCmd)
+}
+
+func runStatuses(cmd *cobra.Command, args []string) {
+	statusCatalog, err := cli.LoadStatusCatalog()
+	if err != nil {
+		fatal(err)
+	}
+
+	printer := cli.NewListPrinter(statusDefinitionHeader...)
+	defer printer.Close()
+	for _, definition := range statusCatalog.Statuses {
+		printer.Print(statusDefinitionRecord{definition})
+	}
+}
+
+var statusDefinitionHeader = []string{"status", "name", "aliases", "description"}
+
+// statusDefinitionRecord prints a status of the status catalog in all output formats.
+type statusDefinitionRecord struct {
+	messaging.StatusDefinition
+}
+
+func (r statusDefinitionRecord) String() string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "%s %s", store.FormatStatus(r.Value), r.Name)
+	if len(r.Aliases) > 0 {
+		fmt.Fprintf(&builder, " (%s)", strings.Join(r.Aliases, ", "))
+	}
+	if r.Description != "" {
+		fmt.Fprintf(&builder, ": %s", r.Description)
+	}
+	builder.WriteString("\n")
+	return builder.String()
+}
+
+func (r statusDefinitionRecord) CSV() []string {
+	return []string{store.FormatStatus(r.Value), r.Name, strings.Join(r.Aliases, " "), r.Description}
+}
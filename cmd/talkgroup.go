@@ -3,9 +3,11 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"log"
 	"strings"
 
 	"github.com/ftl/tetra-cli/pkg/cli"
+	"github.com/ftl/tetra-cli/pkg/messaging"
 	"github.com/ftl/tetra-cli/pkg/radio"
 	"github.com/ftl/tetra-pei/ctrl"
 	"github.com/spf13/cobra"
@@ -15,9 +17,13 @@ var talkgroupFlags = struct {
 }{}
 
 var setTalkgroupCmd = &cobra.Command{
-	Use:   "set-talkgroup <TMO|DMO> [<GTSI>]",
+	Use:   "set-talkgroup <TMO|DMO> [<GTSI>|<name>]",
 	Short: "Set the operating mode and the talk group",
-	Run:   cli.RunWithPEIAndTimeout(runSetTalkgroup, fatal),
+	Long: `Set the operating mode and the talk group.
+
+The talk group is given by its GTSI, by its name or alias in the address book (see "tetra-cli addressbook"), or by
+its name, which is looked up in the talk groups of the given operating mode.`,
+	Run: cli.RunWithPEIAndTimeout(runSetTalkgroup, fatal),
 }
 
 var getTalkgroupCmd = &cobra.Command{
@@ -40,7 +46,7 @@ func init() {
 
 func runSetTalkgroup(ctx context.Context, pei radio.PEI, cmd *cobra.Command, args []string) {
 	if len(args) < 1 {
-		fatalf("tetra-cli set-talkgroup <TMO|DMO> [<GTSI>]")
+		fatalf("tetra-cli set-talkgroup <TMO|DMO> [<GTSI>|<name>]")
 	}
 
 	aiMode, err := ctrl.AIModeByName(args[0])
@@ -48,24 +54,76 @@ func runSetTalkgroup(ctx context.Context, pei radio.PEI, cmd *cobra.Command, arg
 		fatalf("invalid AI mode %s", args[0])
 	}
 
-	var gtsi string
+	var talkgroup string
 	if len(args) > 1 {
-		gtsi = strings.TrimSpace(args[1])
+		talkgroup = strings.TrimSpace(strings.Join(args[1:], " "))
+	}
+
+	addressBook, err := cli.LoadAddressBook()
+	if err != nil {
+		fatal(err)
+	}
+	talkgroup, err = resolveTalkgroup(addressBook, talkgroup)
+	if err != nil {
+		fatal(err)
 	}
 
 	err = pei.ATs(ctx,
 		"ATZ",
 		"ATE0",
 		"AT+CTSP=1,1,11",
-		ctrl.SetOperatingMode(aiMode),
 	)
 	if err != nil {
 		fatalf("cannot initialize radio: %v", err)
 	}
 
+	_, err = switchTalkgroup(ctx, pei, aiMode, talkgroup)
+	if err != nil {
+		fatal(err)
+	}
+}
+
+// resolveTalkgroup returns the GTSI of the given talkgroup if it is a talkgroup from the address book. Other talkgroups
+// are returned unchanged.
+func resolveTalkgroup(addressBook *messaging.AddressBook, talkgroup string) (string, error) {
+	entry, ok, err := addressBook.Resolve(talkgroup)
+	if err != nil {
+		return "", err
+	}
+	if ok && !entry.IsGroup() {
+		return "", fmt.Errorf("%s (%s) is a radio, not a talkgroup", entry.Name, entry.ISSI)
+	}
+	if ok {
+		return entry.GTSI, nil
+	}
+	return talkgroup, nil
+}
+
+// switchTalkgroup switches to the given operating mode and talkgroup. A talkgroup given by name is looked up in the
+// talkgroups of the operating mode. Without a talkgroup, only the operating mode is switched. It returns the GTSI of
+// the talkgroup.
+func switchTalkgroup(ctx context.Context, pei radio.PEI, aiMode ctrl.AIMode, talkgroup string) (string, error) {
+	_, err := pei.AT(ctx, ctrl.SetOperatingMode(aiMode))
+	if err != nil {
+		return "", fmt.Errorf("cannot switch to %s: %w", aiMode, err)
+	}
+
+	gtsi := talkgroup
+	if gtsi != "" && strings.Trim(gtsi, "0123456789") != "" {
+		info, err := messaging.LookupTalkgroup(ctx, pei, gtsi)
+		if err != nil {
+			return "", err
+		}
+		log.Printf("talkgroup %s: %s", strings.TrimSpace(info.Name), info.GTSI)
+		gtsi = info.GTSI
+	}
 	if gtsi != "" {
-		pei.AT(ctx, ctrl.SetTalkgroup(gtsi))
+		_, err = pei.AT(ctx, ctrl.SetTalkgroup(gtsi))
+		if err != nil {
+			return "", fmt.Errorf("cannot switch to the talkgroup %s: %w", gtsi, err)
+		}
 	}
+	return gtsi, nil
 }
 
 func runGetTalkgroup(ctx context.Context, pei radio.PEI, cmd *cobra.Command, args []string) {
@@ -82,13 +140,34 @@ func runGetTalkgroup(ctx context.Context, pei radio.PEI, cmd *cobra.Command, arg
 	if err != nil {
 		fatalf("cannot find out the current operating mode: %v", err)
 	}
-	fmt.Printf("MODE: %s\n", currentAIMode)
 
-	currentTalkgroup, err := ctrl.RequestTalkgroup(ctx, pei)
+	record := currentTalkgroupRecord{Mode: currentAIMode.String()}
+	printer := cli.NewPrinter("mode", "gtsi")
+
+	record.GTSI, err = ctrl.RequestTalkgroup(ctx, pei)
 	if err != nil {
+		// print at least the mode, like before the talkgroup was requested
+		printer.Print(record)
 		fatalf("cannot find out the current talkgroup: %v", err)
 	}
-	fmt.Printf("GTSI: %s\n", currentTalkgroup)
+
+	printer.Print(record)
+}
+
+type currentTalkgroupRecord struct {
+	Mode string `json:"mode"`
+	GTSI string `json:"gtsi,omitempty"`
+}
+
+func (r currentTalkgroupRecord) String() string {
+	if r.GTSI == "" {
+		return fmt.Sprintf("MODE: %s\n", r.Mode)
+	}
+	return fmt.Sprintf("MODE: %s\nGTSI: %s\n", r.Mode, r.GTSI)
+}
+
+func (r currentTalkgroupRecord) CSV() []string {
+	return []string{r.Mode, r.GTSI}
 }
 
 func runGetTalkgroups(ctx context.Context, pei radio.PEI, cmd *cobra.Command, args []string) {
@@ -111,13 +190,16 @@ func runGetTalkgroups(ctx context.Context, pei radio.PEI, cmd *cobra.Command, ar
 			fatalf("cannot switch to TMO: %v", err)
 		}
 	}
+	printer := cli.NewListPrinter("mode", "gtsi", "name")
+	defer printer.Close()
+
 	tmoTalkgroups := make([]ctrl.TalkgroupInfo, 0, 2000)
 	tmoTalkgroups, err = ctrl.RequestTalkgroups(ctx, pei, ctrl.TalkgroupDynamic, tmoTalkgroups)
 	if err != nil {
 		fatalf("cannot read TMO talkgroups: %v", err)
 	}
 	for _, info := range tmoTalkgroups {
-		fmt.Printf("TMO;%s;%s\n", info.GTSI, info.Name)
+		printer.Print(talkgroupRecord{Mode: ctrl.TMO.String(), GTSI: info.GTSI, Name: info.Name})
 	}
 
 	_, err = pei.AT(ctx, ctrl.SetOperatingMode(ctrl.DMO))
@@ -130,7 +212,7 @@ func runGetTalkgroups(ctx context.Context, pei radio.PEI, cmd *cobra.Command, ar
 		fatalf("cannot read DMO talkgroups: %v", err)
 	}
 	for _, info := range dmoTalkgroups {
-		fmt.Printf("DMO;%s;%s\n", info.GTSI, info.Name)
+		printer.Print(talkgroupRecord{Mode: ctrl.DMO.String(), GTSI: info.GTSI, Name: info.Name})
 	}
 
 	if lastMode != ctrl.DMO {
@@ -140,3 +222,17 @@ func runGetTalkgroups(ctx context.Context, pei radio.PEI, cmd *cobra.Command, ar
 		}
 	}
 }
+
+type talkgroupRecord struct {
+	Mode string `json:"mode"`
+	GTSI string `json:"gtsi"`
+	Name string `json:"name"`
+}
+
+func (r talkgroupRecord) String() string {
+	return fmt.Sprintf("%s;%s;%s\n", r.Mode, r.GTSI, r.Name)
+}
+
+func (r talkgroupRecord) CSV() []string {
+	return []string{r.Mode, r.GTSI, r.Name}
+}
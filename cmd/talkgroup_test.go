@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/ftl/tetra-cli/pkg/fakepei"
+)
+
+func TestRunGetTalkgroups(t *testing.T) {
+	useTestFlags(t)
+	pei := fakepei.New(
+		fakepei.Expect("ATZ"),
+		fakepei.Expect("ATE0"),
+		fakepei.Expect("AT+CTOM?", "+CTOM: 1"),
+		fakepei.Expect("AT+CTOM=0"),
+		fakepei.Expect("AT+CNUMD=?", "+CNUMD: (0),(1-2),(1-2)"),
+		fakepei.Expect("AT+CNUMD=0,1,2"),
+		fakepei.Expect("AT+CNUMD?", "+CNUMD: 1,2620011001,Operations", "+CNUMD: 2,2620011002,Fire"),
+		fakepei.Expect("AT+CTOM=1"),
+		fakepei.Expect("AT+CNUMS=?", "+CNUMS: (0),(1-1),(1-1)"),
+		fakepei.Expect("AT+CNUMS=0,1,1"),
+		fakepei.Expect("AT+CNUMS?", "+CNUMS: 1,1001,DMO 1"),
+	)
+
+	output := captureOutput(t, func() {
+		runGetTalkgroups(testContext(t), pei, getTalkgroupsCmd, nil)
+	})
+
+	verifyScript(t, pei)
+	expected := "TMO;2620011001;Operations\nTMO;2620011002;Fire\nDMO;1001;DMO 1\n"
+	if output != expected {
+		t.Errorf("unexpected output:\n%s\nexpected:\n%s", output, expected)
+	}
+}
+
+func TestRunGetTalkgroups_RestoresTheLastMode(t *testing.T) {
+	useTestFlags(t)
+	pei := fakepei.New(
+		fakepei.Expect("ATZ"),
+		fakepei.Expect("ATE0"),
+		fakepei.Expect("AT+CTOM?", "+CTOM: 0"),
+		fakepei.Expect("AT+CNUMD=?", "+CNUMD: (0),(1-1),(1-1)"),
+		fakepei.Expect("AT+CNUMD=0,1,1"),
+		fakepei.Expect("AT+CNUMD?", "+CNUMD: 1,2620011001,Operations"),
+		fakepei.Expect("AT+CTOM=1"),
+		fakepei.Expect("AT+CNUMS=?", "+CNUMS: (0),(1-1),(1-1)"),
+		fakepei.Expect("AT+CNUMS=0,1,1"),
+		fakepei.Expect("AT+CNUMS?", "+CNUMS: 1,1001,DMO 1"),
+		fakepei.Expect("AT+CTOM=0"),
+	)
+
+	captureOutput(t, func() {
+		runGetTalkgroups(testContext(t), pei, getTalkgroupsCmd, nil)
+	})
+
+	verifyScript(t, pei)
+}
+
+func TestRunGetTalkgroup(t *testing.T) {
+	useTestFlags(t)
+	pei := fakepei.New(
+		fakepei.Expect("ATZ"),
+		fakepei.Expect("ATE0"),
+		fakepei.Expect("AT+CTSP=1,1,11"),
+		fakepei.Expect("AT+CTOM?", "+CTOM: 0"),
+		fakepei.Expect("AT+CTGS?", "+CTGS: 1,2620011001"),
+	)
+
+	output := captureOutput(t, func() {
+		runGetTalkgroup(testContext(t), pei, getTalkgroupCmd, nil)
+	})
+
+	verifyScript(t, pei)
+	expected := "MODE: TMO\nGTSI: 2620011001\n"
+	if output != expected {
+		t.Errorf("unexpected output:\n%s\nexpected:\n%s", output, expected)
+	}
+}
+
+func TestCurrentTalkgroupRecord_WithoutTalkgroup(t *testing.T) {
+	record := currentTalkgroupRecord{Mode: "TMO"}
+	if record.String() != "MODE: TMO\n" {
+		t.Errorf("unexpected text %q", record.String())
+	}
+}
@@ -0,0 +1,245 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ftl/tetra-cli/pkg/cli"
+	"github.com/ftl/tetra-cli/pkg/messaging"
+)
+
+var templatesCmd = &cobra.Command{
+	Use:   "templates",
+	Short: "List the message templates",
+	Long: `List the message templates defined in the templates file (see --templates).
+
+The templates file is a JSON object with the templates by their name, e.g.:
+
+  {
+    "alarm": {"text": "ALARM {{code}}: {{address}}", "description": "alarm with code and address"},
+    "callback": {"text": "Please call {{number}}", "defaults": {"number": "112"}}
+  }
+
+Use "tetra-cli send --template <name>" to send a message based on a template.`,
+	Run: runTemplates,
+}
+
+func init() {
+	rootCmd.AddCommand(templatesCmd)
+}
+
+func runTemplates(cmd *cobra.Command, args []string) {
+	templates, err := cli.LoadTemplates()
+	if err != nil {
+		fatal(err)
+	}
+
+	printer := cli.NewListPrinter(templateHeader...)
+	defer printer.Close()
+	for _, name := range templates.Names() {
+		printer.Print(templateRecord{templates[name]})
+	}
+}
+
+var templateHeader = []string{"name", "placeholders", "description", "text"}
+
+// templateRecord prints a message template in all output formats.
+type templateRecord struct {
+	messaging.Template
+}
+
+func (r templateRecord) MarshalJSON() ([]byte, error) {
+	type template messaging.Template
+	return json.Marshal(struct {
+		template
+		Placeholders []string `json:"placeholders"`
+	}{template(r.Template), r.Placeholders()})
+}
+
+func (r templateRecord) String() string {
+	var builder strings.Builder
+	builder.WriteString(r.Name)
+	if placeholders := r.Placeholders(); len(placeholders) > 0 {
+		fmt.Fprintf(&builder, " (%s)", strings.Join(placeholders, ", "))
+	}
+	if r.Description != "" {
+		fmt.Fprintf(&builder, ": %s", r.Description)
+	}
+	fmt.Fprintf(&builder, "\n  %s\n", strings.ReplaceAll(r.Text, "\n", "\n  "))
+	return builder.String()
+}
+
+func (r templateRecord) CSV() []string {
+	return []string{
+		r.Name,
+		strings.Join(r.Placeholders(), " "),
+		r.Description,
+		r.Text,
+	}
+}
+
+// renderSendTemplate fills the template with the given name with the values given through the flags of the send
+// command or the environment.
+func renderSendTemplate(name string) (string, error) {
+	templates, err := cli.LoadTemplates()
+	if err != nil {
+		return "", err
+	}
+	template, err := templates.Get(name)
+	if err != nil {
+		return "", err
+	}
+
+	values, err := templateValues(template)
+	if err != nil {
+		return "", err
+	}
+	return template.Render(values)
+}
+
+// templateValues collects the values for the placeholders of the given template. The values given with --var take
+// precedence over the values given with --vars, which take precedence over the environment.
+func templateValues(template messaging.Template) (map[string]string, error) {
+	result := make(map[string]string)
+	for _, placeholder := range template.Placeholders() {
+		if value, ok := os.LookupEnv(placeholder); ok {
+			result[placeholder] = value
+		}
+	}
+
+	if sendFlags.varsJSON != "" {
+		values, err := readTemplateValues(sendFlags.varsJSON)
+		if err != nil {
+			return nil, err
+		}
+		for name, value := range values {
+			result[name] = value
+		}
+	}
+
+	for _, variable := range sendFlags.vars {
+		name, value, ok := strings.Cut(variable, "=")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("invalid value for a placeholder: %q, use name=value", variable)
+		}
+		result[strings.TrimSpace(name)] = value
+	}
+	return result, nil
+}
+
+// readTemplateValues reads a JSON object with the values for the placeholders of a template. The given argument is
+// either the JSON object itself, the name of a file, or "-" to read from stdin.
+func readTemplateValues(arg string) (map[string]string, error) {
+	var content []byte
+	var err error
+	switch {
+	case strings.HasPrefix(strings.TrimSpace(arg), "{"):
+		content = []byte(arg)
+	case arg == "-":
+		content, err = io.ReadAll(os.Stdin)
+	default:
+		content, err = os.ReadFile(arg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the values for the template: %w", err)
+	}
+
+	var values map[string]any
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	err = decoder.Decode(&values)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the values for the template: %w", err)
+	}
+
+	result := make(map[string]string, len(values))
+	for name, value := range values {
+		switch value := value.(type) {
+		case string:
+			result[name] = value
+		case json.Number, bool:
+			result[name] = fmt.Sprint(value)
+		case nil:
+			result[name] = ""
+		default:
+			return nil, fmt.Errorf("the value for %s must be a string, a number or a boolean", name)
+		}
+	}
+	return result, nil
+}
+
+// printSendPreview prints the text of the prepared messages, their length and the number of parts they need.
+func printSendPreview() {
+	messages := []messaging.TextMessage{sendMessage}
+	if sendFlags.batch != "" {
+		messages = messages[:0]
+		for _, row := range sendBatch {
+			messages = append(messages, row.Message)
+		}
+	}
+
+	printer := cli.NewListPrinter(previewHeader...)
+	defer printer.Close()
+	for _, message := range messages {
+		printer.Print(previewRecord{
+			Destination: string(message.Destination),
+			Text:        message.Text,
+			Preview:     messaging.PreviewText(message),
+		})
+	}
+}
+
+var previewHeader = []string{"destination", "characters", "encoding", "pdu_bits", "max_pdu_bits", "parts", "text"}
+
+// previewRecord prints the preview of a text message in all output formats.
+type previewRecord struct {
+	Destination string
+	Text        string
+	messaging.Preview
+}
+
+func (r previewRecord) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Destination string `json:"destination,omitempty"`
+		Characters  int    `json:"characters"`
+		Encoding    string `json:"encoding"`
+		PDUBits     int    `json:"pdu_bits"`
+		MaxPDUBits  int    `json:"max_pdu_bits"`
+		Parts       int    `json:"parts"`
+		Text        string `json:"text"`
+	}{r.Destination, r.Characters, messaging.EncodingName(r.Encoding), r.PDUBits, r.MaxPDUBits, r.Parts, r.Text})
+}
+
+func (r previewRecord) String() string {
+	var builder strings.Builder
+	if r.Destination != "" {
+		fmt.Fprintf(&builder, "%s: ", r.Destination)
+	}
+	fmt.Fprintf(&builder, "%d characters in %s, %d of %d bits", r.Characters, messaging.EncodingName(r.Encoding), r.PDUBits, r.MaxPDUBits)
+	if r.Parts == 1 {
+		builder.WriteString(", 1 part\n")
+	} else {
+		fmt.Fprintf(&builder, ", %d parts\n", r.Parts)
+	}
+	fmt.Fprintf(&builder, "%s\n", r.Text)
+	return builder.String()
+}
+
+func (r previewRecord) CSV() []string {
+	return []string{
+		r.Destination,
+		strconv.Itoa(r.Characters),
+		messaging.EncodingName(r.Encoding),
+		strconv.Itoa(r.PDUBits),
+		strconv.Itoa(r.MaxPDUBits),
+		strconv.Itoa(r.Parts),
+		r.Text,
+	}
+}
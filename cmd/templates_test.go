@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ftl/tetra-cli/pkg/cli"
+)
+
+// useTestTemplateFlags resets the template flags of the send command while the test runs.
+func useTestTemplateFlags(t *testing.T) {
+	t.Helper()
+	vars, varsJSON := sendFlags.vars, sendFlags.varsJSON
+	t.Cleanup(func() {
+		sendFlags.vars = vars
+		sendFlags.varsJSON = varsJSON
+	})
+	sendFlags.vars = nil
+	sendFlags.varsJSON = ""
+}
+
+func TestRenderSendTemplate(t *testing.T) {
+	useTestFlags(t)
+	useTestTemplateFlags(t)
+	os.WriteFile(cli.DefaultTetraFlags.Templates, []byte(`{
+		"alarm": {"text": "{{code}} {{address}} {{unit}} {{number}}", "defaults": {"number": "112"}}
+	}`), 0600)
+	t.Setenv("code", "env-code")
+	t.Setenv("address", "env-address")
+	t.Setenv("unit", "env-unit")
+
+	got, err := renderSendTemplate("alarm")
+	if err != nil || got != "env-code env-address env-unit 112" {
+		t.Errorf("the environment should fill the placeholders, got %q %v", got, err)
+	}
+
+	sendFlags.varsJSON = `{"code": "json-code", "address": 4, "number": null}`
+	got, err = renderSendTemplate("alarm")
+	if err != nil || got != "json-code 4 env-unit " {
+		t.Errorf("--vars should take precedence over the environment, got %q %v", got, err)
+	}
+
+	sendFlags.vars = []string{"code=flag-code", " unit =flag=unit"}
+	got, err = renderSendTemplate("alarm")
+	if err != nil || got != "flag-code 4 flag=unit " {
+		t.Errorf("--var should take precedence over --vars, got %q %v", got, err)
+	}
+
+	valuesFile := filepath.Join(t.TempDir(), "values.json")
+	os.WriteFile(valuesFile, []byte(`{"address": "Main St 1", "number": true}`), 0600)
+	sendFlags.varsJSON = valuesFile
+	got, err = renderSendTemplate("alarm")
+	if err != nil || got != "flag-code Main St 1 flag=unit true" {
+		t.Errorf("--vars should read the file, got %q %v", got, err)
+	}
+
+	for name, flags := range map[string]struct {
+		vars     []string
+		varsJSON string
+	}{
+		"invalid --var":  {vars: []string{"code"}},
+		"empty name":     {vars: []string{" =x"}},
+		"invalid --vars": {varsJSON: `{"code": {"value": 1}}`},
+		"missing file":   {varsJSON: filepath.Join(t.TempDir(), "missing.json")},
+	} {
+		sendFlags.vars, sendFlags.varsJSON = flags.vars, flags.varsJSON
+		if _, err := renderSendTemplate("alarm"); err == nil {
+			t.Errorf("%s should be rejected", name)
+		}
+	}
+
+	sendFlags.vars, sendFlags.varsJSON = nil, ""
+	if _, err := renderSendTemplate("fire"); err == nil {
+		t.Error("an unknown template should be rejected")
+	}
+}
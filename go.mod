@@ -5,15 +5,23 @@ go 1.25.4
 // replace github.com/ftl/tetra-pei => ../tetra-pei
 
 require (
+	github.com/eclipse/paho.mqtt.golang v1.5.1
+	github.com/emersion/go-sasl v0.0.0-20200509203442-7bfe0ed36a21
+	github.com/emersion/go-smtp v0.15.0
 	github.com/ftl/tetra-pei v1.4.3
+	github.com/gorilla/websocket v1.5.3
 	github.com/spf13/cobra v1.9.1
+	golang.org/x/sys v0.38.0
+	golang.org/x/text v0.29.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	github.com/hedhyw/Go-Serial-Detector v1.0.0-rc1 // indirect
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
 	github.com/jacobsa/go-serial v0.0.0-20180131005756-15cf729a72d4 // indirect
+	github.com/kr/text v0.2.0 // indirect
 	github.com/spf13/pflag v1.0.6 // indirect
-	golang.org/x/sys v0.38.0 // indirect
-	golang.org/x/text v0.21.0 // indirect
+	golang.org/x/net v0.44.0 // indirect
+	golang.org/x/sync v0.17.0 // indirect
 )
@@ -4,15 +4,20 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"io"
+	"log"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/ftl/tetra-pei/serial"
 	"github.com/spf13/cobra"
 
+	"github.com/ftl/tetra-cli/pkg/daemon"
+	"github.com/ftl/tetra-cli/pkg/messaging"
 	"github.com/ftl/tetra-cli/pkg/radio"
+	"github.com/ftl/tetra-cli/pkg/store"
+	"github.com/ftl/tetra-cli/pkg/trace"
 )
 
 // DefaultTetraFlags defines default flags for TETRA commands:
@@ -26,8 +31,57 @@ var DefaultTetraFlags = struct {
 	// TracePEIFilename is the name of the file used to trace the PEI communication, defined through the hidden flag "trace-pei"
 	// The PEI communication is only traced, if this flag is set to a valid filename.
 	TracePEIFilename string
+
+	// Reconnect defines if long running commands reconnect to the radio after the connection was lost, defined through
+	// the "reconnect" flag of these commands (see InitReconnectFlags).
+	Reconnect bool
+
+	// ReconnectBackoff is the time to wait before the first reconnect attempt. It is doubled with every failed attempt.
+	ReconnectBackoff time.Duration
+
+	// ReconnectMaxBackoff is the maximum time to wait between two reconnect attempts.
+	ReconnectMaxBackoff time.Duration
+
+	// ReplayFilename is the name of a PEI trace file, defined through the hidden flag "replay".
+	// If this flag is set, the last session in the trace file is replayed instead of connecting to a device.
+	ReplayFilename string
+
+	// DaemonSocket is the path of the daemon's Unix domain socket. If a daemon is listening on this socket,
+	// commands use the daemon to access the radio instead of opening the device.
+	DaemonSocket string
+
+	// NoDaemon defines that commands always open the device, even if a daemon is running.
+	NoDaemon bool
+
+	// MessageStore is the path of the file that records all incoming and outgoing text and status messages.
+	// If it is empty, no messages are recorded.
+	MessageStore string
+
+	// Outbox is the path of the file that keeps track of the delivery state of outgoing text messages.
+	// If it is empty, the delivery state is only tracked by the sending command.
+	Outbox string
+
+	// Queue is the path of the file that keeps outgoing text messages for automatic retries.
+	Queue string
+
+	// Templates is the path of the file that defines the message templates.
+	Templates string
+
+	// AddressBook is the path of the file that maps names, units and roles to ISSIs and GTSIs.
+	AddressBook string
+
+	// StatusCatalog is the path of the file that defines the names of the statuses.
+	StatusCatalog string
+
+	// Output is the name of the output format: text, json, ndjson or csv.
+	Output string
 }{}
 
+const (
+	defaultReconnectBackoff    = 1 * time.Second
+	defaultReconnectMaxBackoff = 1 * time.Minute
+)
+
 var DefaultFatalErrorHandler func(error) = func(err error) {
 	fmt.Println(err)
 	os.Exit(1)
@@ -37,26 +91,65 @@ var DefaultFatalErrorHandler func(error) = func(err error) {
 func InitDefaultTetraFlags(command *cobra.Command, defaultCommandTimeout time.Duration) {
 	command.PersistentFlags().StringVar(&DefaultTetraFlags.Device, "device", "", "serial communication device (leave empty for auto detection)")
 	command.PersistentFlags().DurationVar(&DefaultTetraFlags.CommandTimeout, "commandTimeout", defaultCommandTimeout, "timeout for commands")
+	command.PersistentFlags().StringVar(&DefaultTetraFlags.Output, "output", string(TextOutput), "output format: text, json, ndjson, csv")
+	command.PersistentFlags().StringVar(&DefaultTetraFlags.DaemonSocket, "daemon-socket", daemon.DefaultSocketPath(), "path of the daemon's socket")
+	command.PersistentFlags().BoolVar(&DefaultTetraFlags.NoDaemon, "no-daemon", false, "do not use a running daemon, open the device directly")
+	command.PersistentFlags().StringVar(&DefaultTetraFlags.MessageStore, "message-store", store.DefaultPath(), "file that records all incoming and outgoing messages (empty to disable)")
+	command.PersistentFlags().StringVar(&DefaultTetraFlags.Outbox, "outbox", messaging.DefaultOutboxPath(), "file that tracks the delivery state of outgoing messages (empty to disable)")
+	command.PersistentFlags().StringVar(&DefaultTetraFlags.Templates, "templates", messaging.DefaultTemplatesPath(), "file that defines the message templates")
+	command.PersistentFlags().StringVar(&DefaultTetraFlags.AddressBook, "address-book", messaging.DefaultAddressBookPath(), "file that maps names, units and roles to ISSIs and GTSIs")
+	command.PersistentFlags().StringVar(&DefaultTetraFlags.StatusCatalog, "status-catalog", messaging.DefaultStatusCatalogPath(), "file that defines the names of the statuses")
+	command.PersistentFlags().StringVar(&DefaultTetraFlags.Queue, "queue", messaging.DefaultQueuePath(), "file that keeps outgoing messages for automatic retries (empty to disable)")
 
 	// the trace-pei flag is hidden as it is mainly targeted at deveolpers
 	command.PersistentFlags().StringVar(&DefaultTetraFlags.TracePEIFilename, "trace-pei", "", "filename for tracing the PEI communication")
 	command.PersistentFlags().MarkHidden("trace-pei")
+	command.PersistentFlags().StringVar(&DefaultTetraFlags.ReplayFilename, "replay", "", "filename of a PEI trace to replay instead of connecting to a device")
+	command.PersistentFlags().MarkHidden("replay")
 }
 
-// RunWithPEIAndTimeout returns a cobra command function, that is executed using the PEI device defined in the "device" flag.
-// The initializer is used to initialize the radio.
+// InitReconnectFlags adds the flags that control reconnecting to the given long running command. Commands without
+// these flags do not reconnect.
+func InitReconnectFlags(command *cobra.Command) {
+	command.Flags().BoolVar(&DefaultTetraFlags.Reconnect, "reconnect", true, "reconnect to the radio after the connection was lost")
+	command.Flags().DurationVar(&DefaultTetraFlags.ReconnectBackoff, "reconnect-backoff", defaultReconnectBackoff, "time to wait before the first reconnect attempt, doubled with every failed attempt")
+	command.Flags().DurationVar(&DefaultTetraFlags.ReconnectMaxBackoff, "reconnect-max-backoff", defaultReconnectMaxBackoff, "maximum time to wait between two reconnect attempts")
+}
+
+// RunWithRadio returns a cobra command function, that is executed using the PEI device defined in the "device" flag.
+// The initializer is used to initialize the radio. If the command has the "reconnect" flag (see InitReconnectFlags)
+// and it is set, the radio reconnects to the PEI device after the connection was lost and is initialized again.
 // The fatalErrorHandler is invoked to handle any error that cannot be handled otherwise (e.g. the given device filename is invalid).
 func RunWithRadio(run func(context.Context, *radio.Radio, *cobra.Command, []string), initializer radio.Initializer, fatalErrorHandler func(error)) func(*cobra.Command, []string) {
-	return RunWithPEI(func(ctx context.Context, pei radio.PEI, cmd *cobra.Command, args []string) {
-		radio, err := radio.Open(ctx, pei, initializer)
+	return func(cmd *cobra.Command, args []string) {
+		if fatalErrorHandler == nil {
+			fatalErrorHandler = DefaultFatalErrorHandler
+		}
+
+		rootCtx := cmd.Context()
+
+		connect := func(context.Context) (radio.PEI, error) {
+			return openPEI()
+		}
+		reconnectConfig := radio.ReconnectConfig{
+			Enabled:           DefaultTetraFlags.Reconnect && DefaultTetraFlags.ReplayFilename == "",
+			InitialBackoff:    DefaultTetraFlags.ReconnectBackoff,
+			MaxBackoff:        DefaultTetraFlags.ReconnectMaxBackoff,
+			InitializeTimeout: DefaultTetraFlags.CommandTimeout,
+			OnEvent: func(event radio.Event) {
+				log.Print(event)
+			},
+		}
+		defer closeTracePEIWriter()
+		radio, err := radio.Connect(rootCtx, connect, initializer, reconnectConfig)
 		if err != nil {
 			fatalErrorHandler(err)
 		}
 
-		run(ctx, radio, cmd, args)
+		run(rootCtx, radio, cmd, args)
 
 		radio.Close()
-	}, fatalErrorHandler)
+	}
 }
 
 // RunWithPEIAndTimeout returns a cobra command function, that is executed using the PEI device defined in the "device" flag.
@@ -79,30 +172,14 @@ func RunWithPEI(run func(context.Context, radio.PEI, *cobra.Command, []string),
 			fatalErrorHandler = DefaultFatalErrorHandler
 		}
 
-		var err error
 		rootCtx := cmd.Context()
 
-		tracePEIFile, err := setupTracePEI()
-		if err != nil {
-			fatalErrorHandler(fmt.Errorf("cannot access PEI trace file: %v", err))
-		}
-
-		portName, err := FindRadioPortName()
+		defer closeTracePEIWriter()
+		pei, err := openPEI()
 		if err != nil {
 			fatalErrorHandler(err)
 		}
 
-		var pei radio.PEI
-		if tracePEIFile != nil {
-			defer tracePEIFile.Close()
-			pei, err = serial.OpenWithTrace(portName, tracePEIFile)
-		} else {
-			pei, err = serial.Open(portName)
-		}
-		if err != nil {
-			fatalErrorHandler(fmt.Errorf("cannot connect to radio: %v", err))
-		}
-
 		err = pei.ClearSyntaxErrors(rootCtx)
 		if err != nil {
 			fatalErrorHandler(fmt.Errorf("cannot initialize radio: %v", err))
@@ -118,12 +195,152 @@ func RunWithPEI(run func(context.Context, radio.PEI, *cobra.Command, []string),
 	}
 }
 
-func setupTracePEI() (io.WriteCloser, error) {
-	if DefaultTetraFlags.TracePEIFilename == "" {
+// OpenMessageStore opens the message store defined through the "message-store" flag.
+// If the flag is empty, nil is returned.
+func OpenMessageStore() (*store.Store, error) {
+	if DefaultTetraFlags.MessageStore == "" {
+		return nil, nil
+	}
+	return store.Open(DefaultTetraFlags.MessageStore)
+}
+
+// OpenOutbox opens the outbox defined through the "outbox" flag.
+// If the flag is empty, nil is returned.
+func OpenOutbox() (*messaging.Outbox, error) {
+	if DefaultTetraFlags.Outbox == "" {
 		return nil, nil
 	}
+	return messaging.OpenOutbox(DefaultTetraFlags.Outbox)
+}
+
+// OpenQueue opens the outgoing queue defined through the "queue" flag.
+// If the flag is empty, nil is returned.
+func OpenQueue() (*messaging.Queue, error) {
+	if DefaultTetraFlags.Queue == "" {
+		return nil, nil
+	}
+	return messaging.OpenQueue(DefaultTetraFlags.Queue)
+}
+
+// LoadTemplates loads the message templates defined through the "templates" flag.
+// If the file does not exist, no templates are defined.
+func LoadTemplates() (messaging.Templates, error) {
+	if DefaultTetraFlags.Templates == "" {
+		return messaging.Templates{}, nil
+	}
+	return messaging.LoadTemplates(DefaultTetraFlags.Templates)
+}
+
+// LoadAddressBook loads the address book defined through the "address-book" flag.
+// If the file does not exist, the address book is empty.
+func LoadAddressBook() (*messaging.AddressBook, error) {
+	if DefaultTetraFlags.AddressBook == "" {
+		return &messaging.AddressBook{}, nil
+	}
+	return messaging.LoadAddressBook(DefaultTetraFlags.AddressBook)
+}
+
+// LoadStatusCatalog loads the status catalog defined through the "status-catalog" flag.
+// If the file does not exist, the status catalog is empty.
+func LoadStatusCatalog() (*messaging.StatusCatalog, error) {
+	if DefaultTetraFlags.StatusCatalog == "" {
+		return &messaging.StatusCatalog{}, nil
+	}
+	return messaging.LoadStatusCatalog(DefaultTetraFlags.StatusCatalog)
+}
+
+// openPEI opens the PEI defined through the default TETRA flags. This is either the replay of a PEI trace, a running
+// daemon, or the given serial device. If a trace file is defined, the PEI communication is traced.
+func openPEI() (radio.PEI, error) {
+	if DefaultTetraFlags.ReplayFilename != "" {
+		return openReplay(DefaultTetraFlags.ReplayFilename)
+	}
+
+	pei, err := openDaemonOrDevice()
+	if err != nil {
+		return nil, err
+	}
+
+	if DefaultTetraFlags.TracePEIFilename == "" {
+		return pei, nil
+	}
+	writer, err := openTracePEIWriter()
+	if err != nil {
+		pei.Close()
+		return nil, fmt.Errorf("cannot access PEI trace file: %v", err)
+	}
+	return trace.Wrap(pei, writer), nil
+}
+
+// tracePEIWriter writes the PEI trace of all connections of this process, each connection as a new session.
+var (
+	tracePEIWriter *trace.Writer
+	tracePEILock   sync.Mutex
+)
+
+// openTracePEIWriter opens the PEI trace file with the first connection and returns the same writer for all further
+// connections.
+func openTracePEIWriter() (*trace.Writer, error) {
+	tracePEILock.Lock()
+	defer tracePEILock.Unlock()
+	if tracePEIWriter != nil {
+		return tracePEIWriter, nil
+	}
+	tracePEIFile, err := os.OpenFile(DefaultTetraFlags.TracePEIFilename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	tracePEIWriter = trace.NewWriter(tracePEIFile)
+	return tracePEIWriter, nil
+}
+
+func closeTracePEIWriter() {
+	tracePEILock.Lock()
+	defer tracePEILock.Unlock()
+	if tracePEIWriter == nil {
+		return
+	}
+	tracePEIWriter.Close()
+	tracePEIWriter = nil
+}
+
+// openDaemonOrDevice connects to a running daemon, or opens the serial device if no daemon is available.
+func openDaemonOrDevice() (radio.PEI, error) {
+	if !DefaultTetraFlags.NoDaemon && DefaultTetraFlags.DaemonSocket != "" {
+		client, err := daemon.Dial(DefaultTetraFlags.DaemonSocket)
+		if err == nil {
+			return client, nil
+		}
+	}
+
+	portName, err := FindRadioPortName()
+	if err != nil {
+		return nil, err
+	}
+
+	pei, err := serial.Open(portName)
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to radio: %v", err)
+	}
+	return pei, nil
+}
+
+func openReplay(filename string) (radio.PEI, error) {
+	traceFile, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open PEI trace file: %v", err)
+	}
+	defer traceFile.Close()
+
+	sessions, err := trace.Read(traceFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read PEI trace file: %v", err)
+	}
+	if len(sessions) == 0 {
+		return nil, fmt.Errorf("the PEI trace file %s is empty", filename)
+	}
 
-	return os.OpenFile(DefaultTetraFlags.TracePEIFilename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	return trace.Replay(sessions[len(sessions)-1]), nil
 }
 
 // FindRadioPortName returns the filename for the first TETRA device it can find.
@@ -0,0 +1,183 @@
+package cli
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// OutputFormat defines how commands print their results.
+type OutputFormat string
+
+// All supported output formats.
+const (
+	// TextOutput is the human readable format.
+	TextOutput OutputFormat = "text"
+	// JSONOutput prints a single JSON document: an object for commands with a single result, an array for commands with a list of results.
+	JSONOutput OutputFormat = "json"
+	// NDJSONOutput prints one JSON object per line.
+	NDJSONOutput OutputFormat = "ndjson"
+	// CSVOutput prints a header line and one line per result.
+	CSVOutput OutputFormat = "csv"
+)
+
+var outputFormats = []OutputFormat{TextOutput, JSONOutput, NDJSONOutput, CSVOutput}
+
+// ParseOutputFormat returns the output format with the given name.
+func ParseOutputFormat(name string) (OutputFormat, error) {
+	format := OutputFormat(strings.ToLower(strings.TrimSpace(name)))
+	for _, f := range outputFormats {
+		if f == format {
+			return format, nil
+		}
+	}
+	return "", fmt.Errorf("unknown output format %q, use one of text, json, ndjson, csv", name)
+}
+
+// ValidateOutputFlag checks if the output format defined through the "output" flag is valid.
+func ValidateOutputFlag() error {
+	_, err := ParseOutputFormat(DefaultTetraFlags.Output)
+	return err
+}
+
+// Record is a result of a command that can be printed in all output formats. The JSON representation
+// is defined through the usual encoding/json mechanisms.
+type Record interface {
+	// String returns the human readable representation, including the trailing line break.
+	String() string
+	// CSV returns the values of the CSV columns.
+	CSV() []string
+}
+
+// Printer prints the results of a command in the output format defined through the "output" flag.
+// It is safe for concurrent use.
+type Printer struct {
+	lock   sync.Mutex
+	w      io.Writer
+	format OutputFormat
+	list   bool
+	header []string
+	csv    *csv.Writer
+	count  int
+	closed bool
+}
+
+// NewPrinter returns a printer for a command that prints a single result. The given header defines
+// the names of the CSV columns.
+func NewPrinter(header ...string) *Printer {
+	return newPrinter(os.Stdout, false, header)
+}
+
+// NewListPrinter returns a printer for a command that prints a list or a stream of results. The given header defines
+// the names of the CSV columns. The printer must be closed to complete the JSON output.
+func NewListPrinter(header ...string) *Printer {
+	return newPrinter(os.Stdout, true, header)
+}
+
+func newPrinter(w io.Writer, list bool, header []string) *Printer {
+	format, err := ParseOutputFormat(DefaultTetraFlags.Output)
+	if err != nil {
+		format = TextOutput
+	}
+	return &Printer{
+		w:      w,
+		format: format,
+		list:   list,
+		header: header,
+		csv:    csv.NewWriter(w),
+	}
+}
+
+// Format returns the output format of this printer.
+func (p *Printer) Format() OutputFormat {
+	return p.format
+}
+
+// Print the given record. Records are not printed anymore after the printer is closed.
+func (p *Printer) Print(record Record) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.closed {
+		return nil
+	}
+	defer func() { p.count++ }()
+
+	switch p.format {
+	case JSONOutput:
+		return p.printJSON(record)
+	case NDJSONOutput:
+		return json.NewEncoder(p.w).Encode(record)
+	case CSVOutput:
+		if p.count == 0 {
+			p.csv.Write(p.header)
+		}
+		p.csv.Write(record.CSV())
+		p.csv.Flush()
+		return p.csv.Error()
+	default:
+		_, err := fmt.Fprint(p.w, record.String())
+		return err
+	}
+}
+
+func (p *Printer) printJSON(record Record) error {
+	data, err := json.MarshalIndent(record, p.jsonIndent(), "  ")
+	if err != nil {
+		return err
+	}
+	if !p.list {
+		_, err = fmt.Fprintf(p.w, "%s\n", data)
+		return err
+	}
+	if p.count == 0 {
+		_, err = fmt.Fprintf(p.w, "[\n  %s", data)
+	} else {
+		_, err = fmt.Fprintf(p.w, ",\n  %s", data)
+	}
+	return err
+}
+
+func (p *Printer) jsonIndent() string {
+	if p.list {
+		return "  "
+	}
+	return ""
+}
+
+// Close completes the output. For lists in JSON format, it closes the array. For commands without results,
+// it prints an empty array in JSON format and the header line in CSV format.
+func (p *Printer) Close() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+
+	switch p.format {
+	case JSONOutput:
+		if !p.list {
+			return nil
+		}
+		var err error
+		if p.count == 0 {
+			_, err = fmt.Fprint(p.w, "[]\n")
+		} else {
+			_, err = fmt.Fprint(p.w, "\n]\n")
+		}
+		return err
+	case CSVOutput:
+		if p.count == 0 {
+			p.csv.Write(p.header)
+			p.csv.Flush()
+		}
+		return p.csv.Error()
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,234 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ftl/tetra-cli/pkg/radio"
+)
+
+var _ radio.PEI = (*Client)(nil)
+
+// indicationQueueSize is the number of indications that are buffered until they are handled. Further indications
+// are dropped.
+const indicationQueueSize = 100
+
+// Client is connected to a daemon and implements the radio.PEI interface.
+type Client struct {
+	conn net.Conn
+
+	writeLock sync.Mutex
+	encoder   *json.Encoder
+
+	lock               sync.Mutex
+	nextID             uint64
+	pending            map[uint64]chan message
+	indications        map[string]func([]string)
+	disconnectCallback func()
+
+	indicationQueue chan message
+	closed          chan struct{}
+}
+
+// Dial connects to the daemon listening on the Unix domain socket with the given path.
+func Dial(socketPath string) (*Client, error) {
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Client{
+		conn:            conn,
+		encoder:         json.NewEncoder(conn),
+		pending:         make(map[uint64]chan message),
+		indications:     make(map[string]func([]string)),
+		indicationQueue: make(chan message, indicationQueueSize),
+		closed:          make(chan struct{}),
+	}
+	go result.readLoop()
+	go result.indicationLoop()
+
+	return result, nil
+}
+
+func (c *Client) readLoop() {
+	defer func() {
+		c.lock.Lock()
+		callback := c.disconnectCallback
+		c.pending = nil
+		c.lock.Unlock()
+
+		close(c.indicationQueue)
+		close(c.closed)
+		if callback != nil {
+			callback()
+		}
+	}()
+
+	decoder := json.NewDecoder(c.conn)
+	for {
+		var msg message
+		err := decoder.Decode(&msg)
+		if err != nil {
+			return
+		}
+
+		switch msg.Op {
+		case opResponse:
+			c.lock.Lock()
+			response, ok := c.pending[msg.ID]
+			delete(c.pending, msg.ID)
+			c.lock.Unlock()
+			if ok {
+				response <- msg
+			}
+		case opIndication:
+			// never block the read loop, otherwise the responses to the requests of the handlers are not read
+			select {
+			case c.indicationQueue <- msg:
+			default:
+				log.Printf("too many indications are waiting, dropping indication %s", msg.Prefix)
+			}
+		}
+	}
+}
+
+// indicationLoop calls the indication handlers outside of the read loop, so that the handlers may send requests.
+func (c *Client) indicationLoop() {
+	for msg := range c.indicationQueue {
+		c.lock.Lock()
+		handler, ok := c.indications[msg.Prefix]
+		c.lock.Unlock()
+		if ok {
+			handler(msg.Lines)
+		}
+	}
+}
+
+func (c *Client) Close() {
+	c.conn.Close()
+}
+
+func (c *Client) Closed() bool {
+	select {
+	case <-c.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *Client) WaitUntilClosed(ctx context.Context) {
+	select {
+	case <-c.closed:
+	case <-ctx.Done():
+	}
+}
+
+func (c *Client) OnDisconnect(callback func()) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.disconnectCallback = callback
+}
+
+func (c *Client) AddIndication(prefix string, trailingLines int, handler func(lines []string)) error {
+	c.lock.Lock()
+	_, registered := c.indications[prefix]
+	c.indications[prefix] = handler
+	c.lock.Unlock()
+	if registered {
+		// the daemon already sends these indications to this client, only the handler is replaced
+		return nil
+	}
+
+	_, err := c.roundTrip(context.Background(), message{
+		Op:            opIndication,
+		Prefix:        prefix,
+		TrailingLines: trailingLines,
+	})
+	if err != nil {
+		c.lock.Lock()
+		delete(c.indications, prefix)
+		c.lock.Unlock()
+	}
+	return err
+}
+
+func (c *Client) ClearSyntaxErrors(ctx context.Context) error {
+	_, err := c.AT(ctx, "AT")
+	return err
+}
+
+func (c *Client) Request(ctx context.Context, request string) ([]string, error) {
+	return c.AT(ctx, request)
+}
+
+func (c *Client) AT(ctx context.Context, request string) ([]string, error) {
+	msg := message{
+		Op:      opAT,
+		Request: request,
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		msg.Timeout = int64(max(time.Until(deadline), time.Millisecond))
+	}
+	response, err := c.roundTrip(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+	return response.Lines, nil
+}
+
+func (c *Client) ATs(ctx context.Context, requests ...string) error {
+	for _, request := range requests {
+		_, err := c.AT(ctx, request)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Client) roundTrip(ctx context.Context, request message) (message, error) {
+	c.lock.Lock()
+	if c.pending == nil {
+		c.lock.Unlock()
+		return message{}, errors.New("the connection to the daemon is closed")
+	}
+	c.nextID++
+	request.ID = c.nextID
+	response := make(chan message, 1)
+	c.pending[request.ID] = response
+	c.lock.Unlock()
+
+	c.writeLock.Lock()
+	err := c.encoder.Encode(request)
+	c.writeLock.Unlock()
+	if err != nil {
+		c.forget(request.ID)
+		return message{}, fmt.Errorf("cannot send request to daemon: %w", err)
+	}
+
+	select {
+	case msg := <-response:
+		return msg, errorFromMessage(msg)
+	case <-c.closed:
+		return message{}, errors.New("the connection to the daemon is closed")
+	case <-ctx.Done():
+		c.forget(request.ID)
+		return message{}, ctx.Err()
+	}
+}
+
+func (c *Client) forget(id uint64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.pending != nil {
+		delete(c.pending, id)
+	}
+}
@@ -0,0 +1,147 @@
+package daemon
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ftl/tetra-cli/pkg/fakepei"
+	"github.com/ftl/tetra-cli/pkg/radio"
+)
+
+// startServer serves the radio with the given fake PEI on a socket in a temporary directory and returns a connected
+// client.
+func startServer(t *testing.T, pei *fakepei.PEI) *Client {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	r, err := radio.Open(ctx, pei, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	socketPath := filepath.Join(t.TempDir(), "daemon.sock")
+	listener, err := Listen(socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go NewServer(r).Serve(ctx, listener)
+
+	client, err := Dial(socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.Close)
+	return client
+}
+
+func initialization() []fakepei.Exchange {
+	return []fakepei.Exchange{
+		fakepei.Expect("ATZ"),
+		fakepei.Expect("ATE0"),
+		fakepei.Expect("AT+CSCS=8859-1"),
+	}
+}
+
+func TestClient_AT(t *testing.T) {
+	pei := fakepei.New(append(initialization(), fakepei.Expect("AT+CTOM?", "+CTOM: 0"))...)
+	client := startServer(t, pei)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	_, err := client.AT(ctx, "ATZ")
+	if err != nil {
+		t.Fatalf("ATZ failed: %v", err)
+	}
+	response, err := client.AT(ctx, "AT+CTOM?")
+	if err != nil {
+		t.Fatalf("AT+CTOM? failed: %v", err)
+	}
+	if len(response) != 1 || response[0] != "+CTOM: 0" {
+		t.Errorf("unexpected response %v", response)
+	}
+	if err := pei.Verify(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestClient_IndicationHandlerCanSendRequests(t *testing.T) {
+	pei := fakepei.New(initialization()...)
+	pei.OnUnexpected = func(request string) ([]string, error) {
+		return []string{"+CSQ: 20,99"}, nil
+	}
+	client := startServer(t, pei)
+
+	done := make(chan error, 1)
+	err := client.AddIndication("+CTOM: ", 0, func(lines []string) {
+		// let the other indications arrive before sending the request
+		time.Sleep(100 * time.Millisecond)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_, err := client.AT(ctx, "AT+CSQ?")
+		select {
+		case done <- err:
+		default:
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// more indications than the client can buffer while the handler is busy
+	for i := range 5 * indicationQueueSize {
+		pei.Indicate("+CTOM: 1")
+		if i%10 == 0 {
+			// give the server time to send the indications
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("the request of the handler failed: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the request of the handler did not complete")
+	}
+}
+
+func TestClient_AddIndicationSubscribesOnlyOnce(t *testing.T) {
+	pei := fakepei.New(initialization()...)
+	client := startServer(t, pei)
+
+	received := make(chan string, 10)
+	for _, name := range []string{"first", "second", "third"} {
+		err := client.AddIndication("+CTOM: ", 0, func([]string) {
+			received <- name
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pei.Indicate("+CTOM: 1")
+
+	select {
+	case name := <-received:
+		if name != "third" {
+			t.Errorf("the latest handler should be called, got the %s", name)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the indication did not arrive")
+	}
+	select {
+	case name := <-received:
+		t.Errorf("the indication should arrive only once, got it again for the %s handler", name)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestSession_PushAfterClose(t *testing.T) {
+	session := &session{outgoing: make(chan message, 1)}
+	session.close()
+
+	session.push(message{Op: opIndication, Prefix: "+CTOM: "})
+}
@@ -0,0 +1,41 @@
+// Package daemon allows several tetra-cli processes to share one radio. The daemon owns the radio and
+// accepts clients on a Unix domain socket. A client implements the radio.PEI interface and forwards all
+// requests and indication registrations to the daemon.
+//
+// The protocol consists of JSON objects, one per line. The client sends "at" requests and "indication"
+// registrations, the daemon answers with "response" messages and pushes "indication" messages for all
+// indications the client registered.
+package daemon
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const (
+	opAT         = "at"
+	opIndication = "indication"
+	opResponse   = "response"
+)
+
+type message struct {
+	ID            uint64   `json:"id,omitempty"`
+	Op            string   `json:"op"`
+	Request       string   `json:"request,omitempty"`
+	Timeout       int64    `json:"timeout_ns,omitempty"`
+	Prefix        string   `json:"prefix,omitempty"`
+	TrailingLines int      `json:"trailing_lines,omitempty"`
+	Lines         []string `json:"lines,omitempty"`
+	Error         string   `json:"error,omitempty"`
+}
+
+// DefaultSocketPath returns the default path of the daemon's socket. It is located in $XDG_RUNTIME_DIR,
+// or in the temporary directory, if $XDG_RUNTIME_DIR is not set.
+func DefaultSocketPath() string {
+	runtimeDir := os.Getenv("XDG_RUNTIME_DIR")
+	if runtimeDir != "" {
+		return filepath.Join(runtimeDir, "tetra-cli.sock")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("tetra-cli-%d.sock", os.Getuid()))
+}
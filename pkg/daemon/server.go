@@ -0,0 +1,249 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ftl/tetra-cli/pkg/radio"
+)
+
+const (
+	// defaultRequestTimeout is used for requests of clients that do not define a deadline.
+	defaultRequestTimeout = 1 * time.Minute
+
+	// outgoingQueueSize is the number of messages that are buffered for a client before indications are dropped.
+	outgoingQueueSize = 100
+)
+
+// Listen on the Unix domain socket with the given path. A stale socket file is removed. If another daemon
+// is already listening on this path, an error is returned.
+func Listen(socketPath string) (net.Listener, error) {
+	_, err := os.Stat(socketPath)
+	if err == nil {
+		conn, err := net.Dial("unix", socketPath)
+		if err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("another daemon is already listening on %s", socketPath)
+		}
+		err = os.Remove(socketPath)
+		if err != nil {
+			return nil, fmt.Errorf("cannot remove stale socket: %w", err)
+		}
+	}
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+	err = os.Chmod(socketPath, 0600)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// Server shares one radio between several clients.
+//
+// The server keeps the configuration of the radio: requests to reset the radio (ATZ) or to change the echo
+// mode (ATE) are answered with OK, but not forwarded to the radio. The SDS service selection (AT+CTSDS)
+// is tracked per client and restored before the client sends an SDS (AT+CMGS).
+type Server struct {
+	radio *radio.Radio
+
+	sdsLock         sync.Mutex
+	selectedService string
+}
+
+// NewServer returns a new server for the given radio.
+func NewServer(radio *radio.Radio) *Server {
+	return &Server{
+		radio: radio,
+	}
+}
+
+// Serve accepts clients on the given listener until the context is done.
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	var connections sync.WaitGroup
+	defer connections.Wait()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		connections.Go(func() {
+			s.serveConnection(ctx, conn)
+		})
+	}
+}
+
+// session contains the state of one client connection.
+type session struct {
+	service string
+
+	lock     sync.Mutex
+	closed   bool
+	outgoing chan message
+}
+
+func (s *Server) serveConnection(ctx context.Context, conn net.Conn) {
+	connCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-connCtx.Done()
+		conn.Close()
+	}()
+
+	session := &session{
+		outgoing: make(chan message, outgoingQueueSize),
+	}
+	var writer sync.WaitGroup
+	defer writer.Wait()
+	defer session.close()
+	writer.Go(func() {
+		encoder := json.NewEncoder(conn)
+		for msg := range session.outgoing {
+			err := encoder.Encode(msg)
+			if err != nil {
+				cancel()
+			}
+		}
+	})
+
+	var unsubscribes []func()
+	defer func() {
+		for _, unsubscribe := range unsubscribes {
+			unsubscribe()
+		}
+	}()
+
+	decoder := json.NewDecoder(conn)
+	for {
+		var request message
+		err := decoder.Decode(&request)
+		if err != nil {
+			return
+		}
+
+		response := message{
+			ID: request.ID,
+			Op: opResponse,
+		}
+		switch request.Op {
+		case opAT:
+			response.Lines, err = s.at(connCtx, session, request)
+		case opIndication:
+			var unsubscribe func()
+			unsubscribe, err = s.radio.Subscribe(request.Prefix, request.TrailingLines, func(lines []string) {
+				session.push(message{
+					Op:     opIndication,
+					Prefix: request.Prefix,
+					Lines:  lines,
+				})
+			})
+			if err == nil {
+				unsubscribes = append(unsubscribes, unsubscribe)
+			}
+		default:
+			err = fmt.Errorf("unknown operation %q", request.Op)
+		}
+		if err != nil {
+			response.Error = err.Error()
+		}
+
+		select {
+		case session.outgoing <- response:
+		case <-connCtx.Done():
+			return
+		}
+	}
+}
+
+// push the given message to the client without blocking. If the client is too slow, the message is dropped.
+// Indications are pushed by the dispatchers of the radio, so they may arrive after the session was closed.
+func (s *session) push(msg message) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return
+	}
+
+	select {
+	case s.outgoing <- msg:
+	default:
+		log.Printf("client too slow, dropping indication %s", msg.Prefix)
+	}
+}
+
+// close the outgoing queue, all further indications are dropped.
+func (s *session) close() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.closed = true
+	close(s.outgoing)
+}
+
+func (s *Server) at(ctx context.Context, session *session, request message) ([]string, error) {
+	timeout := time.Duration(request.Timeout)
+	if timeout <= 0 {
+		timeout = defaultRequestTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	command := strings.ToUpper(strings.TrimSpace(request.Request))
+	switch {
+	case command == "ATZ", strings.HasPrefix(command, "ATE"):
+		return nil, nil
+	case strings.HasPrefix(command, "AT+CTSDS="):
+		s.sdsLock.Lock()
+		defer s.sdsLock.Unlock()
+
+		session.service = command
+		response, err := s.radio.AT(ctx, request.Request)
+		if err == nil {
+			s.selectedService = command
+		}
+		return response, err
+	case strings.HasPrefix(command, "AT+CMGS=") && command != "AT+CMGS=?":
+		s.sdsLock.Lock()
+		defer s.sdsLock.Unlock()
+
+		if session.service != "" && session.service != s.selectedService {
+			_, err := s.radio.AT(ctx, session.service)
+			if err != nil {
+				return nil, fmt.Errorf("cannot restore SDS service selection: %w", err)
+			}
+			s.selectedService = session.service
+		}
+		return s.radio.AT(ctx, request.Request)
+	default:
+		return s.radio.AT(ctx, request.Request)
+	}
+}
+
+// errorFromMessage returns the error contained in the given message, or nil.
+func errorFromMessage(msg message) error {
+	if msg.Error == "" {
+		return nil
+	}
+	return errors.New(msg.Error)
+}
@@ -0,0 +1,169 @@
+// Package events turns the indications of a TETRA radio into typed events. A Listener registers the indications
+// for incoming text and status messages, voice and talkgroup events and delivers the events to its subscribers.
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/ftl/tetra-pei/ctrl"
+	"github.com/ftl/tetra-pei/sds"
+	"github.com/ftl/tetra-pei/tetra"
+)
+
+// Kind is the type of an event.
+type Kind string
+
+// All kinds of events.
+const (
+	TextMessageKind       Kind = "message"
+	StatusMessageKind     Kind = "status"
+	VoiceTxKind           Kind = "voice-tx"
+	VoiceRxKind           Kind = "voice-rx"
+	TalkgroupIdleKind     Kind = "talkgroup-idle"
+	TalkgroupInactiveKind Kind = "talkgroup-inactive"
+	AIModeChangedKind     Kind = "ai-mode"
+)
+
+// Kinds contains all kinds of events.
+var Kinds = []Kind{TextMessageKind, StatusMessageKind, VoiceTxKind, VoiceRxKind, TalkgroupIdleKind, TalkgroupInactiveKind, AIModeChangedKind}
+
+// Event is received from the radio.
+type Event interface {
+	Kind() Kind
+	Timestamp() time.Time
+}
+
+// TextMessage is an incoming SDS text message. The leading OPTA and the trailing ITSI are split from the text.
+type TextMessage struct {
+	Time        time.Time
+	Source      tetra.Identity
+	Destination tetra.Identity
+	ITSI        string
+	OPTA        string
+	Text        string
+}
+
+func (e TextMessage) Kind() Kind                   { return TextMessageKind }
+func (e TextMessage) Timestamp() time.Time         { return e.Time }
+func (e TextMessage) MarshalJSON() ([]byte, error) { return json.Marshal(NewRecord(e)) }
+
+// StatusMessage is an incoming status message.
+type StatusMessage struct {
+	Time        time.Time
+	Source      tetra.Identity
+	Destination tetra.Identity
+	Status      sds.Status
+}
+
+func (e StatusMessage) Kind() Kind                   { return StatusMessageKind }
+func (e StatusMessage) Timestamp() time.Time         { return e.Time }
+func (e StatusMessage) MarshalJSON() ([]byte, error) { return json.Marshal(NewRecord(e)) }
+
+// VoiceTx indicates that the radio transmits voice.
+type VoiceTx struct {
+	Time time.Time
+}
+
+func (e VoiceTx) Kind() Kind                   { return VoiceTxKind }
+func (e VoiceTx) Timestamp() time.Time         { return e.Time }
+func (e VoiceTx) MarshalJSON() ([]byte, error) { return json.Marshal(NewRecord(e)) }
+
+// VoiceRx indicates that the radio receives voice from the given ITSI.
+type VoiceRx struct {
+	Time time.Time
+	ITSI string
+}
+
+func (e VoiceRx) Kind() Kind                   { return VoiceRxKind }
+func (e VoiceRx) Timestamp() time.Time         { return e.Time }
+func (e VoiceRx) MarshalJSON() ([]byte, error) { return json.Marshal(NewRecord(e)) }
+
+// TalkgroupIdle indicates that the transmission on the talkgroup ended.
+type TalkgroupIdle struct {
+	Time time.Time
+}
+
+func (e TalkgroupIdle) Kind() Kind                   { return TalkgroupIdleKind }
+func (e TalkgroupIdle) Timestamp() time.Time         { return e.Time }
+func (e TalkgroupIdle) MarshalJSON() ([]byte, error) { return json.Marshal(NewRecord(e)) }
+
+// TalkgroupInactive indicates that the call on the talkgroup was released.
+type TalkgroupInactive struct {
+	Time time.Time
+}
+
+func (e TalkgroupInactive) Kind() Kind                   { return TalkgroupInactiveKind }
+func (e TalkgroupInactive) Timestamp() time.Time         { return e.Time }
+func (e TalkgroupInactive) MarshalJSON() ([]byte, error) { return json.Marshal(NewRecord(e)) }
+
+// AIModeChanged indicates that the operating mode of the radio changed.
+type AIModeChanged struct {
+	Time   time.Time
+	AIMode ctrl.AIMode
+}
+
+func (e AIModeChanged) Kind() Kind                   { return AIModeChangedKind }
+func (e AIModeChanged) Timestamp() time.Time         { return e.Time }
+func (e AIModeChanged) MarshalJSON() ([]byte, error) { return json.Marshal(NewRecord(e)) }
+
+// Record is the flat representation of an event, used for the JSON and CSV formats.
+type Record struct {
+	Type   Kind      `json:"type"`
+	Time   time.Time `json:"time"`
+	Source string    `json:"source,omitempty"`
+	// SourceName is the name of the source, e.g. from an address book. NewRecord leaves it empty.
+	SourceName string `json:"source_name,omitempty"`
+	ITSI       string `json:"itsi,omitempty"`
+	OPTA       string `json:"opta,omitempty"`
+	Text       string `json:"text,omitempty"`
+	Status     string `json:"status,omitempty"`
+	// StatusName and StatusDescription describe the status, e.g. from a status catalog. NewRecord leaves them empty.
+	StatusName        string `json:"status_name,omitempty"`
+	StatusDescription string `json:"status_description,omitempty"`
+	// UnknownStatus flags a status that is not defined, e.g. in a status catalog. NewRecord leaves it false.
+	UnknownStatus bool   `json:"unknown_status,omitempty"`
+	AIMode        string `json:"ai_mode,omitempty"`
+}
+
+// RecordHeader contains the names of the CSV columns of a record.
+var RecordHeader = []string{"type", "time", "source", "source_name", "itsi", "opta", "text", "status", "status_name", "status_description", "unknown_status", "ai_mode"}
+
+// NewRecord returns the flat representation of the given event.
+func NewRecord(event Event) Record {
+	result := Record{
+		Type: event.Kind(),
+		Time: event.Timestamp(),
+	}
+	switch e := event.(type) {
+	case TextMessage:
+		result.Source = string(e.Source)
+		result.ITSI = e.ITSI
+		result.OPTA = e.OPTA
+		result.Text = e.Text
+	case StatusMessage:
+		result.Source = string(e.Source)
+		result.Status = FormatStatus(e.Status)
+	case VoiceRx:
+		result.Source = e.ITSI
+		result.ITSI = e.ITSI
+	case AIModeChanged:
+		result.AIMode = e.AIMode.String()
+	}
+	return result
+}
+
+// CSV returns the values of the CSV columns of this record.
+func (r Record) CSV() []string {
+	unknownStatus := ""
+	if r.UnknownStatus {
+		unknownStatus = "true"
+	}
+	return []string{string(r.Type), r.Time.Format(time.RFC3339Nano), r.Source, r.SourceName, r.ITSI, r.OPTA, r.Text, r.Status, r.StatusName, r.StatusDescription, unknownStatus, r.AIMode}
+}
+
+// FormatStatus returns the given status as hex string with four digits.
+func FormatStatus(status sds.Status) string {
+	return fmt.Sprintf("%04x", uint16(status))
+}
@@ -0,0 +1,302 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ftl/tetra-pei/ctrl"
+	"github.com/ftl/tetra-pei/sds"
+
+	"github.com/ftl/tetra-cli/pkg/radio"
+)
+
+// responseTimeout is the maximum time to send a response (e.g. an SDS-REPORT) for an incoming message.
+const responseTimeout = 5 * time.Second
+
+// ActivateSignalling activates the unsolicited indications for calls, status and text messages.
+var ActivateSignalling radio.InitializerFunc = func(ctx context.Context, pei radio.PEI) error {
+	err := pei.ATs(ctx,
+		"AT+CTSP=2,0,0",   // call signaling
+		"AT+CTSP=2,2,20",  // status
+		"AT+CTSP=1,3,2",   // simple text messaging
+		"AT+CTSP=1,3,9",   // simple immediate text messaging
+		"AT+CTSP=1,3,130", // text messaging
+		"AT+CTSP=1,3,137", // immediate text messaging
+		"AT+CTSP=1,3,138", // message with UDH
+	)
+	if err != nil {
+		return fmt.Errorf("cannot activate signalling: %w", err)
+	}
+	return nil
+}
+
+// subscriberQueueSize is the number of events that are buffered for a subscriber before events are dropped.
+const subscriberQueueSize = 100
+
+// Listener turns the indications of a radio into events and delivers them to its subscribers.
+// A Listener implements radio.Initializer and must be used to initialize the radio.
+type Listener struct {
+	lock        sync.RWMutex
+	subscribers map[int]*subscriber
+	nextID      int
+}
+
+// subscriber receives the events through a queue, so that a slow subscriber does not block the processing
+// of the indications.
+type subscriber struct {
+	handler func(Event)
+	// limit is the maximum number of waiting events, 0 means no limit.
+	limit int
+
+	lock    sync.Mutex
+	waiting []Event
+	closed  bool
+	wake    chan struct{}
+	done    chan struct{}
+}
+
+func newSubscriber(handler func(Event), limit int) *subscriber {
+	return &subscriber{
+		handler: handler,
+		limit:   limit,
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+}
+
+// put the given event into the queue without blocking. It returns false if the event is dropped because too many
+// events are waiting.
+func (s *subscriber) put(event Event) bool {
+	s.lock.Lock()
+	if s.limit > 0 && len(s.waiting) >= s.limit {
+		s.lock.Unlock()
+		return false
+	}
+	s.waiting = append(s.waiting, event)
+	s.lock.Unlock()
+	s.signal()
+	return true
+}
+
+// close the queue. The waiting events are still handled.
+func (s *subscriber) close() {
+	s.lock.Lock()
+	s.closed = true
+	s.lock.Unlock()
+	s.signal()
+}
+
+func (s *subscriber) signal() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *subscriber) run() {
+	defer close(s.done)
+	for {
+		s.lock.Lock()
+		events, closed := s.waiting, s.closed
+		s.waiting = nil
+		s.lock.Unlock()
+
+		for _, event := range events {
+			s.handler(event)
+		}
+		switch {
+		case len(events) > 0:
+		case closed:
+			return
+		default:
+			<-s.wake
+		}
+	}
+}
+
+// NewListener returns a new Listener without subscribers.
+func NewListener() *Listener {
+	return &Listener{
+		subscribers: make(map[int]*subscriber),
+	}
+}
+
+// Subscribe the given handler to all events. The handler is called on its own goroutine, one event after the other.
+// If the handler is too slow and too many events are waiting, events are dropped. The returned function removes the
+// handler again and waits until the handler processed the waiting events.
+func (l *Listener) Subscribe(handler func(Event)) func() {
+	return l.subscribe(handler, subscriberQueueSize)
+}
+
+// SubscribeDurable subscribes the given handler to all events like Subscribe, but no events are dropped: all events
+// wait for the handler, however slow it is. Use it for handlers that must not miss any event, e.g. to record the
+// messages.
+func (l *Listener) SubscribeDurable(handler func(Event)) func() {
+	return l.subscribe(handler, 0)
+}
+
+func (l *Listener) subscribe(handler func(Event), limit int) func() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	id := l.nextID
+	l.nextID++
+	subscriber := newSubscriber(handler, limit)
+	l.subscribers[id] = subscriber
+	go subscriber.run()
+
+	return func() {
+		l.lock.Lock()
+		_, ok := l.subscribers[id]
+		if ok {
+			delete(l.subscribers, id)
+			subscriber.close()
+		}
+		l.lock.Unlock()
+		<-subscriber.done
+	}
+}
+
+// Events returns a channel that receives all events until the given context is done. The channel buffers
+// the given number of events; if the buffer is full, events are dropped.
+func (l *Listener) Events(ctx context.Context, bufferSize int) <-chan Event {
+	result := make(chan Event, bufferSize)
+
+	unsubscribe := l.Subscribe(func(event Event) {
+		select {
+		case result <- event:
+		default:
+			log.Printf("event channel full, dropping %s event", event.Kind())
+		}
+	})
+	go func() {
+		<-ctx.Done()
+		unsubscribe()
+		close(result)
+	}()
+
+	return result
+}
+
+// publish the given event to all subscribers. publish is called while the indication is processed and never blocks.
+func (l *Listener) publish(event Event) {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	for _, subscriber := range l.subscribers {
+		if !subscriber.put(event) {
+			log.Printf("subscriber too slow, dropping %s event", event.Kind())
+		}
+	}
+}
+
+// Initialize activates the signalling and registers the indications for all events at the given PEI.
+func (l *Listener) Initialize(ctx context.Context, pei radio.PEI) error {
+	err := ActivateSignalling(ctx, pei)
+	if err != nil {
+		return err
+	}
+
+	// initialize the SDS stack with callbacks for the different message types
+	stack := sds.NewStack().WithMessageCallback(func(m sds.Message) {
+		var opta, sanitizedText, itsi string
+		opta, sanitizedText = sds.SplitLeadingOPTA(m.Text())
+		sanitizedText, itsi = sds.SplitTrailingITSI(sanitizedText)
+		l.publish(TextMessage{
+			Time:        time.Now(),
+			Source:      m.Source,
+			Destination: m.Destination,
+			ITSI:        itsi,
+			OPTA:        opta,
+			Text:        sanitizedText,
+		})
+	}).WithStatusCallback(func(m sds.StatusMessage) {
+		l.publish(StatusMessage{
+			Time:        time.Now(),
+			Source:      m.Source,
+			Destination: m.Destination,
+			Status:      m.Value,
+		})
+	}).WithResponseCallback(func(responses []string) error {
+		ctx, cancel := context.WithTimeout(context.Background(), responseTimeout)
+		defer cancel()
+		for _, response := range responses {
+			_, err := pei.AT(ctx, response)
+			if err != nil {
+				log.Printf("cannot send response command %s:\n%v", response, err)
+				return err
+			}
+		}
+		return nil
+	})
+
+	// setup a function to decode SDS message parts
+	var decodeMessagePart = func(lines []string) {
+		if len(lines) == 2 {
+			part, err := sds.ParseIncomingMessage(lines[0], lines[1])
+			if err != nil {
+				log.Printf("cannot decode message part: %v", err)
+				return
+			}
+			stack.Put(part)
+		}
+	}
+
+	// enable the indiciation for SDS message parts and use the decode to process them
+	err = pei.AddIndication("+CTSDSR: 12,", 1, decodeMessagePart)
+	if err != nil {
+		return fmt.Errorf("cannot activate message indication (12): %w", err)
+	}
+	err = pei.AddIndication("+CTSDSR: 13,", 1, decodeMessagePart)
+	if err != nil {
+		return fmt.Errorf("cannot activate message indication (13): %w", err)
+	}
+
+	// enable indications for several voice and talkgroup events
+	err = pei.AddIndication("+CTXG:", 0, func(lines []string) {
+		parts := strings.Split(lines[0][6:], ",")
+		switch len(parts) {
+		case 4:
+			l.publish(VoiceTx{Time: time.Now()})
+		case 6:
+			l.publish(VoiceRx{Time: time.Now(), ITSI: strings.TrimSpace(parts[5])})
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("cannot activate voice indication: %w", err)
+	}
+
+	err = pei.AddIndication("+CDTXC:", 0, func(lines []string) {
+		l.publish(TalkgroupIdle{Time: time.Now()})
+	})
+	if err != nil {
+		return fmt.Errorf("cannot activate talkgroup idle indication: %w", err)
+	}
+
+	err = pei.AddIndication("+CTCR:", 0, func(lines []string) {
+		l.publish(TalkgroupInactive{Time: time.Now()})
+	})
+	if err != nil {
+		return fmt.Errorf("cannot activate talkgroup inactive indication: %w", err)
+	}
+
+	err = pei.AddIndication("+CTOM: ", 0, func(lines []string) {
+		aiMode, err := strconv.Atoi(lines[0][7:])
+		if err != nil {
+			return
+		}
+		l.publish(AIModeChanged{Time: time.Now(), AIMode: ctrl.AIMode(aiMode)})
+	})
+	if err != nil {
+		return fmt.Errorf("cannot activate CTOM indication")
+	}
+
+	return nil
+}
+
+var _ radio.Initializer = (*Listener)(nil)
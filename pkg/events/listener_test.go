@@ -0,0 +1,85 @@
+package events
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestListener_SlowSubscriberDoesNotBlockPublish(t *testing.T) {
+	listener := NewListener()
+	release := make(chan struct{})
+	unsubscribe := listener.Subscribe(func(Event) { <-release })
+
+	published := make(chan struct{})
+	go func() {
+		for range 2 * subscriberQueueSize {
+			listener.publish(TalkgroupIdle{Time: time.Now()})
+		}
+		close(published)
+	}()
+
+	select {
+	case <-published:
+	case <-time.After(time.Second):
+		t.Error("publish is blocked by the subscriber")
+	}
+	close(release)
+	unsubscribe()
+}
+
+func TestListener_UnsubscribeWaitsForTheQueuedEvents(t *testing.T) {
+	listener := NewListener()
+	received := 0
+	unsubscribe := listener.Subscribe(func(Event) {
+		time.Sleep(time.Millisecond)
+		received++
+	})
+
+	for range 10 {
+		listener.publish(TalkgroupIdle{Time: time.Now()})
+	}
+	unsubscribe()
+	unsubscribe()
+
+	if received != 10 {
+		t.Errorf("expected 10 events, got %d", received)
+	}
+	listener.publish(TalkgroupIdle{Time: time.Now()})
+	if received != 10 {
+		t.Error("the handler must not be called after unsubscribe")
+	}
+}
+
+func TestListener_DurableSubscriberReceivesAllEvents(t *testing.T) {
+	listener := NewListener()
+	release := make(chan struct{})
+	var received []time.Time
+	unsubscribe := listener.SubscribeDurable(func(event Event) {
+		<-release
+		received = append(received, event.(TalkgroupIdle).Time)
+	})
+
+	var published []time.Time
+	done := make(chan struct{})
+	go func() {
+		for i := range 3 * subscriberQueueSize {
+			published = append(published, time.Unix(int64(i), 0))
+			listener.publish(TalkgroupIdle{Time: published[i]})
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("publish is blocked by the subscriber")
+	}
+	close(release)
+	<-done
+	unsubscribe()
+
+	if !slices.Equal(received, published) {
+		t.Errorf("expected all %d events in order, got %d", len(published), len(received))
+	}
+}
@@ -0,0 +1,331 @@
+// Package fakepei provides an in-memory implementation of the radio.PEI interface that is driven by a script
+// of expected requests and canned responses. Unsolicited indications can be injected at any time.
+// It allows to exercise code that talks to a PEI without a physical radio terminal.
+package fakepei
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ftl/tetra-cli/pkg/radio"
+)
+
+var _ radio.PEI = (*PEI)(nil)
+
+// ErrUnexpectedRequest is returned by the fake PEI for requests that do not match the script.
+var ErrUnexpectedRequest = errors.New("unexpected request")
+
+// Exchange describes one expected request and the canned response of the fake PEI.
+type Exchange struct {
+	// Request is the expected request. It is compared literally, if Match is nil.
+	Request string
+	// Match optionally decides if a request matches this exchange.
+	Match func(request string) bool
+
+	// Response contains the lines that are returned for the request.
+	Response []string
+	// Err is returned instead of the response lines, if set.
+	Err error
+	// Indications are emitted asynchronously and in order once the request was answered.
+	Indications []Indication
+}
+
+// Expect returns an exchange for the given request that responds with the given lines.
+func Expect(request string, response ...string) Exchange {
+	return Exchange{
+		Request:  request,
+		Response: response,
+	}
+}
+
+// ExpectPrefix returns an exchange for any request with the given prefix that responds with the given lines.
+func ExpectPrefix(prefix string, response ...string) Exchange {
+	return Exchange{
+		Request: prefix + "...",
+		Match: func(request string) bool {
+			return strings.HasPrefix(request, prefix)
+		},
+		Response: response,
+	}
+}
+
+// Fail returns a copy of this exchange that returns the given error.
+func (e Exchange) Fail(err error) Exchange {
+	e.Err = err
+	return e
+}
+
+// Then returns a copy of this exchange that emits an indication with the given lines after the response.
+func (e Exchange) Then(lines ...string) Exchange {
+	return e.ThenAfter(0, lines...)
+}
+
+// ThenAfter returns a copy of this exchange that emits an indication with the given lines after the response,
+// delayed by the given duration.
+func (e Exchange) ThenAfter(delay time.Duration, lines ...string) Exchange {
+	e.Indications = append(append([]Indication{}, e.Indications...), Indication{Delay: delay, Lines: lines})
+	return e
+}
+
+func (e Exchange) matches(request string) bool {
+	if e.Match != nil {
+		return e.Match(request)
+	}
+	return e.Request == request
+}
+
+// Indication describes an unsolicited indication emitted by the fake PEI.
+type Indication struct {
+	// Delay is the time to wait after the response or the previous indication before the indication is emitted.
+	Delay time.Duration
+	// Lines of the indication, the first line must start with the prefix of a registered indication.
+	Lines []string
+}
+
+// PEI is a fake implementation of the radio.PEI interface. The zero value is not usable, use New.
+type PEI struct {
+	// OnUnexpected is invoked for requests that do not match the next exchange in the script.
+	// If it is nil, ErrUnexpectedRequest is returned and the request is reported by Verify.
+	OnUnexpected func(request string) ([]string, error)
+
+	mutex              sync.Mutex
+	script             []Exchange
+	requests           []string
+	unexpected         []string
+	indications        []indicationConfig
+	disconnectCallback func()
+	closed             chan struct{}
+	pending            sync.WaitGroup
+}
+
+type indicationConfig struct {
+	prefix        string
+	trailingLines int
+	handler       func(lines []string)
+}
+
+// New returns a new fake PEI that follows the given script.
+func New(script ...Exchange) *PEI {
+	return &PEI{
+		script: script,
+		closed: make(chan struct{}),
+	}
+}
+
+// Script appends the given exchanges to the script of this fake PEI.
+func (p *PEI) Script(exchanges ...Exchange) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.script = append(p.script, exchanges...)
+}
+
+// Requests returns all requests this fake PEI received so far.
+func (p *PEI) Requests() []string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return append([]string{}, p.requests...)
+}
+
+// Verify returns an error if there were unexpected requests that were not handled by OnUnexpected, or if the script
+// was not completed.
+func (p *PEI) Verify() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if len(p.unexpected) > 0 {
+		return fmt.Errorf("%d unexpected requests, first: %q", len(p.unexpected), p.unexpected[0])
+	}
+	if len(p.script) > 0 {
+		return fmt.Errorf("%d expected requests are missing, next: %q", len(p.script), p.script[0].Request)
+	}
+	return nil
+}
+
+// Indicate emits an unsolicited indication with the given lines. The handler registered for the
+// matching prefix is invoked synchronously. If no handler matches, an error is returned.
+func (p *PEI) Indicate(lines ...string) error {
+	if len(lines) == 0 {
+		return fmt.Errorf("empty indication")
+	}
+
+	p.mutex.Lock()
+	config, ok := p.findIndication(lines[0])
+	p.mutex.Unlock()
+	if !ok {
+		return fmt.Errorf("no indication registered for %q", lines[0])
+	}
+	if len(lines) != config.trailingLines+1 {
+		return fmt.Errorf("indication %s expects %d trailing lines, got %d", config.prefix, config.trailingLines, len(lines)-1)
+	}
+
+	config.handler(lines)
+	return nil
+}
+
+// WaitForIndications waits until all delayed indications were emitted or the context is done.
+func (p *PEI) WaitForIndications(ctx context.Context) {
+	done := make(chan struct{})
+	go func() {
+		p.pending.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
+
+// Disconnect simulates the loss of the connection to the radio.
+func (p *PEI) Disconnect() {
+	p.Close()
+}
+
+// findIndication returns the first registered indication whose prefix matches the given line.
+func (p *PEI) findIndication(line string) (indicationConfig, bool) {
+	upperLine := strings.ToUpper(line)
+	for _, config := range p.indications {
+		if strings.HasPrefix(upperLine, config.prefix) {
+			return config, true
+		}
+	}
+	return indicationConfig{}, false
+}
+
+func (p *PEI) emit(indications []Indication) {
+	if len(indications) == 0 {
+		return
+	}
+
+	p.pending.Add(1)
+	go func() {
+		defer p.pending.Done()
+		for _, indication := range indications {
+			if indication.Delay > 0 {
+				select {
+				case <-time.After(indication.Delay):
+				case <-p.closed:
+					return
+				}
+			}
+			p.Indicate(indication.Lines...)
+		}
+	}()
+}
+
+func (p *PEI) Close() {
+	p.mutex.Lock()
+	select {
+	case <-p.closed:
+		p.mutex.Unlock()
+		return
+	default:
+		close(p.closed)
+	}
+	callback := p.disconnectCallback
+	p.mutex.Unlock()
+
+	if callback != nil {
+		callback()
+	}
+}
+
+func (p *PEI) Closed() bool {
+	select {
+	case <-p.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *PEI) WaitUntilClosed(ctx context.Context) {
+	select {
+	case <-p.closed:
+	case <-ctx.Done():
+	}
+}
+
+func (p *PEI) OnDisconnect(callback func()) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.disconnectCallback = callback
+}
+
+func (p *PEI) AddIndication(prefix string, trailingLines int, handler func(lines []string)) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	config := indicationConfig{
+		prefix:        strings.ToUpper(prefix),
+		trailingLines: trailingLines,
+		handler:       handler,
+	}
+	for i, existing := range p.indications {
+		if existing.prefix == config.prefix {
+			p.indications[i] = config
+			return nil
+		}
+	}
+	p.indications = append(p.indications, config)
+	return nil
+}
+
+// ClearSyntaxErrors does not consume any exchange of the script.
+func (p *PEI) ClearSyntaxErrors(ctx context.Context) error {
+	if p.Closed() {
+		return fmt.Errorf("connection closed")
+	}
+	return nil
+}
+
+func (p *PEI) Request(ctx context.Context, request string) ([]string, error) {
+	return p.AT(ctx, request)
+}
+
+func (p *PEI) AT(ctx context.Context, request string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if p.Closed() {
+		return nil, fmt.Errorf("connection closed")
+	}
+
+	p.mutex.Lock()
+	p.requests = append(p.requests, request)
+	if len(p.script) == 0 || !p.script[0].matches(request) {
+		onUnexpected := p.OnUnexpected
+		if onUnexpected == nil {
+			p.unexpected = append(p.unexpected, request)
+		}
+		p.mutex.Unlock()
+
+		if onUnexpected == nil {
+			return nil, fmt.Errorf("%w: %q", ErrUnexpectedRequest, request)
+		}
+		return onUnexpected(request)
+	}
+	exchange := p.script[0]
+	p.script = p.script[1:]
+	p.mutex.Unlock()
+
+	p.emit(exchange.Indications)
+	if exchange.Err != nil {
+		return nil, exchange.Err
+	}
+	return append([]string{}, exchange.Response...), nil
+}
+
+func (p *PEI) ATs(ctx context.Context, requests ...string) error {
+	for _, request := range requests {
+		_, err := p.AT(ctx, request)
+		if err != nil {
+			return fmt.Errorf("%s failed: %w", request, err)
+		}
+	}
+	return nil
+}
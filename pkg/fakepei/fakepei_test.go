@@ -0,0 +1,136 @@
+package fakepei
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPEI_FollowsTheScript(t *testing.T) {
+	pei := New(
+		Expect("ATZ"),
+		ExpectPrefix("AT+CTOM", "+CTOM: 0"),
+	)
+
+	_, err := pei.AT(context.Background(), "ATZ")
+	if err != nil {
+		t.Fatalf("ATZ failed: %v", err)
+	}
+	response, err := pei.AT(context.Background(), "AT+CTOM?")
+	if err != nil {
+		t.Fatalf("AT+CTOM? failed: %v", err)
+	}
+	if len(response) != 1 || response[0] != "+CTOM: 0" {
+		t.Errorf("unexpected response %v", response)
+	}
+	if err := pei.Verify(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPEI_ReportsUnexpectedAndMissingRequests(t *testing.T) {
+	pei := New(Expect("ATZ"), Expect("ATE0"))
+
+	_, err := pei.AT(context.Background(), "ATE0")
+	if !errors.Is(err, ErrUnexpectedRequest) {
+		t.Errorf("expected ErrUnexpectedRequest, got %v", err)
+	}
+	if err := pei.Verify(); err == nil {
+		t.Error("Verify should report the unexpected request")
+	}
+
+	pei = New(Expect("ATZ"), Expect("ATE0"))
+	pei.AT(context.Background(), "ATZ")
+	if err := pei.Verify(); err == nil {
+		t.Error("Verify should report the missing request")
+	}
+}
+
+func TestPEI_VerifyIgnoresRequestsHandledByOnUnexpected(t *testing.T) {
+	pei := New(Expect("ATZ"))
+	pei.OnUnexpected = func(request string) ([]string, error) {
+		return []string{"+CSQ: 20,99"}, nil
+	}
+
+	response, err := pei.AT(context.Background(), "AT+CSQ?")
+	if err != nil {
+		t.Fatalf("AT+CSQ? failed: %v", err)
+	}
+	if len(response) != 1 || response[0] != "+CSQ: 20,99" {
+		t.Errorf("unexpected response %v", response)
+	}
+	pei.AT(context.Background(), "ATZ")
+	if err := pei.Verify(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPEI_IndicationsWithOverlappingPrefixes(t *testing.T) {
+	for range 20 {
+		pei := New()
+		var got string
+		pei.AddIndication("+CTSDSR:", 1, func(lines []string) { got = "any" })
+		pei.AddIndication("+CTSDSR: 12,", 1, func(lines []string) { got = "sds-tl" })
+
+		err := pei.Indicate("+CTSDSR: 12,1234567,0,2345678,0,40", "8200014E")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != "any" {
+			t.Fatalf("the first registered indication must win, got %q", got)
+		}
+	}
+}
+
+func TestPEI_AddIndicationReplacesTheHandler(t *testing.T) {
+	pei := New()
+	var got string
+	pei.AddIndication("+CTOM: ", 0, func(lines []string) { got = "first" })
+	pei.AddIndication("+ctom: ", 0, func(lines []string) { got = "second" })
+
+	pei.Indicate("+CTOM: 1")
+	if got != "second" {
+		t.Errorf("expected the second handler, got %q", got)
+	}
+}
+
+func TestPEI_EmitsIndicationsAfterTheResponse(t *testing.T) {
+	pei := New(Expect("AT+CTOM=1").ThenAfter(10*time.Millisecond, "+CTOM: 1"))
+	indicated := make(chan string, 1)
+	pei.AddIndication("+CTOM: ", 0, func(lines []string) { indicated <- lines[0] })
+
+	_, err := pei.AT(context.Background(), "AT+CTOM=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	pei.WaitForIndications(ctx)
+
+	select {
+	case line := <-indicated:
+		if line != "+CTOM: 1" {
+			t.Errorf("unexpected indication %q", line)
+		}
+	default:
+		t.Error("the indication was not emitted")
+	}
+}
+
+func TestPEI_Close(t *testing.T) {
+	pei := New()
+	disconnected := false
+	pei.OnDisconnect(func() { disconnected = true })
+
+	pei.Close()
+	pei.Close()
+
+	if !pei.Closed() || !disconnected {
+		t.Error("the PEI should be closed and report the disconnect")
+	}
+	_, err := pei.AT(context.Background(), "ATZ")
+	if err == nil {
+		t.Error("requests must fail after Close")
+	}
+}
@@ -0,0 +1,173 @@
+// Package hooks runs external commands for events. The commands get the fields of the event in environment
+// variables and the event as JSON on stdin. They run in the background with limited concurrency and a timeout,
+// so that a slow command never blocks the processing of the events.
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ftl/tetra-cli/pkg/events"
+)
+
+// QueueSize is the maximum number of events that wait for a free slot. Further events are dropped.
+const QueueSize = 100
+
+// Hook is a shell command that runs for all events of the given kind, or for all events if the kind is empty.
+type Hook struct {
+	Kind    events.Kind
+	Command string
+}
+
+// AllKinds selects all kinds of events in the specification of a hook.
+const AllKinds = "all"
+
+// kindExpression matches the kind of event at the start of a hook specification.
+var kindExpression = regexp.MustCompile(`^\s*([a-z-]+)\s*=`)
+
+// Parse parses a hook in the format <kind>=<command>, e.g. "message=notify-send \"$TETRA_TEXT\"". Without a kind or
+// with the kind "all", the command runs for all events. A prefix that is no kind of event, e.g. the environment
+// variable in "lang=C notify-send", belongs to the command.
+func Parse(spec string) (Hook, error) {
+	var kind string
+	command := spec
+	if match := kindExpression.FindStringSubmatch(spec); match != nil {
+		switch {
+		case match[1] == AllKinds:
+			command = spec[len(match[0]):]
+		case slices.Contains(events.Kinds, events.Kind(match[1])):
+			kind = match[1]
+			command = spec[len(match[0]):]
+		}
+	}
+	command = strings.TrimSpace(command)
+	if command == "" {
+		return Hook{}, fmt.Errorf("the hook %q has no command", spec)
+	}
+	return Hook{Kind: events.Kind(kind), Command: command}, nil
+}
+
+// Matches indicates that the hook runs for events of the given kind.
+func (h Hook) Matches(kind events.Kind) bool {
+	return h.Kind == "" || h.Kind == kind
+}
+
+func (h Hook) String() string {
+	if h.Kind == "" {
+		return h.Command
+	}
+	return fmt.Sprintf("%s=%s", h.Kind, h.Command)
+}
+
+type job struct {
+	hook   Hook
+	record events.Record
+}
+
+// Runner runs the hooks for the events.
+type Runner struct {
+	hooks       []Hook
+	concurrency int
+	timeout     time.Duration
+	jobs        chan job
+}
+
+// NewRunner returns a new runner for the given hooks. At most the given number of commands run at the same time,
+// each command is stopped after the given timeout.
+func NewRunner(hooks []Hook, concurrency int, timeout time.Duration) *Runner {
+	return &Runner{
+		hooks:       hooks,
+		concurrency: max(concurrency, 1),
+		timeout:     timeout,
+		jobs:        make(chan job, QueueSize),
+	}
+}
+
+// Run runs the commands until the given context is done.
+func (r *Runner) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for range r.concurrency {
+		wg.Go(func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case job := <-r.jobs:
+					r.run(ctx, job)
+				}
+			}
+		})
+	}
+	wg.Wait()
+}
+
+// Handle queues the commands of all hooks that match the given event. Handle never blocks; if the queue is full,
+// the event is dropped for the remaining hooks.
+func (r *Runner) Handle(record events.Record) {
+	for _, hook := range r.hooks {
+		if !hook.Matches(record.Type) {
+			continue
+		}
+		select {
+		case r.jobs <- job{hook: hook, record: record}:
+		default:
+			log.Printf("hook %s: too many commands are waiting, the %s event is dropped", hook, record.Type)
+		}
+	}
+}
+
+func (r *Runner) run(ctx context.Context, job job) {
+	input, err := json.Marshal(job.record)
+	if err != nil {
+		log.Printf("hook %s: cannot marshal the %s event: %v", job.hook, job.record.Type, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", job.hook.Command)
+	cmd.Env = append(os.Environ(), Environment(job.record)...)
+	cmd.Stdin = bytes.NewReader(append(input, '\n'))
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	cmd.WaitDelay = time.Second
+	stopProcessGroup(cmd)
+
+	err = cmd.Run()
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		log.Printf("hook %s: the command for the %s event did not finish within %v", job.hook, job.record.Type, r.timeout)
+	case err != nil:
+		log.Printf("hook %s: the command for the %s event failed: %v", job.hook, job.record.Type, err)
+	}
+}
+
+// Environment returns the fields of the given record as environment variables, e.g. TETRA_SOURCE=1234567.
+func Environment(record events.Record) []string {
+	return []string{
+		"TETRA_EVENT=" + string(record.Type),
+		"TETRA_TIME=" + record.Time.Format(time.RFC3339Nano),
+		"TETRA_SOURCE=" + record.Source,
+		"TETRA_SOURCE_NAME=" + record.SourceName,
+		"TETRA_ITSI=" + record.ITSI,
+		"TETRA_OPTA=" + record.OPTA,
+		"TETRA_TEXT=" + record.Text,
+		"TETRA_STATUS=" + record.Status,
+		"TETRA_STATUS_NAME=" + record.StatusName,
+		"TETRA_STATUS_DESCRIPTION=" + record.StatusDescription,
+		"TETRA_UNKNOWN_STATUS=" + strconv.FormatBool(record.UnknownStatus),
+		"TETRA_AI_MODE=" + record.AIMode,
+	}
+}
@@ -0,0 +1,251 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ftl/tetra-cli/pkg/events"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		spec    string
+		kind    events.Kind
+		command string
+	}{
+		{`message=notify-send "$TETRA_TEXT"`, events.TextMessageKind, `notify-send "$TETRA_TEXT"`},
+		{` status = ./on-status.sh`, events.StatusMessageKind, `./on-status.sh`},
+		{`./on-event.sh`, "", `./on-event.sh`},
+		{`all=./on-event.sh`, "", `./on-event.sh`},
+		{`lang=C notify-send "$TETRA_TEXT"`, "", `lang=C notify-send "$TETRA_TEXT"`},
+		{`foo=1 ./x.sh`, "", `foo=1 ./x.sh`},
+		{`message=lang=C ./x.sh`, events.TextMessageKind, `lang=C ./x.sh`},
+	}
+	for _, tt := range tests {
+		hook, err := Parse(tt.spec)
+		if err != nil {
+			t.Errorf("%q: %v", tt.spec, err)
+			continue
+		}
+		if hook.Kind != tt.kind || hook.Command != tt.command {
+			t.Errorf("%q: unexpected hook %+v", tt.spec, hook)
+		}
+	}
+
+	for _, spec := range []string{"", "  ", "message=", "all= "} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("%q should be rejected", spec)
+		}
+	}
+}
+
+func TestHook_Matches(t *testing.T) {
+	all, _ := Parse("./on-event.sh")
+	message, _ := Parse("message=./on-message.sh")
+
+	if !all.Matches(events.StatusMessageKind) || !message.Matches(events.TextMessageKind) || message.Matches(events.StatusMessageKind) {
+		t.Error("the hooks match the wrong events")
+	}
+	if all.String() != "./on-event.sh" || message.String() != "message=./on-message.sh" {
+		t.Errorf("unexpected strings %q, %q", all, message)
+	}
+}
+
+func TestEnvironment(t *testing.T) {
+	record := events.NewRecord(events.TextMessage{
+		Time:   time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC),
+		Source: "1234567",
+		Text:   "hello",
+	})
+	record.SourceName = "Engine 1"
+
+	environment := Environment(record)
+
+	for _, variable := range []string{
+		"TETRA_EVENT=message",
+		"TETRA_TIME=2024-05-01T12:30:00Z",
+		"TETRA_SOURCE=1234567",
+		"TETRA_SOURCE_NAME=Engine 1",
+		"TETRA_TEXT=hello",
+		"TETRA_STATUS=",
+		"TETRA_UNKNOWN_STATUS=false",
+	} {
+		if !slices.Contains(environment, variable) {
+			t.Errorf("%s is missing in %v", variable, environment)
+		}
+	}
+}
+
+// logBuffer collects the log output of a test.
+type logBuffer struct {
+	lock   sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buffer.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buffer.String()
+}
+
+func captureLog(t *testing.T) *logBuffer {
+	result := &logBuffer{}
+	log.SetOutput(result)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return result
+}
+
+func runTestRunner(t *testing.T, runner *Runner) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runner.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+// waitFor waits until the given condition is true.
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func readLines(filename string) []string {
+	content, _ := os.ReadFile(filename)
+	return strings.Fields(string(content))
+}
+
+func TestRunner_PassesTheEventOnStdin(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "event.json")
+	hook, _ := Parse(fmt.Sprintf(`message=cat > %s.tmp && echo "$TETRA_SOURCE" >> %[1]s.tmp && mv %[1]s.tmp %[1]s`, filename))
+	runner := NewRunner([]Hook{hook}, 1, 5*time.Second)
+	runTestRunner(t, runner)
+
+	runner.Handle(events.NewRecord(events.StatusMessage{Source: "2345678", Status: 0x8002}))
+	runner.Handle(events.NewRecord(events.TextMessage{Source: "1234567", Text: "hello"}))
+
+	var content []byte
+	waitFor(t, "the command", func() bool {
+		content, _ = os.ReadFile(filename)
+		return len(content) > 0
+	})
+	input, variable, _ := strings.Cut(strings.TrimSpace(string(content)), "\n")
+	var record events.Record
+	err := json.Unmarshal([]byte(input), &record)
+	if err != nil {
+		t.Fatalf("invalid JSON on stdin %q: %v", input, err)
+	}
+	if record.Type != events.TextMessageKind || record.Source != "1234567" || record.Text != "hello" {
+		t.Errorf("unexpected record %+v", record)
+	}
+	if variable != "1234567" {
+		t.Errorf("unexpected environment variable %q", variable)
+	}
+}
+
+func TestRunner_StopsSlowCommands(t *testing.T) {
+	logged := captureLog(t)
+	hook, _ := Parse("sleep 10")
+	runner := NewRunner([]Hook{hook}, 1, 100*time.Millisecond)
+	runTestRunner(t, runner)
+	start := time.Now()
+
+	runner.Handle(events.NewRecord(events.TextMessage{Source: "1234567"}))
+
+	waitFor(t, "the timeout", func() bool {
+		return strings.Contains(logged.String(), "did not finish within 100ms")
+	})
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("the command was not stopped in time: %v", elapsed)
+	}
+}
+
+func TestRunner_LogsFailures(t *testing.T) {
+	logged := captureLog(t)
+	hook, _ := Parse("exit 3")
+	runner := NewRunner([]Hook{hook}, 1, time.Second)
+	runTestRunner(t, runner)
+
+	runner.Handle(events.NewRecord(events.TextMessage{Source: "1234567"}))
+
+	waitFor(t, "the failure", func() bool {
+		return strings.Contains(logged.String(), "hook exit 3: the command for the message event failed: exit status 3")
+	})
+}
+
+func TestRunner_LimitsTheConcurrency(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "runs.txt")
+	hook, _ := Parse(fmt.Sprintf("echo start >> %s; sleep 0.1; echo end >> %[1]s", filename))
+	runner := NewRunner([]Hook{hook}, 2, 5*time.Second)
+	runTestRunner(t, runner)
+
+	for range 6 {
+		runner.Handle(events.NewRecord(events.TextMessage{Source: "1234567"}))
+	}
+
+	waitFor(t, "all commands", func() bool { return len(readLines(filename)) == 12 })
+	running, maxRunning := 0, 0
+	for _, line := range readLines(filename) {
+		if line == "start" {
+			running++
+		} else {
+			running--
+		}
+		maxRunning = max(maxRunning, running)
+	}
+	if maxRunning != 2 {
+		t.Errorf("expected 2 commands at the same time, got %d", maxRunning)
+	}
+}
+
+func TestRunner_HandleDropsEventsWhenTheQueueIsFull(t *testing.T) {
+	logged := captureLog(t)
+	hook, _ := Parse("true")
+	runner := NewRunner([]Hook{hook}, 1, time.Second)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range QueueSize + 2 {
+			runner.Handle(events.NewRecord(events.TextMessage{Source: "1234567"}))
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Handle blocks")
+	}
+	if len(runner.jobs) != QueueSize {
+		t.Errorf("expected %d waiting commands, got %d", QueueSize, len(runner.jobs))
+	}
+	if drops := strings.Count(logged.String(), "the message event is dropped"); drops != 2 {
+		t.Errorf("expected 2 dropped events, got %d", drops)
+	}
+}
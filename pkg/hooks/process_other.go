@@ -0,0 +1,11 @@
+//go:build !unix
+
+package hooks
+
+import (
+	"os/exec"
+)
+
+// stopProcessGroup is only supported on Unix systems. On other systems, only the shell is stopped when the command
+// is canceled.
+func stopProcessGroup(cmd *exec.Cmd) {}
@@ -0,0 +1,393 @@
+// Package httpapi provides an HTTP API to send SDS text and status messages and to stream the events received
+// from the radio.
+//
+// All endpoints consume and produce JSON:
+//
+//	POST /api/v1/messages              send a text message
+//	GET  /api/v1/messages/{reference}  get the delivery state of a text message
+//	POST /api/v1/statuses              send a status message
+//	GET  /api/v1/events                stream the received events as Server-Sent Events
+//	GET  /api/v1/events/ws             stream the received events through a WebSocket
+//
+// Sending a text message and getting its delivery state may block until the given delivery state
+// is reached ("wait": "received" or "consumed") or the given timeout expired (long-polling).
+// The event streams can be filtered by event type and source using the query parameters "type" and "source".
+//
+// The POST endpoints require the content type application/json, so that web pages cannot send messages through
+// the API with simple cross-origin requests. If the server has a token, all requests must provide it as bearer
+// token in the Authorization header; the event streams also accept it in the query parameter "token", because
+// browsers cannot set headers for EventSource and WebSocket connections.
+package httpapi
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ftl/tetra-pei/sds"
+	"github.com/ftl/tetra-pei/tetra"
+
+	"github.com/ftl/tetra-cli/pkg/messaging"
+)
+
+const (
+	// DefaultWaitTimeout is used when a client waits for a delivery state without defining a timeout.
+	DefaultWaitTimeout = 30 * time.Second
+
+	// MaxWaitTimeout is the maximum time a client may wait for a delivery state.
+	MaxWaitTimeout = 5 * time.Minute
+
+	defaultEncoding = "ISO8859-1"
+)
+
+// SendTextRequest is the body of POST /api/v1/messages.
+type SendTextRequest struct {
+	Destination      string `json:"destination"`
+	Text             string `json:"text"`
+	Immediate        bool   `json:"immediate,omitempty"`
+	AckReceive       bool   `json:"ack_receive,omitempty"`
+	AckConsume       bool   `json:"ack_consume,omitempty"`
+	Simple           bool   `json:"simple,omitempty"`
+	Encoding         string `json:"encoding,omitempty"`
+	MessageReference int    `json:"message_reference,omitempty"`
+	// Group defines that the destination is a group, given as GSSI, GTSI or the name of a talkgroup.
+	Group bool `json:"group,omitempty"`
+
+	// Wait defines the delivery state ("received" or "consumed") to wait for before responding.
+	Wait string `json:"wait,omitempty"`
+	// Timeout is the maximum time to wait for the delivery state, e.g. "30s".
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// DeliveryResponse describes the delivery state of a text message.
+type DeliveryResponse struct {
+	Destination      string `json:"destination"`
+	MessageReference int    `json:"message_reference"`
+	Parts            int    `json:"parts"`
+	State            string `json:"state"`
+	// PartStates contains the state of each part of a concatenated message.
+	PartStates     []string `json:"part_states,omitempty"`
+	DeliveryStatus string   `json:"delivery_status,omitempty"`
+	// TimedOut indicates that the requested delivery state was not reached in time.
+	TimedOut bool `json:"timed_out,omitempty"`
+	// Warning explains why a request was not fully applied, e.g. delivery reports requested for a group.
+	Warning string `json:"warning,omitempty"`
+}
+
+// SendStatusRequest is the body of POST /api/v1/statuses.
+type SendStatusRequest struct {
+	Destination string `json:"destination"`
+	// Status is the status value as hex string, e.g. "8005".
+	Status string `json:"status"`
+	// Group defines that the destination is a group, given as GSSI, GTSI or the name of a talkgroup.
+	Group bool `json:"group,omitempty"`
+}
+
+// StatusResponse confirms a sent status message.
+type StatusResponse struct {
+	Destination string `json:"destination"`
+	Status      string `json:"status"`
+}
+
+// ErrorResponse is returned with any error status code.
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// Server handles the requests of the HTTP API.
+type Server struct {
+	sender         *messaging.Sender
+	commandTimeout time.Duration
+	token          string
+	mux            *http.ServeMux
+}
+
+// NewServer returns a new server that uses the given sender. Sending a message may take at most the given command timeout.
+// If a hub is given, the server also provides the event streams of this hub.
+func NewServer(sender *messaging.Sender, hub *Hub, commandTimeout time.Duration) *Server {
+	result := &Server{
+		sender:         sender,
+		commandTimeout: commandTimeout,
+		mux:            http.NewServeMux(),
+	}
+
+	result.mux.HandleFunc("POST /api/v1/messages", requireJSON(result.sendText))
+	result.mux.HandleFunc("GET /api/v1/messages/{reference}", result.getDelivery)
+	result.mux.HandleFunc("POST /api/v1/statuses", requireJSON(result.sendStatus))
+	if hub != nil {
+		result.mux.HandleFunc("GET /api/v1/events", hub.ServeSSE)
+		result.mux.HandleFunc("GET /api/v1/events/ws", hub.ServeWebSocket)
+	}
+
+	return result
+}
+
+// WithToken requires all requests to provide the given token. An empty token disables the check.
+func (s *Server) WithToken(token string) *Server {
+	s.token = token
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, http.StatusUnauthorized, fmt.Errorf("missing or invalid token"))
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// authorized checks the bearer token of the given request. The event streams may also provide the token in the query
+// parameter "token".
+func (s *Server) authorized(r *http.Request) bool {
+	if s.token == "" {
+		return true
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok && r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/api/v1/events") {
+		token = r.URL.Query().Get("token")
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimSpace(token)), []byte(s.token)) == 1
+}
+
+// requireJSON rejects requests without the content type application/json. Browsers send requests with this content
+// type to other origins only after a preflight request, which the server does not answer.
+func requireJSON(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || mediaType != "application/json" {
+			writeError(w, http.StatusUnsupportedMediaType, fmt.Errorf("the content type must be application/json"))
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func (s *Server) sendText(w http.ResponseWriter, r *http.Request) {
+	var request SendTextRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+
+	message, err := request.textMessage()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	waitState, waitTimeout, err := parseWait(request.Wait, request.Timeout)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	var warning string
+	if message.GroupReportsRequested() || message.Group && waitState != "" {
+		warning = "groups do not send delivery reports, the message was sent without requesting delivery reports"
+		waitState = ""
+	}
+	err = checkWait(message, waitState)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	sendCtx, cancelSend := context.WithTimeout(r.Context(), s.commandTimeout)
+	defer cancelSend()
+	delivery, err := s.sender.SendText(sendCtx, message)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+
+	response := waitForDelivery(r.Context(), delivery, waitState, waitTimeout)
+	response.Warning = warning
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (r SendTextRequest) textMessage() (messaging.TextMessage, error) {
+	destination := strings.TrimSpace(r.Destination)
+	err := messaging.ValidateDestination(tetra.Identity(destination), r.Group)
+	if err != nil {
+		return messaging.TextMessage{}, err
+	}
+	if r.MessageReference < 0 || r.MessageReference > 255 {
+		return messaging.TextMessage{}, fmt.Errorf("the message reference must be 1-255, but got %d", r.MessageReference)
+	}
+	encodingName := r.Encoding
+	if encodingName == "" {
+		encodingName = defaultEncoding
+	}
+	encoding, err := messaging.ParseEncoding(encodingName)
+	if err != nil {
+		return messaging.TextMessage{}, err
+	}
+	err = messaging.ValidateText(r.Text, encoding)
+	if err != nil {
+		return messaging.TextMessage{}, err
+	}
+
+	return messaging.TextMessage{
+		Destination:      tetra.Identity(destination),
+		Text:             r.Text,
+		MessageReference: sds.MessageReference(r.MessageReference),
+		Encoding:         encoding,
+		Immediate:        r.Immediate,
+		AckReceive:       r.AckReceive,
+		AckConsume:       r.AckConsume,
+		Simple:           r.Simple,
+		Group:            r.Group,
+	}, nil
+}
+
+func (s *Server) getDelivery(w http.ResponseWriter, r *http.Request) {
+	reference, err := strconv.Atoi(r.PathValue("reference"))
+	if err != nil || reference < 1 || reference > 255 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid message reference: %s", r.PathValue("reference")))
+		return
+	}
+	waitState, waitTimeout, err := parseWait(r.URL.Query().Get("wait"), r.URL.Query().Get("timeout"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// message references are allocated per destination, the destination selects the message unambiguously
+	var delivery *messaging.Delivery
+	var ok bool
+	if destination := strings.TrimSpace(r.URL.Query().Get("destination")); destination != "" {
+		delivery, ok = s.sender.DeliveryTo(tetra.Identity(destination), sds.MessageReference(reference))
+	} else {
+		delivery, ok = s.sender.Delivery(sds.MessageReference(reference))
+	}
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no message sent with reference %d", reference))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, waitForDelivery(r.Context(), delivery, waitState, waitTimeout))
+}
+
+func (s *Server) sendStatus(w http.ResponseWriter, r *http.Request) {
+	var request SendStatusRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+
+	destination := strings.TrimSpace(request.Destination)
+	err = messaging.ValidateDestination(tetra.Identity(destination), request.Group)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	status, err := messaging.ParseStatus(request.Status)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.commandTimeout)
+	defer cancel()
+	err = s.sender.SendStatus(ctx, messaging.StatusMessage{
+		Destination: tetra.Identity(destination),
+		Status:      status,
+		Group:       request.Group,
+	})
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, StatusResponse{
+		Destination: destination,
+		Status:      fmt.Sprintf("%04x", uint16(status)),
+	})
+}
+
+// parseWait parses the delivery state to wait for and the timeout. An empty state means not to wait.
+func parseWait(state string, timeout string) (messaging.DeliveryState, time.Duration, error) {
+	var waitState messaging.DeliveryState
+	switch messaging.DeliveryState(strings.ToLower(strings.TrimSpace(state))) {
+	case "":
+		return "", 0, nil
+	case messaging.Received:
+		waitState = messaging.Received
+	case messaging.Consumed:
+		waitState = messaging.Consumed
+	default:
+		return "", 0, fmt.Errorf("cannot wait for %q, only received or consumed", state)
+	}
+
+	if timeout == "" {
+		return waitState, DefaultWaitTimeout, nil
+	}
+	waitTimeout, err := time.ParseDuration(timeout)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid timeout: %w", err)
+	}
+	return waitState, min(waitTimeout, MaxWaitTimeout), nil
+}
+
+// checkWait checks that the given message requests the delivery report to wait for, otherwise the request would wait
+// until the timeout for a report that never arrives.
+func checkWait(message messaging.TextMessage, state messaging.DeliveryState) error {
+	switch {
+	case state == "":
+		return nil
+	case message.Simple:
+		return fmt.Errorf("simple text messages have no delivery reports, cannot wait for %s", state)
+	case state == messaging.Received && !message.AckReceive && !message.AckConsume:
+		return fmt.Errorf("cannot wait for %s without ack_receive or ack_consume", state)
+	case state == messaging.Consumed && !message.AckConsume:
+		return fmt.Errorf("cannot wait for %s without ack_consume", state)
+	default:
+		return nil
+	}
+}
+
+func waitForDelivery(ctx context.Context, delivery *messaging.Delivery, state messaging.DeliveryState, timeout time.Duration) DeliveryResponse {
+	var timedOut bool
+	if state != "" {
+		waitCtx, cancel := context.WithTimeout(ctx, timeout)
+		err := delivery.Wait(waitCtx, state)
+		timedOut = errors.Is(err, context.DeadlineExceeded)
+		cancel()
+	}
+
+	currentState, deliveryStatus := delivery.State()
+	result := DeliveryResponse{
+		Destination:      string(delivery.Destination),
+		MessageReference: int(delivery.MessageReference),
+		Parts:            delivery.Parts,
+		State:            string(currentState),
+		TimedOut:         timedOut,
+	}
+	if currentState != messaging.Sent {
+		result.DeliveryStatus = fmt.Sprintf("0x%02x", byte(deliveryStatus))
+	}
+	if delivery.Parts > 1 {
+		for _, partState := range delivery.PartStates() {
+			result.PartStates = append(result.PartStates, string(partState))
+		}
+	}
+	return result
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, statusCode int, err error) {
+	writeJSON(w, statusCode, ErrorResponse{Error: err.Error()})
+}
@@ -0,0 +1,154 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ftl/tetra-pei/sds"
+
+	"github.com/ftl/tetra-cli/pkg/fakepei"
+	"github.com/ftl/tetra-cli/pkg/messaging"
+)
+
+func newTestServer(t *testing.T, exchanges ...fakepei.Exchange) (*Server, *fakepei.PEI) {
+	t.Helper()
+	pei := fakepei.New(exchanges...)
+	sender, err := messaging.NewSender(pei)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewServer(sender, nil, time.Second), pei
+}
+
+func postStatus(server http.Handler, contentType string, token string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodPost, "/api/v1/statuses", strings.NewReader(`{"destination": "1234567", "status": "8005"}`))
+	if contentType != "" {
+		request.Header.Set("Content-Type", contentType)
+	}
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	response := httptest.NewRecorder()
+	server.ServeHTTP(response, request)
+	return response
+}
+
+func TestServer_SendStatus(t *testing.T) {
+	server, pei := newTestServer(t,
+		fakepei.Expect("AT+CTSP=2,2,20"),
+		fakepei.Expect("AT+CTSDS=13,0"),
+		fakepei.Expect(sds.SendMessage("1234567", sds.Status(0x8005))),
+	)
+
+	response := postStatus(server, "application/json; charset=utf-8", "")
+
+	if response.Code != http.StatusOK {
+		t.Errorf("unexpected status %d: %s", response.Code, response.Body)
+	}
+	if err := pei.Verify(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestServer_RequiresJSON(t *testing.T) {
+	for _, contentType := range []string{"", "text/plain", "application/x-www-form-urlencoded", "multipart/form-data; boundary=x"} {
+		server, pei := newTestServer(t)
+
+		response := postStatus(server, contentType, "")
+
+		if response.Code != http.StatusUnsupportedMediaType {
+			t.Errorf("%q: expected status 415, got %d", contentType, response.Code)
+		}
+		if len(pei.Requests()) != 0 {
+			t.Errorf("%q: no request must reach the radio, got %q", contentType, pei.Requests())
+		}
+	}
+}
+
+func TestServer_RequiresTheToken(t *testing.T) {
+	server, pei := newTestServer(t,
+		fakepei.Expect("AT+CTSP=2,2,20"),
+		fakepei.Expect("AT+CTSDS=13,0"),
+		fakepei.Expect(sds.SendMessage("1234567", sds.Status(0x8005))),
+	)
+	server.WithToken("secret")
+
+	for _, token := range []string{"", "wrong"} {
+		response := postStatus(server, "application/json", token)
+		if response.Code != http.StatusUnauthorized {
+			t.Errorf("token %q: expected status 401, got %d", token, response.Code)
+		}
+	}
+	if len(pei.Requests()) != 0 {
+		t.Errorf("no request must reach the radio without the token, got %q", pei.Requests())
+	}
+
+	response := postStatus(server, "application/json", "secret")
+	if response.Code != http.StatusOK {
+		t.Errorf("unexpected status %d: %s", response.Code, response.Body)
+	}
+	if err := pei.Verify(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestServer_AcceptsTheTokenInTheQueryOfTheEventStreams(t *testing.T) {
+	server, _ := newTestServer(t)
+	server.WithToken("secret")
+
+	tests := []struct {
+		method string
+		target string
+		valid  bool
+	}{
+		{http.MethodGet, "/api/v1/events?token=secret", true},
+		{http.MethodGet, "/api/v1/events/ws?token=secret", true},
+		{http.MethodGet, "/api/v1/events?token=wrong", false},
+		{http.MethodGet, "/api/v1/messages/1?token=secret", false},
+		{http.MethodPost, "/api/v1/statuses?token=secret", false},
+	}
+	for _, tt := range tests {
+		request := httptest.NewRequest(tt.method, tt.target, nil)
+		if got := server.authorized(request); got != tt.valid {
+			t.Errorf("%s %s: expected %t, got %t", tt.method, tt.target, tt.valid, got)
+		}
+	}
+}
+
+func TestServer_RejectsWaitingForReportsThatWereNotRequested(t *testing.T) {
+	tests := []struct {
+		body  string
+		valid bool
+	}{
+		{`{"destination": "1234567", "text": "hello", "wait": "received"}`, false},
+		{`{"destination": "1234567", "text": "hello", "wait": "consumed", "ack_receive": true}`, false},
+		{`{"destination": "1234567", "text": "hello", "wait": "received", "simple": true, "ack_receive": true}`, false},
+		{`{"destination": "1234567", "text": "hello", "wait": "received", "ack_consume": true}`, true},
+		{`{"destination": "2620001", "text": "hello", "wait": "consumed", "group": true}`, true},
+	}
+	for _, tt := range tests {
+		server, pei := newTestServer(t)
+		pei.OnUnexpected = func(request string) ([]string, error) {
+			return nil, fmt.Errorf("the radio is not available")
+		}
+		request := httptest.NewRequest(http.MethodPost, "/api/v1/messages", strings.NewReader(tt.body))
+		request.Header.Set("Content-Type", "application/json")
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+
+		if tt.valid && response.Code != http.StatusBadGateway {
+			t.Errorf("%s: the request should reach the radio, got %d: %s", tt.body, response.Code, response.Body)
+		}
+		if !tt.valid && response.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", tt.body, response.Code)
+		}
+		if !tt.valid && len(pei.Requests()) != 0 {
+			t.Errorf("%s: no request must reach the radio, got %q", tt.body, pei.Requests())
+		}
+	}
+}
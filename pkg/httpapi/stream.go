@@ -0,0 +1,246 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/ftl/tetra-cli/pkg/events"
+)
+
+const (
+	// subscriberQueueSize is the number of events that are buffered for a subscriber before events are dropped.
+	subscriberQueueSize = 100
+
+	// keepaliveInterval is the interval in which idle streams are kept alive.
+	keepaliveInterval = 30 * time.Second
+
+	websocketWriteTimeout = 10 * time.Second
+)
+
+// Filter selects events by their type and source. An empty set matches all events.
+type Filter struct {
+	Types   map[string]bool
+	Sources map[string]bool
+}
+
+// ParseFilter reads the filter from the query parameters "type" and "source" of the given request.
+// Both parameters may be repeated or contain a comma separated list of values.
+func ParseFilter(r *http.Request) Filter {
+	return Filter{
+		Types:   queryValues(r, "type"),
+		Sources: queryValues(r, "source"),
+	}
+}
+
+func queryValues(r *http.Request, name string) map[string]bool {
+	result := make(map[string]bool)
+	for _, value := range r.URL.Query()[name] {
+		for _, v := range strings.Split(value, ",") {
+			v = strings.TrimSpace(v)
+			if v != "" {
+				result[strings.ToLower(v)] = true
+			}
+		}
+	}
+	return result
+}
+
+// Matches indicates if the given event matches this filter.
+func (f Filter) Matches(event events.Event) bool {
+	record := events.NewRecord(event)
+	if len(f.Types) > 0 && !f.Types[string(record.Type)] {
+		return false
+	}
+	if len(f.Sources) > 0 && !f.Sources[strings.ToLower(record.Source)] {
+		return false
+	}
+	return true
+}
+
+// Hub distributes events to any number of subscribers. Each subscriber receives the events that match its filter.
+// The Hub provides the stream of events as Server-Sent Events and through a WebSocket.
+type Hub struct {
+	lock        sync.Mutex
+	subscribers map[*subscriber]bool
+	upgrader    websocket.Upgrader
+}
+
+type subscriber struct {
+	filter Filter
+	events chan events.Event
+}
+
+// NewHub returns a new Hub without subscribers. WebSocket connections are accepted from the same origin and from the
+// given allowed origins, e.g. "https://dashboard.example.com". The origin "*" allows all origins.
+func NewHub(allowedOrigins ...string) *Hub {
+	origins := make(map[string]bool)
+	for _, origin := range allowedOrigins {
+		origins[normalizeOrigin(origin)] = true
+	}
+	return &Hub{
+		subscribers: make(map[*subscriber]bool),
+		upgrader: websocket.Upgrader{
+			CheckOrigin: func(r *http.Request) bool { return checkOrigin(r, origins) },
+		},
+	}
+}
+
+// checkOrigin accepts requests without an Origin header (not sent by a browser), requests from the same origin,
+// and requests from one of the allowed origins.
+func checkOrigin(r *http.Request, allowedOrigins map[string]bool) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if allowedOrigins["*"] || allowedOrigins[normalizeOrigin(origin)] {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+func normalizeOrigin(origin string) string {
+	return strings.ToLower(strings.TrimSuffix(strings.TrimSpace(origin), "/"))
+}
+
+// Publish the given event to all matching subscribers. Publish never blocks, events are dropped for subscribers
+// that are too slow.
+func (h *Hub) Publish(event events.Event) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	for subscriber := range h.subscribers {
+		if !subscriber.filter.Matches(event) {
+			continue
+		}
+		select {
+		case subscriber.events <- event:
+		default:
+			log.Printf("subscriber too slow, dropping %s event", event.Kind())
+		}
+	}
+}
+
+func (h *Hub) subscribe(filter Filter) (*subscriber, func()) {
+	result := &subscriber{
+		filter: filter,
+		events: make(chan events.Event, subscriberQueueSize),
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.subscribers[result] = true
+
+	return result, func() {
+		h.lock.Lock()
+		defer h.lock.Unlock()
+		delete(h.subscribers, result)
+	}
+}
+
+// ServeSSE streams the events as Server-Sent Events.
+func (h *Hub) ServeSSE(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("streaming is not supported"))
+		return
+	}
+
+	subscriber, unsubscribe := h.subscribe(ParseFilter(r))
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepalive := time.NewTicker(keepaliveInterval)
+	defer keepalive.Stop()
+
+	var id int
+	for {
+		select {
+		case event := <-subscriber.events:
+			data, err := json.Marshal(event)
+			if err != nil {
+				log.Printf("cannot encode %s event: %v", event.Kind(), err)
+				continue
+			}
+			id++
+			_, err = fmt.Fprintf(w, "id: %d\ndata: %s\n\n", id, data)
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-keepalive.C:
+			_, err := fmt.Fprint(w, ": keepalive\n\n")
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// ServeWebSocket streams the events as JSON text messages through a WebSocket.
+func (h *Hub) ServeWebSocket(w http.ResponseWriter, r *http.Request) {
+	filter := ParseFilter(r)
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader already responded with an error
+		return
+	}
+	defer conn.Close()
+
+	subscriber, unsubscribe := h.subscribe(filter)
+	defer unsubscribe()
+
+	// the client is not expected to send anything, but reading is required to process control messages
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			_, _, err := conn.NextReader()
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	keepalive := time.NewTicker(keepaliveInterval)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case event := <-subscriber.events:
+			conn.SetWriteDeadline(time.Now().Add(websocketWriteTimeout))
+			err := conn.WriteJSON(event)
+			if err != nil {
+				return
+			}
+		case <-keepalive.C:
+			err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(websocketWriteTimeout))
+			if err != nil {
+				return
+			}
+		case <-closed:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
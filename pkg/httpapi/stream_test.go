@@ -0,0 +1,67 @@
+package httpapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func dialEvents(t *testing.T, hub *Hub, origin string) (int, error) {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(hub.ServeWebSocket))
+	defer server.Close()
+
+	header := http.Header{}
+	if origin != "" {
+		header.Set("Origin", origin)
+	}
+	conn, response, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), header)
+	if conn != nil {
+		conn.Close()
+	}
+	if response == nil {
+		return 0, err
+	}
+	return response.StatusCode, err
+}
+
+func TestHub_ChecksTheOriginOfWebSockets(t *testing.T) {
+	tests := []struct {
+		name           string
+		allowedOrigins []string
+		origin         string
+		valid          bool
+	}{
+		{"no origin", nil, "", true},
+		{"other origin", nil, "https://evil.example.com", false},
+		{"allowed origin", []string{"https://dashboard.example.com/"}, "https://Dashboard.example.com", true},
+		{"not allowed origin", []string{"https://dashboard.example.com"}, "https://evil.example.com", false},
+		{"all origins", []string{"*"}, "https://evil.example.com", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, err := dialEvents(t, NewHub(tt.allowedOrigins...), tt.origin)
+			if tt.valid && err != nil {
+				t.Errorf("the connection should be accepted: %v", err)
+			}
+			if !tt.valid && status != http.StatusForbidden {
+				t.Errorf("the connection should be rejected, got status %d", status)
+			}
+		})
+	}
+}
+
+func TestCheckOrigin_SameOrigin(t *testing.T) {
+	request := httptest.NewRequest(http.MethodGet, "http://localhost:8080/api/v1/events/ws", nil)
+	request.Header.Set("Origin", "http://localhost:8080")
+	if !checkOrigin(request, nil) {
+		t.Error("the same origin should be accepted")
+	}
+	request.Header.Set("Origin", "http://localhost:3000")
+	if checkOrigin(request, nil) {
+		t.Error("another port should be rejected")
+	}
+}
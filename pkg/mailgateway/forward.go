@@ -0,0 +1,175 @@
+package mailgateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-sasl"
+	"github.com/emersion/go-smtp"
+
+	"github.com/ftl/tetra-cli/pkg/events"
+	"github.com/ftl/tetra-cli/pkg/messaging"
+)
+
+// QueueSize is the maximum number of text messages that wait to be forwarded. Further text messages are dropped.
+const QueueSize = 100
+
+// sendTimeout is the maximum time to send an email to the SMTP server.
+const sendTimeout = time.Minute
+
+// Forwarder sends the received text messages as emails.
+type Forwarder struct {
+	config   Config
+	messages chan events.Record
+}
+
+// NewForwarder returns a new forwarder with the given configuration.
+func NewForwarder(config Config) *Forwarder {
+	return &Forwarder{
+		config:   config,
+		messages: make(chan events.Record, QueueSize),
+	}
+}
+
+// Run sends the emails until the given context is done.
+func (f *Forwarder) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case record := <-f.messages:
+			err := f.send(record)
+			if err != nil {
+				log.Printf("cannot forward the text message from %s as email: %v", record.Source, err)
+			} else {
+				log.Printf("forwarded the text message from %s to %s", record.Source, strings.Join(f.config.Forward.To, ", "))
+			}
+		}
+	}
+}
+
+// Handle queues the given event if it is a text message. Handle never blocks; if the queue is full, the text message
+// is dropped.
+func (f *Forwarder) Handle(record events.Record) {
+	if record.Type != events.TextMessageKind {
+		return
+	}
+	select {
+	case f.messages <- record:
+	default:
+		log.Printf("too many emails are waiting, the text message from %s is not forwarded", record.Source)
+	}
+}
+
+func (f *Forwarder) send(record events.Record) error {
+	email, err := f.Email(record)
+	if err != nil {
+		return err
+	}
+	from, _ := mail.ParseAddress(f.config.SMTP.From)
+	to := make([]string, len(f.config.Forward.To))
+	for i, recipient := range f.config.Forward.To {
+		address, _ := mail.ParseAddress(recipient)
+		to[i] = address.Address
+	}
+
+	conn, err := net.DialTimeout("tcp", f.config.SMTP.Server, sendTimeout)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(sendTimeout))
+	host, _, _ := net.SplitHostPort(f.config.SMTP.Server)
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		err = client.StartTLS(&tls.Config{ServerName: host})
+		if err != nil {
+			return err
+		}
+	}
+	if f.config.SMTP.Username != "" {
+		err = client.Auth(sasl.NewPlainClient("", f.config.SMTP.Username, f.config.SMTP.Password))
+		if err != nil {
+			return err
+		}
+	}
+	err = client.Mail(from.Address, nil)
+	if err != nil {
+		return err
+	}
+	for _, recipient := range to {
+		err = client.Rcpt(recipient)
+		if err != nil {
+			return err
+		}
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(email)
+	if err != nil {
+		return err
+	}
+	err = writer.Close()
+	if err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// Email returns the email for the given text message, with subject and body rendered from the templates. If the
+// gateway has a domain, replies to the email are sent back to the source of the text message.
+func (f *Forwarder) Email(record events.Record) ([]byte, error) {
+	sender := record.Source
+	if record.SourceName != "" {
+		sender = fmt.Sprintf("%s (%s)", record.Source, record.SourceName)
+	}
+	values := map[string]string{
+		"sender":      sender,
+		"source":      record.Source,
+		"source_name": record.SourceName,
+		"text":        record.Text,
+		"opta":        record.OPTA,
+		"time":        record.Time.Format(time.RFC3339),
+	}
+	subject, err := messaging.Template{Name: "subject", Text: f.config.Forward.Subject}.Render(values)
+	if err != nil {
+		return nil, err
+	}
+	body, err := messaging.Template{Name: "body", Text: f.config.Forward.Body}.Render(values)
+	if err != nil {
+		return nil, err
+	}
+
+	var buffer bytes.Buffer
+	fmt.Fprintf(&buffer, "From: %s\r\n", f.config.SMTP.From)
+	fmt.Fprintf(&buffer, "To: %s\r\n", strings.Join(f.config.Forward.To, ", "))
+	if f.config.Domain != "" && record.Source != "" {
+		fmt.Fprintf(&buffer, "Reply-To: %s@%s\r\n", record.Source, f.config.Domain)
+	}
+	fmt.Fprintf(&buffer, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", strings.ReplaceAll(subject, "\n", " ")))
+	fmt.Fprintf(&buffer, "Date: %s\r\n", record.Time.Format(time.RFC1123Z))
+	buffer.WriteString("MIME-Version: 1.0\r\n")
+	buffer.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buffer.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	writer := quotedprintable.NewWriter(&buffer)
+	writer.Write([]byte(strings.ReplaceAll(body, "\n", "\r\n")))
+	writer.Close()
+	buffer.WriteString("\r\n")
+	return buffer.Bytes(), nil
+}
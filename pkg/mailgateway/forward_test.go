@@ -0,0 +1,130 @@
+package mailgateway
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-smtp"
+
+	"github.com/ftl/tetra-cli/pkg/events"
+)
+
+// sentMail is an email received by the test SMTP server.
+type sentMail struct {
+	from string
+	to   []string
+	data string
+}
+
+// testSMTPServer is a local SMTP server that accepts all emails.
+type testSMTPServer struct {
+	mails chan sentMail
+}
+
+func (s *testSMTPServer) Login(_ *smtp.ConnectionState, _, _ string) (smtp.Session, error) {
+	return nil, smtp.ErrAuthUnsupported
+}
+
+func (s *testSMTPServer) AnonymousLogin(_ *smtp.ConnectionState) (smtp.Session, error) {
+	return &testSMTPSession{server: s}, nil
+}
+
+type testSMTPSession struct {
+	server *testSMTPServer
+	mail   sentMail
+}
+
+func (s *testSMTPSession) Reset()        { s.mail = sentMail{} }
+func (s *testSMTPSession) Logout() error { return nil }
+
+func (s *testSMTPSession) Mail(from string, _ smtp.MailOptions) error {
+	s.mail.from = from
+	return nil
+}
+
+func (s *testSMTPSession) Rcpt(to string) error {
+	s.mail.to = append(s.mail.to, to)
+	return nil
+}
+
+func (s *testSMTPSession) Data(r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	s.mail.data = string(data)
+	s.server.mails <- s.mail
+	return nil
+}
+
+func runTestSMTPServer(t *testing.T) (string, chan sentMail) {
+	t.Helper()
+	backend := &testSMTPServer{mails: make(chan sentMail, 10)}
+	server := smtp.NewServer(backend)
+	server.Domain = "localhost"
+	server.AuthDisabled = true
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+	return listener.Addr().String(), backend.mails
+}
+
+func TestForwarder_SendsTextMessagesAsEmails(t *testing.T) {
+	addr, mails := runTestSMTPServer(t)
+	forwarder := NewForwarder(Config{
+		Domain: "tetra.example.com",
+		SMTP:   SMTPConfig{Server: addr, From: "TETRA <tetra@example.com>"},
+		Forward: ForwardConfig{
+			To:      []string{"ops@example.com", "Duty Officer <duty@example.com>"},
+			Subject: DefaultSubject,
+			Body:    "{{text}} ({{time}})",
+		},
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go forwarder.Run(ctx)
+
+	messageTime := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+	forwarder.Handle(events.NewRecord(events.StatusMessage{Time: messageTime, Source: "1234567", Status: 0x8002}))
+	record := events.NewRecord(events.TextMessage{Time: messageTime, Source: "1234567", Text: "Straße frei"})
+	record.SourceName = "Engine 1"
+	forwarder.Handle(record)
+
+	var mail sentMail
+	select {
+	case mail = <-mails:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no email was sent")
+	}
+	if mail.from != "tetra@example.com" || strings.Join(mail.to, ",") != "ops@example.com,duty@example.com" {
+		t.Errorf("unexpected envelope %s -> %v", mail.from, mail.to)
+	}
+	for _, header := range []string{
+		"From: TETRA <tetra@example.com>\r\n",
+		"To: ops@example.com, Duty Officer <duty@example.com>\r\n",
+		"Reply-To: 1234567@tetra.example.com\r\n",
+		"Subject: SDS from 1234567 (Engine 1)\r\n",
+		"Date: Wed, 01 May 2024 12:30:00 +0000\r\n",
+	} {
+		if !strings.Contains(mail.data, header) {
+			t.Errorf("the header %q is missing:\n%s", header, mail.data)
+		}
+	}
+	if !strings.HasSuffix(mail.data, "\r\n\r\nStra=C3=9Fe frei (2024-05-01T12:30:00Z)\r\n") {
+		t.Errorf("unexpected body:\n%s", mail.data)
+	}
+
+	select {
+	case mail = <-mails:
+		t.Errorf("only text messages should be forwarded, got %s", mail.data)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,571 @@
+// Package mailgateway connects SDS text messages with email. An embedded SMTP receiver accepts emails to
+// <destination>@<domain> from allowed clients and senders and turns them into text messages, a forwarder sends the
+// received text messages as emails through an SMTP server.
+package mailgateway
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-smtp"
+	"github.com/ftl/tetra-pei/sds"
+	"golang.org/x/text/encoding/htmlindex"
+	"gopkg.in/yaml.v3"
+
+	"github.com/ftl/tetra-cli/pkg/messaging"
+	"github.com/ftl/tetra-cli/pkg/store"
+)
+
+// Default parameters of the gateway.
+const (
+	DefaultText            = "{{subject}}\n{{body}}"
+	DefaultSubject         = "SDS from {{sender}}"
+	DefaultBody            = "{{text}}"
+	DefaultMaxMessageBytes = 1024 * 1024
+)
+
+const receiverTimeout = 5 * time.Minute
+
+// DefaultConfigPath returns the default path of the gateway configuration.
+func DefaultConfigPath() string {
+	return filepath.Join(store.ConfigDir(), "email.yaml")
+}
+
+// Config defines how emails are received and sent.
+type Config struct {
+	// Listen is the address of the SMTP receiver, e.g. localhost:2525. If it is empty, no emails are received.
+	Listen string `json:"listen,omitempty" yaml:"listen,omitempty"`
+	// Domain is the domain of the recipients, e.g. tetra.example.com for 1234567@tetra.example.com.
+	Domain string `json:"domain,omitempty" yaml:"domain,omitempty"`
+	// Clients are the hosts that may connect to the receiver, given as IP address or as network, e.g. 10.0.0.0/8.
+	// Without clients, only connections from the loopback interface are accepted.
+	Clients []string `json:"clients,omitempty" yaml:"clients,omitempty"`
+	// Allow are the senders that may send emails to the gateway, given as address or as @domain. The envelope sender
+	// is checked, which is not proof of identity: any client may claim any sender, so restrict the clients as well.
+	Allow []string `json:"allow,omitempty" yaml:"allow,omitempty"`
+	// Text is the template of the text message for a received email, with the placeholders {{from}},
+	// {{from_name}}, {{subject}} and {{body}}.
+	Text string `json:"text,omitempty" yaml:"text,omitempty"`
+	// MaxLength is the maximum number of characters of a text message for a received email. Longer texts are
+	// truncated, 0 means no limit.
+	MaxLength int `json:"max_length,omitempty" yaml:"max_length,omitempty"`
+
+	// SMTP is the server that sends the forwarded emails.
+	SMTP SMTPConfig `json:"smtp,omitzero" yaml:"smtp,omitempty"`
+	// Forward defines how the received text messages are forwarded as emails.
+	Forward ForwardConfig `json:"forward,omitzero" yaml:"forward,omitempty"`
+}
+
+// SMTPConfig defines the connection to the SMTP server that sends emails.
+type SMTPConfig struct {
+	// Server is the address of the SMTP server, e.g. mail.example.com:587. STARTTLS is used if the server supports it.
+	Server   string `json:"server" yaml:"server"`
+	Username string `json:"username,omitempty" yaml:"username,omitempty"`
+	Password string `json:"password,omitempty" yaml:"password,omitempty"`
+	// From is the sender of the forwarded emails, e.g. "TETRA <tetra@example.com>".
+	From string `json:"from" yaml:"from"`
+}
+
+// ForwardConfig defines how the received text messages are forwarded as emails.
+type ForwardConfig struct {
+	// To are the recipients of the forwarded emails. Without recipients, no text messages are forwarded.
+	To []string `json:"to,omitempty" yaml:"to,omitempty"`
+	// Subject and Body are templates with the placeholders {{sender}}, {{source}}, {{source_name}}, {{text}},
+	// {{opta}} and {{time}}.
+	Subject string `json:"subject,omitempty" yaml:"subject,omitempty"`
+	Body    string `json:"body,omitempty" yaml:"body,omitempty"`
+}
+
+// Placeholders of the templates.
+var (
+	mailPlaceholders    = []string{"from", "from_name", "subject", "body"}
+	messagePlaceholders = []string{"sender", "source", "source_name", "text", "opta", "time"}
+)
+
+// LoadConfig reads the gateway configuration from the given YAML or JSON file. Example:
+//
+//	listen: localhost:2525
+//	domain: tetra.example.com
+//	clients: [127.0.0.1, 192.168.1.0/24]
+//	allow: [dispatch@example.com, "@fire.example.com"]
+//	smtp:
+//	  server: mail.example.com:587
+//	  username: tetra
+//	  password: s3cr3t
+//	  from: TETRA <tetra@example.com>
+//	forward:
+//	  to: [ops@example.com]
+func LoadConfig(path string) (Config, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("cannot read the email configuration: %w", err)
+	}
+
+	// JSON is a subset of YAML, so both formats are read the same way.
+	var result Config
+	err = yaml.Unmarshal(content, &result)
+	if err != nil {
+		return Config{}, fmt.Errorf("cannot read the email configuration from %s: %w", path, err)
+	}
+	if result.Text == "" {
+		result.Text = DefaultText
+	}
+	if result.Forward.Subject == "" {
+		result.Forward.Subject = DefaultSubject
+	}
+	if result.Forward.Body == "" {
+		result.Forward.Body = DefaultBody
+	}
+	err = result.validate()
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid email configuration %s: %w", path, err)
+	}
+	return result, nil
+}
+
+func (c Config) validate() error {
+	if c.Listen == "" && len(c.Forward.To) == 0 {
+		return fmt.Errorf("neither receiving (listen) nor forwarding (forward.to) is configured")
+	}
+	if c.Listen != "" && len(c.Allow) == 0 {
+		return fmt.Errorf("the receiver needs an allowlist of senders (allow)")
+	}
+	_, err := c.clientNetworks()
+	if err != nil {
+		return err
+	}
+	for _, sender := range c.Allow {
+		if !strings.Contains(sender, "@") {
+			return fmt.Errorf("invalid allowed sender %q, use an address or @domain", sender)
+		}
+	}
+	if c.MaxLength < 0 {
+		return fmt.Errorf("invalid max_length %d", c.MaxLength)
+	}
+	err = validateTemplate("text", c.Text, mailPlaceholders)
+	if err != nil {
+		return err
+	}
+
+	if len(c.Forward.To) == 0 {
+		return nil
+	}
+	if c.SMTP.Server == "" || c.SMTP.From == "" {
+		return fmt.Errorf("forwarding needs an SMTP server (smtp.server) and a sender (smtp.from)")
+	}
+	_, err = mail.ParseAddress(c.SMTP.From)
+	if err != nil {
+		return fmt.Errorf("invalid sender %q: %w", c.SMTP.From, err)
+	}
+	for _, to := range c.Forward.To {
+		_, err = mail.ParseAddress(to)
+		if err != nil {
+			return fmt.Errorf("invalid recipient %q: %w", to, err)
+		}
+	}
+	err = validateTemplate("subject", c.Forward.Subject, messagePlaceholders)
+	if err != nil {
+		return err
+	}
+	return validateTemplate("body", c.Forward.Body, messagePlaceholders)
+}
+
+func validateTemplate(name string, text string, placeholders []string) error {
+	template := messaging.Template{Name: name, Text: text}
+	for _, placeholder := range template.Placeholders() {
+		if !slices.Contains(placeholders, placeholder) {
+			return fmt.Errorf("unknown placeholder {{%s}} in the %s, use one of %s", placeholder, name, strings.Join(placeholders, ", "))
+		}
+	}
+	return nil
+}
+
+// clientNetworks returns the networks of the clients that may connect to the receiver.
+func (c Config) clientNetworks() ([]*net.IPNet, error) {
+	if len(c.Clients) == 0 {
+		return []*net.IPNet{
+			{IP: net.IPv4(127, 0, 0, 0), Mask: net.CIDRMask(8, 32)},
+			{IP: net.IPv6loopback, Mask: net.CIDRMask(128, 128)},
+		}, nil
+	}
+	result := make([]*net.IPNet, 0, len(c.Clients))
+	for _, client := range c.Clients {
+		client = strings.TrimSpace(client)
+		if ip := net.ParseIP(client); ip != nil {
+			bits := 8 * len(ip)
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+			result = append(result, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(client)
+		if err != nil {
+			return nil, fmt.Errorf("invalid client %q, use an IP address or a network", client)
+		}
+		result = append(result, network)
+	}
+	return result, nil
+}
+
+// ClientAllowed indicates that the client with the given address may connect to the receiver.
+func (c Config) ClientAllowed(addr net.Addr) bool {
+	host := addr.String()
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		host = tcpAddr.IP.String()
+	} else if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	networks, err := c.clientNetworks()
+	if err != nil {
+		return false
+	}
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Allowed indicates that the given sender address may send emails to the gateway.
+func (c Config) Allowed(address string) bool {
+	address = strings.ToLower(strings.TrimSpace(address))
+	for _, sender := range c.Allow {
+		sender = strings.ToLower(strings.TrimSpace(sender))
+		if strings.HasPrefix(sender, "@") && strings.HasSuffix(address, sender) || address == sender {
+			return true
+		}
+	}
+	return false
+}
+
+// Mail is a received email.
+type Mail struct {
+	// From is the sender of the email. The receiver uses the envelope sender that was checked against the allowlist.
+	From string
+	// FromName is the display name of the sender, taken from the From header if it has the same address as From.
+	FromName string
+	// Recipients are the local parts of the recipients, e.g. 1234567 for 1234567@tetra.example.com.
+	Recipients []string
+	Subject    string
+	Body       string
+}
+
+// Values returns the values of the placeholders of the text template.
+func (m Mail) Values() map[string]string {
+	return map[string]string{
+		"from":      m.From,
+		"from_name": m.FromName,
+		"subject":   m.Subject,
+		"body":      m.Body,
+	}
+}
+
+// Receiver is the embedded SMTP server that receives the emails.
+type Receiver struct {
+	config  Config
+	check   func(recipient string) error
+	deliver func(ctx context.Context, mail Mail) error
+	server  *smtp.Server
+	ctx     context.Context
+}
+
+// NewReceiver returns a new SMTP receiver. Each recipient is checked with the given check function when the
+// email is submitted, the received emails are handed over to the given deliver function. If it fails, the email is
+// rejected with a temporary error, so that the sending server tries again later.
+func NewReceiver(config Config, check func(recipient string) error, deliver func(ctx context.Context, mail Mail) error) *Receiver {
+	result := &Receiver{
+		config:  config,
+		check:   check,
+		deliver: deliver,
+	}
+	result.server = smtp.NewServer(result)
+	result.server.Addr = config.Listen
+	result.server.Domain = config.Domain
+	result.server.MaxMessageBytes = DefaultMaxMessageBytes
+	result.server.MaxRecipients = 10
+	result.server.ReadTimeout = receiverTimeout
+	result.server.WriteTimeout = receiverTimeout
+	result.server.AuthDisabled = true
+	return result
+}
+
+// Run receives emails until the given context is done.
+func (r *Receiver) Run(ctx context.Context) error {
+	listener, err := net.Listen("tcp", r.config.Listen)
+	if err != nil {
+		return err
+	}
+	log.Printf("SMTP receiver listening on %s", r.config.Listen)
+	return r.serve(ctx, listener)
+}
+
+func (r *Receiver) serve(ctx context.Context, listener net.Listener) error {
+	r.ctx = ctx
+	go func() {
+		<-ctx.Done()
+		r.server.Close()
+	}()
+	err := r.server.Serve(listener)
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+// Login implements smtp.Backend. Authentication is disabled, the clients and the senders are checked against the
+// allowlists.
+func (r *Receiver) Login(_ *smtp.ConnectionState, _, _ string) (smtp.Session, error) {
+	return nil, smtp.ErrAuthUnsupported
+}
+
+// AnonymousLogin implements smtp.Backend.
+func (r *Receiver) AnonymousLogin(state *smtp.ConnectionState) (smtp.Session, error) {
+	if !r.config.ClientAllowed(state.RemoteAddr) {
+		log.Printf("email from client %s rejected, the client is not allowed", state.RemoteAddr)
+		return nil, &smtp.SMTPError{Code: 550, EnhancedCode: smtp.EnhancedCode{5, 7, 1}, Message: "Client not allowed"}
+	}
+	return &session{receiver: r}, nil
+}
+
+// session is a single SMTP transaction.
+type session struct {
+	receiver   *Receiver
+	from       string
+	recipients []string
+}
+
+func (s *session) Reset() {
+	s.from = ""
+	s.recipients = nil
+}
+
+func (s *session) Logout() error {
+	return nil
+}
+
+func (s *session) Mail(from string, _ smtp.MailOptions) error {
+	if !s.receiver.config.Allowed(from) {
+		log.Printf("email from %s rejected, the sender is not allowed", from)
+		return &smtp.SMTPError{Code: 550, EnhancedCode: smtp.EnhancedCode{5, 7, 1}, Message: "Sender not allowed"}
+	}
+	s.from = from
+	return nil
+}
+
+func (s *session) Rcpt(to string) error {
+	localPart, domain, ok := strings.Cut(to, "@")
+	if !ok || s.receiver.config.Domain != "" && !strings.EqualFold(domain, s.receiver.config.Domain) {
+		return &smtp.SMTPError{Code: 550, EnhancedCode: smtp.EnhancedCode{5, 1, 2}, Message: "Unknown domain"}
+	}
+	err := s.receiver.check(localPart)
+	if err != nil {
+		log.Printf("email from %s to %s rejected: %v", s.from, to, err)
+		return &smtp.SMTPError{Code: 550, EnhancedCode: smtp.EnhancedCode{5, 1, 1}, Message: err.Error()}
+	}
+	s.recipients = append(s.recipients, localPart)
+	return nil
+}
+
+func (s *session) Data(r io.Reader) error {
+	received, err := ReadMail(r)
+	if err != nil {
+		log.Printf("email from %s rejected: %v", s.from, err)
+		return &smtp.SMTPError{Code: 554, EnhancedCode: smtp.EnhancedCode{5, 6, 0}, Message: err.Error()}
+	}
+	// only the envelope sender is checked against the allowlist, the From header may claim anybody
+	if !strings.EqualFold(received.From, s.from) {
+		received.FromName = ""
+	}
+	received.From = s.from
+	received.Recipients = s.recipients
+
+	err = s.receiver.deliver(s.receiver.ctx, received)
+	if err != nil {
+		log.Printf("email from %s not delivered: %v", s.from, err)
+		return &smtp.SMTPError{Code: 451, EnhancedCode: smtp.EnhancedCode{4, 3, 0}, Message: err.Error()}
+	}
+	return nil
+}
+
+// ReadMail reads an email and extracts the sender, the subject and the text of the body. The body is taken from the
+// first text/plain part, or from the first text/html part without the markup. Quoted lines and the signature are
+// removed from the body.
+func ReadMail(r io.Reader) (Mail, error) {
+	message, err := mail.ReadMessage(r)
+	if err != nil {
+		return Mail{}, fmt.Errorf("cannot read the email: %w", err)
+	}
+
+	var result Mail
+	decoder := &mime.WordDecoder{CharsetReader: charsetReader}
+	if from, err := message.Header.AddressList("From"); err == nil && len(from) > 0 {
+		result.From = from[0].Address
+		result.FromName = from[0].Name
+	}
+	result.Subject, err = decoder.DecodeHeader(message.Header.Get("Subject"))
+	if err != nil {
+		result.Subject = message.Header.Get("Subject")
+	}
+	result.Subject = strings.TrimSpace(result.Subject)
+
+	body, htmlBody, err := readBody(textproto.MIMEHeader(message.Header), message.Body)
+	if err != nil {
+		return Mail{}, err
+	}
+	if body == "" && htmlBody != "" {
+		body = stripMarkup(htmlBody)
+	}
+	result.Body = cleanBody(body)
+	if result.Subject == "" && result.Body == "" {
+		return Mail{}, errors.New("the email has neither subject nor text")
+	}
+	return result, nil
+}
+
+// readBody returns the first text/plain and the first text/html content of the given entity.
+func readBody(header textproto.MIMEHeader, body io.Reader) (string, string, error) {
+	contentType := header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "text/plain"
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid content type %q: %w", contentType, err)
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		var plain, htmlBody string
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextPart()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return "", "", fmt.Errorf("cannot read the email: %w", err)
+			}
+			partPlain, partHTML, err := readBody(part.Header, part)
+			if err != nil {
+				return "", "", err
+			}
+			if plain == "" {
+				plain = partPlain
+			}
+			if htmlBody == "" {
+				htmlBody = partHTML
+			}
+		}
+		return plain, htmlBody, nil
+	}
+	if mediaType != "text/plain" && mediaType != "text/html" {
+		return "", "", nil
+	}
+
+	switch strings.ToLower(header.Get("Content-Transfer-Encoding")) {
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	}
+	if charset := params["charset"]; charset != "" {
+		body, err = charsetReader(charset, body)
+		if err != nil {
+			return "", "", err
+		}
+	}
+	content, err := io.ReadAll(body)
+	if err != nil {
+		return "", "", fmt.Errorf("cannot read the email: %w", err)
+	}
+	if mediaType == "text/html" {
+		return "", string(content), nil
+	}
+	return string(content), "", nil
+}
+
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	switch strings.ToLower(charset) {
+	case "utf-8", "us-ascii":
+		return input, nil
+	}
+	encoding, err := htmlindex.Get(charset)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported charset %q", charset)
+	}
+	return encoding.NewDecoder().Reader(input), nil
+}
+
+var (
+	markupExpression        = regexp.MustCompile(`(?s)<(script|style).*?</(script|style)>|<[^>]*>`)
+	lineBreakExpression     = regexp.MustCompile(`(?i)<br\s*/?>|</p>|</div>`)
+	emptyLinesExpression    = regexp.MustCompile(`\n{3,}`)
+	trailingSpaceExpression = regexp.MustCompile(`[ \t]+\n`)
+)
+
+func stripMarkup(text string) string {
+	text = lineBreakExpression.ReplaceAllString(text, "\n")
+	text = markupExpression.ReplaceAllString(text, "")
+	return html.UnescapeString(text)
+}
+
+// cleanBody removes quoted lines and the signature from the body of an email.
+func cleanBody(body string) string {
+	body = strings.ReplaceAll(body, "\r\n", "\n")
+	lines := strings.Split(body, "\n")
+	result := make([]string, 0, len(lines))
+	for _, line := range lines {
+		if line == "-- " || line == "--" {
+			break
+		}
+		if strings.HasPrefix(line, ">") {
+			continue
+		}
+		result = append(result, line)
+	}
+	body = strings.Join(result, "\n")
+	body = trailingSpaceExpression.ReplaceAllString(body, "\n")
+	body = emptyLinesExpression.ReplaceAllString(body, "\n\n")
+	return strings.TrimSpace(body)
+}
+
+// TextMessage returns the text of the message for the given email, made representable in the given text encoding
+// and truncated to the maximum length.
+func (c Config) TextMessage(received Mail, encoding sds.TextEncoding) (string, error) {
+	template := messaging.Template{Name: "text", Text: c.Text}
+	text, err := template.Render(received.Values())
+	if err != nil {
+		return "", err
+	}
+	text = strings.TrimSpace(messaging.ReplaceInvalidCharacters(text, encoding, "?"))
+	if c.MaxLength > 0 && len([]rune(text)) > c.MaxLength {
+		text = strings.TrimSpace(string([]rune(text)[:max(c.MaxLength-3, 0)])) + "..."
+	}
+	if text == "" {
+		return "", errors.New("the text is empty")
+	}
+	return text, nil
+}
@@ -0,0 +1,315 @@
+package mailgateway
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/emersion/go-smtp"
+	"github.com/ftl/tetra-pei/sds"
+)
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		valid   bool
+	}{
+		{"receiver", "listen: localhost:2525\nallow: [dispatch@example.com]", true},
+		{"clients", "listen: localhost:2525\nclients: [127.0.0.1, 192.168.1.0/24, '::1']\nallow: [dispatch@example.com]", true},
+		{"nothing", "domain: tetra.example.com", false},
+		{"no allowlist", "listen: localhost:2525", false},
+		{"invalid sender", "listen: localhost:2525\nallow: [example.com]", false},
+		{"invalid client", "listen: localhost:2525\nclients: [mail.example.com]\nallow: [dispatch@example.com]", false},
+		{"unknown placeholder", "listen: localhost:2525\nallow: [dispatch@example.com]\ntext: '{{to}}'", false},
+		{"forward without server", "forward:\n  to: [ops@example.com]", false},
+		{"forward", "smtp:\n  server: mail.example.com:587\n  from: TETRA <tetra@example.com>\nforward:\n  to: [ops@example.com]", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "email.yaml")
+			os.WriteFile(path, []byte(tt.content), 0644)
+
+			config, err := LoadConfig(path)
+			if tt.valid && err != nil {
+				t.Fatal(err)
+			}
+			if !tt.valid && err == nil {
+				t.Fatal("the configuration should be rejected")
+			}
+			if tt.valid && (config.Text != DefaultText || config.Forward.Subject != DefaultSubject || config.Forward.Body != DefaultBody) {
+				t.Errorf("the defaults are missing: %+v", config)
+			}
+		})
+	}
+}
+
+func TestConfig_Allowed(t *testing.T) {
+	config := Config{Allow: []string{"dispatch@example.com", "@fire.example.com"}}
+
+	tests := []struct {
+		address string
+		allowed bool
+	}{
+		{"dispatch@example.com", true},
+		{" Dispatch@Example.com ", true},
+		{"chief@fire.example.com", true},
+		{"other@example.com", false},
+		{"chief@evilfire.example.com", false},
+		{"chief@fire.example.com.evil.com", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := config.Allowed(tt.address); got != tt.allowed {
+			t.Errorf("%q: expected %t, got %t", tt.address, tt.allowed, got)
+		}
+	}
+}
+
+func TestConfig_ClientAllowed(t *testing.T) {
+	tests := []struct {
+		clients []string
+		address string
+		allowed bool
+	}{
+		{nil, "127.0.0.1", true},
+		{nil, "127.1.2.3", true},
+		{nil, "::1", true},
+		{nil, "192.168.1.10", false},
+		{[]string{"192.168.1.0/24"}, "192.168.1.10", true},
+		{[]string{"192.168.1.0/24"}, "192.168.2.10", false},
+		{[]string{"192.168.1.0/24"}, "127.0.0.1", false},
+		{[]string{"10.0.0.1"}, "10.0.0.1", true},
+		{[]string{"10.0.0.1"}, "::ffff:10.0.0.1", true},
+		{[]string{"10.0.0.1"}, "10.0.0.2", false},
+		{[]string{"2001:db8::/32"}, "2001:db8::1", true},
+	}
+	for _, tt := range tests {
+		config := Config{Clients: tt.clients}
+		addr := &net.TCPAddr{IP: net.ParseIP(tt.address), Port: 25}
+		if got := config.ClientAllowed(addr); got != tt.allowed {
+			t.Errorf("%v %s: expected %t, got %t", tt.clients, tt.address, tt.allowed, got)
+		}
+	}
+}
+
+func TestReadMail(t *testing.T) {
+	tests := []struct {
+		name    string
+		email   string
+		from    string
+		subject string
+		body    string
+	}{
+		{
+			name:    "plain",
+			email:   "From: Dispatch <dispatch@example.com>\r\nSubject: Fire\r\n\r\nBuilding 4\r\n\r\n-- \r\nDispatch Center\r\n",
+			from:    "dispatch@example.com",
+			subject: "Fire",
+			body:    "Building 4",
+		},
+		{
+			name:    "encoded",
+			email:   "From: dispatch@example.com\r\nSubject: =?iso-8859-1?q?Stra=DFe?=\r\nContent-Type: text/plain; charset=iso-8859-1\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\nHauptstra=DFe 1\r\n> quoted\r\n",
+			from:    "dispatch@example.com",
+			subject: "Straße",
+			body:    "Hauptstraße 1",
+		},
+		{
+			name: "multipart",
+			email: "From: dispatch@example.com\r\nSubject: Fire\r\nContent-Type: multipart/alternative; boundary=b\r\n\r\n" +
+				"--b\r\nContent-Type: text/html\r\n\r\n<p>html</p>\r\n" +
+				"--b\r\nContent-Type: text/plain\r\n\r\nplain\r\n--b--\r\n",
+			from:    "dispatch@example.com",
+			subject: "Fire",
+			body:    "plain",
+		},
+		{
+			name:    "html",
+			email:   "From: dispatch@example.com\r\nContent-Type: text/html\r\n\r\n<p>Building&nbsp;4</p><style>p {}</style><p>Floor 2</p>\r\n",
+			from:    "dispatch@example.com",
+			subject: "",
+			body:    "Building 4\nFloor 2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			received, err := ReadMail(strings.NewReader(tt.email))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if received.From != tt.from || received.Subject != tt.subject || received.Body != tt.body {
+				t.Errorf("unexpected mail %+v", received)
+			}
+		})
+	}
+
+	_, err := ReadMail(strings.NewReader("From: dispatch@example.com\r\n\r\n> only quoted\r\n"))
+	if err == nil {
+		t.Error("an email without subject and text should be rejected")
+	}
+}
+
+func TestConfig_TextMessage(t *testing.T) {
+	received := Mail{From: "dispatch@example.com", FromName: "Dispatch", Subject: "Fire", Body: "Building “4” – €"}
+
+	text, err := Config{Text: DefaultText}.TextMessage(received, sds.ISO8859_1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text != "Fire\nBuilding \"4\" - ?" {
+		t.Errorf("unexpected text %q", text)
+	}
+
+	text, _ = Config{Text: "{{from_name}} <{{from}}>: {{body}}", MaxLength: 20}.TextMessage(received, sds.ISO8859_1)
+	if text != "Dispatch <dispatc..." {
+		t.Errorf("unexpected truncated text %q", text)
+	}
+
+	_, err = Config{Text: "{{body}}"}.TextMessage(Mail{Subject: "only subject"}, sds.ISO8859_1)
+	if err == nil {
+		t.Error("an empty text should be rejected")
+	}
+}
+
+// testReceiver runs a receiver on a local port and records the delivered emails.
+type testReceiver struct {
+	addr string
+
+	lock      sync.Mutex
+	delivered []Mail
+}
+
+func runTestReceiver(t *testing.T, config Config, deliverErr error) *testReceiver {
+	t.Helper()
+	result := &testReceiver{}
+	check := func(recipient string) error {
+		if recipient == "nobody" {
+			return errors.New("unknown destination")
+		}
+		return nil
+	}
+	deliver := func(ctx context.Context, received Mail) error {
+		result.lock.Lock()
+		defer result.lock.Unlock()
+		result.delivered = append(result.delivered, received)
+		return deliverErr
+	}
+	if config.Text == "" {
+		config.Text = DefaultText
+	}
+	receiver := NewReceiver(config, check, deliver)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result.addr = listener.Addr().String()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := receiver.serve(ctx, listener); err != nil {
+			t.Error(err)
+		}
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return result
+}
+
+func (r *testReceiver) send(from string, to []string, email string) error {
+	return smtp.SendMail(r.addr, nil, from, to, strings.NewReader(email))
+}
+
+func (r *testReceiver) mails() []Mail {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]Mail{}, r.delivered...)
+}
+
+// expectSMTPError fails if the given error is not an SMTP error with the given code.
+func expectSMTPError(t *testing.T, err error, code int) {
+	t.Helper()
+	var smtpErr *smtp.SMTPError
+	if !errors.As(err, &smtpErr) || smtpErr.Code != code {
+		t.Errorf("expected SMTP error %d, got %v", code, err)
+	}
+}
+
+func TestReceiver_DeliversEmailsFromAllowedSenders(t *testing.T) {
+	receiver := runTestReceiver(t, Config{Domain: "tetra.example.com", Allow: []string{"@example.com"}}, nil)
+
+	err := receiver.send("dispatch@example.com", []string{"1234567@tetra.example.com", "engine-1@TETRA.example.com"},
+		"From: Dispatch <dispatch@example.com>\r\nSubject: Fire\r\n\r\nBuilding 4\r\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mails := receiver.mails()
+	if len(mails) != 1 {
+		t.Fatalf("expected 1 email, got %d", len(mails))
+	}
+	received := mails[0]
+	if received.From != "dispatch@example.com" || received.FromName != "Dispatch" || received.Subject != "Fire" || received.Body != "Building 4" {
+		t.Errorf("unexpected email %+v", received)
+	}
+	if strings.Join(received.Recipients, ",") != "1234567,engine-1" {
+		t.Errorf("unexpected recipients %v", received.Recipients)
+	}
+}
+
+func TestReceiver_UsesTheEnvelopeSender(t *testing.T) {
+	receiver := runTestReceiver(t, Config{Allow: []string{"dispatch@example.com"}}, nil)
+
+	err := receiver.send("dispatch@example.com", []string{"1234567@tetra.example.com"},
+		"From: Chief <chief@example.com>\r\nSubject: Fire\r\n\r\nBuilding 4\r\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mails := receiver.mails()
+	if len(mails) != 1 {
+		t.Fatalf("expected 1 email, got %d", len(mails))
+	}
+	if mails[0].From != "dispatch@example.com" || mails[0].FromName != "" {
+		t.Errorf("the sender must be the checked envelope sender, got %q (%q)", mails[0].From, mails[0].FromName)
+	}
+}
+
+func TestReceiver_RejectsEmails(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     Config
+		deliverErr error
+		from       string
+		to         string
+		code       int
+	}{
+		{"unknown sender", Config{}, nil, "other@example.com", "1234567@tetra.example.com", 550},
+		{"unknown client", Config{Clients: []string{"192.0.2.0/24"}}, nil, "dispatch@example.com", "1234567@tetra.example.com", 550},
+		{"unknown domain", Config{Domain: "tetra.example.com"}, nil, "dispatch@example.com", "1234567@example.com", 550},
+		{"unknown destination", Config{}, nil, "dispatch@example.com", "nobody@tetra.example.com", 550},
+		{"not delivered", Config{}, errors.New("radio not available"), "dispatch@example.com", "1234567@tetra.example.com", 451},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.Allow = []string{"dispatch@example.com"}
+			receiver := runTestReceiver(t, tt.config, tt.deliverErr)
+
+			err := receiver.send(tt.from, []string{tt.to}, "Subject: Fire\r\n\r\nBuilding 4\r\n")
+
+			expectSMTPError(t, err, tt.code)
+			if tt.deliverErr == nil && len(receiver.mails()) > 0 {
+				t.Error("the email must not be delivered")
+			}
+		})
+	}
+}
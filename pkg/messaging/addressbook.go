@@ -0,0 +1,308 @@
+package messaging
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ftl/tetra-pei/tetra"
+	"gopkg.in/yaml.v3"
+
+	"github.com/ftl/tetra-cli/pkg/store"
+)
+
+// DefaultAddressBookPath returns the default path of the address book.
+func DefaultAddressBookPath() string {
+	return filepath.Join(store.ConfigDir(), "addressbook.yaml")
+}
+
+// AddressEntry gives a name to a radio (ISSI) or a talkgroup (GTSI). A radio may also be addressed by its unit or
+// its role, a talkgroup or a radio by any of its aliases.
+type AddressEntry struct {
+	Name    string   `json:"name" yaml:"name"`
+	ISSI    string   `json:"issi,omitempty" yaml:"issi,omitempty"`
+	GTSI    string   `json:"gtsi,omitempty" yaml:"gtsi,omitempty"`
+	Unit    string   `json:"unit,omitempty" yaml:"unit,omitempty"`
+	Role    string   `json:"role,omitempty" yaml:"role,omitempty"`
+	Aliases []string `json:"aliases,omitempty" yaml:"aliases,omitempty"`
+}
+
+// Identity returns the ISSI or GTSI of the entry.
+func (e AddressEntry) Identity() tetra.Identity {
+	if e.GTSI != "" {
+		return tetra.Identity(e.GTSI)
+	}
+	return tetra.Identity(e.ISSI)
+}
+
+// IsGroup indicates that the entry is a talkgroup.
+func (e AddressEntry) IsGroup() bool {
+	return e.GTSI != ""
+}
+
+func (e AddressEntry) hasName(name string) bool {
+	if strings.EqualFold(e.Name, name) {
+		return true
+	}
+	for _, alias := range e.Aliases {
+		if strings.EqualFold(alias, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// AddressBook maps names, units and roles to ISSIs and GTSIs. A nil address book is empty.
+type AddressBook struct {
+	Entries []AddressEntry `json:"entries" yaml:"entries"`
+}
+
+// LoadAddressBook reads the address book from the given YAML or JSON file. If the file does not exist, the address
+// book is empty. Example:
+//
+//	entries:
+//	  - name: Engine 1
+//	    issi: "1234567"
+//	    unit: FF Musterstadt
+//	    role: engine
+//	    aliases: [E1]
+//	  - name: Operations
+//	    gtsi: "2620001"
+func LoadAddressBook(path string) (*AddressBook, error) {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &AddressBook{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the address book: %w", err)
+	}
+
+	// JSON is a subset of YAML, so both formats are read the same way.
+	result := &AddressBook{}
+	err = yaml.Unmarshal(content, result)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the address book from %s: %w", path, err)
+	}
+	for i, entry := range result.Entries {
+		err = validateAddressEntry(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid entry %d in %s: %w", i+1, path, err)
+		}
+	}
+	return result, nil
+}
+
+func validateAddressEntry(entry AddressEntry) error {
+	if strings.TrimSpace(entry.Name) == "" {
+		return fmt.Errorf("the name is missing")
+	}
+	if (entry.ISSI == "") == (entry.GTSI == "") {
+		return fmt.Errorf("%s needs either an ISSI or a GTSI", entry.Name)
+	}
+	err := ValidateIdentity(entry.Identity())
+	if err != nil {
+		return fmt.Errorf("%s: %w", entry.Name, err)
+	}
+	return nil
+}
+
+// Save writes the address book to the given file, as JSON if the file name ends with .json, otherwise as YAML.
+// The file is replaced, so comments and the formatting of an existing file are lost.
+func (b *AddressBook) Save(path string) error {
+	var content bytes.Buffer
+	var err error
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		encoder := json.NewEncoder(&content)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(b)
+	} else {
+		encoder := yaml.NewEncoder(&content)
+		encoder.SetIndent(2)
+		err = encoder.Encode(b)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot write the address book: %w", err)
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return fmt.Errorf("cannot write the address book: %w", err)
+	}
+	// write a new file and replace the address book with it, so that it is never left incomplete
+	temp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("cannot write the address book: %w", err)
+	}
+	defer os.Remove(temp.Name())
+	defer temp.Close()
+
+	_, err = temp.Write(content.Bytes())
+	if err == nil {
+		err = temp.Chmod(0644)
+	}
+	if err == nil {
+		err = temp.Sync()
+	}
+	if err != nil {
+		return fmt.Errorf("cannot write the address book: %w", err)
+	}
+	err = os.Rename(temp.Name(), path)
+	if err != nil {
+		return fmt.Errorf("cannot write the address book: %w", err)
+	}
+	return nil
+}
+
+// Resolve finds the entry with the given name, alias, unit or role, or with the given ISSI or GTSI. Names and aliases
+// take precedence over units and roles. Names are not case sensitive. It is an error if more than one entry matches.
+func (b *AddressBook) Resolve(name string) (AddressEntry, bool, error) {
+	name = strings.TrimSpace(name)
+	if b == nil || name == "" {
+		return AddressEntry{}, false, nil
+	}
+	if store.IsNumeric(name) {
+		for _, entry := range b.Entries {
+			if string(entry.Identity()) == name {
+				return entry, true, nil
+			}
+		}
+		return AddressEntry{}, false, nil
+	}
+
+	matches := b.filter(func(entry AddressEntry) bool { return entry.hasName(name) })
+	if len(matches) == 0 {
+		matches = b.filter(func(entry AddressEntry) bool {
+			return strings.EqualFold(entry.Unit, name) || strings.EqualFold(entry.Role, name)
+		})
+	}
+	switch len(matches) {
+	case 0:
+		return AddressEntry{}, false, nil
+	case 1:
+		return matches[0], true, nil
+	default:
+		names := make([]string, len(matches))
+		for i, match := range matches {
+			names[i] = fmt.Sprintf("%s (%s)", match.Name, match.Identity())
+		}
+		return AddressEntry{}, false, fmt.Errorf("%q is ambiguous in the address book: %s", name, strings.Join(names, ", "))
+	}
+}
+
+func (b *AddressBook) filter(match func(AddressEntry) bool) []AddressEntry {
+	var result []AddressEntry
+	for _, entry := range b.Entries {
+		if match(entry) {
+			result = append(result, entry)
+		}
+	}
+	return result
+}
+
+// ResolveDestination resolves the destination of a message through the address book. Destinations that are not in
+// the address book are returned as they are, except names of individuals. Names of groups are looked up in the
+// talkgroups of the radio when the message is sent. The result is a group destination if the entry is a talkgroup.
+func (b *AddressBook) ResolveDestination(destination tetra.Identity, group bool) (tetra.Identity, bool, error) {
+	entry, ok, err := b.Resolve(string(destination))
+	if err != nil {
+		return "", false, err
+	}
+	if !ok && !group && destination != "" && !store.IsNumeric(string(destination)) {
+		return "", false, fmt.Errorf("unknown destination %q, use the numeric ISSI, GSSI, ITSI or GTSI, or a name from the address book", destination)
+	}
+	if !ok {
+		return destination, group, nil
+	}
+	if group && !entry.IsGroup() {
+		return "", false, fmt.Errorf("%s (%s) is a radio, not a group", entry.Name, entry.ISSI)
+	}
+	return entry.Identity(), entry.IsGroup(), nil
+}
+
+// Lookup finds the entry of the given identity. The identity may be the full ITSI of a radio, while the address
+// book only contains its ISSI.
+func (b *AddressBook) Lookup(identity tetra.Identity) (AddressEntry, bool) {
+	if b == nil || identity == "" {
+		return AddressEntry{}, false
+	}
+	for _, entry := range b.Entries {
+		if entry.Identity() == identity {
+			return entry, true
+		}
+	}
+	for _, entry := range b.Entries {
+		if SameIdentity(string(entry.Identity()), string(identity)) {
+			return entry, true
+		}
+	}
+	return AddressEntry{}, false
+}
+
+// Name returns the name of the given identity, or an empty string if the identity is not in the address book.
+func (b *AddressBook) Name(identity tetra.Identity) string {
+	entry, ok := b.Lookup(identity)
+	if !ok {
+		return ""
+	}
+	return entry.Name
+}
+
+// Label returns the given identity with its name from the address book, e.g. "1234567 (Engine 1)".
+func (b *AddressBook) Label(identity tetra.Identity) string {
+	name := b.Name(identity)
+	if name == "" {
+		return string(identity)
+	}
+	return fmt.Sprintf("%s (%s)", identity, name)
+}
+
+// ImportTalkgroups adds the talkgroups from the output of "tetra-cli talkgroups" to the address book, either in the
+// CSV format (mode,gtsi,name) or in the text format (mode;gtsi;name). Talkgroups whose GTSI is already in the address
+// book are skipped. ImportTalkgroups returns the number of added talkgroups.
+func (b *AddressBook) ImportTalkgroups(r io.Reader) (int, error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return 0, fmt.Errorf("cannot read the talkgroups: %w", err)
+	}
+	reader := csv.NewReader(strings.NewReader(string(content)))
+	firstLine, _, _ := strings.Cut(string(content), "\n")
+	if strings.Contains(firstLine, ";") {
+		reader.Comma = ';'
+	}
+	reader.FieldsPerRecord = 3
+	reader.LazyQuotes = true
+
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return 0, fmt.Errorf("cannot read the talkgroups: %w", err)
+	}
+	if len(rows) > 0 && strings.EqualFold(rows[0][0], "mode") {
+		rows = rows[1:]
+	}
+
+	added := 0
+	for i, row := range rows {
+		gtsi := strings.TrimSpace(row[1])
+		name := strings.TrimSpace(row[2])
+		if name == "" {
+			name = gtsi
+		}
+		entry := AddressEntry{Name: name, GTSI: gtsi}
+		err = validateAddressEntry(entry)
+		if err != nil {
+			return added, fmt.Errorf("invalid talkgroup in line %d: %w", i+1, err)
+		}
+		if len(b.filter(func(e AddressEntry) bool { return e.GTSI == gtsi })) > 0 {
+			continue
+		}
+		b.Entries = append(b.Entries, entry)
+		added++
+	}
+	return added, nil
+}
@@ -0,0 +1,208 @@
+package messaging
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ftl/tetra-pei/tetra"
+)
+
+func testAddressBook() *AddressBook {
+	return &AddressBook{Entries: []AddressEntry{
+		{Name: "Engine 1", ISSI: "1234567", Unit: "FF Musterstadt", Role: "engine", Aliases: []string{"E1"}},
+		{Name: "Engine 2", ISSI: "1234568", Unit: "FF Musterstadt", Role: "Engine 1"},
+		{Name: "Chief", ISSI: "2345678", Unit: "Command", Role: "chief"},
+		{Name: "Operations", GTSI: "2620010000001", Aliases: []string{"ops"}},
+	}}
+}
+
+func TestAddressBook_Resolve(t *testing.T) {
+	addressBook := testAddressBook()
+
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{"engine 1", "Engine 1"},
+		{" e1 ", "Engine 1"},
+		{"OPS", "Operations"},
+		{"command", "Chief"},
+		{"chief", "Chief"},
+		{"1234568", "Engine 2"},
+		{"2620010000001", "Operations"},
+	}
+	for _, tt := range tests {
+		entry, ok, err := addressBook.Resolve(tt.name)
+		if err != nil || !ok || entry.Name != tt.expected {
+			t.Errorf("%q: expected %s, got %+v %t %v", tt.name, tt.expected, entry, ok, err)
+		}
+	}
+
+	for _, name := range []string{"", "Engine 3", "9999999"} {
+		if entry, ok, err := addressBook.Resolve(name); ok || err != nil {
+			t.Errorf("%q: expected no entry, got %+v %v", name, entry, err)
+		}
+	}
+
+	_, _, err := addressBook.Resolve("ff musterstadt")
+	if err == nil || err.Error() != `"ff musterstadt" is ambiguous in the address book: Engine 1 (1234567), Engine 2 (1234568)` {
+		t.Errorf("the ambiguous entries should be listed, got %v", err)
+	}
+	addressBook.Entries = append(addressBook.Entries, AddressEntry{Name: "Chief", GTSI: "2620010000002"})
+	if _, _, err := addressBook.Resolve("chief"); err == nil {
+		t.Error("two entries with the same name are ambiguous")
+	}
+
+	var empty *AddressBook
+	if _, ok, err := empty.Resolve("Engine 1"); ok || err != nil {
+		t.Errorf("a nil address book should be empty, got %t %v", ok, err)
+	}
+}
+
+func TestAddressBook_ResolveDestination(t *testing.T) {
+	addressBook := testAddressBook()
+
+	tests := []struct {
+		destination tetra.Identity
+		group       bool
+		expected    tetra.Identity
+		isGroup     bool
+	}{
+		{"E1", false, "1234567", false},
+		{"ops", false, "2620010000001", true},
+		{"ops", true, "2620010000001", true},
+		{"7654321", false, "7654321", false},
+		{"7654321", true, "7654321", true},
+		{"Fire North", true, "Fire North", true},
+	}
+	for _, tt := range tests {
+		destination, group, err := addressBook.ResolveDestination(tt.destination, tt.group)
+		if err != nil || destination != tt.expected || group != tt.isGroup {
+			t.Errorf("%q: expected %s %t, got %s %t %v", tt.destination, tt.expected, tt.isGroup, destination, group, err)
+		}
+	}
+
+	for name, destination := range map[string]tetra.Identity{"unknown name": "Fire North", "ambiguous": "ff musterstadt"} {
+		if _, _, err := addressBook.ResolveDestination(destination, false); err == nil {
+			t.Errorf("%s should be rejected", name)
+		}
+	}
+	_, _, err := addressBook.ResolveDestination("E1", true)
+	if err == nil || !strings.Contains(err.Error(), "is a radio, not a group") {
+		t.Errorf("a radio cannot be addressed as group, got %v", err)
+	}
+}
+
+func TestAddressBook_Lookup(t *testing.T) {
+	addressBook := testAddressBook()
+	addressBook.Entries = append(addressBook.Entries, AddressEntry{Name: "Guest", ISSI: "2620020001234567"})
+
+	tests := []struct {
+		identity tetra.Identity
+		expected string
+	}{
+		{"1234567", "Engine 1"},
+		{"2620010001234567", "Engine 1"},
+		{"2620010000001", "Operations"},
+		{"2620020001234567", "Guest"},
+	}
+	for _, tt := range tests {
+		entry, ok := addressBook.Lookup(tt.identity)
+		if !ok || entry.Name != tt.expected {
+			t.Errorf("%s: expected %s, got %+v %t", tt.identity, tt.expected, entry, ok)
+		}
+	}
+
+	if entry, ok := addressBook.Lookup("7654321"); ok {
+		t.Errorf("expected no entry, got %+v", entry)
+	}
+	if label := addressBook.Label("2620010001234567"); label != "2620010001234567 (Engine 1)" {
+		t.Errorf("unexpected label %q", label)
+	}
+	if label := addressBook.Label("7654321"); label != "7654321" {
+		t.Errorf("unexpected label %q", label)
+	}
+}
+
+func TestAddressBook_ImportTalkgroups(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"text", "TMO;2620011001;Operations\nTMO;2620011002;Fire\nDMO;1001;\n"},
+		{"csv", "mode,gtsi,name\nTMO,2620011001,Operations\nTMO,2620011002,\"Fire\"\nDMO,1001,\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addressBook := &AddressBook{Entries: []AddressEntry{{Name: "Ops", GTSI: "2620011001"}}}
+
+			added, err := addressBook.ImportTalkgroups(strings.NewReader(tt.content))
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := []AddressEntry{
+				{Name: "Ops", GTSI: "2620011001"},
+				{Name: "Fire", GTSI: "2620011002"},
+				{Name: "1001", GTSI: "1001"},
+			}
+			if added != 2 || !reflect.DeepEqual(addressBook.Entries, expected) {
+				t.Errorf("unexpected entries %d %+v", added, addressBook.Entries)
+			}
+
+			added, err = addressBook.ImportTalkgroups(strings.NewReader(tt.content))
+			if err != nil || added != 0 || len(addressBook.Entries) != 3 {
+				t.Errorf("the talkgroups should be imported only once, got %d %v", added, err)
+			}
+		})
+	}
+
+	for name, content := range map[string]string{
+		"invalid GTSI":   "TMO;26200a1001;Operations\n",
+		"missing column": "TMO;2620011001\n",
+	} {
+		if _, err := (&AddressBook{}).ImportTalkgroups(strings.NewReader(content)); err == nil {
+			t.Errorf("%s should be rejected", name)
+		}
+	}
+}
+
+func TestAddressBook_Save(t *testing.T) {
+	dir := t.TempDir()
+	addressBook := testAddressBook()
+
+	for _, filename := range []string{"addressbook.yaml", "addressbook.json"} {
+		path := filepath.Join(dir, "config", filename)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		os.WriteFile(path, []byte("# a comment\nentries: []\n"), 0644)
+
+		err := addressBook.Save(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		loaded, err := LoadAddressBook(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(loaded, addressBook) {
+			t.Errorf("%s: unexpected address book %+v", filename, loaded)
+		}
+		info, err := os.Stat(path)
+		if err != nil || info.Mode().Perm() != 0644 {
+			t.Errorf("%s: unexpected file %v %v", filename, info, err)
+		}
+	}
+	content, _ := os.ReadFile(filepath.Join(dir, "config", "addressbook.json"))
+	if !strings.HasPrefix(string(content), "{\n  \"entries\": [") {
+		t.Errorf("the address book should be written as JSON:\n%s", content)
+	}
+
+	files, _ := os.ReadDir(filepath.Join(dir, "config"))
+	if len(files) != 2 {
+		t.Errorf("no temporary files should be left, got %v", files)
+	}
+}
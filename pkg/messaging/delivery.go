@@ -0,0 +1,249 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ftl/tetra-pei/sds"
+	"github.com/ftl/tetra-pei/tetra"
+
+	"github.com/ftl/tetra-cli/pkg/store"
+)
+
+// DeliveryState is the state of an outgoing message.
+type DeliveryState string
+
+// All delivery states.
+const (
+	// Sent means that the radio accepted the message.
+	Sent DeliveryState = "sent"
+	// Received means that the destination reported the reception of the message.
+	Received DeliveryState = "received"
+	// Consumed means that the destination reported that the message was consumed, e.g. read by the user.
+	Consumed DeliveryState = "consumed"
+	// Failed means that a negative delivery report was received.
+	Failed DeliveryState = "failed"
+	// Expired means that the requested delivery reports were not received in time.
+	Expired DeliveryState = "expired"
+)
+
+// Reached indicates if this state includes the given state. Consumed includes Received, and Received includes Sent.
+func (s DeliveryState) Reached(state DeliveryState) bool {
+	rank := func(state DeliveryState) int {
+		switch state {
+		case Sent:
+			return 1
+		case Received:
+			return 2
+		case Consumed:
+			return 3
+		default:
+			return 0
+		}
+	}
+	return rank(s) >= rank(state)
+}
+
+// combinePartStates returns the overall state of a message with the given part states: Failed if any part failed,
+// otherwise the lowest state that all parts reached.
+func combinePartStates(partStates []DeliveryState) DeliveryState {
+	result := Consumed
+	for _, state := range partStates {
+		switch {
+		case state == Failed:
+			return Failed
+		case !state.Reached(result):
+			result = state
+		}
+	}
+	return result
+}
+
+// Delivery tracks the delivery of an outgoing text message. Each part of a concatenated message is confirmed
+// by its own delivery reports; the overall state is the lowest state that all parts reached.
+type Delivery struct {
+	Destination       tetra.Identity
+	MessageReference  sds.MessageReference
+	Parts             int
+	ReceivedRequested bool
+	ConsumedRequested bool
+	// Group indicates that the message was sent to a group, which does not send delivery reports.
+	Group bool
+
+	lock           sync.Mutex
+	sent           time.Time
+	updated        time.Time
+	state          DeliveryState
+	deliveryStatus sds.DeliveryStatus
+	partStates     []DeliveryState
+	failedPart     int
+	changed        chan struct{}
+	record         store.Message
+}
+
+func newDelivery(destination tetra.Identity, messageReference sds.MessageReference, parts int) *Delivery {
+	partStates := make([]DeliveryState, max(parts, 1))
+	for i := range partStates {
+		partStates[i] = Sent
+	}
+	now := time.Now()
+	return &Delivery{
+		Destination:      destination,
+		MessageReference: messageReference,
+		Parts:            parts,
+		sent:             now,
+		updated:          now,
+		state:            Sent,
+		partStates:       partStates,
+		changed:          make(chan struct{}),
+	}
+}
+
+// State returns the current state of the delivery and the delivery status of the last delivery report.
+func (d *Delivery) State() (DeliveryState, sds.DeliveryStatus) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.state, d.deliveryStatus
+}
+
+// PartStates returns the current state of each part of the message.
+func (d *Delivery) PartStates() []DeliveryState {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return slices.Clone(d.partStates)
+}
+
+// Unconfirmed returns the numbers (starting at 1) of the parts that did not reach the given state yet.
+func (d *Delivery) Unconfirmed(state DeliveryState) []int {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	var result []int
+	for i, partState := range d.partStates {
+		if partState == Failed || !partState.Reached(state) {
+			result = append(result, i+1)
+		}
+	}
+	return result
+}
+
+// covers indicates if the given message reference belongs to one of the parts of the message.
+func (d *Delivery) covers(messageReference sds.MessageReference) bool {
+	part := int(messageReference) - int(d.MessageReference)
+	return part >= 0 && part < max(d.Parts, 1)
+}
+
+// Done indicates if no more delivery reports are expected for this delivery.
+func (d *Delivery) Done() bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.done()
+}
+
+func (d *Delivery) done() bool {
+	switch {
+	case d.state == Failed:
+		return true
+	case d.ConsumedRequested:
+		return d.state == Consumed
+	case d.ReceivedRequested:
+		return d.state.Reached(Received)
+	default:
+		return true
+	}
+}
+
+// outdated indicates if this delivery is done for longer than the given retention time, or if it is older than
+// the given maximum age.
+func (d *Delivery) outdated(now time.Time, retention time.Duration, maxAge time.Duration) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return (d.done() && now.Sub(d.updated) > retention) || now.Sub(d.sent) > maxAge
+}
+
+// update the state of the part with the given message reference. It returns true if the overall state changed.
+func (d *Delivery) update(messageReference sds.MessageReference, state DeliveryState, deliveryStatus sds.DeliveryStatus) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	part := int(messageReference) - int(d.MessageReference)
+	if part < 0 || part >= len(d.partStates) {
+		return false
+	}
+	partState := d.partStates[part]
+	if partState == Failed || (state != Failed && partState.Reached(state)) {
+		return false
+	}
+	d.partStates[part] = state
+	d.updated = time.Now()
+
+	overallState := combinePartStates(d.partStates)
+	overallChanged := overallState != d.state
+	if overallChanged {
+		d.state = overallState
+		d.deliveryStatus = deliveryStatus
+		if overallState == Failed {
+			d.failedPart = part + 1
+		}
+	}
+	close(d.changed)
+	d.changed = make(chan struct{})
+	return overallChanged
+}
+
+func (d *Delivery) setRecord(record store.Message) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.record = record
+}
+
+// storedRecord returns the record of the delivered message in the message store, if there is one.
+func (d *Delivery) storedRecord() (store.Message, bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.record, d.record.ID != ""
+}
+
+// Wait until the delivery reached the given state. If a negative delivery report is received, or if the given
+// context is done before all parts reached the given state, an error is returned.
+func (d *Delivery) Wait(ctx context.Context, state DeliveryState) error {
+	for {
+		d.lock.Lock()
+		current := d.state
+		deliveryStatus := d.deliveryStatus
+		failedPart := d.failedPart
+		changed := d.changed
+		d.lock.Unlock()
+
+		if current == Failed {
+			if d.Parts > 1 {
+				return fmt.Errorf("message delivery failed: part %d of %d: 0x%x", failedPart, d.Parts, byte(deliveryStatus))
+			}
+			return fmt.Errorf("message delivery failed: 0x%x", byte(deliveryStatus))
+		}
+		if current.Reached(state) {
+			return nil
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			if d.Parts > 1 {
+				return fmt.Errorf("parts %s of %d were not %s in time: %w", formatPartNumbers(d.Unconfirmed(state)), d.Parts, state, ctx.Err())
+			}
+			return fmt.Errorf("the message was not %s in time: %w", state, ctx.Err())
+		}
+	}
+}
+
+func formatPartNumbers(parts []int) string {
+	result := make([]string, len(parts))
+	for i, part := range parts {
+		result[i] = strconv.Itoa(part)
+	}
+	return strings.Join(result, ", ")
+}
@@ -0,0 +1,146 @@
+package messaging
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ftl/tetra-pei/sds"
+	"golang.org/x/text/encoding/charmap"
+)
+
+// ParseEncoding returns the text encoding with the given name, e.g. "ISO8859-1" or "CodePage850". The name is not
+// case sensitive.
+func ParseEncoding(name string) (sds.TextEncoding, error) {
+	name = strings.TrimSpace(name)
+	for encodingName, encoding := range sds.EncodingByName {
+		if strings.EqualFold(encodingName, name) {
+			return encoding, nil
+		}
+	}
+	return 0, fmt.Errorf("unexpected encoding: %s, use one of %s", name, strings.Join(EncodingNames(), ", "))
+}
+
+// EncodingNames returns the names of all supported text encodings in alphabetical order.
+func EncodingNames() []string {
+	result := make([]string, 0, len(sds.EncodingByName))
+	for name := range sds.EncodingByName {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// EncodingName returns the name of the given text encoding.
+func EncodingName(encoding sds.TextEncoding) string {
+	for name, e := range sds.EncodingByName {
+		if e == encoding {
+			return name
+		}
+	}
+	return fmt.Sprintf("encoding %d", encoding)
+}
+
+// InvalidCharacter is a character of a text that cannot be represented in the text encoding of the message.
+type InvalidCharacter struct {
+	// Position is the position of the character in the text, counted in characters starting with 1.
+	Position int
+	Rune     rune
+}
+
+func (c InvalidCharacter) String() string {
+	if c.Rune == utf8.RuneError {
+		return fmt.Sprintf("invalid UTF-8 at position %d", c.Position)
+	}
+	return fmt.Sprintf("%q (U+%04X) at position %d", c.Rune, c.Rune, c.Position)
+}
+
+// InvalidTextError reports the characters of a text that cannot be represented in the chosen text encoding.
+type InvalidTextError struct {
+	Encoding   sds.TextEncoding
+	Characters []InvalidCharacter
+}
+
+// maxReportedCharacters limits the number of characters listed in the error message.
+const maxReportedCharacters = 10
+
+func (e *InvalidTextError) Error() string {
+	characters := make([]string, 0, min(len(e.Characters), maxReportedCharacters))
+	for i, character := range e.Characters {
+		if i == maxReportedCharacters {
+			break
+		}
+		characters = append(characters, character.String())
+	}
+	more := ""
+	if len(e.Characters) > maxReportedCharacters {
+		more = fmt.Sprintf(" and %d more", len(e.Characters)-maxReportedCharacters)
+	}
+	return fmt.Sprintf("the text cannot be represented in %s: %s%s", EncodingName(e.Encoding), strings.Join(characters, ", "), more)
+}
+
+// ValidateText checks that the given text can be represented in the given text encoding. Otherwise, an
+// *InvalidTextError lists the offending characters. Encodings that are not supported by the sds package are
+// validated like ISO8859-1, which is used as fallback when the text is encoded.
+func ValidateText(text string, encoding sds.TextEncoding) error {
+	codec, ok := sds.TextCodecs[encoding]
+	if !ok {
+		codec = charmap.ISO8859_1
+	}
+	encoder := codec.NewEncoder()
+
+	var invalid []InvalidCharacter
+	position := 0
+	for i, r := range text {
+		position++
+		if r == utf8.RuneError {
+			if _, size := utf8.DecodeRuneInString(text[i:]); size <= 1 {
+				invalid = append(invalid, InvalidCharacter{Position: position, Rune: r})
+				continue
+			}
+		}
+		_, err := encoder.String(string(r))
+		if err != nil {
+			invalid = append(invalid, InvalidCharacter{Position: position, Rune: r})
+		}
+	}
+	if len(invalid) > 0 {
+		return &InvalidTextError{Encoding: encoding, Characters: invalid}
+	}
+	return nil
+}
+
+// typographicReplacements are ASCII replacements for typographic characters that are common in texts from other
+// sources, like emails, but cannot be represented in most text encodings.
+var typographicReplacements = map[rune]string{
+	'‘': "'", '’': "'", '‚': "'", '‹': "'", '›': "'",
+	'“': "\"", '”': "\"", '„': "\"",
+	'–': "-", '—': "-", '‐': "-", '−': "-",
+	'…': "...", '•': "*",
+	'\u2009': " ", '\u202f': " ", '\u2007': " ",
+	'\u200b': "", '\ufeff': "",
+}
+
+// ReplaceInvalidCharacters makes the given text representable in the given text encoding: typographic quotes, dashes
+// and ellipses are replaced with their ASCII counterparts, all other characters that cannot be represented are
+// replaced with the given replacement.
+func ReplaceInvalidCharacters(text string, encoding sds.TextEncoding, replacement string) string {
+	codec, ok := sds.TextCodecs[encoding]
+	if !ok {
+		codec = charmap.ISO8859_1
+	}
+	encoder := codec.NewEncoder()
+
+	var builder strings.Builder
+	for _, r := range text {
+		if _, err := encoder.String(string(r)); r != utf8.RuneError && err == nil {
+			builder.WriteRune(r)
+		} else if ascii, ok := typographicReplacements[r]; ok {
+			builder.WriteString(ascii)
+		} else {
+			builder.WriteString(replacement)
+		}
+	}
+	return builder.String()
+}
@@ -0,0 +1,103 @@
+package messaging
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/ftl/tetra-pei/sds"
+)
+
+func TestParseEncoding(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected sds.TextEncoding
+	}{
+		{"ISO8859-1", sds.ISO8859_1},
+		{" iso8859-15 ", sds.ISO8859_15},
+		{"codepage850", sds.CodePage850},
+	}
+	for _, tt := range tests {
+		got, err := ParseEncoding(tt.name)
+		if err != nil || got != tt.expected {
+			t.Errorf("%q: expected %d, got %d %v", tt.name, tt.expected, got, err)
+		}
+	}
+
+	_, err := ParseEncoding("UTF-8")
+	if err == nil || !strings.Contains(err.Error(), "ISO8859-1, ISO8859-10") {
+		t.Errorf("the error should list the encodings, got %v", err)
+	}
+}
+
+func TestValidateText(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		encoding sds.TextEncoding
+		invalid  []InvalidCharacter
+	}{
+		{"valid", "Straße frei\nÜbung", sds.ISO8859_1, nil},
+		{"euro in ISO8859-15", "5 €", sds.ISO8859_15, nil},
+		{"euro in ISO8859-1", "5 €", sds.ISO8859_1, []InvalidCharacter{{3, '€'}}},
+		{"several", "„Feuer“ 🔥", sds.ISO8859_1, []InvalidCharacter{{1, '„'}, {7, '“'}, {9, '🔥'}}},
+		{"invalid UTF-8", "ab\xffc\xe2\x82", sds.ISO8859_1, []InvalidCharacter{{3, utf8.RuneError}, {5, utf8.RuneError}, {6, utf8.RuneError}}},
+		{"replacement character", "a�b", sds.ISO8859_1, []InvalidCharacter{{2, utf8.RuneError}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateText(tt.text, tt.encoding)
+			if tt.invalid == nil {
+				if err != nil {
+					t.Errorf("the text should be valid: %v", err)
+				}
+				return
+			}
+			var invalidText *InvalidTextError
+			if !errors.As(err, &invalidText) {
+				t.Fatalf("expected an InvalidTextError, got %v", err)
+			}
+			if !reflect.DeepEqual(invalidText.Characters, tt.invalid) {
+				t.Errorf("expected %v, got %v", tt.invalid, invalidText.Characters)
+			}
+		})
+	}
+}
+
+func TestInvalidTextError(t *testing.T) {
+	err := ValidateText("5 € \xff", sds.ISO8859_1)
+	expected := `the text cannot be represented in ISO8859-1: '€' (U+20AC) at position 3, invalid UTF-8 at position 5`
+	if err == nil || err.Error() != expected {
+		t.Errorf("expected %q, got %v", expected, err)
+	}
+
+	err = ValidateText(strings.Repeat("€", 12), sds.ISO8859_1)
+	if err == nil || !strings.HasSuffix(err.Error(), "at position 10 and 2 more") {
+		t.Errorf("the error should list only the first characters, got %v", err)
+	}
+}
+
+func TestReplaceInvalidCharacters(t *testing.T) {
+	tests := []struct {
+		text     string
+		encoding sds.TextEncoding
+		expected string
+	}{
+		{"Straße frei", sds.ISO8859_1, "Straße frei"},
+		{"„Feuer“ – ‘Halle’…", sds.ISO8859_1, "\"Feuer\" - 'Halle'..."},
+		{"5 € 🔥", sds.ISO8859_1, "5 ? ?"},
+		{"5 € 🔥", sds.ISO8859_15, "5 € ?"},
+		{"a\xffb\u200b", sds.ISO8859_1, "a?b"},
+	}
+	for _, tt := range tests {
+		got := ReplaceInvalidCharacters(tt.text, tt.encoding, "?")
+		if got != tt.expected {
+			t.Errorf("%q: expected %q, got %q", tt.text, tt.expected, got)
+		}
+		if err := ValidateText(got, tt.encoding); err != nil {
+			t.Errorf("%q: the result should be valid: %v", tt.text, err)
+		}
+	}
+}
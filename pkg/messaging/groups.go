@@ -0,0 +1,137 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ftl/tetra-pei/ctrl"
+	"github.com/ftl/tetra-pei/tetra"
+
+	"github.com/ftl/tetra-cli/pkg/store"
+)
+
+// MaxSSI is the highest short subscriber identity (24 bits), for individuals (ISSI) and groups (GSSI).
+const MaxSSI = 1<<24 - 1
+
+// maxTSIDigits is the maximum number of digits of a TETRA subscriber identity: 4 digits MCC, 5 digits MNC, 8 digits SSI.
+const maxTSIDigits = 17
+
+// ValidateIdentity checks that the given identity is a valid destination: either a short subscriber identity
+// (ISSI or GSSI, up to 16777215) or a TETRA subscriber identity (ITSI or GTSI, up to 17 digits).
+func ValidateIdentity(identity tetra.Identity) error {
+	value := string(identity)
+	if value == "" {
+		return fmt.Errorf("the destination is missing")
+	}
+	if !store.IsNumeric(value) {
+		return fmt.Errorf("invalid destination %q, use the numeric ISSI, GSSI, ITSI or GTSI", value)
+	}
+	if len(value) > maxTSIDigits {
+		return fmt.Errorf("invalid destination %s, it has more than %d digits", value, maxTSIDigits)
+	}
+	if len(value) <= store.MaxSSIDigits {
+		ssi, _ := strconv.Atoi(value)
+		if ssi > MaxSSI {
+			return fmt.Errorf("invalid destination %s, the SSI must not exceed %d", value, MaxSSI)
+		}
+	}
+	return nil
+}
+
+// ValidateDestination checks the destination of a message. The destination of a message to a group may also be the
+// name of a talkgroup, which is looked up when the message is sent.
+func ValidateDestination(destination tetra.Identity, group bool) error {
+	if group && destination != "" && !store.IsNumeric(string(destination)) {
+		return nil
+	}
+	return ValidateIdentity(destination)
+}
+
+// identityType returns the type of the given identity, as it is used to select the addressing of the SDS services.
+func identityType(identity tetra.Identity) tetra.IdentityType {
+	if len(identity) > store.MaxSSIDigits {
+		return tetra.TSI
+	}
+	return tetra.SSI
+}
+
+// sdsTLServiceRequest selects the SDS-TL service with the addressing that fits the given destination, see [PEI] 6.14.6.
+func sdsTLServiceRequest(destination tetra.Identity) string {
+	return fmt.Sprintf("AT+CTSDS=12,%d,0,0,1", identityType(destination))
+}
+
+// statusServiceRequest selects the status service with the addressing that fits the given destination, see [PEI] 6.14.6.
+func statusServiceRequest(destination tetra.Identity) string {
+	return fmt.Sprintf("AT+CTSDS=13,%d", identityType(destination))
+}
+
+// GroupReportsRequested indicates that delivery reports are requested for a message to a group. Groups do not send
+// delivery reports, so they never arrive.
+func (m TextMessage) GroupReportsRequested() bool {
+	return m.Group && (m.AckReceive || m.AckConsume)
+}
+
+// LookupTalkgroup finds the talkgroup with the given name or GTSI in the talkgroups of the current operating mode:
+// the dynamic talkgroups in TMO and the static talkgroups in DMO.
+func LookupTalkgroup(ctx context.Context, requester tetra.Requester, name string) (ctrl.TalkgroupInfo, error) {
+	mode, err := ctrl.RequestOperatingMode(ctx, requester)
+	if err != nil {
+		return ctrl.TalkgroupInfo{}, fmt.Errorf("cannot find out the current operating mode: %w", err)
+	}
+	kind := ctrl.TalkgroupDynamic
+	if mode == ctrl.DMO {
+		kind = ctrl.TalkgroupStatic
+	}
+
+	talkgroups, err := ctrl.RequestTalkgroups(ctx, requester, kind, nil)
+	if err != nil {
+		return ctrl.TalkgroupInfo{}, fmt.Errorf("cannot read the %s talkgroups: %w", mode, err)
+	}
+	return FindTalkgroup(talkgroups, name)
+}
+
+// FindTalkgroup finds the talkgroup with the given name or GTSI in the given list. The name is not case sensitive.
+// If no name matches exactly, a talkgroup whose name contains the given name is chosen, if it is unique.
+func FindTalkgroup(talkgroups []ctrl.TalkgroupInfo, name string) (ctrl.TalkgroupInfo, error) {
+	name = strings.TrimSpace(name)
+	for _, talkgroup := range talkgroups {
+		if talkgroup.GTSI == name || strings.EqualFold(strings.TrimSpace(talkgroup.Name), name) {
+			return talkgroup, nil
+		}
+	}
+
+	var candidates []ctrl.TalkgroupInfo
+	lowerName := strings.ToLower(name)
+	for _, talkgroup := range talkgroups {
+		if strings.Contains(strings.ToLower(talkgroup.Name), lowerName) {
+			candidates = append(candidates, talkgroup)
+		}
+	}
+	switch len(candidates) {
+	case 0:
+		return ctrl.TalkgroupInfo{}, fmt.Errorf("unknown talkgroup %q", name)
+	case 1:
+		return candidates[0], nil
+	default:
+		names := make([]string, len(candidates))
+		for i, candidate := range candidates {
+			names[i] = fmt.Sprintf("%s (%s)", strings.TrimSpace(candidate.Name), candidate.GTSI)
+		}
+		return ctrl.TalkgroupInfo{}, fmt.Errorf("the talkgroup %q is ambiguous: %s", name, strings.Join(names, ", "))
+	}
+}
+
+// resolveGroup returns the GTSI of a group destination that is given by the name of the talkgroup. Numeric
+// destinations are returned as they are. The caller must hold the send lock.
+func (s *Sender) resolveGroup(ctx context.Context, destination tetra.Identity, group bool) (tetra.Identity, error) {
+	if !group || store.IsNumeric(string(destination)) {
+		return destination, nil
+	}
+	talkgroup, err := LookupTalkgroup(ctx, s.pei, string(destination))
+	if err != nil {
+		return "", err
+	}
+	return tetra.Identity(talkgroup.GTSI), nil
+}
@@ -0,0 +1,174 @@
+package messaging
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ftl/tetra-pei/ctrl"
+	"github.com/ftl/tetra-pei/sds"
+	"github.com/ftl/tetra-pei/tetra"
+
+	"github.com/ftl/tetra-cli/pkg/fakepei"
+)
+
+func TestValidateIdentity(t *testing.T) {
+	for _, identity := range []tetra.Identity{"1", "1234567", "16777215", "123456789", "2620010000001", "12345678901234567"} {
+		if err := ValidateIdentity(identity); err != nil {
+			t.Errorf("%s should be valid: %v", identity, err)
+		}
+	}
+
+	tests := []struct {
+		identity tetra.Identity
+		message  string
+	}{
+		{"", "missing"},
+		{"12a4567", "numeric"},
+		{" 1234567", "numeric"},
+		{"-1234567", "numeric"},
+		{"16777216", "must not exceed 16777215"},
+		{"99999999", "must not exceed 16777215"},
+		{"123456789012345678", "more than 17 digits"},
+	}
+	for _, tt := range tests {
+		err := ValidateIdentity(tt.identity)
+		if err == nil || !strings.Contains(err.Error(), tt.message) {
+			t.Errorf("%q: expected an error with %q, got %v", tt.identity, tt.message, err)
+		}
+	}
+}
+
+func TestValidateDestination(t *testing.T) {
+	if err := ValidateDestination("Fire Ops", true); err != nil {
+		t.Errorf("a talkgroup name should be a valid group destination: %v", err)
+	}
+	if err := ValidateDestination("Fire Ops", false); err == nil {
+		t.Error("a name is no valid individual destination")
+	}
+	if err := ValidateDestination("16777216", true); err == nil {
+		t.Error("a numeric group destination must be a valid identity")
+	}
+	if err := ValidateDestination("", true); err == nil {
+		t.Error("the destination is missing")
+	}
+}
+
+func TestFindTalkgroup(t *testing.T) {
+	talkgroups := []ctrl.TalkgroupInfo{
+		{GTSI: "2620011001", Name: "Operations"},
+		{GTSI: "2620011002", Name: "Fire North "},
+		{GTSI: "2620011003", Name: "Fire South"},
+		{GTSI: "2620011004", Name: "Fire"},
+	}
+
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{"2620011001", "2620011001"},
+		{"operations", "2620011001"},
+		{" Fire North", "2620011002"},
+		{"fire", "2620011004"},
+		{"south", "2620011003"},
+		{"OPER", "2620011001"},
+	}
+	for _, tt := range tests {
+		talkgroup, err := FindTalkgroup(talkgroups, tt.name)
+		if err != nil || talkgroup.GTSI != tt.expected {
+			t.Errorf("%q: expected %s, got %+v %v", tt.name, tt.expected, talkgroup, err)
+		}
+	}
+
+	_, err := FindTalkgroup(talkgroups, "th")
+	if err == nil || err.Error() != `the talkgroup "th" is ambiguous: Fire North (2620011002), Fire South (2620011003)` {
+		t.Errorf("the ambiguous talkgroups should be listed, got %v", err)
+	}
+	_, err = FindTalkgroup(talkgroups, "police")
+	if err == nil || !strings.Contains(err.Error(), "unknown talkgroup") {
+		t.Errorf("expected an unknown talkgroup, got %v", err)
+	}
+}
+
+func TestServiceRequests(t *testing.T) {
+	tests := []struct {
+		destination tetra.Identity
+		sdsTL       string
+		status      string
+	}{
+		{"1234567", "AT+CTSDS=12,0,0,0,1", "AT+CTSDS=13,0"},
+		{"16777215", "AT+CTSDS=12,0,0,0,1", "AT+CTSDS=13,0"},
+		{"262001234567", "AT+CTSDS=12,1,0,0,1", "AT+CTSDS=13,1"},
+		{"2620010000001", "AT+CTSDS=12,1,0,0,1", "AT+CTSDS=13,1"},
+	}
+	for _, tt := range tests {
+		if got := sdsTLServiceRequest(tt.destination); got != tt.sdsTL {
+			t.Errorf("%s: expected %s, got %s", tt.destination, tt.sdsTL, got)
+		}
+		if got := statusServiceRequest(tt.destination); got != tt.status {
+			t.Errorf("%s: expected %s, got %s", tt.destination, tt.status, got)
+		}
+	}
+}
+
+func TestSender_SendTextToAGroupRequestsNoReports(t *testing.T) {
+	transfer := sds.NewTextMessageTransfer(1, false, sds.NoReportRequested, sds.ISO8859_1, "hello")
+	pei := fakepei.New(
+		fakepei.Expect("AT+CTOM?", "+CTOM: 0"),
+		fakepei.Expect("AT+CNUMD=?", "+CNUMD: (0),(1-2),(1-2)"),
+		fakepei.Expect("AT+CNUMD=0,1,2"),
+		fakepei.Expect("AT+CNUMD?", "+CNUMD: 1,2620011001,Operations", "+CNUMD: 2,2620011002,Fire"),
+		fakepei.Expect("AT+CTSDS=12,1,0,0,1"),
+		fakepei.Expect("AT+CMGS=?", "+CMGS: (0-99999999),(0-2047)"),
+		fakepei.Expect(sds.SendMessage("2620011002", transfer), "+CMGS: 0,1"),
+	)
+	sender, err := NewSender(pei)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	delivery, err := sender.SendText(ctx, TextMessage{
+		Destination: "fire",
+		Group:       true,
+		Text:        "hello",
+		Encoding:    sds.ISO8859_1,
+		AckReceive:  true,
+		AckConsume:  true,
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pei.Verify(); err != nil {
+		t.Errorf("%v\nrequests: %q", err, pei.Requests())
+	}
+	if delivery.Destination != "2620011002" || !delivery.Group || delivery.ReceivedRequested || delivery.ConsumedRequested {
+		t.Errorf("unexpected delivery %+v", delivery)
+	}
+}
+
+func TestSender_SendStatusToAGroup(t *testing.T) {
+	pei := fakepei.New(
+		fakepei.Expect("AT+CTSP=2,2,20"),
+		fakepei.Expect("AT+CTSDS=13,0"),
+		fakepei.Expect(sds.SendMessage("1001", sds.Status(0x8002)), "+CMGS: 0"),
+	)
+	sender, err := NewSender(pei)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	err = sender.SendStatus(ctx, StatusMessage{Destination: "1001", Group: true, Status: 0x8002})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pei.Verify(); err != nil {
+		t.Errorf("%v\nrequests: %q", err, pei.Requests())
+	}
+}
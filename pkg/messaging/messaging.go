@@ -0,0 +1,466 @@
+// Package messaging sends SDS text and status messages through a PEI device and tracks the delivery reports
+// of the outgoing text messages.
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/ftl/tetra-pei/sds"
+	"github.com/ftl/tetra-pei/tetra"
+
+	"github.com/ftl/tetra-cli/pkg/radio"
+	"github.com/ftl/tetra-cli/pkg/store"
+)
+
+// MaxPDUBits is the maximum length of a message PDU that is sent as a single message.
+// This is the value that seems to work in practice. Grateful for any hint how this is supposed to work.
+const MaxPDUBits = 668
+
+// DeliveryRetention is the time a sender keeps track of a delivery after it is done, so that its final state can
+// still be queried.
+const DeliveryRetention = time.Hour
+
+// TextMessage describes an outgoing SDS text message.
+type TextMessage struct {
+	Destination      tetra.Identity
+	Text             string
+	MessageReference sds.MessageReference
+	Encoding         sds.TextEncoding
+	Immediate        bool
+	AckReceive       bool
+	AckConsume       bool
+	Simple           bool
+	// Group defines that the destination is a group (GSSI or GTSI). Groups do not send delivery reports.
+	Group bool
+}
+
+// DeliveryReportRequest returns the delivery reports requested for this message.
+// Groups do not send delivery reports, so no reports are requested for messages to a group.
+func (m TextMessage) DeliveryReportRequest() sds.DeliveryReportRequest {
+	result := sds.NoReportRequested
+	if m.Group {
+		return result
+	}
+	if m.AckReceive {
+		result |= sds.MessageReceivedReportRequested
+	}
+	if m.AckConsume {
+		result |= sds.MessageConsumedReportRequested
+	}
+	return result
+}
+
+// StatusMessage describes an outgoing status message.
+type StatusMessage struct {
+	Destination tetra.Identity
+	Status      sds.Status
+	// Group defines that the destination is a group (GSSI or GTSI).
+	Group bool
+}
+
+// ParseStatus parses the given hex string as status value.
+func ParseStatus(hexStatus string) (sds.Status, error) {
+	statusBytes, err := tetra.HexToBinary(hexStatus)
+	if err != nil {
+		return 0, fmt.Errorf("wrong status format: %w", err)
+	}
+	status, err := sds.ParseStatus(statusBytes)
+	if err != nil {
+		return 0, fmt.Errorf("not a valid status: %w", err)
+	}
+	result, ok := status.(sds.Status)
+	if !ok {
+		return 0, fmt.Errorf("not a valid status: %s", hexStatus)
+	}
+	return result, nil
+}
+
+// Sender sends text and status messages and tracks the delivery of the text messages.
+// It is safe for concurrent use, the messages are sent one after another.
+type Sender struct {
+	pei radio.PEI
+
+	sendLock         sync.Mutex
+	maxPDUBitsProbed bool
+	partConfirmation chan string
+
+	deliveriesLock sync.Mutex
+	deliveries     map[string]*Delivery
+	lastDeliveries map[sds.MessageReference]*Delivery
+	nextReferences map[tetra.Identity]int
+
+	store  *store.Store
+	outbox *Outbox
+}
+
+// NewSender returns a new sender that uses the given PEI. It registers the indications for delivery reports
+// and for the confirmation of sent message parts.
+func NewSender(pei radio.PEI) (*Sender, error) {
+	result := &Sender{
+		pei:              pei,
+		partConfirmation: make(chan string, 1),
+		deliveries:       make(map[string]*Delivery),
+		lastDeliveries:   make(map[sds.MessageReference]*Delivery),
+		nextReferences:   make(map[tetra.Identity]int),
+	}
+
+	err := pei.AddIndication("+CTSDSR: 12,", 1, result.handleReport)
+	if err != nil {
+		return nil, fmt.Errorf("cannot activate delivery report indication: %w", err)
+	}
+	err = pei.AddIndication("+CMGS: 0,", 0, func(lines []string) {
+		if len(lines) != 1 {
+			return
+		}
+		select {
+		case result.partConfirmation <- lines[0]:
+		default:
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot activate message part confirmation: %w", err)
+	}
+
+	return result, nil
+}
+
+// WithStore records all outgoing messages and the changes of their delivery state in the given store.
+func (s *Sender) WithStore(store *store.Store) *Sender {
+	s.store = store
+	return s
+}
+
+// WithOutbox registers all outgoing text messages in the given outbox and updates the outbox with all
+// received delivery reports.
+func (s *Sender) WithOutbox(outbox *Outbox) *Sender {
+	s.outbox = outbox
+	return s
+}
+
+// Delivery returns the delivery of the last text message sent with the given message reference.
+func (s *Sender) Delivery(messageReference sds.MessageReference) (*Delivery, bool) {
+	s.deliveriesLock.Lock()
+	defer s.deliveriesLock.Unlock()
+	delivery, ok := s.lastDeliveries[messageReference]
+	return delivery, ok
+}
+
+// DeliveryTo returns the delivery of the text message sent to the given destination with the given message reference.
+func (s *Sender) DeliveryTo(destination tetra.Identity, messageReference sds.MessageReference) (*Delivery, bool) {
+	s.deliveriesLock.Lock()
+	defer s.deliveriesLock.Unlock()
+	delivery, ok := s.deliveries[outboxKey(string(destination), int(messageReference))]
+	return delivery, ok
+}
+
+// deliveryForReport returns the delivery that belongs to a delivery report from the given source.
+func (s *Sender) deliveryForReport(source tetra.Identity, messageReference sds.MessageReference) (*Delivery, bool) {
+	s.deliveriesLock.Lock()
+	defer s.deliveriesLock.Unlock()
+	result := s.deliveries[outboxKey(string(source), int(messageReference))]
+	if result != nil && !result.Done() {
+		return result, true
+	}
+	// a pending delivery to the same radio is preferred over a delivery that is done already
+	for _, delivery := range s.deliveries {
+		if !delivery.covers(messageReference) || !SameIdentity(string(delivery.Destination), string(source)) {
+			continue
+		}
+		if result == nil || (result.Done() && !delivery.Done()) {
+			result = delivery
+		}
+	}
+	return result, result != nil
+}
+
+// SendText sends the given text message. If the text does not fit into a single message PDU, it is sent
+// as concatenated message. The returned delivery tracks the delivery reports for the message.
+func (s *Sender) SendText(ctx context.Context, message TextMessage) (*Delivery, error) {
+	err := ValidateDestination(message.Destination, message.Group)
+	if err != nil {
+		return nil, err
+	}
+	err = ValidateText(message.Text, message.Encoding)
+	if err != nil {
+		return nil, err
+	}
+	if message.Group {
+		message.AckReceive = false
+		message.AckConsume = false
+	}
+
+	s.sendLock.Lock()
+	defer s.sendLock.Unlock()
+
+	message.Destination, err = s.resolveGroup(ctx, message.Destination, message.Group)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.pei.ATs(ctx, sdsTLServiceRequest(message.Destination))
+	if err != nil {
+		return nil, fmt.Errorf("cannot select the SDS-TL service: %w", err)
+	}
+
+	if !s.maxPDUBitsProbed {
+		_, err = sds.RequestMaxMessagePDUBits(ctx, s.pei)
+		if err != nil {
+			return nil, fmt.Errorf("cannot find out how long an SDS text message may be: %w", err)
+		}
+		s.maxPDUBitsProbed = true
+	}
+
+	if message.Simple {
+		pdu := sds.NewSimpleTextMessage(message.Immediate, message.Encoding, message.Text)
+		_, err = s.pei.AT(ctx, sds.SendMessage(message.Destination, pdu))
+		if err != nil {
+			return nil, fmt.Errorf("cannot send SDS text message: %w", err)
+		}
+		// simple text messages have no message reference
+		delivery := newDelivery(message.Destination, 0, 1)
+		delivery.Group = message.Group
+		s.recordText(delivery, message.Text, false)
+		return delivery, nil
+	}
+
+	parts := PartCount(message)
+	if message.MessageReference == 0 {
+		message.MessageReference, err = s.allocateMessageReference(message.Destination, parts)
+		if err != nil {
+			return nil, err
+		}
+	} else if int(message.MessageReference)+parts-1 > MaxMessageReference {
+		return nil, fmt.Errorf("the message reference %d leaves no room for the %d parts of the message", message.MessageReference, parts)
+	}
+
+	if parts == 1 {
+		sdsTransfer := sds.NewTextMessageTransfer(message.MessageReference, message.Immediate, message.DeliveryReportRequest(), message.Encoding, message.Text)
+		delivery, err := s.sendSingleTextMessage(ctx, message.Destination, sdsTransfer)
+		if err != nil {
+			return nil, err
+		}
+		delivery.Group = message.Group
+		s.recordText(delivery, message.Text, !message.Group)
+		return delivery, nil
+	}
+	delivery, err := s.sendConcatenatedTextMessage(ctx, message)
+	if err != nil {
+		return nil, err
+	}
+	delivery.Group = message.Group
+	s.recordText(delivery, message.Text, !message.Group)
+	return delivery, nil
+}
+
+func (s *Sender) sendSingleTextMessage(ctx context.Context, destination tetra.Identity, sdsTransfer sds.SDSTransfer) (*Delivery, error) {
+	delivery := newDelivery(destination, sdsTransfer.MessageReference, 1)
+	delivery.ReceivedRequested = sdsTransfer.ReceivedReportRequested()
+	delivery.ConsumedRequested = sdsTransfer.ConsumedReportRequested()
+	s.track(delivery)
+
+	_, err := s.pei.AT(ctx, sds.SendMessage(destination, sdsTransfer))
+	if err != nil {
+		s.forget(delivery)
+		return nil, fmt.Errorf("cannot send SDS text message: %w", err)
+	}
+	return delivery, nil
+}
+
+func (s *Sender) sendConcatenatedTextMessage(ctx context.Context, message TextMessage) (*Delivery, error) {
+	pdus := sds.NewConcatenatedMessageTransfer(message.MessageReference, message.DeliveryReportRequest(), message.Encoding, MaxPDUBits, message.Text)
+	delivery := newDelivery(message.Destination, message.MessageReference, len(pdus))
+	delivery.ReceivedRequested = message.AckReceive
+	delivery.ConsumedRequested = message.AckConsume
+	s.track(delivery)
+
+	// drop any stale confirmation
+	select {
+	case <-s.partConfirmation:
+	default:
+	}
+
+	for i, pdu := range pdus {
+		_, err := s.pei.AT(ctx, sds.SendMessage(message.Destination, pdu))
+		if err != nil {
+			s.forget(delivery)
+			return nil, fmt.Errorf("cannot send SDS text message part #%d: %w", i+1, err)
+		}
+		if i < len(pdus)-1 {
+			select {
+			case <-s.partConfirmation:
+			case <-ctx.Done():
+				s.forget(delivery)
+				return nil, ctx.Err()
+			}
+		}
+	}
+	return delivery, nil
+}
+
+// SendStatus sends the given status message.
+func (s *Sender) SendStatus(ctx context.Context, message StatusMessage) error {
+	err := ValidateDestination(message.Destination, message.Group)
+	if err != nil {
+		return err
+	}
+
+	s.sendLock.Lock()
+	defer s.sendLock.Unlock()
+
+	message.Destination, err = s.resolveGroup(ctx, message.Destination, message.Group)
+	if err != nil {
+		return err
+	}
+
+	err = s.pei.ATs(ctx,
+		"AT+CTSP=2,2,20", // status
+		statusServiceRequest(message.Destination),
+	)
+	if err != nil {
+		return fmt.Errorf("cannot select the status service: %w", err)
+	}
+
+	_, err = s.pei.AT(ctx, sds.SendMessage(message.Destination, message.Status))
+	if err != nil {
+		return fmt.Errorf("cannot send status message: %w", err)
+	}
+	s.recordStatus(message)
+	return nil
+}
+
+// recordText records the given delivery in the message store and, if it can receive delivery reports, in the outbox.
+func (s *Sender) recordText(delivery *Delivery, text string, reportable bool) {
+	state, _ := delivery.State()
+	var partStates []DeliveryState
+	if delivery.Parts > 1 {
+		partStates = delivery.PartStates()
+	}
+	var record store.Message
+	if s.store != nil {
+		var err error
+		record, err = s.store.Add(store.Message{
+			Direction:        store.Outgoing,
+			Type:             store.TextMessage,
+			Destination:      string(delivery.Destination),
+			Group:            delivery.Group,
+			Text:             text,
+			MessageReference: int(delivery.MessageReference),
+			State:            string(state),
+		})
+		if err != nil {
+			log.Printf("cannot record outgoing text message: %v", err)
+		} else {
+			delivery.setRecord(record)
+		}
+	}
+
+	if s.outbox == nil || !reportable {
+		return
+	}
+	err := s.outbox.Register(OutboxEntry{
+		Destination:       string(delivery.Destination),
+		MessageReference:  int(delivery.MessageReference),
+		Text:              text,
+		Parts:             delivery.Parts,
+		ReceivedRequested: delivery.ReceivedRequested,
+		ConsumedRequested: delivery.ConsumedRequested,
+		State:             state,
+		PartStates:        partStates,
+		MessageID:         record.ID,
+	})
+	if err != nil {
+		log.Printf("cannot register outgoing text message in the outbox: %v", err)
+	}
+}
+
+func (s *Sender) recordStatus(message StatusMessage) {
+	if s.store == nil {
+		return
+	}
+	_, err := s.store.Add(store.Message{
+		Direction:   store.Outgoing,
+		Type:        store.StatusMessage,
+		Destination: string(message.Destination),
+		Group:       message.Group,
+		Status:      store.FormatStatus(message.Status),
+		State:       string(Sent),
+	})
+	if err != nil {
+		log.Printf("cannot record outgoing status message: %v", err)
+	}
+}
+
+func (s *Sender) updateDelivery(delivery *Delivery, messageReference sds.MessageReference, state DeliveryState, deliveryStatus sds.DeliveryStatus) {
+	// with an outbox, the message store is updated through the outbox entry
+	if !delivery.update(messageReference, state, deliveryStatus) || s.store == nil || s.outbox != nil {
+		return
+	}
+	record, ok := delivery.storedRecord()
+	if !ok {
+		return
+	}
+	overallState, _ := delivery.State()
+	record.State = string(overallState)
+	err := s.store.Update(record)
+	if err != nil {
+		log.Printf("cannot record delivery state: %v", err)
+	}
+}
+
+// track the given delivery. Each part of the message is tracked with its own message reference.
+// Outdated deliveries are removed.
+func (s *Sender) track(delivery *Delivery) {
+	s.deliveriesLock.Lock()
+	defer s.deliveriesLock.Unlock()
+	s.pruneDeliveries(time.Now())
+	for i := range max(delivery.Parts, 1) {
+		s.deliveries[outboxKey(string(delivery.Destination), int(delivery.MessageReference)+i)] = delivery
+	}
+	s.lastDeliveries[delivery.MessageReference] = delivery
+}
+
+func (s *Sender) forget(delivery *Delivery) {
+	s.deliveriesLock.Lock()
+	defer s.deliveriesLock.Unlock()
+	for i := range max(delivery.Parts, 1) {
+		key := outboxKey(string(delivery.Destination), int(delivery.MessageReference)+i)
+		if s.deliveries[key] == delivery {
+			delete(s.deliveries, key)
+		}
+	}
+	if s.lastDeliveries[delivery.MessageReference] == delivery {
+		delete(s.lastDeliveries, delivery.MessageReference)
+	}
+}
+
+// pruneDeliveries removes the deliveries that are done for longer than DeliveryRetention, and the deliveries that
+// are older than DefaultOutboxMaxAge and will not receive any delivery reports anymore. The caller must hold
+// the deliveries lock.
+func (s *Sender) pruneDeliveries(now time.Time) {
+	for key, delivery := range s.deliveries {
+		if delivery.outdated(now, DeliveryRetention, DefaultOutboxMaxAge) {
+			delete(s.deliveries, key)
+		}
+	}
+	for messageReference, delivery := range s.lastDeliveries {
+		if delivery.outdated(now, DeliveryRetention, DefaultOutboxMaxAge) {
+			delete(s.lastDeliveries, messageReference)
+		}
+	}
+}
+
+func (s *Sender) handleReport(lines []string) {
+	report, ok := parseDeliveryReport(lines)
+	if !ok {
+		return
+	}
+	delivery, ok := s.deliveryForReport(report.Source, report.MessageReference)
+	if ok {
+		s.updateDelivery(delivery, report.MessageReference, report.State, report.DeliveryStatus)
+	}
+	applyReport(s.outbox, s.store, report)
+}
@@ -0,0 +1,67 @@
+package messaging
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ftl/tetra-pei/sds"
+
+	"github.com/ftl/tetra-cli/pkg/fakepei"
+)
+
+func TestSender_PrunesOutdatedDeliveries(t *testing.T) {
+	sender, err := NewSender(fakepei.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+
+	doneLongAgo := newDelivery("1234567", 1, 1)
+	doneLongAgo.updated = now.Add(-2 * DeliveryRetention)
+	doneRecently := newDelivery("1234567", 2, 1)
+	pendingTooLong := newDelivery("1234567", 3, 1)
+	pendingTooLong.ReceivedRequested = true
+	pendingTooLong.sent = now.Add(-2 * DefaultOutboxMaxAge)
+	pending := newDelivery("1234567", 4, 2)
+	pending.ReceivedRequested = true
+	for _, delivery := range []*Delivery{doneLongAgo, doneRecently, pendingTooLong, pending} {
+		sender.track(delivery)
+	}
+	sender.track(newDelivery("2345678", 1, 1))
+
+	for _, reference := range []sds.MessageReference{1, 3} {
+		if _, ok := sender.DeliveryTo("1234567", reference); ok {
+			t.Errorf("the delivery with reference %d should be removed", reference)
+		}
+	}
+	for _, reference := range []sds.MessageReference{2, 4, 5} {
+		if _, ok := sender.DeliveryTo("1234567", reference); !ok {
+			t.Errorf("the delivery with reference %d should be kept", reference)
+		}
+	}
+	if delivery, ok := sender.Delivery(1); !ok || delivery.Destination != "2345678" {
+		t.Error("the last delivery with reference 1 should be kept")
+	}
+	if _, ok := sender.Delivery(3); ok {
+		t.Error("the last delivery with reference 3 should be removed")
+	}
+}
+
+func TestSender_ReportsPreferPendingDeliveries(t *testing.T) {
+	sender, err := NewSender(fakepei.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := newDelivery("1234567", 1, 1)
+	pending := newDelivery("2620010001234567", 1, 1)
+	pending.ReceivedRequested = true
+	sender.track(done)
+	sender.track(pending)
+
+	for range 10 {
+		delivery, ok := sender.deliveryForReport("1234567", 1)
+		if !ok || delivery != pending {
+			t.Fatal("the report should belong to the pending delivery")
+		}
+	}
+}
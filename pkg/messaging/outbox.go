@@ -0,0 +1,317 @@
+package messaging
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/ftl/tetra-pei/sds"
+	"github.com/ftl/tetra-pei/tetra"
+
+	"github.com/ftl/tetra-cli/pkg/radio"
+	"github.com/ftl/tetra-cli/pkg/store"
+)
+
+// DefaultOutboxMaxAge is the time after which a message in the outbox expires if the requested delivery reports
+// were not received.
+const DefaultOutboxMaxAge = 24 * time.Hour
+
+// DefaultOutboxPath returns the default path of the outbox, next to the message store.
+func DefaultOutboxPath() string {
+	return filepath.Join(store.DataDir(), "outbox.jsonl")
+}
+
+// OutboxEntry is an outgoing text message that is registered in the outbox.
+type OutboxEntry struct {
+	Destination       string          `json:"destination"`
+	MessageReference  int             `json:"message_reference"`
+	Time              time.Time       `json:"time"`
+	Text              string          `json:"text,omitempty"`
+	Parts             int             `json:"parts"`
+	ReceivedRequested bool            `json:"received_requested"`
+	ConsumedRequested bool            `json:"consumed_requested"`
+	State             DeliveryState   `json:"state"`
+	PartStates        []DeliveryState `json:"part_states,omitempty"`
+	DeliveryStatus    string          `json:"delivery_status,omitempty"`
+	Expires           time.Time       `json:"expires"`
+	Updated           time.Time       `json:"updated,omitzero"`
+	MessageID         string          `json:"message_id,omitempty"`
+}
+
+func outboxKey(destination string, messageReference int) string {
+	return fmt.Sprintf("%s/%d", destination, messageReference)
+}
+
+// Key returns the key of this entry in the outbox: the destination and the message reference.
+func (e OutboxEntry) Key() string {
+	return outboxKey(e.Destination, e.MessageReference)
+}
+
+// covers indicates if the given message reference belongs to one of the parts of this entry's message.
+func (e OutboxEntry) covers(messageReference int) bool {
+	return messageReference >= e.MessageReference && messageReference < e.MessageReference+max(e.Parts, 1)
+}
+
+// Pending indicates if delivery reports are still expected for this entry.
+func (e OutboxEntry) Pending() bool {
+	switch {
+	case e.State == Failed || e.State == Expired:
+		return false
+	case e.ConsumedRequested:
+		return e.State != Consumed
+	case e.ReceivedRequested:
+		return !e.State.Reached(Received)
+	default:
+		return false
+	}
+}
+
+// Outbox keeps track of the outgoing text messages and their delivery state in a file, so that delivery reports
+// can be matched by any process that receives them. It is safe for concurrent use.
+type Outbox struct {
+	log *store.Log[OutboxEntry]
+
+	// MaxAge is the time after which a pending entry expires.
+	MaxAge time.Duration
+}
+
+// OpenOutbox opens the outbox with the given path. The file and its directory are created if necessary.
+func OpenOutbox(path string) (*Outbox, error) {
+	outboxLog, err := store.OpenLog(path, OutboxEntry.Key)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open outbox: %w", err)
+	}
+	return &Outbox{log: outboxLog, MaxAge: DefaultOutboxMaxAge}, nil
+}
+
+// Register the given entry in the outbox. It replaces any previous entry with the same destination
+// and message reference.
+func (o *Outbox) Register(entry OutboxEntry) error {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	if entry.Expires.IsZero() {
+		entry.Expires = entry.Time.Add(o.MaxAge)
+	}
+	if entry.State == "" {
+		entry.State = Sent
+	}
+	if entry.Parts > 1 && len(entry.PartStates) != entry.Parts {
+		entry.PartStates = make([]DeliveryState, entry.Parts)
+		for i := range entry.PartStates {
+			entry.PartStates[i] = entry.State
+		}
+	}
+	return o.append(entry)
+}
+
+func (o *Outbox) append(entry OutboxEntry) error {
+	err := o.log.Append(entry)
+	if err != nil {
+		return fmt.Errorf("cannot write to outbox: %w", err)
+	}
+	return nil
+}
+
+// Entries returns all entries of the outbox in the order they were registered. Pending entries that are older
+// than their expiry time have the state Expired.
+func (o *Outbox) Entries() ([]OutboxEntry, error) {
+	entries, err := o.log.Load()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read outbox: %w", err)
+	}
+	now := time.Now()
+	for i, entry := range entries {
+		entries[i] = entry.expire(now)
+	}
+	return entries, nil
+}
+
+// expire sets the state Expired if this entry is pending and older than its expiry time.
+func (e OutboxEntry) expire(now time.Time) OutboxEntry {
+	if e.Pending() && !e.Expires.IsZero() && now.After(e.Expires) {
+		e.State = Expired
+	}
+	return e
+}
+
+// Entry returns the entry with the given destination and message reference.
+func (o *Outbox) Entry(destination string, messageReference int) (OutboxEntry, bool, error) {
+	entry, ok, err := o.log.Get(outboxKey(destination, messageReference))
+	if err != nil {
+		return OutboxEntry{}, false, fmt.Errorf("cannot read outbox: %w", err)
+	}
+	if !ok {
+		return OutboxEntry{}, false, nil
+	}
+	return entry.expire(time.Now()), true, nil
+}
+
+// match returns the entry that belongs to a delivery report from the given source with the given message reference.
+// The message reference may belong to any part of a concatenated message. The source of a report may be the full ITSI,
+// while the entry's destination is only the ISSI, or vice versa. If several entries match, e.g. because the message
+// reference was used again, a pending entry is preferred over the others, and a newer entry over an older one.
+func (o *Outbox) match(source string, messageReference int) (OutboxEntry, bool, error) {
+	entries, err := o.Entries()
+	if err != nil {
+		return OutboxEntry{}, false, err
+	}
+	var result OutboxEntry
+	found := false
+	for _, entry := range entries {
+		if !entry.covers(messageReference) || !SameIdentity(entry.Destination, source) {
+			continue
+		}
+		if !found || preferredMatch(entry, result) {
+			result = entry
+			found = true
+		}
+	}
+	return result, found, nil
+}
+
+// preferredMatch indicates if the entry a should be preferred over the entry b as match for a delivery report.
+func preferredMatch(a, b OutboxEntry) bool {
+	if a.Pending() != b.Pending() {
+		return a.Pending()
+	}
+	return !a.Time.Before(b.Time)
+}
+
+// Update the state of the entry that matches the given destination and message reference. The message reference
+// may belong to any part of a concatenated message. The state only changes if the part did not reach the given state
+// yet. Update returns the updated entry and if it was changed.
+func (o *Outbox) Update(destination string, messageReference int, state DeliveryState, deliveryStatus sds.DeliveryStatus) (OutboxEntry, bool, error) {
+	entry, ok, err := o.match(destination, messageReference)
+	if err != nil || !ok {
+		return entry, false, err
+	}
+	// a late report is still better than no report
+	if entry.State == Expired {
+		entry.State = Sent
+	}
+
+	if len(entry.PartStates) > 1 {
+		part := messageReference - entry.MessageReference
+		partState := entry.PartStates[part]
+		if partState == Failed || (state != Failed && partState.Reached(state)) {
+			return entry, false, nil
+		}
+		entry.PartStates = slices.Clone(entry.PartStates)
+		entry.PartStates[part] = state
+		entry.State = combinePartStates(entry.PartStates)
+	} else {
+		if entry.State == Failed || (state != Failed && entry.State.Reached(state)) {
+			return entry, false, nil
+		}
+		entry.State = state
+	}
+	entry.DeliveryStatus = fmt.Sprintf("0x%02x", byte(deliveryStatus))
+	entry.Updated = time.Now()
+	return entry, true, o.append(entry)
+}
+
+// SameIdentity indicates if the given identities belong to the same radio. One identity may be the full ITSI,
+// while the other one is only the ISSI; then the ISSI is compared with the SSI part of the ITSI.
+func SameIdentity(a, b string) bool {
+	return store.SameIdentity(a, b)
+}
+
+// deliveryReport is a delivery report for an outgoing message, sent by the destination of the message.
+type deliveryReport struct {
+	Source           tetra.Identity
+	MessageReference sds.MessageReference
+	State            DeliveryState
+	DeliveryStatus   sds.DeliveryStatus
+}
+
+// parseDeliveryReport parses the given indication lines as delivery report.
+func parseDeliveryReport(lines []string) (deliveryReport, bool) {
+	if len(lines) != 2 {
+		return deliveryReport{}, false
+	}
+	part, err := sds.ParseIncomingMessage(lines[0], lines[1])
+	if err != nil {
+		log.Printf("cannot decode message part: %v", err)
+		return deliveryReport{}, false
+	}
+
+	result := deliveryReport{Source: part.Header.Source}
+	switch report := part.Payload.(type) {
+	case sds.SDSReport:
+		result.MessageReference = report.MessageReference
+		result.DeliveryStatus = report.DeliveryStatus
+		switch {
+		case report.DeliveryStatus == sds.ReceiptAckByDestination:
+			result.State = Received
+		case report.DeliveryStatus == sds.ConsumedByDestination:
+			result.State = Consumed
+		case report.DeliveryStatus.DataDeliveryFailed():
+			result.State = Failed
+		default:
+			log.Printf("unexpected delivery report: 0x%x", report.DeliveryStatus)
+			return deliveryReport{}, false
+		}
+	case sds.SDSShortReport:
+		result.MessageReference = report.MessageReference
+		switch report.ReportType {
+		case sds.MessageReceivedShort:
+			result.State = Received
+			result.DeliveryStatus = sds.ReceiptAckByDestination
+		case sds.MessageConsumedShort:
+			result.State = Consumed
+			result.DeliveryStatus = sds.ConsumedByDestination
+		default:
+			log.Printf("unexpected short delivery report: 0x%x", report.ReportType)
+			return deliveryReport{}, false
+		}
+	default:
+		return deliveryReport{}, false
+	}
+	return result, true
+}
+
+// applyReport updates the outbox entry and the recorded message that belong to the given delivery report.
+// The outbox and the message store are optional.
+func applyReport(outbox *Outbox, messageStore *store.Store, report deliveryReport) {
+	if outbox == nil {
+		return
+	}
+	entry, changed, err := outbox.Update(string(report.Source), int(report.MessageReference), report.State, report.DeliveryStatus)
+	if err != nil {
+		log.Printf("cannot update outbox: %v", err)
+		return
+	}
+	if !changed || messageStore == nil || entry.MessageID == "" {
+		return
+	}
+
+	record, ok, err := messageStore.Message(entry.MessageID)
+	if err != nil || !ok {
+		return
+	}
+	record.State = string(entry.State)
+	err = messageStore.Update(record)
+	if err != nil {
+		log.Printf("cannot record delivery state: %v", err)
+	}
+}
+
+// TrackDeliveryReports registers the indication for delivery reports at the given PEI and matches all received
+// reports with the entries in the given outbox. The state of the matching messages in the message store is updated
+// too, if a message store is given.
+func TrackDeliveryReports(pei radio.PEI, outbox *Outbox, messageStore *store.Store) error {
+	err := pei.AddIndication("+CTSDSR: 12,", 1, func(lines []string) {
+		report, ok := parseDeliveryReport(lines)
+		if !ok {
+			return
+		}
+		applyReport(outbox, messageStore, report)
+	})
+	if err != nil {
+		return fmt.Errorf("cannot activate delivery report indication: %w", err)
+	}
+	return nil
+}
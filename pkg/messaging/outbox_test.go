@@ -0,0 +1,74 @@
+package messaging
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ftl/tetra-pei/sds"
+)
+
+func openTestOutbox(t *testing.T) *Outbox {
+	t.Helper()
+	result, err := OpenOutbox(filepath.Join(t.TempDir(), "outbox.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestOutbox_UpdateDoesNotMatchOtherRadios(t *testing.T) {
+	outbox := openTestOutbox(t)
+	outbox.Register(OutboxEntry{Destination: "51234", MessageReference: 1, ReceivedRequested: true})
+
+	_, ok, err := outbox.Update("1234", 1, Received, sds.ReceiptAckByDestination)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Error("the report of 1234 must not update the message to 51234")
+	}
+}
+
+func TestOutbox_UpdatePrefersPendingEntries(t *testing.T) {
+	outbox := openTestOutbox(t)
+	now := time.Now()
+	outbox.Register(OutboxEntry{Destination: "2620010001234567", MessageReference: 1, Time: now, ReceivedRequested: true})
+	outbox.Register(OutboxEntry{Destination: "1234567", MessageReference: 1, Time: now.Add(time.Minute), ReceivedRequested: true, State: Received})
+
+	entry, ok, err := outbox.Update("1234567", 1, Received, sds.ReceiptAckByDestination)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok || entry.Destination != "2620010001234567" {
+		t.Errorf("the pending entry should be updated, got %+v", entry)
+	}
+}
+
+func TestOutbox_UpdatePrefersNewerEntries(t *testing.T) {
+	outbox := openTestOutbox(t)
+	now := time.Now()
+	outbox.Register(OutboxEntry{Destination: "1234567", MessageReference: 1, Time: now.Add(time.Minute), ReceivedRequested: true})
+	outbox.Register(OutboxEntry{Destination: "2620010001234567", MessageReference: 1, Time: now, ReceivedRequested: true})
+
+	entry, ok, err := outbox.Update("2620010001234567", 1, Received, sds.ReceiptAckByDestination)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok || entry.Destination != "1234567" {
+		t.Errorf("the newer entry should be updated, got %+v", entry)
+	}
+}
+
+func TestOutbox_Entry(t *testing.T) {
+	outbox := openTestOutbox(t)
+	outbox.Register(OutboxEntry{Destination: "1234567", MessageReference: 1, ReceivedRequested: true, Expires: time.Now().Add(-time.Second)})
+
+	entry, ok, err := outbox.Entry("1234567", 1)
+	if err != nil || !ok {
+		t.Fatalf("the entry was not found: %v", err)
+	}
+	if entry.State != Expired {
+		t.Errorf("the entry should be expired, got %s", entry.State)
+	}
+}
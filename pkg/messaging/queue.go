@@ -0,0 +1,423 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/ftl/tetra-pei/sds"
+	"github.com/ftl/tetra-pei/tetra"
+
+	"github.com/ftl/tetra-cli/pkg/store"
+)
+
+// Default retry parameters for queued messages.
+const (
+	DefaultRetryBackoff    = 30 * time.Second
+	DefaultRetryMaxBackoff = 10 * time.Minute
+	DefaultRetryMaxAge     = 1 * time.Hour
+)
+
+// sendingLease is the time after which a message that is still claimed for sending may be claimed again, e.g.
+// because the process that claimed it crashed.
+const sendingLease = 10 * time.Minute
+
+// DefaultQueuePath returns the default path of the outgoing queue, next to the message store.
+func DefaultQueuePath() string {
+	return filepath.Join(store.DataDir(), "queue.jsonl")
+}
+
+// QueueState is the state of a message in the outgoing queue.
+type QueueState string
+
+// All queue states.
+const (
+	// QueueWaiting means that the message waits for the next attempt to send it.
+	QueueWaiting QueueState = "waiting"
+	// QueueSending means that a process claimed the message and sends it right now.
+	QueueSending QueueState = "sending"
+	// QueueSent means that the radio accepted the message and the requested delivery reports are pending.
+	QueueSent QueueState = "sent"
+	// QueueDelivered means that the message reached the requested delivery state.
+	QueueDelivered QueueState = "delivered"
+	// QueueFailed means that the message cannot be delivered, e.g. because the destination is not authorized.
+	QueueFailed QueueState = "failed"
+	// QueueExpired means that the message could not be delivered within its maximum age.
+	QueueExpired QueueState = "expired"
+	// QueueCanceled means that the message was removed from the queue.
+	QueueCanceled QueueState = "canceled"
+)
+
+// Active indicates if the queue still works on a message in this state.
+func (s QueueState) Active() bool {
+	return s == QueueWaiting || s == QueueSending || s == QueueSent
+}
+
+// Retryable indicates if a message that failed with the given delivery status may be delivered with another attempt.
+func Retryable(deliveryStatus sds.DeliveryStatus) bool {
+	switch deliveryStatus {
+	case sds.NetworkOverload,
+		sds.ServiceTemporaryNotAvailable,
+		sds.ValidityPeriodExpiredNotReceived,
+		sds.DeliveryFailed,
+		sds.DestinationNotRegistered,
+		sds.DestinationQueueFull,
+		sds.DestinationHostNotConnected,
+		sds.DestinationMemoryFullMessageDiscarded,
+		sds.DestinationNotAcceptingSDS,
+		sds.DestinationNotReachable,
+		sds.NotAllConcatenationPartsReceived,
+		sds.DestinationEngagedInAnotherServiceBySwMI,
+		sds.DestinationEngagedInAnotherServiceByDest:
+		return true
+	default:
+		return false
+	}
+}
+
+// QueueEntry is a text message in the outgoing queue.
+type QueueEntry struct {
+	ID          string           `json:"id"`
+	Created     time.Time        `json:"created"`
+	Destination string           `json:"destination"`
+	Group       bool             `json:"group,omitempty"`
+	Text        string           `json:"text"`
+	Encoding    sds.TextEncoding `json:"encoding"`
+	Immediate   bool             `json:"immediate,omitempty"`
+	AckReceive  bool             `json:"ack_receive,omitempty"`
+	AckConsume  bool             `json:"ack_consume,omitempty"`
+
+	Backoff    time.Duration `json:"backoff_ns"`
+	MaxBackoff time.Duration `json:"max_backoff_ns"`
+	Expires    time.Time     `json:"expires"`
+
+	State            QueueState `json:"state"`
+	Owner            string     `json:"owner,omitempty"`
+	Attempts         int        `json:"attempts"`
+	LastAttempt      time.Time  `json:"last_attempt,omitzero"`
+	NextAttempt      time.Time  `json:"next_attempt,omitzero"`
+	MessageReference int        `json:"message_reference,omitempty"`
+	LastError        string     `json:"last_error,omitempty"`
+	Updated          time.Time  `json:"updated,omitzero"`
+}
+
+// NewQueueEntry returns a new queue entry for the given text message with the default retry parameters.
+// Simple text messages cannot be queued, since they do not provide delivery reports.
+func NewQueueEntry(message TextMessage) QueueEntry {
+	return QueueEntry{
+		Destination: string(message.Destination),
+		Group:       message.Group,
+		Text:        message.Text,
+		Encoding:    message.Encoding,
+		Immediate:   message.Immediate,
+		AckReceive:  message.AckReceive,
+		AckConsume:  message.AckConsume,
+		Backoff:     DefaultRetryBackoff,
+		MaxBackoff:  DefaultRetryMaxBackoff,
+	}
+}
+
+// TextMessage returns the text message of this entry.
+func (e QueueEntry) TextMessage() TextMessage {
+	return TextMessage{
+		Destination: tetra.Identity(e.Destination),
+		Group:       e.Group,
+		Text:        e.Text,
+		Encoding:    e.Encoding,
+		Immediate:   e.Immediate,
+		AckReceive:  e.AckReceive,
+		AckConsume:  e.AckConsume,
+	}
+}
+
+// retryDelay returns the time to wait before the next attempt: the backoff is doubled with every attempt.
+func (e QueueEntry) retryDelay() time.Duration {
+	result := max(e.Backoff, time.Second)
+	for i := 1; i < e.Attempts; i++ {
+		result *= 2
+		if e.MaxBackoff > 0 && result >= e.MaxBackoff {
+			return e.MaxBackoff
+		}
+	}
+	return result
+}
+
+// Attempted records an attempt to send the message at the given time. If the attempt failed, the next attempt is
+// scheduled, unless the message expires before.
+func (e *QueueEntry) Attempted(now time.Time, messageReference sds.MessageReference, err error) {
+	e.Attempts++
+	e.LastAttempt = now
+	if err == nil {
+		e.State = QueueSent
+		e.MessageReference = int(messageReference)
+		e.LastError = ""
+		if !e.AckReceive && !e.AckConsume {
+			e.State = QueueDelivered
+		}
+		return
+	}
+	e.Retry(now, err.Error())
+}
+
+// Retry schedules the next attempt to send the message, unless the message expires before.
+func (e *QueueEntry) Retry(now time.Time, reason string) {
+	e.LastError = reason
+	e.NextAttempt = now.Add(e.retryDelay())
+	e.State = QueueWaiting
+	if !e.Expires.IsZero() && e.NextAttempt.After(e.Expires) {
+		e.State = QueueExpired
+	}
+}
+
+// Queue keeps outgoing text messages in a file until they are delivered. Messages that cannot be sent, or that
+// are reported as not delivered for a temporary reason, are sent again after an increasing delay, until they expire.
+// It is safe for concurrent use, also by several processes: a message is claimed under the lock of the queue's file
+// before it is sent.
+type Queue struct {
+	log   *store.Log[QueueEntry]
+	owner string
+}
+
+// OpenQueue opens the queue with the given path. The file and its directory are created if necessary.
+func OpenQueue(path string) (*Queue, error) {
+	queueLog, err := store.OpenLog(path, func(entry QueueEntry) string { return entry.ID })
+	if err != nil {
+		return nil, fmt.Errorf("cannot open queue: %w", err)
+	}
+	owner := fmt.Sprintf("%d-%04x", os.Getpid(), rand.Intn(0x10000))
+	return &Queue{log: queueLog, owner: owner}, nil
+}
+
+// Add the given entry to the queue. The ID, the creation time and the expiry time are set if they are missing.
+// If the entry has no state, it waits for its first attempt. The stored entry is returned.
+func (q *Queue) Add(entry QueueEntry, maxAge time.Duration) (QueueEntry, error) {
+	if entry.Created.IsZero() {
+		entry.Created = time.Now()
+	}
+	if entry.ID == "" {
+		entry.ID = fmt.Sprintf("%s-%04x", entry.Created.UTC().Format("20060102150405"), rand.Intn(0x10000))
+	}
+	if entry.Expires.IsZero() {
+		entry.Expires = entry.Created.Add(maxAge)
+	}
+	if entry.State == "" {
+		entry.State = QueueWaiting
+	}
+	return entry, q.Update(entry)
+}
+
+// Update the given entry in the queue.
+func (q *Queue) Update(entry QueueEntry) error {
+	entry.Updated = time.Now()
+	err := q.log.Append(entry)
+	if err != nil {
+		return fmt.Errorf("cannot write to queue: %w", err)
+	}
+	return nil
+}
+
+// Entries returns all entries of the queue in the order they were added.
+func (q *Queue) Entries() ([]QueueEntry, error) {
+	result, err := q.log.Load()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read queue: %w", err)
+	}
+	return result, nil
+}
+
+// Entry returns the entry with the given ID.
+func (q *Queue) Entry(id string) (QueueEntry, bool, error) {
+	result, ok, err := q.log.Get(id)
+	if err != nil {
+		return QueueEntry{}, false, fmt.Errorf("cannot read queue: %w", err)
+	}
+	return result, ok, nil
+}
+
+// Cancel the entry with the given ID.
+func (q *Queue) Cancel(id string) (QueueEntry, error) {
+	var found bool
+	entry, _, err := q.log.Modify(id, func(entry QueueEntry, ok bool) (QueueEntry, bool) {
+		found = ok
+		if !ok || !entry.State.Active() {
+			return entry, false
+		}
+		entry.State = QueueCanceled
+		entry.Updated = time.Now()
+		return entry, true
+	})
+	if err != nil {
+		return QueueEntry{}, fmt.Errorf("cannot write to queue: %w", err)
+	}
+	if !found {
+		return QueueEntry{}, fmt.Errorf("no message %s in the queue", id)
+	}
+	if entry.State != QueueCanceled {
+		return entry, fmt.Errorf("the message %s is already %s", id, entry.State)
+	}
+	return entry, nil
+}
+
+// replace the stored entry with the given entry, unless it was changed since the given previous snapshot was read,
+// e.g. because another process canceled or claimed it in the meantime. It returns the stored entry and if it was
+// replaced.
+func (q *Queue) replace(previous QueueEntry, entry QueueEntry) (QueueEntry, bool, error) {
+	entry.Updated = time.Now()
+	result, replaced, err := q.log.Modify(entry.ID, func(stored QueueEntry, ok bool) (QueueEntry, bool) {
+		if !ok || stored.State != previous.State || stored.Owner != previous.Owner || !stored.Updated.Equal(previous.Updated) {
+			return stored, false
+		}
+		return entry, true
+	})
+	if err != nil {
+		return QueueEntry{}, false, fmt.Errorf("cannot write to queue: %w", err)
+	}
+	return result, replaced, nil
+}
+
+// claim the given entry for sending. Other processes do not send a claimed message, unless the claim is older than
+// the sending lease. It returns the claimed entry, or false if the entry was changed or claimed by another process.
+func (q *Queue) claim(entry QueueEntry) (QueueEntry, bool, error) {
+	claimed := entry
+	claimed.State = QueueSending
+	claimed.Owner = q.owner
+	return q.replace(entry, claimed)
+}
+
+// Run processes the queue with the given sender until the context is done. The queue is checked in the given interval.
+// Each attempt to send a message may take the given send timeout.
+func (q *Queue) Run(ctx context.Context, sender *Sender, interval time.Duration, sendTimeout time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		err := q.Process(ctx, sender, sendTimeout)
+		if err != nil {
+			log.Printf("cannot process the queue: %v", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Process sends all messages in the queue that are due and checks the delivery state of the sent messages.
+// The delivery state is taken from the sender's outbox, or from the sender itself, if it has no outbox.
+func (q *Queue) Process(ctx context.Context, sender *Sender, sendTimeout time.Duration) error {
+	entries, err := q.Entries()
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			return nil
+		}
+		now := time.Now()
+		previous := entry
+		switch entry.State {
+		case QueueWaiting, QueueSending:
+			if entry.State == QueueSending && now.Sub(entry.Updated) < sendingLease {
+				continue
+			}
+			if !entry.Expires.IsZero() && now.After(entry.Expires) {
+				entry.State = QueueExpired
+				entry.Owner = ""
+				break
+			}
+			if now.Before(entry.NextAttempt) {
+				continue
+			}
+			claimed, ok, err := q.claim(entry)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+			previous = claimed
+			sendCtx, cancel := context.WithTimeout(ctx, sendTimeout)
+			delivery, err := sender.SendText(sendCtx, entry.TextMessage())
+			cancel()
+			var messageReference sds.MessageReference
+			if err == nil {
+				messageReference = delivery.MessageReference
+			}
+			entry.Owner = ""
+			entry.Attempted(now, messageReference, err)
+			if err != nil {
+				log.Printf("cannot send queued message %s to %s (attempt #%d): %v", entry.ID, entry.Destination, entry.Attempts, err)
+			}
+		case QueueSent:
+			if !q.checkDelivery(sender, &entry, now) {
+				continue
+			}
+		default:
+			continue
+		}
+
+		// do not overwrite the changes of other processes, e.g. when the entry was canceled while it was sent
+		_, replaced, err := q.replace(previous, entry)
+		if err != nil {
+			return err
+		}
+		if !replaced {
+			log.Printf("queued message %s to %s was changed in the meantime, keeping the change", entry.ID, entry.Destination)
+		}
+	}
+	return nil
+}
+
+// checkDelivery updates the state of the given sent entry with its delivery state. It returns true if the entry changed.
+func (q *Queue) checkDelivery(sender *Sender, entry *QueueEntry, now time.Time) bool {
+	state, deliveryStatus, ok := sender.deliveryState(entry.Destination, entry.MessageReference, entry.LastAttempt)
+	switch {
+	case !ok && !entry.Expires.IsZero() && now.After(entry.Expires):
+		entry.State = QueueExpired
+	case !ok:
+		return false
+	case state == Failed && Retryable(deliveryStatus):
+		entry.Retry(now, fmt.Sprintf("message delivery failed: 0x%02x", byte(deliveryStatus)))
+		log.Printf("queued message %s to %s was not delivered (0x%02x), next attempt at %s", entry.ID, entry.Destination, byte(deliveryStatus), entry.NextAttempt.Format(time.TimeOnly))
+	case state == Failed:
+		entry.State = QueueFailed
+		entry.LastError = fmt.Sprintf("message delivery failed: 0x%02x", byte(deliveryStatus))
+	case entry.AckConsume && state == Consumed, !entry.AckConsume && state.Reached(Received):
+		entry.State = QueueDelivered
+	case !entry.Expires.IsZero() && now.After(entry.Expires):
+		entry.State = QueueExpired
+	default:
+		return false
+	}
+	return true
+}
+
+// deliveryState returns the delivery state of the message with the given destination and message reference that
+// was sent at or after the given time.
+func (s *Sender) deliveryState(destination string, messageReference int, sent time.Time) (DeliveryState, sds.DeliveryStatus, bool) {
+	if s.outbox == nil {
+		delivery, ok := s.DeliveryTo(tetra.Identity(destination), sds.MessageReference(messageReference))
+		if !ok {
+			return "", 0, false
+		}
+		state, deliveryStatus := delivery.State()
+		return state, deliveryStatus, true
+	}
+
+	entry, ok, err := s.outbox.Entry(destination, messageReference)
+	if err != nil || !ok || entry.Time.Before(sent) {
+		// the message reference may be used by a newer message already
+		return "", 0, false
+	}
+	var deliveryStatus uint64
+	if entry.DeliveryStatus != "" {
+		deliveryStatus, _ = strconv.ParseUint(entry.DeliveryStatus, 0, 8)
+	}
+	return entry.State, sds.DeliveryStatus(deliveryStatus), true
+}
@@ -0,0 +1,232 @@
+package messaging
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ftl/tetra-pei/sds"
+
+	"github.com/ftl/tetra-cli/pkg/fakepei"
+)
+
+func openTestQueue(t *testing.T) *Queue {
+	t.Helper()
+	result, err := OpenQueue(filepath.Join(t.TempDir(), "queue.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+// sendScript returns the script to send a single text message, the given function is called when the message
+// itself is sent.
+func sendScript(onSend func()) []fakepei.Exchange {
+	return []fakepei.Exchange{
+		fakepei.Expect("AT+CTSDS=12,0,0,0,1"),
+		fakepei.Expect("AT+CMGS=?", "+CMGS: (0-99999999),(0-2047)"),
+		{
+			Request: "AT+CMGS=...",
+			Match: func(request string) bool {
+				if !strings.HasPrefix(request, "AT+CMGS=") {
+					return false
+				}
+				onSend()
+				return true
+			},
+			Response: []string{"+CMGS: 0,1"},
+		},
+	}
+}
+
+func TestQueue_Process(t *testing.T) {
+	queue := openTestQueue(t)
+	entry, _ := queue.Add(QueueEntry{Destination: "1234567", Text: "hello", Encoding: sds.ISO8859_1}, time.Hour)
+	pei := fakepei.New(sendScript(func() {})...)
+	sender, err := NewSender(pei)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = queue.Process(context.Background(), sender, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := pei.Verify(); err != nil {
+		t.Error(err)
+	}
+	entry, _, _ = queue.Entry(entry.ID)
+	if entry.State != QueueDelivered || entry.Attempts != 1 {
+		t.Errorf("the message should be delivered, got %+v", entry)
+	}
+}
+
+func TestQueue_ProcessKeepsTheCancelWhileSending(t *testing.T) {
+	queue := openTestQueue(t)
+	entry, _ := queue.Add(QueueEntry{Destination: "1234567", Text: "hello", Encoding: sds.ISO8859_1, AckReceive: true}, time.Hour)
+	pei := fakepei.New(sendScript(func() {
+		// another process cancels the message while it is sent
+		_, err := queue.Cancel(entry.ID)
+		if err != nil {
+			t.Error(err)
+		}
+	})...)
+	sender, err := NewSender(pei)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = queue.Process(context.Background(), sender, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry, _, _ = queue.Entry(entry.ID)
+	if entry.State != QueueCanceled {
+		t.Errorf("the cancel must not be overwritten, got %s", entry.State)
+	}
+}
+
+func TestQueue_ProcessSkipsCanceledEntries(t *testing.T) {
+	queue := openTestQueue(t)
+	entry, _ := queue.Add(QueueEntry{Destination: "1234567", Text: "hello", Encoding: sds.ISO8859_1}, time.Hour)
+	entries, _ := queue.Entries()
+	queue.Cancel(entry.ID)
+	pei := fakepei.New()
+	sender, err := NewSender(pei)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the entries were loaded before the cancel
+	if entries[0].State != QueueWaiting {
+		t.Fatal("the entry should be waiting")
+	}
+	err = queue.Process(context.Background(), sender, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pei.Requests()) != 0 {
+		t.Errorf("the canceled message must not be sent, got %q", pei.Requests())
+	}
+}
+
+func TestQueue_ProcessSendsOnlyOnceWithSeveralQueues(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.jsonl")
+	var queues []*Queue
+	for range 2 {
+		queue, err := OpenQueue(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		queues = append(queues, queue)
+	}
+	entry, _ := queues[0].Add(QueueEntry{Destination: "1234567", Text: "hello", Encoding: sds.ISO8859_1}, time.Hour)
+
+	var sent atomic.Int32
+	var wg sync.WaitGroup
+	for _, queue := range queues {
+		pei := fakepei.New(sendScript(func() {
+			sent.Add(1)
+			time.Sleep(50 * time.Millisecond)
+		})...)
+		sender, err := NewSender(pei)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := queue.Process(context.Background(), sender, time.Second)
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if sent.Load() != 1 {
+		t.Errorf("the message should be sent once, got %d", sent.Load())
+	}
+	entry, _, _ = queues[1].Entry(entry.ID)
+	if entry.State != QueueDelivered || entry.Attempts != 1 || entry.Owner != "" {
+		t.Errorf("the message should be delivered, got %+v", entry)
+	}
+}
+
+func TestQueue_ProcessSkipsClaimedEntries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.jsonl")
+	queue, _ := OpenQueue(path)
+	other, _ := OpenQueue(path)
+	entry, _ := queue.Add(QueueEntry{Destination: "1234567", Text: "hello", Encoding: sds.ISO8859_1}, time.Hour)
+	idle := fakepei.New()
+	idleSender, err := NewSender(idle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pei := fakepei.New(sendScript(func() {
+		// the other queue is processed while the message is sent
+		stored, _, _ := other.Entry(entry.ID)
+		if stored.State != QueueSending || stored.Owner != queue.owner {
+			t.Errorf("the message should be claimed, got %+v", stored)
+		}
+		err := other.Process(context.Background(), idleSender, time.Second)
+		if err != nil {
+			t.Error(err)
+		}
+	})...)
+	sender, err := NewSender(pei)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = queue.Process(context.Background(), sender, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(idle.Requests()) != 0 {
+		t.Errorf("the claimed message must not be sent again, got %q", idle.Requests())
+	}
+	entry, _, _ = other.Entry(entry.ID)
+	if entry.State != QueueDelivered {
+		t.Errorf("the message should be delivered, got %+v", entry)
+	}
+}
+
+func TestQueue_ProcessSendsStaleClaimsAgain(t *testing.T) {
+	queue := openTestQueue(t)
+	entry, _ := queue.Add(QueueEntry{
+		Destination: "1234567",
+		Text:        "hello",
+		Encoding:    sds.ISO8859_1,
+		State:       QueueSending,
+		Owner:       "crashed",
+	}, time.Hour)
+	// the claim is older than the lease
+	entry.Updated = time.Now().Add(-2 * sendingLease)
+	queue.log.Append(entry)
+	pei := fakepei.New(sendScript(func() {})...)
+	sender, err := NewSender(pei)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = queue.Process(context.Background(), sender, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := pei.Verify(); err != nil {
+		t.Error(err)
+	}
+	entry, _, _ = queue.Entry(entry.ID)
+	if entry.State != QueueDelivered {
+		t.Errorf("the message should be delivered, got %+v", entry)
+	}
+}
@@ -0,0 +1,105 @@
+package messaging
+
+import (
+	"fmt"
+
+	"github.com/ftl/tetra-pei/sds"
+	"github.com/ftl/tetra-pei/tetra"
+)
+
+// MaxMessageReference is the highest message reference. Message references are in the range 1-255.
+const MaxMessageReference = 255
+
+// PartCount returns the number of parts that are required to send the given text message. Each part of
+// a concatenated message uses its own message reference.
+func PartCount(message TextMessage) int {
+	if message.Simple {
+		return 1
+	}
+	sdsTransfer := sds.NewTextMessageTransfer(1, message.Immediate, message.DeliveryReportRequest(), message.Encoding, message.Text)
+	_, pduBits := sdsTransfer.Encode([]byte{}, 0)
+	if pduBits <= MaxPDUBits {
+		return 1
+	}
+	return len(sds.NewConcatenatedMessageTransfer(1, message.DeliveryReportRequest(), message.Encoding, MaxPDUBits, message.Text))
+}
+
+// allocateMessageReference returns the first message reference for a message with the given number of parts
+// to the given destination. The references are handed out round-robin per destination. References of messages that
+// still wait for delivery reports are skipped. If the sender has an outbox, the outbox is used to continue with
+// the references used by previous runs and by other processes.
+func (s *Sender) allocateMessageReference(destination tetra.Identity, parts int) (sds.MessageReference, error) {
+	inFlight := make(map[int]bool)
+	markInFlight := func(messageReference int, parts int) {
+		for i := range max(parts, 1) {
+			inFlight[messageReference+i] = true
+		}
+	}
+
+	s.deliveriesLock.Lock()
+	start := s.nextReferences[destination]
+	for _, delivery := range s.deliveries {
+		if SameIdentity(string(delivery.Destination), string(destination)) && !delivery.Done() {
+			markInFlight(int(delivery.MessageReference), delivery.Parts)
+		}
+	}
+	s.deliveriesLock.Unlock()
+
+	if s.outbox != nil {
+		entries, err := s.outbox.Entries()
+		if err != nil {
+			return 0, err
+		}
+		var latest *OutboxEntry
+		for i, entry := range entries {
+			if !SameIdentity(entry.Destination, string(destination)) {
+				continue
+			}
+			if entry.Pending() {
+				markInFlight(entry.MessageReference, entry.Parts)
+			}
+			if latest == nil || entry.Time.After(latest.Time) {
+				latest = &entries[i]
+			}
+		}
+		if latest != nil {
+			start = latest.MessageReference + max(latest.Parts, 1)
+		}
+	}
+
+	result, ok := nextMessageReference(start, parts, inFlight)
+	if !ok {
+		return 0, fmt.Errorf("no free message reference for %s, all references are waiting for delivery reports", destination)
+	}
+
+	s.deliveriesLock.Lock()
+	s.nextReferences[destination] = int(result) + parts
+	s.deliveriesLock.Unlock()
+
+	return result, nil
+}
+
+// nextMessageReference returns the first block of the given number of consecutive message references, beginning
+// at the given start reference, that are not in flight. The block must not wrap around the highest message reference.
+func nextMessageReference(start int, count int, inFlight map[int]bool) (sds.MessageReference, bool) {
+	if start < 1 || start > MaxMessageReference {
+		start = 1
+	}
+	for i := range MaxMessageReference {
+		candidate := (start-1+i)%MaxMessageReference + 1
+		if candidate+count-1 > MaxMessageReference {
+			continue
+		}
+		free := true
+		for reference := candidate; reference < candidate+count; reference++ {
+			if inFlight[reference] {
+				free = false
+				break
+			}
+		}
+		if free {
+			return sds.MessageReference(candidate), true
+		}
+	}
+	return 0, false
+}
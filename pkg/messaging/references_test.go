@@ -0,0 +1,131 @@
+package messaging
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ftl/tetra-pei/sds"
+
+	"github.com/ftl/tetra-cli/pkg/fakepei"
+)
+
+func inFlightReferences(references ...int) map[int]bool {
+	result := make(map[int]bool)
+	for _, reference := range references {
+		result[reference] = true
+	}
+	return result
+}
+
+func TestNextMessageReference(t *testing.T) {
+	tests := []struct {
+		name     string
+		start    int
+		count    int
+		inFlight map[int]bool
+		expected sds.MessageReference
+	}{
+		{"start 0", 0, 1, nil, 1},
+		{"start 256", 256, 1, nil, 1},
+		{"start", 42, 1, nil, 42},
+		{"highest", 255, 1, nil, 255},
+		{"in flight", 42, 1, inFlightReferences(42, 43), 44},
+		{"block in flight", 42, 3, inFlightReferences(44), 45},
+		{"block at the end", 254, 3, nil, 1},
+		{"block wraps around", 254, 3, inFlightReferences(1, 2, 3), 4},
+		{"wrap around", 255, 1, inFlightReferences(255), 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := nextMessageReference(tt.start, tt.count, tt.inFlight)
+			if !ok || got != tt.expected {
+				t.Errorf("expected %d, got %d %t", tt.expected, got, ok)
+			}
+			if int(got)+tt.count-1 > MaxMessageReference {
+				t.Errorf("the block %d-%d crosses %d", got, int(got)+tt.count-1, MaxMessageReference)
+			}
+		})
+	}
+}
+
+func TestNextMessageReference_NeverCrossesTheHighestReference(t *testing.T) {
+	for start := range MaxMessageReference + 2 {
+		for count := 1; count <= 8; count++ {
+			got, ok := nextMessageReference(start, count, nil)
+			if !ok || got < 1 || int(got)+count-1 > MaxMessageReference {
+				t.Fatalf("start %d, count %d: invalid block at %d %t", start, count, got, ok)
+			}
+		}
+	}
+}
+
+func TestNextMessageReference_Exhausted(t *testing.T) {
+	all := make(map[int]bool)
+	for reference := 1; reference <= MaxMessageReference; reference++ {
+		all[reference] = true
+	}
+	if got, ok := nextMessageReference(1, 1, all); ok {
+		t.Errorf("all references are in flight, got %d", got)
+	}
+
+	// every other reference is free, but there is no block of two
+	everyOther := make(map[int]bool)
+	for reference := 1; reference <= MaxMessageReference; reference += 2 {
+		everyOther[reference] = true
+	}
+	if got, ok := nextMessageReference(1, 2, everyOther); ok {
+		t.Errorf("there is no free block of two references, got %d", got)
+	}
+	if got, ok := nextMessageReference(1, 1, everyOther); !ok || got != 2 {
+		t.Errorf("expected 2, got %d %t", got, ok)
+	}
+}
+
+func TestAllocateMessageReference_ContinuesWithTheOutbox(t *testing.T) {
+	outbox := openTestOutbox(t)
+	now := time.Now()
+	outbox.Register(OutboxEntry{Destination: "1234567", MessageReference: 10, Parts: 1, Time: now.Add(-time.Hour), ReceivedRequested: true})
+	outbox.Register(OutboxEntry{Destination: "1234567", MessageReference: 20, Parts: 2, Time: now.Add(-time.Minute)})
+	outbox.Register(OutboxEntry{Destination: "1234567", MessageReference: 23, Parts: 1, Time: now.Add(-2 * time.Minute), ReceivedRequested: true})
+	outbox.Register(OutboxEntry{Destination: "2345678", MessageReference: 30, Parts: 1, Time: now})
+	sender, err := NewSender(fakepei.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender.WithOutbox(outbox)
+
+	// the latest entry is 20-21, 22 is free, 23 is still pending
+	got, err := sender.allocateMessageReference("1234567", 1)
+	if err != nil || got != 22 {
+		t.Errorf("expected 22, got %d %v", got, err)
+	}
+	got, err = sender.allocateMessageReference("1234567", 2)
+	if err != nil || got != 24 {
+		t.Errorf("expected 24, got %d %v", got, err)
+	}
+
+	// the references are allocated per destination
+	got, err = sender.allocateMessageReference("2345678", 1)
+	if err != nil || got != 31 {
+		t.Errorf("expected 31, got %d %v", got, err)
+	}
+}
+
+func TestAllocateMessageReference_SkipsPendingEntries(t *testing.T) {
+	outbox := openTestOutbox(t)
+	now := time.Now()
+	outbox.Register(OutboxEntry{Destination: "1234567", MessageReference: 254, Parts: 2, Time: now})
+	outbox.Register(OutboxEntry{Destination: "1234567", MessageReference: 1, Parts: 3, Time: now.Add(-time.Hour), ConsumedRequested: true, State: Received})
+	outbox.Register(OutboxEntry{Destination: "1234567", MessageReference: 5, Parts: 1, Time: now.Add(-time.Hour), ReceivedRequested: true, State: Received})
+	sender, err := NewSender(fakepei.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender.WithOutbox(outbox)
+
+	// 1-3 wait for the consumed report, 5 is already received
+	got, err := sender.allocateMessageReference("1234567", 2)
+	if err != nil || got != 4 {
+		t.Errorf("expected 4, got %d %v", got, err)
+	}
+}
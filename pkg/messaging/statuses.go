@@ -0,0 +1,193 @@
+package messaging
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ftl/tetra-pei/sds"
+	"gopkg.in/yaml.v3"
+
+	"github.com/ftl/tetra-cli/pkg/store"
+)
+
+// DefaultStatusCatalogPath returns the default path of the status catalog.
+func DefaultStatusCatalogPath() string {
+	return filepath.Join(store.ConfigDir(), "statuses.yaml")
+}
+
+// StatusDefinition gives a name and a description to a status value.
+type StatusDefinition struct {
+	// Status is the status value as hex string, e.g. "8005".
+	Status      string   `json:"status" yaml:"status"`
+	Name        string   `json:"name" yaml:"name"`
+	Description string   `json:"description,omitempty" yaml:"description,omitempty"`
+	Aliases     []string `json:"aliases,omitempty" yaml:"aliases,omitempty"`
+
+	// Value is the parsed status value.
+	Value sds.Status `json:"-" yaml:"-"`
+}
+
+func (d StatusDefinition) hasName(name string) bool {
+	if strings.EqualFold(d.Name, name) {
+		return true
+	}
+	for _, alias := range d.Aliases {
+		if strings.EqualFold(alias, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// StatusCatalog defines the statuses that are used by an organisation. A nil status catalog is empty.
+type StatusCatalog struct {
+	Statuses []StatusDefinition `json:"statuses" yaml:"statuses"`
+}
+
+// LoadStatusCatalog reads the status catalog from the given YAML or JSON file. If the file does not exist, the
+// status catalog is empty. Example:
+//
+//	statuses:
+//	  - status: "8005"
+//	    name: on-scene
+//	    description: Arrived at the scene
+//	    aliases: ["3"]
+func LoadStatusCatalog(path string) (*StatusCatalog, error) {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &StatusCatalog{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the status catalog: %w", err)
+	}
+
+	// JSON is a subset of YAML, so both formats are read the same way.
+	result := &StatusCatalog{}
+	err = yaml.Unmarshal(content, result)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the status catalog from %s: %w", path, err)
+	}
+	err = result.validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid status catalog %s: %w", path, err)
+	}
+	return result, nil
+}
+
+func (c *StatusCatalog) validate() error {
+	values := make(map[sds.Status]string)
+	for i, definition := range c.Statuses {
+		if strings.TrimSpace(definition.Name) == "" {
+			return fmt.Errorf("the status #%d has no name", i+1)
+		}
+		value, err := ParseStatus(definition.Status)
+		if err != nil {
+			return fmt.Errorf("the status %s has an invalid value %q: %w", definition.Name, definition.Status, err)
+		}
+		if other, ok := values[value]; ok {
+			return fmt.Errorf("the statuses %s and %s have the same value %s", other, definition.Name, store.FormatStatus(value))
+		}
+		values[value] = definition.Name
+		c.Statuses[i].Value = value
+	}
+
+	names := make(map[string]string)
+	for _, definition := range c.Statuses {
+		for _, name := range append([]string{definition.Name}, definition.Aliases...) {
+			key := strings.ToLower(strings.TrimSpace(name))
+			if other, ok := names[key]; ok {
+				return fmt.Errorf("the name %q is used by the statuses %s and %s", name, other, definition.Name)
+			}
+			names[key] = definition.Name
+
+			// names are resolved before hex values, a name must not hide the value of another status
+			value, err := ParseStatus(key)
+			if other, ok := values[value]; err == nil && ok && value != definition.Value {
+				return fmt.Errorf("the name %q of the status %s is the value of the status %s", name, definition.Name, other)
+			}
+		}
+	}
+	return nil
+}
+
+// Empty indicates that the catalog does not define any status.
+func (c *StatusCatalog) Empty() bool {
+	return c == nil || len(c.Statuses) == 0
+}
+
+// Names returns the names of all statuses in the order of the catalog.
+func (c *StatusCatalog) Names() []string {
+	if c == nil {
+		return nil
+	}
+	result := make([]string, len(c.Statuses))
+	for i, definition := range c.Statuses {
+		result[i] = definition.Name
+	}
+	return result
+}
+
+// Parse returns the status with the given name or alias from the catalog, or parses the given value as hex status.
+// Names are not case sensitive.
+func (c *StatusCatalog) Parse(value string) (sds.Status, error) {
+	value = strings.TrimSpace(value)
+	if c != nil {
+		for _, definition := range c.Statuses {
+			if definition.hasName(value) {
+				return definition.Value, nil
+			}
+		}
+	}
+
+	result, err := ParseStatus(value)
+	if err == nil {
+		return result, nil
+	}
+	if c.Empty() {
+		return 0, err
+	}
+	return 0, fmt.Errorf("unknown status %q, use a hex status or one of %s", value, strings.Join(c.Names(), ", "))
+}
+
+// Lookup finds the definition of the given status.
+func (c *StatusCatalog) Lookup(status sds.Status) (StatusDefinition, bool) {
+	if c == nil {
+		return StatusDefinition{}, false
+	}
+	for _, definition := range c.Statuses {
+		if definition.Value == status {
+			return definition, true
+		}
+	}
+	return StatusDefinition{}, false
+}
+
+// Unknown indicates that the given status is not defined in the catalog. If the catalog is empty, no status is
+// unknown.
+func (c *StatusCatalog) Unknown(status sds.Status) bool {
+	if c.Empty() {
+		return false
+	}
+	_, ok := c.Lookup(status)
+	return !ok
+}
+
+// Label returns the given status as hex string with its name and description from the catalog,
+// e.g. "8005 (on-scene: Arrived at the scene)", or "8123 (unknown)" if the status is not in the catalog.
+func (c *StatusCatalog) Label(status sds.Status) string {
+	value := store.FormatStatus(status)
+	definition, ok := c.Lookup(status)
+	switch {
+	case ok && definition.Description != "":
+		return fmt.Sprintf("%s (%s: %s)", value, definition.Name, definition.Description)
+	case ok:
+		return fmt.Sprintf("%s (%s)", value, definition.Name)
+	case c.Unknown(status):
+		return fmt.Sprintf("%s (unknown)", value)
+	default:
+		return value
+	}
+}
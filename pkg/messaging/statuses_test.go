@@ -0,0 +1,67 @@
+package messaging
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStatusCatalog_Validate(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []StatusDefinition
+		err      string
+	}{
+		{"valid", []StatusDefinition{
+			{Status: "8002", Name: "available", Aliases: []string{"2", "8002"}},
+			{Status: "8005", Name: "on scene", Aliases: []string{"5"}},
+		}, ""},
+		{"same value", []StatusDefinition{
+			{Status: "8002", Name: "available"},
+			{Status: "8002", Name: "on duty"},
+		}, "the same value"},
+		{"same name", []StatusDefinition{
+			{Status: "8002", Name: "available"},
+			{Status: "8005", Name: "on scene", Aliases: []string{"Available"}},
+		}, "is used by"},
+		{"alias is the value of another status", []StatusDefinition{
+			{Status: "8002", Name: "available", Aliases: []string{"8005"}},
+			{Status: "8005", Name: "on scene"},
+		}, "is the value of the status on scene"},
+		{"name is the value of a later status", []StatusDefinition{
+			{Status: "8002", Name: "8005"},
+			{Status: "8005", Name: "on scene"},
+		}, "is the value of the status on scene"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			catalog := &StatusCatalog{Statuses: tt.statuses}
+			err := catalog.validate()
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Errorf("expected an error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestStatusCatalog_Parse(t *testing.T) {
+	catalog := &StatusCatalog{Statuses: []StatusDefinition{
+		{Status: "8002", Name: "available", Aliases: []string{"2"}},
+		{Status: "8005", Name: "on scene"},
+	}}
+	if err := catalog.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	for value, expected := range map[string]uint16{"available": 0x8002, "2": 0x8002, "On Scene": 0x8005, "8005": 0x8005, "8123": 0x8123} {
+		status, err := catalog.Parse(value)
+		if err != nil || uint16(status) != expected {
+			t.Errorf("%q: expected %04x, got %04x %v", value, expected, uint16(status), err)
+		}
+	}
+	if _, err := catalog.Parse("unknown"); err == nil {
+		t.Error("an unknown name should fail")
+	}
+}
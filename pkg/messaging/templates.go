@@ -0,0 +1,153 @@
+package messaging
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/ftl/tetra-pei/sds"
+
+	"github.com/ftl/tetra-cli/pkg/store"
+)
+
+// DefaultTemplatesPath returns the default path of the message templates file.
+func DefaultTemplatesPath() string {
+	return filepath.Join(store.ConfigDir(), "templates.json")
+}
+
+// Template is a named message text with placeholders like {{code}}, which are filled in when the message is sent.
+type Template struct {
+	Name        string            `json:"name"`
+	Text        string            `json:"text"`
+	Description string            `json:"description,omitempty"`
+	Defaults    map[string]string `json:"defaults,omitempty"`
+}
+
+// Templates are the message templates by their name.
+type Templates map[string]Template
+
+// LoadTemplates reads the message templates from the given JSON file. The file contains an object with the templates
+// by their name. Example:
+//
+//	{
+//	  "alarm": {"text": "ALARM {{code}}: {{address}}", "description": "alarm with code and address"},
+//	  "callback": {"text": "Please call {{number}}", "defaults": {"number": "112"}}
+//	}
+func LoadTemplates(path string) (Templates, error) {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return Templates{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read templates: %w", err)
+	}
+
+	var result Templates
+	err = json.Unmarshal(content, &result)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read templates from %s: %w", path, err)
+	}
+	for name, template := range result {
+		if template.Text == "" {
+			return nil, fmt.Errorf("the template %s in %s has no text", name, path)
+		}
+		template.Name = name
+		result[name] = template
+	}
+	return result, nil
+}
+
+// Names returns the names of all templates in alphabetical order.
+func (t Templates) Names() []string {
+	result := make([]string, 0, len(t))
+	for name := range t {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// Get returns the template with the given name.
+func (t Templates) Get(name string) (Template, error) {
+	template, ok := t[name]
+	if !ok && len(t) == 0 {
+		return Template{}, fmt.Errorf("unknown template %q, no templates are defined", name)
+	}
+	if !ok {
+		return Template{}, fmt.Errorf("unknown template %q, use one of %s", name, strings.Join(t.Names(), ", "))
+	}
+	return template, nil
+}
+
+var placeholderExpression = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_.-]+)\s*\}\}`)
+
+// Placeholders returns the names of all placeholders in the template in the order of their first appearance.
+func (t Template) Placeholders() []string {
+	var result []string
+	seen := make(map[string]bool)
+	for _, match := range placeholderExpression.FindAllStringSubmatch(t.Text, -1) {
+		name := match[1]
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		result = append(result, name)
+	}
+	return result
+}
+
+// Render fills the placeholders of the template with the given values. Placeholders without a value take the
+// template's default value. It is an error if a placeholder has no value at all.
+func (t Template) Render(values map[string]string) (string, error) {
+	var missing []string
+	result := placeholderExpression.ReplaceAllStringFunc(t.Text, func(placeholder string) string {
+		name := placeholderExpression.FindStringSubmatch(placeholder)[1]
+		if value, ok := values[name]; ok {
+			return value
+		}
+		if value, ok := t.Defaults[name]; ok {
+			return value
+		}
+		if !slices.Contains(missing, name) {
+			missing = append(missing, name)
+		}
+		return placeholder
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("the template %s needs a value for %s", t.Name, strings.Join(missing, ", "))
+	}
+	return result, nil
+}
+
+// Preview describes how a text message is sent: how long its PDU is and how many parts it needs.
+type Preview struct {
+	Characters int
+	Encoding   sds.TextEncoding
+	// PDUBits is the length of the message if it is sent as a single PDU.
+	PDUBits int
+	// MaxPDUBits is the maximum length of a single PDU.
+	MaxPDUBits int
+	Parts      int
+}
+
+// PreviewText computes the preview of the given text message, using the same computation as SendText.
+func PreviewText(message TextMessage) Preview {
+	result := Preview{
+		Characters: len([]rune(message.Text)),
+		Encoding:   message.Encoding,
+		MaxPDUBits: MaxPDUBits,
+		Parts:      PartCount(message),
+	}
+	if message.Simple {
+		_, result.PDUBits = sds.NewSimpleTextMessage(message.Immediate, message.Encoding, message.Text).Encode([]byte{}, 0)
+	} else {
+		_, result.PDUBits = sds.NewTextMessageTransfer(1, message.Immediate, message.DeliveryReportRequest(), message.Encoding, message.Text).Encode([]byte{}, 0)
+	}
+	return result
+}
@@ -0,0 +1,109 @@
+package messaging
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/ftl/tetra-pei/sds"
+)
+
+func TestLoadTemplates(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "templates.json")
+	os.WriteFile(path, []byte(`{
+		"alarm": {"text": "ALARM {{code}}: {{address}}", "description": "alarm with code and address"},
+		"callback": {"text": "Please call {{number}}", "defaults": {"number": "112"}}
+	}`), 0600)
+
+	templates, err := LoadTemplates(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(templates.Names(), []string{"alarm", "callback"}) {
+		t.Errorf("unexpected templates %v", templates.Names())
+	}
+	callback, err := templates.Get("callback")
+	if err != nil || callback.Name != "callback" || callback.Defaults["number"] != "112" {
+		t.Errorf("unexpected template %+v %v", callback, err)
+	}
+	if _, err := templates.Get("fire"); err == nil || !strings.Contains(err.Error(), "use one of alarm, callback") {
+		t.Errorf("the error should list the templates, got %v", err)
+	}
+
+	templates, err = LoadTemplates(filepath.Join(dir, "missing.json"))
+	if err != nil || len(templates) != 0 {
+		t.Errorf("a missing file should have no templates, got %v %v", templates, err)
+	}
+	if _, err := templates.Get("alarm"); err == nil || !strings.Contains(err.Error(), "no templates are defined") {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	for name, content := range map[string]string{
+		"invalid JSON": `{"alarm": `,
+		"no text":      `{"alarm": {"description": "alarm"}}`,
+	} {
+		os.WriteFile(path, []byte(content), 0600)
+		if _, err := LoadTemplates(path); err == nil {
+			t.Errorf("%s should be rejected", name)
+		}
+	}
+}
+
+func TestTemplate_Placeholders(t *testing.T) {
+	template := Template{Text: "{{code}} at {{ address }}, call {{number}} or {{code}}; {{not a placeholder}} {{}}"}
+
+	got := template.Placeholders()
+
+	if !slices.Equal(got, []string{"code", "address", "number"}) {
+		t.Errorf("unexpected placeholders %v", got)
+	}
+}
+
+func TestTemplate_Render(t *testing.T) {
+	template := Template{
+		Name:     "alarm",
+		Text:     "ALARM {{code}}: {{ address }}, call {{number}}",
+		Defaults: map[string]string{"number": "112", "code": "B1"},
+	}
+
+	got, err := template.Render(map[string]string{"code": "B3", "address": "Main St 1", "unused": "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "ALARM B3: Main St 1, call 112" {
+		t.Errorf("unexpected text %q", got)
+	}
+
+	got, err = template.Render(map[string]string{"address": "", "number": ""})
+	if err != nil || got != "ALARM B1: , call " {
+		t.Errorf("empty values should be used, got %q %v", got, err)
+	}
+
+	_, err = Template{Name: "alarm", Text: "{{code}} {{address}} {{code}} {{unit}}"}.Render(nil)
+	if err == nil || err.Error() != "the template alarm needs a value for code, address, unit" {
+		t.Errorf("the missing values should be reported once, got %v", err)
+	}
+}
+
+func TestPreviewText(t *testing.T) {
+	short := PreviewText(TextMessage{Text: "Straße", Encoding: sds.ISO8859_1})
+	if short.Characters != 6 || short.Parts != 1 || short.MaxPDUBits != MaxPDUBits || short.PDUBits <= 6*8 || short.PDUBits > MaxPDUBits {
+		t.Errorf("unexpected preview %+v", short)
+	}
+
+	simple := PreviewText(TextMessage{Text: "Straße", Encoding: sds.ISO8859_1, Simple: true})
+	if simple.Parts != 1 || simple.PDUBits >= short.PDUBits {
+		t.Errorf("a simple message should be shorter than %d bits, got %+v", short.PDUBits, simple)
+	}
+
+	long := PreviewText(TextMessage{Text: strings.Repeat("x", 300), Encoding: sds.ISO8859_1, AckReceive: true})
+	if long.Characters != 300 || long.Parts < 2 || long.PDUBits <= MaxPDUBits {
+		t.Errorf("a long message needs several parts, got %+v", long)
+	}
+	if long.Parts != PartCount(TextMessage{Text: strings.Repeat("x", 300), Encoding: sds.ISO8859_1, AckReceive: true}) {
+		t.Errorf("the preview must use the same computation as SendText, got %+v", long)
+	}
+}
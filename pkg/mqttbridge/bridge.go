@@ -0,0 +1,276 @@
+// Package mqttbridge connects the radio with an MQTT broker. The received events are published to topics like
+// tetra/<issi>/message and tetra/<issi>/status, commands are received on topics like tetra/command/send and their
+// results are published to tetra/command/send/result.
+//
+// The bridge reconnects automatically if the connection to the broker is lost. Events with QoS 1 or 2 are kept while
+// the bridge reconnects and published after the connection is up again.
+package mqttbridge
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/ftl/tetra-cli/pkg/events"
+)
+
+// Default parameters of the connection to the broker.
+const (
+	DefaultBroker      = "tcp://localhost:1883"
+	DefaultClientID    = "tetra-cli"
+	DefaultTopicPrefix = "tetra"
+	DefaultQoS         = 1
+)
+
+// QueueSize is the maximum number of events that wait to be published. Further events are dropped.
+const QueueSize = 100
+
+const (
+	connectRetryInterval = 5 * time.Second
+	maxReconnectInterval = time.Minute
+	publishTimeout       = 10 * time.Second
+	disconnectQuiesce    = 250 // milliseconds
+)
+
+// The topics below the topic prefix.
+const (
+	// radioTopic replaces the ISSI in the topics of events without a source, e.g. tetra/radio/ai-mode.
+	radioTopic = "radio"
+	// commandTopic is the parent of the command topics, e.g. tetra/command/send.
+	commandTopic = "command"
+	// resultTopic is appended to the command topic for the results, e.g. tetra/command/send/result.
+	resultTopic = "result"
+	// stateTopic is the retained state of the bridge: online or offline.
+	stateTopic = "bridge"
+)
+
+// Config defines the connection to the broker.
+type Config struct {
+	// Broker is the URL of the broker, e.g. tcp://localhost:1883, ssl://broker:8883 or ws://broker:9001/mqtt.
+	Broker   string
+	ClientID string
+	Username string
+	Password string
+	// TopicPrefix is the first level of all topics.
+	TopicPrefix string
+	// QoS is the quality of service for the published events and the subscribed commands: 0, 1 or 2.
+	QoS byte
+	// CleanSession discards the session at the broker when the bridge connects. Otherwise, commands with QoS 1 or 2
+	// that were sent while the bridge was not connected are delivered when the bridge connects again.
+	CleanSession bool
+}
+
+// CommandHandler executes a command with the given JSON payload. The returned value is published as result.
+type CommandHandler func(ctx context.Context, payload []byte) (any, error)
+
+// Result is published to the result topic of a command after the command was executed.
+type Result struct {
+	Command string `json:"command"`
+	// ID is copied from the "id" field of the command, so that clients can match the results to their commands.
+	ID     string `json:"id,omitempty"`
+	OK     bool   `json:"ok"`
+	Error  string `json:"error,omitempty"`
+	Result any    `json:"result,omitempty"`
+}
+
+// Bridge publishes events and executes commands received through MQTT.
+type Bridge struct {
+	config   Config
+	client   mqtt.Client
+	commands map[string]CommandHandler
+	events   chan events.Record
+	ctx      context.Context
+}
+
+// NewBridge returns a new bridge with the given configuration. Register the commands with HandleCommand before
+// connecting the bridge.
+func NewBridge(config Config) (*Bridge, error) {
+	if config.QoS > 2 {
+		return nil, fmt.Errorf("invalid QoS %d, use 0, 1 or 2", config.QoS)
+	}
+	config.TopicPrefix = strings.Trim(config.TopicPrefix, "/")
+	if config.TopicPrefix == "" || strings.ContainsAny(config.TopicPrefix, "#+") {
+		return nil, fmt.Errorf("invalid topic prefix %q", config.TopicPrefix)
+	}
+	return &Bridge{
+		config:   config,
+		commands: make(map[string]CommandHandler),
+		events:   make(chan events.Record, QueueSize),
+	}, nil
+}
+
+// HandleCommand registers the handler for the command with the given name. The command is received on the topic
+// <prefix>/command/<name>.
+func (b *Bridge) HandleCommand(name string, handler CommandHandler) {
+	b.commands[name] = handler
+}
+
+// EventTopic returns the topic of the given event: <prefix>/<source>/<type>, or <prefix>/radio/<type> for events
+// without a source.
+func (b *Bridge) EventTopic(record events.Record) string {
+	source := record.Source
+	if source == "" {
+		source = radioTopic
+	}
+	return strings.Join([]string{b.config.TopicPrefix, source, string(record.Type)}, "/")
+}
+
+// CommandTopic returns the topic of the command with the given name.
+func (b *Bridge) CommandTopic(name string) string {
+	return strings.Join([]string{b.config.TopicPrefix, commandTopic, name}, "/")
+}
+
+// ResultTopic returns the topic for the results of the command with the given name.
+func (b *Bridge) ResultTopic(name string) string {
+	return b.CommandTopic(name) + "/" + resultTopic
+}
+
+func (b *Bridge) stateTopic() string {
+	return b.config.TopicPrefix + "/" + stateTopic
+}
+
+// Run connects to the broker and publishes the events until the given context is done. If the broker is not
+// reachable, Run keeps trying to connect.
+func (b *Bridge) Run(ctx context.Context) error {
+	b.ctx = ctx
+
+	options := mqtt.NewClientOptions().
+		AddBroker(b.config.Broker).
+		SetClientID(b.config.ClientID).
+		SetUsername(b.config.Username).
+		SetPassword(b.config.Password).
+		SetCleanSession(b.config.CleanSession).
+		SetOrderMatters(false).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(connectRetryInterval).
+		SetMaxReconnectInterval(maxReconnectInterval).
+		SetWriteTimeout(publishTimeout).
+		SetBinaryWill(b.stateTopic(), []byte("offline"), b.config.QoS, true).
+		SetOnConnectHandler(b.onConnect).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			log.Printf("the connection to the MQTT broker is lost, reconnecting: %v", err)
+		})
+	b.client = mqtt.NewClient(options)
+
+	log.Printf("connecting to the MQTT broker %s", b.config.Broker)
+	token := b.client.Connect()
+	select {
+	case <-token.Done():
+		if token.Error() != nil {
+			return fmt.Errorf("cannot connect to the MQTT broker %s: %w", b.config.Broker, token.Error())
+		}
+	case <-ctx.Done():
+		b.client.Disconnect(0)
+		return nil
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			b.publishState("offline")
+			b.client.Disconnect(disconnectQuiesce)
+			return nil
+		case record := <-b.events:
+			b.publishEvent(record)
+		}
+	}
+}
+
+// Publish queues the given event for publishing. Publish never blocks; if the queue is full, the event is dropped.
+func (b *Bridge) Publish(record events.Record) {
+	select {
+	case b.events <- record:
+	default:
+		log.Printf("too many events are waiting for the MQTT broker, the %s event is dropped", record.Type)
+	}
+}
+
+func (b *Bridge) publishEvent(record events.Record) {
+	payload, err := json.Marshal(record)
+	if err != nil {
+		log.Printf("cannot marshal the %s event for MQTT: %v", record.Type, err)
+		return
+	}
+	b.publish(b.EventTopic(record), payload, false)
+}
+
+func (b *Bridge) publishState(state string) {
+	b.publish(b.stateTopic(), []byte(state), true).WaitTimeout(time.Second)
+}
+
+// publish sends the given message to the broker without waiting for the acknowledgement. While the bridge reconnects,
+// messages with QoS 1 or 2 are kept by the client and published when the connection is up again.
+func (b *Bridge) publish(topic string, payload []byte, retained bool) mqtt.Token {
+	token := b.client.Publish(topic, b.config.QoS, retained, payload)
+	go func() {
+		<-token.Done()
+		if token.Error() != nil {
+			log.Printf("cannot publish to %s: %v", topic, token.Error())
+		}
+	}()
+	return token
+}
+
+// onConnect subscribes the command topics whenever the bridge (re-)connects to the broker.
+func (b *Bridge) onConnect(client mqtt.Client) {
+	log.Printf("connected to the MQTT broker %s", b.config.Broker)
+	b.publish(b.stateTopic(), []byte("online"), true)
+	if len(b.commands) == 0 {
+		return
+	}
+
+	filters := make(map[string]byte, len(b.commands))
+	for name := range b.commands {
+		filters[b.CommandTopic(name)] = b.config.QoS
+	}
+	token := client.SubscribeMultiple(filters, b.onCommand)
+	go func() {
+		token.Wait()
+		if token.Error() != nil {
+			log.Printf("cannot subscribe the MQTT command topics: %v", token.Error())
+		}
+	}()
+}
+
+func (b *Bridge) onCommand(_ mqtt.Client, message mqtt.Message) {
+	name := strings.TrimPrefix(message.Topic(), b.CommandTopic(""))
+	handler, ok := b.commands[name]
+	if !ok {
+		return
+	}
+	if message.Retained() {
+		// retained commands would be executed again with every connect
+		log.Printf("the retained %s command is ignored, send commands without the retain flag", name)
+		return
+	}
+
+	var envelope struct {
+		ID string `json:"id"`
+	}
+	err := json.Unmarshal(message.Payload(), &envelope)
+	result := Result{Command: name, ID: envelope.ID}
+
+	if err != nil {
+		log.Printf("the %s command has an invalid payload: %v", name, err)
+		result.Error = fmt.Sprintf("invalid payload, use a JSON object: %v", err)
+	} else if value, err := handler(b.ctx, message.Payload()); err != nil {
+		log.Printf("the %s command failed: %v", name, err)
+		result.Error = err.Error()
+	} else {
+		result.OK = true
+		result.Result = value
+	}
+
+	payload, err := json.Marshal(result)
+	if err != nil {
+		log.Printf("cannot marshal the result of the %s command: %v", name, err)
+		return
+	}
+	b.publish(b.ResultTopic(name), payload, false)
+}
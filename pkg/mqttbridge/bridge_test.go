@@ -0,0 +1,405 @@
+package mqttbridge
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+
+	"github.com/ftl/tetra-cli/pkg/events"
+)
+
+// testBroker is a minimal MQTT broker that accepts all clients, records the published messages and sends messages
+// to the subscribers. It supports QoS 0 and 1.
+type testBroker struct {
+	t        *testing.T
+	listener net.Listener
+
+	lock          sync.Mutex
+	connections   map[net.Conn]bool
+	subscriptions map[net.Conn][]string
+	published     chan *packets.PublishPacket
+	connects      int
+}
+
+func newTestBroker(t *testing.T) *testBroker {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := &testBroker{
+		t:             t,
+		listener:      listener,
+		connections:   make(map[net.Conn]bool),
+		subscriptions: make(map[net.Conn][]string),
+		published:     make(chan *packets.PublishPacket, 100),
+	}
+	go result.accept()
+	t.Cleanup(func() {
+		listener.Close()
+		result.disconnectAll()
+	})
+	return result
+}
+
+func (b *testBroker) url() string {
+	return "tcp://" + b.listener.Addr().String()
+}
+
+func (b *testBroker) accept() {
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+		b.lock.Lock()
+		b.connections[conn] = true
+		b.lock.Unlock()
+		go b.serve(conn)
+	}
+}
+
+func (b *testBroker) serve(conn net.Conn) {
+	defer b.disconnect(conn)
+	for {
+		packet, err := packets.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		var response packets.ControlPacket
+		switch p := packet.(type) {
+		case *packets.ConnectPacket:
+			b.lock.Lock()
+			b.connects++
+			b.lock.Unlock()
+			response = packets.NewControlPacket(packets.Connack)
+		case *packets.SubscribePacket:
+			b.lock.Lock()
+			b.subscriptions[conn] = append(b.subscriptions[conn], p.Topics...)
+			b.lock.Unlock()
+			suback := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
+			suback.MessageID = p.MessageID
+			suback.ReturnCodes = make([]byte, len(p.Topics))
+			response = suback
+		case *packets.PublishPacket:
+			b.published <- p
+			if p.Qos > 0 {
+				puback := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+				puback.MessageID = p.MessageID
+				response = puback
+			}
+		case *packets.PingreqPacket:
+			response = packets.NewControlPacket(packets.Pingresp)
+		case *packets.DisconnectPacket:
+			return
+		}
+		if response != nil {
+			b.lock.Lock()
+			err = response.Write(conn)
+			b.lock.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (b *testBroker) disconnect(conn net.Conn) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	conn.Close()
+	delete(b.connections, conn)
+	delete(b.subscriptions, conn)
+}
+
+// disconnectAll closes the connections of all clients, like a broker that restarts.
+func (b *testBroker) disconnectAll() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for conn := range b.connections {
+		conn.Close()
+		delete(b.connections, conn)
+		delete(b.subscriptions, conn)
+	}
+}
+
+// send the given message with QoS 0 to all clients that subscribed the topic. It returns the number of receivers.
+func (b *testBroker) send(topic string, payload string, retained bool) int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	receivers := 0
+	for conn, topics := range b.subscriptions {
+		for _, subscribed := range topics {
+			if subscribed != topic {
+				continue
+			}
+			publish := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+			publish.TopicName = topic
+			publish.Payload = []byte(payload)
+			publish.Retain = retained
+			if publish.Write(conn) == nil {
+				receivers++
+			}
+		}
+	}
+	return receivers
+}
+
+// waitForSubscription waits until a client subscribed the given topic.
+func (b *testBroker) waitForSubscription(topic string) {
+	b.t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		b.lock.Lock()
+		for _, topics := range b.subscriptions {
+			for _, subscribed := range topics {
+				if subscribed == topic {
+					b.lock.Unlock()
+					return
+				}
+			}
+		}
+		b.lock.Unlock()
+		time.Sleep(10 * time.Millisecond)
+	}
+	b.t.Fatalf("%s was not subscribed", topic)
+}
+
+// expect waits for a message on the given topic and returns its payload. Messages on other topics are skipped.
+func (b *testBroker) expect(topic string) string {
+	b.t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case p := <-b.published:
+			if p.TopicName == topic {
+				return string(p.Payload)
+			}
+		case <-timeout:
+			b.t.Fatalf("nothing was published to %s", topic)
+			return ""
+		}
+	}
+}
+
+// expectNothing fails if a message is published to the given topic within a short time.
+func (b *testBroker) expectNothing(topic string) {
+	b.t.Helper()
+	timeout := time.After(200 * time.Millisecond)
+	for {
+		select {
+		case p := <-b.published:
+			if p.TopicName == topic {
+				b.t.Errorf("unexpected message on %s: %s", topic, p.Payload)
+			}
+		case <-timeout:
+			return
+		}
+	}
+}
+
+func runTestBridge(t *testing.T, broker *testBroker, commands map[string]CommandHandler) *Bridge {
+	t.Helper()
+	bridge, err := NewBridge(Config{
+		Broker:      broker.url(),
+		ClientID:    "test",
+		TopicPrefix: "tetra",
+		QoS:         1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, handler := range commands {
+		bridge.HandleCommand(name, handler)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := bridge.Run(ctx); err != nil {
+			t.Error(err)
+		}
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return bridge
+}
+
+func echo(ctx context.Context, payload []byte) (any, error) {
+	var command struct {
+		Text string `json:"text"`
+	}
+	json.Unmarshal(payload, &command)
+	if command.Text == "" {
+		return nil, fmt.Errorf("the text is missing")
+	}
+	return command.Text, nil
+}
+
+func parseResult(t *testing.T, payload string) Result {
+	t.Helper()
+	var result Result
+	err := json.Unmarshal([]byte(payload), &result)
+	if err != nil {
+		t.Fatalf("invalid result %q: %v", payload, err)
+	}
+	return result
+}
+
+func TestNewBridge(t *testing.T) {
+	bridge, err := NewBridge(Config{TopicPrefix: "/site/tetra/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bridge.config.TopicPrefix != "site/tetra" {
+		t.Errorf("the slashes should be trimmed from the prefix, got %q", bridge.config.TopicPrefix)
+	}
+
+	for _, prefix := range []string{"", "/", "tetra/#", "tetra/+/x"} {
+		if _, err := NewBridge(Config{TopicPrefix: prefix}); err == nil {
+			t.Errorf("the prefix %q should be rejected", prefix)
+		}
+	}
+	if _, err := NewBridge(Config{TopicPrefix: "tetra", QoS: 3}); err == nil {
+		t.Error("QoS 3 should be rejected")
+	}
+}
+
+func TestBridge_Topics(t *testing.T) {
+	bridge, _ := NewBridge(Config{TopicPrefix: "tetra"})
+
+	tests := []struct {
+		record events.Record
+		topic  string
+	}{
+		{events.NewRecord(events.TextMessage{Source: "1234567"}), "tetra/1234567/message"},
+		{events.NewRecord(events.StatusMessage{Source: "1234567"}), "tetra/1234567/status"},
+		{events.NewRecord(events.AIModeChanged{}), "tetra/radio/ai-mode"},
+		{events.NewRecord(events.TalkgroupIdle{}), "tetra/radio/talkgroup-idle"},
+	}
+	for _, tt := range tests {
+		if got := bridge.EventTopic(tt.record); got != tt.topic {
+			t.Errorf("expected %s, got %s", tt.topic, got)
+		}
+	}
+	if got := bridge.CommandTopic("send"); got != "tetra/command/send" {
+		t.Errorf("unexpected command topic %s", got)
+	}
+	if got := bridge.ResultTopic("send"); got != "tetra/command/send/result" {
+		t.Errorf("unexpected result topic %s", got)
+	}
+}
+
+func TestBridge_PublishesEvents(t *testing.T) {
+	broker := newTestBroker(t)
+	bridge := runTestBridge(t, broker, nil)
+	if state := broker.expect("tetra/bridge"); state != "online" {
+		t.Errorf("unexpected state %q", state)
+	}
+
+	bridge.Publish(events.NewRecord(events.StatusMessage{Time: time.Now(), Source: "1234567", Status: 0x8005}))
+
+	payload := broker.expect("tetra/1234567/status")
+	if !strings.Contains(payload, `"status":"8005"`) {
+		t.Errorf("unexpected payload %s", payload)
+	}
+}
+
+func TestBridge_ExecutesCommands(t *testing.T) {
+	broker := newTestBroker(t)
+	runTestBridge(t, broker, map[string]CommandHandler{"echo": echo})
+	broker.waitForSubscription("tetra/command/echo")
+
+	broker.send("tetra/command/echo", `{"id": "1", "text": "hello"}`, false)
+	result := parseResult(t, broker.expect("tetra/command/echo/result"))
+	if !result.OK || result.ID != "1" || result.Command != "echo" || result.Result != "hello" {
+		t.Errorf("unexpected result %+v", result)
+	}
+
+	broker.send("tetra/command/echo", `{"id": "2"}`, false)
+	result = parseResult(t, broker.expect("tetra/command/echo/result"))
+	if result.OK || result.ID != "2" || result.Error != "the text is missing" {
+		t.Errorf("unexpected result %+v", result)
+	}
+}
+
+func TestBridge_ReportsInvalidPayloads(t *testing.T) {
+	broker := newTestBroker(t)
+	called := make(chan bool, 1)
+	runTestBridge(t, broker, map[string]CommandHandler{"echo": func(ctx context.Context, payload []byte) (any, error) {
+		called <- true
+		return nil, nil
+	}})
+	broker.waitForSubscription("tetra/command/echo")
+
+	broker.send("tetra/command/echo", `hello`, false)
+
+	result := parseResult(t, broker.expect("tetra/command/echo/result"))
+	if result.OK || !strings.HasPrefix(result.Error, "invalid payload") {
+		t.Errorf("unexpected result %+v", result)
+	}
+	select {
+	case <-called:
+		t.Error("the handler must not be called with an invalid payload")
+	default:
+	}
+}
+
+func TestBridge_IgnoresRetainedCommands(t *testing.T) {
+	broker := newTestBroker(t)
+	called := make(chan bool, 1)
+	runTestBridge(t, broker, map[string]CommandHandler{"echo": func(ctx context.Context, payload []byte) (any, error) {
+		called <- true
+		return nil, nil
+	}})
+	broker.waitForSubscription("tetra/command/echo")
+
+	broker.send("tetra/command/echo", `{"text": "hello"}`, true)
+
+	broker.expectNothing("tetra/command/echo/result")
+	select {
+	case <-called:
+		t.Error("the retained command must not be executed")
+	default:
+	}
+}
+
+func TestBridge_Reconnects(t *testing.T) {
+	broker := newTestBroker(t)
+	bridge := runTestBridge(t, broker, map[string]CommandHandler{"echo": echo})
+	broker.waitForSubscription("tetra/command/echo")
+	broker.expect("tetra/bridge")
+
+	broker.disconnectAll()
+
+	// the bridge connects again and subscribes the commands again
+	if state := broker.expect("tetra/bridge"); state != "online" {
+		t.Errorf("unexpected state %q", state)
+	}
+	broker.waitForSubscription("tetra/command/echo")
+	broker.send("tetra/command/echo", `{"id": "3", "text": "again"}`, false)
+	result := parseResult(t, broker.expect("tetra/command/echo/result"))
+	if !result.OK || result.ID != "3" {
+		t.Errorf("unexpected result %+v", result)
+	}
+
+	bridge.Publish(events.NewRecord(events.AIModeChanged{Time: time.Now()}))
+	broker.expect("tetra/radio/ai-mode")
+
+	broker.lock.Lock()
+	connects := broker.connects
+	broker.lock.Unlock()
+	if connects != 2 {
+		t.Errorf("expected 2 connects, got %d", connects)
+	}
+}
@@ -2,7 +2,10 @@ package radio
 
 import (
 	"context"
-	"io"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 )
@@ -33,50 +36,254 @@ func (f InitializerFunc) Initialize(ctx context.Context, pei PEI) error {
 // The LoopFunc must terminate when ctx.Done() is closed.
 type LoopFunc func(context.Context, PEI)
 
+// A ConnectFunc opens a new connection to a PEI device.
+type ConnectFunc func(context.Context) (PEI, error)
+
+// ErrDisconnected is returned for requests while the radio is not connected to a PEI device.
+var ErrDisconnected = errors.New("radio disconnected")
+
+// ErrClosed is returned if a PEI device is attached to a radio that is already closed.
+var ErrClosed = errors.New("radio closed")
+
+const (
+	defaultInitialBackoff    = 1 * time.Second
+	defaultMaxBackoff        = 1 * time.Minute
+	defaultInitializeTimeout = 30 * time.Second
+	shutdownTimeout          = 5 * time.Second
+)
+
+// ReconnectConfig defines if and how a radio reconnects to the PEI device after the connection was lost.
+type ReconnectConfig struct {
+	// Enabled defines if the radio tries to reconnect at all.
+	Enabled bool
+	// InitialBackoff is the time to wait before the first reconnect attempt. The backoff is doubled with every failed attempt.
+	InitialBackoff time.Duration
+	// MaxBackoff is the maximum time to wait between two reconnect attempts.
+	MaxBackoff time.Duration
+	// MaxAttempts is the maximum number of reconnect attempts, 0 means no limit.
+	MaxAttempts int
+	// InitializeTimeout is the maximum duration for initializing the radio after reconnecting.
+	InitializeTimeout time.Duration
+	// OnEvent is invoked for every connection related event.
+	OnEvent func(Event)
+}
+
+func (c ReconnectConfig) backoff(attempt int) time.Duration {
+	initial := c.InitialBackoff
+	if initial <= 0 {
+		initial = defaultInitialBackoff
+	}
+	maxBackoff := c.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = defaultMaxBackoff
+	}
+
+	result := initial
+	for i := 1; i < attempt && result < maxBackoff; i++ {
+		result *= 2
+	}
+	return min(result, maxBackoff)
+}
+
+// EventKind defines the kind of a connection related event.
+type EventKind int
+
+// All kinds of connection related events.
+const (
+	Disconnected EventKind = iota
+	Reconnecting
+	Reconnected
+	ReconnectFailed
+	GaveUp
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case Disconnected:
+		return "disconnected"
+	case Reconnecting:
+		return "reconnecting"
+	case Reconnected:
+		return "reconnected"
+	case ReconnectFailed:
+		return "reconnect failed"
+	case GaveUp:
+		return "gave up reconnecting"
+	default:
+		return "unknown"
+	}
+}
+
+// Event reports a change of the connection between the radio and the PEI device.
+type Event struct {
+	Kind    EventKind
+	Attempt int
+	Err     error
+}
+
+func (e Event) String() string {
+	switch {
+	case e.Err != nil:
+		return fmt.Sprintf("radio %s (attempt #%d): %v", e.Kind, e.Attempt, e.Err)
+	case e.Attempt > 0:
+		return fmt.Sprintf("radio %s (attempt #%d)", e.Kind, e.Attempt)
+	default:
+		return fmt.Sprintf("radio %s", e.Kind)
+	}
+}
+
+type indicationRegistration struct {
+	prefix        string
+	trailingLines int
+	handlers      []indicationHandler
+}
+
+type indicationHandler struct {
+	id          int
+	handler     func(lines []string)
+	initializer bool
+}
+
+// initializerPEI is passed to the initializer. It registers the indications of the initializer at the radio,
+// so that they are dispatched together with the handlers registered through Subscribe.
+type initializerPEI struct {
+	PEI
+	radio *Radio
+}
+
+func (p *initializerPEI) AddIndication(prefix string, trailingLines int, handler func(lines []string)) error {
+	_, err := p.radio.subscribe(prefix, trailingLines, handler, true)
+	return err
+}
+
 // Radio provides access to a PEI device on a higher level of abstration.
 // It allows to define a custom initializer for the PEI device.
 // It allows to run a loop function in context of the PEI device.
+// If it is connected through a ConnectFunc, it can reconnect to the PEI device after the connection was lost.
+// Unlike a plain PEI, it allows to register several handlers for the same indication.
 // It also implements the PEI interface.
 type Radio struct {
-	pei            PEI
-	tracePEIWriter io.Writer
+	lock               sync.RWMutex
+	pei                PEI
+	ownsPEI            bool
+	connect            ConnectFunc
+	initializer        Initializer
+	reconnect          ReconnectConfig
+	indications        map[string]*indicationRegistration
+	nextHandlerID      int
+	loops              []LoopFunc
+	disconnectCallback func()
+	closing            bool
+
+	closeCtx    context.Context
+	closeCancel context.CancelFunc
+	done        chan struct{}
 
 	loopCtx    context.Context
 	loopCancel context.CancelFunc
 	loopGroup  *sync.WaitGroup
-
-	scanInterval time.Duration
-	scanTimeout  time.Duration
 }
 
 // Open the radio using the given PEI instance. The optionally given initializer is invoked
 // to initialize the radio.
 func Open(ctx context.Context, pei PEI, initializer Initializer) (*Radio, error) {
-	loopCtx, loopCancel := context.WithCancel(context.Background())
+	result := newRadio(initializer, ReconnectConfig{})
+	err := result.attach(ctx, pei)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
 
-	result := &Radio{
-		pei: pei,
+// Connect the radio to a PEI device using the given ConnectFunc. The optionally given initializer is invoked
+// to initialize the radio. If reconnecting is enabled in the given configuration, the radio reconnects and
+// initializes the PEI device again after the connection was lost. The radio closes the connection to the PEI device
+// when it is closed.
+func Connect(ctx context.Context, connect ConnectFunc, initializer Initializer, config ReconnectConfig) (*Radio, error) {
+	result := newRadio(initializer, config)
+	result.connect = connect
+	result.ownsPEI = true
 
-		loopCtx:    loopCtx,
-		loopCancel: loopCancel,
-		loopGroup:  new(sync.WaitGroup),
+	pei, err := connect(ctx)
+	if err != nil {
+		return nil, err
 	}
-	err := result.initialize(ctx, initializer)
+	err = result.attach(ctx, pei)
 	if err != nil {
+		pei.Close()
 		return nil, err
 	}
 
 	return result, nil
 }
 
-func (r *Radio) initialize(ctx context.Context, initializer Initializer) error {
-	err := r.pei.ClearSyntaxErrors(ctx)
+func newRadio(initializer Initializer, config ReconnectConfig) *Radio {
+	closeCtx, closeCancel := context.WithCancel(context.Background())
+	return &Radio{
+		initializer: initializer,
+		reconnect:   config,
+		indications: make(map[string]*indicationRegistration),
+		closeCtx:    closeCtx,
+		closeCancel: closeCancel,
+		done:        make(chan struct{}),
+		loopGroup:   new(sync.WaitGroup),
+	}
+}
+
+// attach the given PEI to this radio, initialize it and restore the indications and loops. If the radio was closed
+// in the meantime, the PEI is not attached and ErrClosed is returned. The PEI's own disconnect callback is left
+// untouched, the radio watches the PEI until it is closed instead.
+func (r *Radio) attach(ctx context.Context, pei PEI) error {
+	r.removeInitializerIndications()
+	err := r.initialize(ctx, pei)
+	if err != nil {
+		return err
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.closing {
+		return ErrClosed
+	}
+	for key, registration := range r.indications {
+		if len(registration.handlers) == 0 {
+			delete(r.indications, key)
+			continue
+		}
+		err := pei.AddIndication(registration.prefix, registration.trailingLines, r.dispatcher(registration.prefix))
+		if err != nil {
+			return fmt.Errorf("cannot restore indication %s: %w", registration.prefix, err)
+		}
+	}
+
+	r.pei = pei
+	r.loopCtx, r.loopCancel = context.WithCancel(r.closeCtx)
+	for _, loop := range r.loops {
+		r.startLoop(loop)
+	}
+	go r.watch(pei)
+
+	return nil
+}
+
+// watch waits until the given PEI is closed and handles the lost connection.
+func (r *Radio) watch(pei PEI) {
+	pei.WaitUntilClosed(r.closeCtx)
+	if pei.Closed() {
+		r.disconnected(pei)
+	}
+}
+
+func (r *Radio) initialize(ctx context.Context, pei PEI) error {
+	err := pei.ClearSyntaxErrors(ctx)
 	if err != nil {
 		return err
 	}
 
 	// initialize the PEI
-	err = r.pei.ATs(ctx,
+	err = pei.ATs(ctx,
 		"ATZ",
 		"ATE0",
 		"AT+CSCS=8859-1",
@@ -85,74 +292,306 @@ func (r *Radio) initialize(ctx context.Context, initializer Initializer) error {
 		return err
 	}
 
-	if initializer == nil {
+	if r.initializer == nil {
 		return nil
 	}
-	return initializer.Initialize(ctx, r.pei)
+	return r.initializer.Initialize(ctx, &initializerPEI{PEI: pei, radio: r})
+}
+
+// removeInitializerIndications removes all indication handlers that were registered by the initializer.
+// The initializer registers them again when it is invoked.
+func (r *Radio) removeInitializerIndications() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for _, registration := range r.indications {
+		registration.handlers = slices.DeleteFunc(registration.handlers, func(h indicationHandler) bool {
+			return h.initializer
+		})
+	}
+}
+
+func (r *Radio) disconnected(pei PEI) {
+	r.lock.Lock()
+	if r.closing || r.pei != pei {
+		r.lock.Unlock()
+		return
+	}
+	r.loopCancel()
+	callback := r.disconnectCallback
+	reconnect := r.reconnect.Enabled && r.connect != nil
+	r.lock.Unlock()
+
+	r.loopGroup.Wait()
+	r.emit(Event{Kind: Disconnected})
+	if callback != nil {
+		callback()
+	}
+
+	if !reconnect {
+		r.finish()
+		return
+	}
+	go r.reconnectLoop()
+}
+
+func (r *Radio) reconnectLoop() {
+	for attempt := 1; r.reconnect.MaxAttempts == 0 || attempt <= r.reconnect.MaxAttempts; attempt++ {
+		select {
+		case <-r.closeCtx.Done():
+			return
+		case <-time.After(r.reconnect.backoff(attempt)):
+		}
+
+		r.emit(Event{Kind: Reconnecting, Attempt: attempt})
+		err := r.reconnectOnce()
+		if err == nil {
+			r.emit(Event{Kind: Reconnected, Attempt: attempt})
+			return
+		}
+		if r.closeCtx.Err() != nil {
+			return
+		}
+		r.emit(Event{Kind: ReconnectFailed, Attempt: attempt, Err: err})
+	}
+
+	r.emit(Event{Kind: GaveUp, Attempt: r.reconnect.MaxAttempts})
+	r.finish()
+}
+
+func (r *Radio) reconnectOnce() error {
+	timeout := r.reconnect.InitializeTimeout
+	if timeout <= 0 {
+		timeout = defaultInitializeTimeout
+	}
+	ctx, cancel := context.WithTimeout(r.closeCtx, timeout)
+	defer cancel()
+
+	pei, err := r.connect(ctx)
+	if err != nil {
+		return err
+	}
+	err = r.attach(ctx, pei)
+	if err != nil {
+		pei.Close()
+		return err
+	}
+	return nil
+}
+
+func (r *Radio) emit(event Event) {
+	if r.reconnect.OnEvent != nil {
+		r.reconnect.OnEvent(event)
+	}
+}
+
+// finish marks this radio as closed.
+func (r *Radio) finish() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	select {
+	case <-r.done:
+	default:
+		close(r.done)
+	}
+}
+
+func (r *Radio) currentPEI() (PEI, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	if r.pei == nil || r.pei.Closed() {
+		return nil, ErrDisconnected
+	}
+	return r.pei, nil
 }
 
 // Connected indicates if this radio is currently connected to a PEI device.
 func (r *Radio) Connected() bool {
-	return r.pei != nil && !r.pei.Closed()
+	_, err := r.currentPEI()
+	return err == nil
 }
 
 // Close the connection to the radio. All running loops are terminated before the connection
 // to the radio is shut down.
 func (r *Radio) Close() {
-	if !r.Connected() {
+	r.lock.Lock()
+	if r.closing {
+		r.lock.Unlock()
 		return
 	}
+	r.closing = true
+	pei := r.pei
+	r.lock.Unlock()
 
-	// stop the running loops and wait until they are stopped
-	r.loopCancel()
+	// stop reconnecting and the running loops and wait until they are stopped
+	r.closeCancel()
 	r.loopGroup.Wait()
+	defer r.finish()
+
+	if pei == nil || pei.Closed() {
+		return
+	}
 
 	// reset the PEI to defaults
-	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), 5*time.Second)
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), shutdownTimeout)
 	defer cancelShutdown()
-	r.pei.AT(shutdownCtx, "ATZ")
+	pei.AT(shutdownCtx, "ATZ")
+
+	if r.ownsPEI {
+		pei.Close()
+		pei.WaitUntilClosed(shutdownCtx)
+	}
 }
 
+// Closed indicates if this radio is closed. A radio that is reconnecting is not closed.
 func (r *Radio) Closed() bool {
-	return r.pei.Closed()
+	select {
+	case <-r.done:
+		return true
+	default:
+		return false
+	}
 }
 
+// WaitUntilClosed waits until this radio is closed or the given context is done.
 func (r *Radio) WaitUntilClosed(ctx context.Context) {
-	r.pei.WaitUntilClosed(ctx)
+	select {
+	case <-r.done:
+	case <-ctx.Done():
+	}
 }
 
+// OnDisconnect sets a callback that is invoked each time the connection to the PEI device is lost.
 func (r *Radio) OnDisconnect(callback func()) {
-	r.pei.OnDisconnect(callback)
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.disconnectCallback = callback
 }
 
+// AddIndication registers the given handler at the connected PEI device. Several handlers can be registered
+// for the same prefix, but all registrations for one prefix must use the same number of trailing lines.
+// The registration is restored after reconnecting.
 func (r *Radio) AddIndication(prefix string, trailingLines int, handler func(lines []string)) error {
-	return r.pei.AddIndication(prefix, trailingLines, handler)
+	_, err := r.Subscribe(prefix, trailingLines, handler)
+	return err
+}
+
+// Subscribe works like AddIndication, but additionally returns a function to remove the given handler again.
+func (r *Radio) Subscribe(prefix string, trailingLines int, handler func(lines []string)) (func(), error) {
+	return r.subscribe(prefix, trailingLines, handler, false)
+}
+
+// subscribe registers the given handler. Handlers of the initializer are registered at the PEI device
+// when the PEI device is attached after the initialization.
+func (r *Radio) subscribe(prefix string, trailingLines int, handler func(lines []string), initializer bool) (func(), error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	key := strings.ToUpper(prefix)
+	registration, ok := r.indications[key]
+	if ok && registration.trailingLines != trailingLines {
+		return nil, fmt.Errorf("indication %s is already registered with %d trailing lines", prefix, registration.trailingLines)
+	}
+	if !ok {
+		registration = &indicationRegistration{
+			prefix:        prefix,
+			trailingLines: trailingLines,
+		}
+		r.indications[key] = registration
+		if r.pei != nil && !initializer {
+			err := r.pei.AddIndication(prefix, trailingLines, r.dispatcher(key))
+			if err != nil {
+				delete(r.indications, key)
+				return nil, err
+			}
+		}
+	}
+
+	id := r.nextHandlerID
+	r.nextHandlerID++
+	registration.handlers = append(registration.handlers, indicationHandler{id: id, handler: handler, initializer: initializer})
+
+	unsubscribe := func() {
+		r.lock.Lock()
+		defer r.lock.Unlock()
+
+		registration.handlers = slices.DeleteFunc(registration.handlers, func(h indicationHandler) bool {
+			return h.id == id
+		})
+	}
+	return unsubscribe, nil
+}
+
+func (r *Radio) dispatcher(key string) func([]string) {
+	return func(lines []string) {
+		r.lock.RLock()
+		registration, ok := r.indications[strings.ToUpper(key)]
+		var handlers []indicationHandler
+		if ok {
+			handlers = slices.Clone(registration.handlers)
+		}
+		r.lock.RUnlock()
+
+		for _, h := range handlers {
+			h.handler(lines)
+		}
+	}
 }
 
 func (r *Radio) ClearSyntaxErrors(ctx context.Context) error {
-	return r.pei.ClearSyntaxErrors(ctx)
+	pei, err := r.currentPEI()
+	if err != nil {
+		return err
+	}
+	return pei.ClearSyntaxErrors(ctx)
 }
 
 func (r *Radio) Request(ctx context.Context, request string) ([]string, error) {
-	return r.Request(ctx, request)
+	pei, err := r.currentPEI()
+	if err != nil {
+		return nil, err
+	}
+	return pei.Request(ctx, request)
 }
 
 func (r *Radio) AT(ctx context.Context, request string) ([]string, error) {
-	return r.AT(ctx, request)
+	pei, err := r.currentPEI()
+	if err != nil {
+		return nil, err
+	}
+	return pei.AT(ctx, request)
 }
 
 func (r *Radio) ATs(ctx context.Context, requests ...string) error {
-	return r.ATs(ctx, requests...)
+	pei, err := r.currentPEI()
+	if err != nil {
+		return err
+	}
+	return pei.ATs(ctx, requests...)
 }
 
 // RunLoop executeds the given loop function in a separate goroutine while this radio is
-// connected to a PEI device.
+// connected to a PEI device. After reconnecting, the loop function is started again.
 func (r *Radio) RunLoop(loop LoopFunc) {
-	if !r.Connected() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.closing {
+		return
+	}
+	r.loops = append(r.loops, loop)
+	if r.pei == nil || r.pei.Closed() {
 		return
 	}
+	r.startLoop(loop)
+}
 
+func (r *Radio) startLoop(loop LoopFunc) {
+	ctx := r.loopCtx
+	pei := r.pei
 	r.loopGroup.Go(func() {
-		loop(r.loopCtx, r.pei)
+		loop(ctx, pei)
 	})
 }
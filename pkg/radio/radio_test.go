@@ -0,0 +1,128 @@
+package radio_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ftl/tetra-cli/pkg/fakepei"
+	"github.com/ftl/tetra-cli/pkg/radio"
+)
+
+func initialization() []fakepei.Exchange {
+	return []fakepei.Exchange{
+		fakepei.Expect("ATZ"),
+		fakepei.Expect("ATE0"),
+		fakepei.Expect("AT+CSCS=8859-1"),
+	}
+}
+
+// connectTo returns a ConnectFunc that connects to the given PEIs one after the other, and a function that returns
+// the number of connections.
+func connectTo(peis ...*fakepei.PEI) (radio.ConnectFunc, func() int) {
+	var lock sync.Mutex
+	connections := 0
+	connect := func(context.Context) (radio.PEI, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		result := peis[connections]
+		connections++
+		return result, nil
+	}
+	count := func() int {
+		lock.Lock()
+		defer lock.Unlock()
+		return connections
+	}
+	return connect, count
+}
+
+func waitFor(t *testing.T, condition func() bool, message string) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal(message)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRadio_ReconnectsAndRestoresTheIndications(t *testing.T) {
+	first := fakepei.New(initialization()...)
+	second := fakepei.New(initialization()...)
+	connect, connections := connectTo(first, second)
+	config := radio.ReconnectConfig{Enabled: true, InitialBackoff: time.Millisecond}
+
+	r, err := radio.Connect(context.Background(), connect, nil, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	indicated := make(chan string, 1)
+	r.AddIndication("+CTOM: ", 0, func(lines []string) { indicated <- lines[0] })
+
+	first.Disconnect()
+	waitFor(t, func() bool { return connections() == 2 && r.Connected() }, "the radio did not reconnect")
+
+	second.Indicate("+CTOM: 1")
+	select {
+	case line := <-indicated:
+		if line != "+CTOM: 1" {
+			t.Errorf("unexpected indication %q", line)
+		}
+	default:
+		t.Error("the indication was not restored")
+	}
+	if r.Closed() {
+		t.Error("a reconnected radio must not be closed")
+	}
+}
+
+func TestRadio_ClosesAPEIThatIsAttachedAfterClose(t *testing.T) {
+	var r *radio.Radio
+	first := fakepei.New(initialization()...)
+	// the radio is closed while the reconnected PEI is initialized
+	second := fakepei.New(
+		fakepei.Expect("ATZ"),
+		fakepei.Expect("ATE0"),
+		fakepei.Exchange{Request: "AT+CSCS=8859-1", Match: func(request string) bool {
+			r.Close()
+			return request == "AT+CSCS=8859-1"
+		}},
+	)
+	connect, _ := connectTo(first, second)
+	config := radio.ReconnectConfig{Enabled: true, InitialBackoff: time.Millisecond}
+
+	var err error
+	r, err = radio.Connect(context.Background(), connect, nil, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first.Disconnect()
+	waitFor(t, second.Closed, "the PEI that was attached after Close is not closed")
+	if !r.Closed() {
+		t.Error("the radio should be closed")
+	}
+}
+
+func TestRadio_KeepsTheDisconnectCallbackOfThePEI(t *testing.T) {
+	pei := fakepei.New(initialization()...)
+	disconnected := make(chan struct{})
+	pei.OnDisconnect(func() { close(disconnected) })
+
+	r, err := radio.Open(context.Background(), pei, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pei.Disconnect()
+
+	select {
+	case <-disconnected:
+	case <-time.After(time.Second):
+		t.Error("the disconnect callback of the PEI was not invoked")
+	}
+	waitFor(t, r.Closed, "the radio is not closed after the PEI disconnected")
+}
@@ -0,0 +1,268 @@
+package rules
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ftl/tetra-pei/ctrl"
+	"github.com/ftl/tetra-pei/sds"
+	"github.com/ftl/tetra-pei/tetra"
+
+	"github.com/ftl/tetra-cli/pkg/events"
+	"github.com/ftl/tetra-cli/pkg/messaging"
+	"github.com/ftl/tetra-cli/pkg/radio"
+)
+
+// QueueSize is the maximum number of events that wait for a free worker. Further events are dropped.
+const QueueSize = 100
+
+// Workers is the number of events that are handled at the same time.
+const Workers = 4
+
+// Engine runs the rules on incoming events and sends the responses through the radio.
+type Engine struct {
+	rules   *Rules
+	sender  *messaging.Sender
+	pei     radio.PEI
+	timeout time.Duration
+	events  chan events.Event
+
+	lastResponsesLock sync.Mutex
+	lastResponses     map[string]lastResponse
+}
+
+// lastResponse is the time of the last response of a rule to a source, the response is remembered for the rule's
+// cooldown time.
+type lastResponse struct {
+	time     time.Time
+	cooldown time.Duration
+}
+
+// NewEngine returns a new engine that runs the given rules. The responses are sent with the given sender, talkgroup
+// switches and position requests use the given PEI. Each response must be done within the given timeout.
+func NewEngine(rules *Rules, sender *messaging.Sender, pei radio.PEI, timeout time.Duration) *Engine {
+	return &Engine{
+		rules:         rules,
+		sender:        sender,
+		pei:           pei,
+		timeout:       timeout,
+		events:        make(chan events.Event, QueueSize),
+		lastResponses: make(map[string]lastResponse),
+	}
+}
+
+// Run handles the queued events with a fixed number of workers until the given context is done.
+func (e *Engine) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for range Workers {
+		wg.Go(func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case event := <-e.events:
+					e.handle(ctx, event)
+				}
+			}
+		})
+	}
+	wg.Wait()
+}
+
+// Handle queues the given event to run the rules on it. Handle never blocks; if the queue is full, the event is
+// dropped. It can be used as subscriber of an events.Listener.
+func (e *Engine) Handle(event events.Event) {
+	select {
+	case e.events <- event:
+	default:
+		log.Printf("rules: too many events are waiting, the %s event is dropped", event.Kind())
+	}
+}
+
+// handle runs the rules on the given event and responds to the matching rules. handle blocks until all responses
+// are done.
+func (e *Engine) handle(ctx context.Context, event events.Event) {
+	record := events.NewRecord(event)
+	for _, rule := range e.rules.rules {
+		groups, ok := rule.match(event, record)
+		if !ok {
+			continue
+		}
+		if e.coolingDown(rule, record.Source, event.Timestamp()) {
+			log.Printf("rule %s: %s is cooling down, no response", rule.Name, record.Source)
+		} else {
+			e.respond(ctx, rule, event, record, groups)
+		}
+		if !rule.Continue {
+			return
+		}
+	}
+}
+
+// coolingDown checks if the given rule already responded to the given source within its cooldown time.
+// Otherwise, it remembers the response. Responses that are older than the cooldown time of their rule are forgotten.
+func (e *Engine) coolingDown(rule *rule, source string, now time.Time) bool {
+	if rule.Cooldown <= 0 {
+		return false
+	}
+	e.lastResponsesLock.Lock()
+	defer e.lastResponsesLock.Unlock()
+
+	for key, last := range e.lastResponses {
+		if now.Sub(last.time) >= last.cooldown {
+			delete(e.lastResponses, key)
+		}
+	}
+
+	key := rule.Name + "|" + source
+	if last, ok := e.lastResponses[key]; ok && now.Sub(last.time) < rule.Cooldown {
+		return true
+	}
+	e.lastResponses[key] = lastResponse{time: now, cooldown: rule.Cooldown}
+	return false
+}
+
+func (e *Engine) respond(ctx context.Context, rule *rule, event events.Event, record events.Record, groups map[string]string) {
+	ctx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+
+	values, err := e.values(ctx, rule, event, record, groups)
+	if err != nil {
+		log.Printf("rule %s: %v", rule.Name, err)
+		return
+	}
+
+	destination, group := rule.destination, rule.group
+	if destination == "" {
+		destination = tetra.Identity(record.Source)
+	}
+	if (rule.Reply != "" || rule.ReplyStatus != "") && destination == "" {
+		log.Printf("rule %s: the %s event has no source to reply to", rule.Name, event.Kind())
+	}
+
+	if rule.Reply != "" && destination != "" {
+		err := e.reply(ctx, rule, destination, group, values)
+		if err != nil {
+			log.Printf("rule %s: cannot reply to %s: %v", rule.Name, destination, err)
+		} else {
+			log.Printf("rule %s: replied to %s", rule.Name, destination)
+		}
+	}
+	if rule.ReplyStatus != "" && destination != "" {
+		err := e.sender.SendStatus(ctx, messaging.StatusMessage{Destination: destination, Status: rule.replyStatus, Group: group})
+		if err != nil {
+			log.Printf("rule %s: cannot send the status to %s: %v", rule.Name, destination, err)
+		} else {
+			log.Printf("rule %s: sent the status %s to %s", rule.Name, e.rules.statusCatalog.Label(rule.replyStatus), destination)
+		}
+	}
+	if rule.Talkgroup != "" {
+		gtsi, err := e.switchTalkgroup(ctx, rule.Talkgroup)
+		if err != nil {
+			log.Printf("rule %s: cannot switch the talkgroup: %v", rule.Name, err)
+		} else {
+			log.Printf("rule %s: switched to the talkgroup %s", rule.Name, gtsi)
+		}
+	}
+	if len(rule.Exec) > 0 {
+		err := e.exec(ctx, rule, values)
+		if err != nil {
+			log.Printf("rule %s: %v", rule.Name, err)
+		}
+	}
+}
+
+// values returns the values for the placeholders of the rule's responses.
+func (e *Engine) values(ctx context.Context, rule *rule, event events.Event, record events.Record, groups map[string]string) (map[string]string, error) {
+	result := map[string]string{
+		"type":               string(record.Type),
+		"time":               record.Time.Format(time.RFC3339),
+		"source":             record.Source,
+		"source_name":        e.rules.addressBook.Name(tetra.Identity(record.Source)),
+		"text":               record.Text,
+		"opta":               record.OPTA,
+		"status":             record.Status,
+		"status_name":        "",
+		"status_description": "",
+	}
+	if status, ok := event.(events.StatusMessage); ok {
+		definition, _ := e.rules.statusCatalog.Lookup(status.Status)
+		result["status_name"] = definition.Name
+		result["status_description"] = definition.Description
+	}
+	for name, value := range groups {
+		result[name] = value
+	}
+
+	if rule.needPosition {
+		latitude, longitude, satellites, timestamp, err := ctrl.RequestGPSPosition(ctx, e.pei)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read the GPS position: %w", err)
+		}
+		result["latitude"] = strconv.FormatFloat(latitude, 'f', 5, 64)
+		result["longitude"] = strconv.FormatFloat(longitude, 'f', 5, 64)
+		result["position"] = result["latitude"] + "," + result["longitude"]
+		result["satellites"] = strconv.Itoa(satellites)
+		result["position_time"] = timestamp.Format("15:04:05")
+	}
+	return result, nil
+}
+
+func (e *Engine) reply(ctx context.Context, rule *rule, destination tetra.Identity, group bool, values map[string]string) error {
+	text, err := rule.reply.Render(values)
+	if err != nil {
+		return err
+	}
+	_, err = e.sender.SendText(ctx, messaging.TextMessage{
+		Destination: destination,
+		Text:        text,
+		Encoding:    sds.ISO8859_1,
+		Group:       group,
+	})
+	return err
+}
+
+// switchTalkgroup switches to the given talkgroup in the current operating mode. Talkgroups given by name are
+// looked up in the talkgroups of the radio.
+func (e *Engine) switchTalkgroup(ctx context.Context, talkgroup string) (string, error) {
+	gtsi := talkgroup
+	if strings.Trim(gtsi, "0123456789") != "" {
+		info, err := messaging.LookupTalkgroup(ctx, e.pei, talkgroup)
+		if err != nil {
+			return "", err
+		}
+		gtsi = info.GTSI
+	}
+	_, err := e.pei.AT(ctx, ctrl.SetTalkgroup(gtsi))
+	if err != nil {
+		return "", err
+	}
+	return gtsi, nil
+}
+
+func (e *Engine) exec(ctx context.Context, rule *rule, values map[string]string) error {
+	args := make([]string, len(rule.exec))
+	for i, template := range rule.exec {
+		arg, err := template.Render(values)
+		if err != nil {
+			return err
+		}
+		args[i] = arg
+	}
+
+	output, err := exec.CommandContext(ctx, args[0], args[1:]...).CombinedOutput()
+	if err != nil && len(output) > 0 {
+		return fmt.Errorf("the command %s failed: %w: %s", args[0], err, strings.TrimSpace(string(output)))
+	}
+	if err != nil {
+		return fmt.Errorf("the command %s failed: %w", args[0], err)
+	}
+	log.Printf("rule %s: executed %s", rule.Name, args[0])
+	return nil
+}
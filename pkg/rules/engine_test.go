@@ -0,0 +1,106 @@
+package rules
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ftl/tetra-pei/sds"
+
+	"github.com/ftl/tetra-cli/pkg/events"
+	"github.com/ftl/tetra-cli/pkg/fakepei"
+	"github.com/ftl/tetra-cli/pkg/messaging"
+)
+
+func newTestEngine(t *testing.T, definitions string, script ...fakepei.Exchange) (*Engine, *fakepei.PEI) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	err := os.WriteFile(path, []byte(definitions), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules, err := Load(path, &messaging.AddressBook{}, &messaging.StatusCatalog{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pei := fakepei.New(script...)
+	sender, err := messaging.NewSender(pei)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewEngine(rules, sender, pei, time.Second), pei
+}
+
+func TestEngine_RespondsToQueuedEvents(t *testing.T) {
+	engine, pei := newTestEngine(t, `
+rules:
+  - name: acknowledge
+    status: "8005"
+    reply_status: "8002"
+`,
+		fakepei.Expect("AT+CTSP=2,2,20"),
+		fakepei.Expect("AT+CTSDS=13,0"),
+		fakepei.Expect(sds.SendMessage("1234567", sds.Status(0x8002))),
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		engine.Run(ctx)
+		close(done)
+	}()
+
+	engine.Handle(events.StatusMessage{Time: time.Now(), Source: "1234567", Status: 0x8005})
+
+	deadline := time.Now().Add(time.Second)
+	for len(pei.Requests()) < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := pei.Verify(); err != nil {
+		t.Error(err)
+	}
+	cancel()
+	<-done
+}
+
+func TestEngine_HandleDoesNotBlock(t *testing.T) {
+	engine, _ := newTestEngine(t, "rules: []\n")
+
+	handled := make(chan struct{})
+	go func() {
+		for range 2 * QueueSize {
+			engine.Handle(events.TalkgroupIdle{Time: time.Now()})
+		}
+		close(handled)
+	}()
+
+	select {
+	case <-handled:
+	case <-time.After(time.Second):
+		t.Error("Handle blocks while no worker runs")
+	}
+}
+
+func TestEngine_ForgetsResponsesAfterTheCooldown(t *testing.T) {
+	engine, _ := newTestEngine(t, "rules: []\n")
+	short := &rule{Rule: Rule{Name: "short", Cooldown: time.Minute}}
+	long := &rule{Rule: Rule{Name: "long", Cooldown: time.Hour}}
+	now := time.Now()
+
+	engine.coolingDown(short, "1234567", now)
+	engine.coolingDown(long, "1234567", now)
+	if !engine.coolingDown(short, "1234567", now.Add(30*time.Second)) {
+		t.Error("the rule should cool down")
+	}
+	if engine.coolingDown(short, "2345678", now.Add(2*time.Minute)) {
+		t.Error("another source must not cool down")
+	}
+
+	if len(engine.lastResponses) != 2 {
+		t.Errorf("the outdated response should be forgotten: %v", engine.lastResponses)
+	}
+	if _, ok := engine.lastResponses["long|1234567"]; !ok {
+		t.Error("the response of the rule with the long cooldown should be remembered")
+	}
+}
@@ -0,0 +1,296 @@
+// Package rules answers incoming events automatically. A rule matches events by their type, source, text, OPTA or
+// status and responds with a text message, a status, a talkgroup switch or an external command.
+package rules
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ftl/tetra-pei/sds"
+	"github.com/ftl/tetra-pei/tetra"
+	"gopkg.in/yaml.v3"
+
+	"github.com/ftl/tetra-cli/pkg/events"
+	"github.com/ftl/tetra-cli/pkg/messaging"
+	"github.com/ftl/tetra-cli/pkg/store"
+)
+
+// DefaultPath returns the default path of the rules file.
+func DefaultPath() string {
+	return filepath.Join(store.ConfigDir(), "rules.yaml")
+}
+
+// Rule defines which events to match and how to respond. All given conditions must match. A rule needs at least one
+// response: Reply, ReplyStatus, Talkgroup or Exec.
+type Rule struct {
+	Name string `json:"name" yaml:"name"`
+
+	// Event is the type of the matching events, e.g. message or status. If it is empty, it is derived from the
+	// conditions: text and OPTA match messages, status matches statuses.
+	Event events.Kind `json:"event,omitempty" yaml:"event,omitempty"`
+	// Source is the ISSI of the sender, or its name from the address book.
+	Source string `json:"source,omitempty" yaml:"source,omitempty"`
+	// Text is a regular expression for the text of a message. Named groups can be used as placeholders.
+	Text string `json:"text,omitempty" yaml:"text,omitempty"`
+	// OPTA is a regular expression for the OPTA of a message.
+	OPTA string `json:"opta,omitempty" yaml:"opta,omitempty"`
+	// Status is the hex value of a status, or its name from the status catalog.
+	Status string `json:"status,omitempty" yaml:"status,omitempty"`
+
+	// Reply is the text of a message that is sent in response. It may contain placeholders like {{source}}.
+	Reply string `json:"reply,omitempty" yaml:"reply,omitempty"`
+	// ReplyStatus is the hex value or the name of a status that is sent in response.
+	ReplyStatus string `json:"reply_status,omitempty" yaml:"reply_status,omitempty"`
+	// To is the destination of the responses instead of the source of the event, given as ISSI, GTSI or name from
+	// the address book.
+	To string `json:"to,omitempty" yaml:"to,omitempty"`
+	// Talkgroup is the GTSI or the name of a talkgroup to switch to, in the current operating mode.
+	Talkgroup string `json:"talkgroup,omitempty" yaml:"talkgroup,omitempty"`
+	// Exec is an external command with its arguments, which may contain placeholders.
+	Exec []string `json:"exec,omitempty" yaml:"exec,omitempty"`
+
+	// Cooldown is the minimum time between two responses of this rule to the same source, e.g. 1m.
+	Cooldown time.Duration `json:"cooldown,omitempty" yaml:"cooldown,omitempty"`
+	// Continue evaluates the following rules after this rule matched. Otherwise, the first matching rule wins.
+	Continue bool `json:"continue,omitempty" yaml:"continue,omitempty"`
+}
+
+// file is the content of a rules file.
+type file struct {
+	Rules []Rule `json:"rules" yaml:"rules"`
+}
+
+// Placeholders that can be used in replies and commands, in addition to the named groups of the text expression.
+// The position placeholders request the current GPS position from the radio.
+var (
+	eventPlaceholders    = []string{"type", "time", "source", "source_name", "text", "opta", "status", "status_name", "status_description"}
+	positionPlaceholders = []string{"position", "latitude", "longitude", "satellites", "position_time"}
+)
+
+// Rules are the compiled rules of a rules file.
+type Rules struct {
+	rules         []*rule
+	addressBook   *messaging.AddressBook
+	statusCatalog *messaging.StatusCatalog
+}
+
+// rule is a compiled rule.
+type rule struct {
+	Rule
+	source       tetra.Identity
+	text         *regexp.Regexp
+	opta         *regexp.Regexp
+	status       sds.Status
+	reply        messaging.Template
+	replyStatus  sds.Status
+	destination  tetra.Identity
+	group        bool
+	exec         []messaging.Template
+	needPosition bool
+}
+
+// Load reads the rules from the given YAML or JSON file. If the file does not exist, there are no rules. Sources,
+// destinations and talkgroups are resolved through the given address book, statuses through the given status
+// catalog. Example:
+//
+//	rules:
+//	  - name: acknowledge alarms
+//	    status: alarm
+//	    reply_status: acknowledged
+//	  - name: position
+//	    text: "(?i)^POS\\?$"
+//	    reply: "POS {{position}}"
+func Load(path string, addressBook *messaging.AddressBook, statusCatalog *messaging.StatusCatalog) (*Rules, error) {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &Rules{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the rules: %w", err)
+	}
+
+	// JSON is a subset of YAML, so both formats are read the same way.
+	var definitions file
+	err = yaml.Unmarshal(content, &definitions)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the rules from %s: %w", path, err)
+	}
+
+	result := &Rules{
+		rules:         make([]*rule, 0, len(definitions.Rules)),
+		addressBook:   addressBook,
+		statusCatalog: statusCatalog,
+	}
+	for i, definition := range definitions.Rules {
+		if definition.Name == "" {
+			definition.Name = fmt.Sprintf("#%d", i+1)
+		}
+		compiled, err := compile(definition, addressBook, statusCatalog)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rule %s in %s: %w", definition.Name, path, err)
+		}
+		result.rules = append(result.rules, compiled)
+	}
+	return result, nil
+}
+
+// Len returns the number of rules.
+func (r *Rules) Len() int {
+	if r == nil {
+		return 0
+	}
+	return len(r.rules)
+}
+
+func compile(definition Rule, addressBook *messaging.AddressBook, statusCatalog *messaging.StatusCatalog) (*rule, error) {
+	result := &rule{Rule: definition}
+	var err error
+
+	if result.Event == "" {
+		switch {
+		case result.Text != "" || result.OPTA != "":
+			result.Event = events.TextMessageKind
+		case result.Status != "":
+			result.Event = events.StatusMessageKind
+		}
+	}
+	if result.Event != "" && !slices.Contains(events.Kinds, result.Event) {
+		return nil, fmt.Errorf("unknown event %q", result.Event)
+	}
+	if (result.Text != "" || result.OPTA != "") && result.Event != events.TextMessageKind {
+		return nil, fmt.Errorf("text and opta only match messages")
+	}
+	if result.Status != "" && result.Event != events.StatusMessageKind {
+		return nil, fmt.Errorf("status only matches statuses")
+	}
+
+	if result.Source != "" {
+		entry, ok, err := addressBook.Resolve(result.Source)
+		switch {
+		case err != nil:
+			return nil, err
+		case ok:
+			result.source = entry.Identity()
+		default:
+			result.source = tetra.Identity(result.Source)
+			err = messaging.ValidateIdentity(result.source)
+			if err != nil {
+				return nil, fmt.Errorf("unknown source: %w", err)
+			}
+		}
+	}
+	if result.Text != "" {
+		result.text, err = regexp.Compile(result.Text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid text expression: %w", err)
+		}
+	}
+	if result.OPTA != "" {
+		result.opta, err = regexp.Compile(result.OPTA)
+		if err != nil {
+			return nil, fmt.Errorf("invalid opta expression: %w", err)
+		}
+	}
+	if result.Status != "" {
+		result.status, err = statusCatalog.Parse(result.Status)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if result.Reply == "" && result.ReplyStatus == "" && result.Talkgroup == "" && len(result.Exec) == 0 {
+		return nil, fmt.Errorf("the rule has no response, use reply, reply_status, talkgroup or exec")
+	}
+	if result.ReplyStatus != "" {
+		result.replyStatus, err = statusCatalog.Parse(result.ReplyStatus)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if result.To != "" {
+		result.destination, result.group, err = addressBook.ResolveDestination(tetra.Identity(result.To), false)
+		if err == nil {
+			err = messaging.ValidateDestination(result.destination, result.group)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if result.Talkgroup != "" {
+		entry, ok, err := addressBook.Resolve(result.Talkgroup)
+		if err != nil {
+			return nil, err
+		}
+		if ok && !entry.IsGroup() {
+			return nil, fmt.Errorf("%s (%s) is a radio, not a talkgroup", entry.Name, entry.ISSI)
+		}
+		if ok {
+			result.Talkgroup = entry.GTSI
+		}
+	}
+
+	knownPlaceholders := slices.Concat(eventPlaceholders, positionPlaceholders)
+	placeholders := knownPlaceholders
+	if result.text != nil {
+		placeholders = slices.Concat(placeholders, result.text.SubexpNames())
+	}
+	templates := make([]messaging.Template, 0, len(result.Exec)+1)
+	if result.Reply != "" {
+		result.reply = messaging.Template{Name: "reply of rule " + result.Name, Text: result.Reply}
+		templates = append(templates, result.reply)
+	}
+	for i, arg := range result.Exec {
+		template := messaging.Template{Name: fmt.Sprintf("exec argument #%d of rule %s", i+1, result.Name), Text: arg}
+		result.exec = append(result.exec, template)
+		templates = append(templates, template)
+	}
+	for _, template := range templates {
+		for _, placeholder := range template.Placeholders() {
+			if !slices.Contains(placeholders, placeholder) {
+				return nil, fmt.Errorf("unknown placeholder {{%s}}, use a named group of the text expression or one of %s", placeholder, strings.Join(knownPlaceholders, ", "))
+			}
+			if slices.Contains(positionPlaceholders, placeholder) {
+				result.needPosition = true
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// match checks if the given event matches the rule. It returns the values of the named groups of the text
+// expression.
+func (r *rule) match(event events.Event, record events.Record) (map[string]string, bool) {
+	if r.Event != "" && event.Kind() != r.Event {
+		return nil, false
+	}
+	if r.source != "" && !messaging.SameIdentity(string(r.source), record.Source) {
+		return nil, false
+	}
+	if r.opta != nil && !r.opta.MatchString(record.OPTA) {
+		return nil, false
+	}
+	if r.Status != "" && record.Status != store.FormatStatus(r.status) {
+		return nil, false
+	}
+
+	values := make(map[string]string)
+	if r.text != nil {
+		match := r.text.FindStringSubmatch(record.Text)
+		if match == nil {
+			return nil, false
+		}
+		for i, name := range r.text.SubexpNames() {
+			if name != "" {
+				values[name] = match[i]
+			}
+		}
+	}
+	return values, true
+}
@@ -0,0 +1,132 @@
+//go:build linux
+
+package simulator
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// disconnectedPollInterval is the time to wait before reading again while no client is connected.
+const disconnectedPollInterval = 100 * time.Millisecond
+
+// PTY is a pseudo terminal that makes the simulated PEI available as serial device.
+type PTY struct {
+	master    *os.File
+	slaveName string
+}
+
+// OpenPTY opens a new pseudo terminal in raw mode.
+func OpenPTY() (*PTY, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var number uint32
+	err = control(master, func(fd int) error {
+		err := unix.IoctlSetPointerInt(fd, unix.TIOCSPTLCK, 0)
+		if err != nil {
+			return fmt.Errorf("cannot unlock pseudo terminal: %w", err)
+		}
+		number, err = unix.IoctlGetUint32(fd, unix.TIOCGPTN)
+		if err != nil {
+			return fmt.Errorf("cannot get pseudo terminal number: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		master.Close()
+		return nil, err
+	}
+
+	result := &PTY{
+		master:    master,
+		slaveName: fmt.Sprintf("/dev/pts/%d", number),
+	}
+	err = result.makeRaw()
+	if err != nil {
+		master.Close()
+		return nil, fmt.Errorf("cannot set pseudo terminal to raw mode: %w", err)
+	}
+
+	return result, nil
+}
+
+func control(f *os.File, op func(fd int) error) error {
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var opErr error
+	err = conn.Control(func(fd uintptr) {
+		opErr = op(int(fd))
+	})
+	if err != nil {
+		return err
+	}
+	return opErr
+}
+
+func (p *PTY) makeRaw() error {
+	slave, err := os.OpenFile(p.slaveName, os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		return err
+	}
+	defer slave.Close()
+
+	return control(slave, func(fd int) error {
+		termios, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+		if err != nil {
+			return err
+		}
+
+		termios.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+		termios.Oflag &^= unix.OPOST
+		termios.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+		termios.Cflag &^= unix.CSIZE | unix.PARENB
+		termios.Cflag |= unix.CS8
+		termios.Cc[unix.VMIN] = 1
+		termios.Cc[unix.VTIME] = 0
+
+		return unix.IoctlSetTermios(fd, unix.TCSETS, termios)
+	})
+}
+
+// Name returns the filename of the device that clients use to connect to the simulated PEI.
+func (p *PTY) Name() string {
+	return p.slaveName
+}
+
+// Read from the pseudo terminal. While no client is connected, everything that was written in the meantime
+// is discarded, so that the next client does not receive any stale data.
+func (p *PTY) Read(b []byte) (int, error) {
+	for {
+		n, err := p.master.Read(b)
+		if !errors.Is(err, syscall.EIO) {
+			return n, err
+		}
+
+		err = control(p.master, func(fd int) error {
+			return unix.IoctlSetInt(fd, unix.TCFLSH, unix.TCIOFLUSH)
+		})
+		if err != nil {
+			return 0, err
+		}
+		time.Sleep(disconnectedPollInterval)
+	}
+}
+
+func (p *PTY) Write(b []byte) (int, error) {
+	return p.master.Write(b)
+}
+
+func (p *PTY) Close() error {
+	return p.master.Close()
+}
@@ -0,0 +1,31 @@
+//go:build !linux
+
+package simulator
+
+import (
+	"errors"
+)
+
+// PTY is a pseudo terminal that makes the simulated PEI available as serial device.
+type PTY struct{}
+
+// OpenPTY is only supported on Linux.
+func OpenPTY() (*PTY, error) {
+	return nil, errors.New("pseudo terminals are only supported on Linux")
+}
+
+func (p *PTY) Name() string {
+	return ""
+}
+
+func (p *PTY) Read(b []byte) (int, error) {
+	return 0, errors.ErrUnsupported
+}
+
+func (p *PTY) Write(b []byte) (int, error) {
+	return 0, errors.ErrUnsupported
+}
+
+func (p *PTY) Close() error {
+	return nil
+}
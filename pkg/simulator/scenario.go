@@ -0,0 +1,196 @@
+package simulator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ftl/tetra-pei/ctrl"
+	"github.com/ftl/tetra-pei/sds"
+	"github.com/ftl/tetra-pei/tetra"
+)
+
+// EventKind defines the kind of indication the simulated radio terminal emits.
+type EventKind string
+
+// All supported event kinds.
+const (
+	TextMessage       EventKind = "message"
+	StatusMessage     EventKind = "status"
+	VoiceTx           EventKind = "voice-tx"
+	VoiceRx           EventKind = "voice-rx"
+	TalkgroupIdle     EventKind = "talkgroup-idle"
+	TalkgroupInactive EventKind = "talkgroup-inactive"
+	AIModeChanged     EventKind = "ai-mode"
+)
+
+// Event describes an indication the simulated radio terminal emits.
+type Event struct {
+	Kind   EventKind
+	Source string
+	Text   string
+	Status sds.Status
+	AIMode ctrl.AIMode
+}
+
+// ScheduledEvent is an event that is emitted after a delay, and optionally repeated.
+type ScheduledEvent struct {
+	Event
+	// After is the delay after the simulation started.
+	After time.Duration
+	// Every is the interval for repeating the event, 0 means no repetition.
+	Every time.Duration
+}
+
+// Scenario is a list of scheduled events.
+type Scenario []ScheduledEvent
+
+// DefaultScenario returns a scenario that emits every kind of event in the given interval.
+func DefaultScenario(interval time.Duration) Scenario {
+	events := []Event{
+		{Kind: TextMessage, Source: "1000002", Text: "Hello from the simulator"},
+		{Kind: StatusMessage, Source: "1000002", Status: sds.Status3},
+		{Kind: VoiceRx, Source: "1000003"},
+		{Kind: TalkgroupIdle},
+		{Kind: VoiceTx},
+		{Kind: TalkgroupInactive},
+		{Kind: AIModeChanged, AIMode: ctrl.TMO},
+	}
+
+	step := interval / time.Duration(len(events))
+	result := make(Scenario, len(events))
+	for i, event := range events {
+		result[i] = ScheduledEvent{
+			Event: event,
+			After: time.Duration(i+1) * step,
+			Every: interval,
+		}
+	}
+	return result
+}
+
+// Run emits the scheduled events of this scenario using the given function until the context is done.
+func (s Scenario) Run(ctx context.Context, emit func(Event)) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for _, scheduled := range s {
+		wg.Go(func() {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(scheduled.After):
+				emit(scheduled.Event)
+			}
+			if scheduled.Every <= 0 {
+				return
+			}
+
+			ticker := time.NewTicker(scheduled.Every)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					emit(scheduled.Event)
+				}
+			}
+		})
+	}
+}
+
+type scenarioEntry struct {
+	After  string `json:"after"`
+	Every  string `json:"every,omitempty"`
+	Kind   string `json:"kind"`
+	Source string `json:"source,omitempty"`
+	Text   string `json:"text,omitempty"`
+	Status string `json:"status,omitempty"`
+	AIMode string `json:"ai_mode,omitempty"`
+}
+
+// ReadScenario reads a scenario in JSON format from the given reader. Example:
+//
+//	[
+//	  {"after": "5s", "every": "1m", "kind": "message", "source": "1234567", "text": "hello"},
+//	  {"after": "10s", "kind": "status", "source": "1234567", "status": "8003"},
+//	  {"after": "20s", "kind": "voice-rx", "source": "1234567"},
+//	  {"after": "30s", "kind": "ai-mode", "ai_mode": "DMO"}
+//	]
+func ReadScenario(r io.Reader) (Scenario, error) {
+	var entries []scenarioEntry
+	err := json.NewDecoder(r).Decode(&entries)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(Scenario, 0, len(entries))
+	for i, entry := range entries {
+		scheduled, err := entry.toScheduledEvent()
+		if err != nil {
+			return nil, fmt.Errorf("invalid scenario entry #%d: %w", i+1, err)
+		}
+		result = append(result, scheduled)
+	}
+	return result, nil
+}
+
+func (e scenarioEntry) toScheduledEvent() (ScheduledEvent, error) {
+	var result ScheduledEvent
+	var err error
+
+	if e.After != "" {
+		result.After, err = time.ParseDuration(e.After)
+		if err != nil {
+			return ScheduledEvent{}, fmt.Errorf("invalid delay: %w", err)
+		}
+	}
+	if e.Every != "" {
+		result.Every, err = time.ParseDuration(e.Every)
+		if err != nil {
+			return ScheduledEvent{}, fmt.Errorf("invalid interval: %w", err)
+		}
+	}
+
+	result.Kind = EventKind(strings.ToLower(strings.TrimSpace(e.Kind)))
+	result.Source = e.Source
+	result.Text = e.Text
+	switch result.Kind {
+	case TextMessage, VoiceRx:
+		if result.Source == "" {
+			return ScheduledEvent{}, fmt.Errorf("%s requires a source", result.Kind)
+		}
+	case StatusMessage:
+		if result.Source == "" {
+			return ScheduledEvent{}, fmt.Errorf("%s requires a source", result.Kind)
+		}
+		statusBytes, err := tetra.HexToBinary(e.Status)
+		if err != nil {
+			return ScheduledEvent{}, fmt.Errorf("wrong status format: %w", err)
+		}
+		status, err := sds.ParseStatus(statusBytes)
+		if err != nil {
+			return ScheduledEvent{}, fmt.Errorf("not a valid status: %w", err)
+		}
+		var ok bool
+		result.Status, ok = status.(sds.Status)
+		if !ok {
+			return ScheduledEvent{}, fmt.Errorf("not a valid status: %s", e.Status)
+		}
+	case AIModeChanged:
+		result.AIMode, err = ctrl.AIModeByName(e.AIMode)
+		if err != nil {
+			return ScheduledEvent{}, err
+		}
+	case VoiceTx, TalkgroupIdle, TalkgroupInactive:
+	default:
+		return ScheduledEvent{}, fmt.Errorf("unknown event kind %q", e.Kind)
+	}
+
+	return result, nil
+}
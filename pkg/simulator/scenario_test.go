@@ -0,0 +1,171 @@
+package simulator
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ftl/tetra-pei/ctrl"
+	"github.com/ftl/tetra-pei/sds"
+	"github.com/ftl/tetra-pei/tetra"
+)
+
+func TestReadScenario(t *testing.T) {
+	scenario, err := ReadScenario(strings.NewReader(`[
+		{"after": "5s", "every": "1m", "kind": "message", "source": "1234567", "text": "hello"},
+		{"after": "10s", "kind": "Status", "source": "1234567", "status": "8003"},
+		{"after": "20s", "kind": "voice-rx", "source": "1234567"},
+		{"kind": "talkgroup-idle"},
+		{"after": "30s", "kind": "ai-mode", "ai_mode": "DMO"}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Scenario{
+		{Event: Event{Kind: TextMessage, Source: "1234567", Text: "hello"}, After: 5 * time.Second, Every: time.Minute},
+		{Event: Event{Kind: StatusMessage, Source: "1234567", Status: sds.Status(0x8003)}, After: 10 * time.Second},
+		{Event: Event{Kind: VoiceRx, Source: "1234567"}, After: 20 * time.Second},
+		{Event: Event{Kind: TalkgroupIdle}},
+		{Event: Event{Kind: AIModeChanged, AIMode: ctrl.DMO}, After: 30 * time.Second},
+	}
+	if len(scenario) != len(expected) {
+		t.Fatalf("expected %d events, got %d", len(expected), len(scenario))
+	}
+	for i := range expected {
+		if scenario[i] != expected[i] {
+			t.Errorf("event #%d: expected %+v, got %+v", i+1, expected[i], scenario[i])
+		}
+	}
+}
+
+func TestReadScenario_RejectsInvalidEntries(t *testing.T) {
+	for _, entry := range []string{
+		`{"after": "soon", "kind": "voice-tx"}`,
+		`{"every": "often", "kind": "voice-tx"}`,
+		`{"kind": "call"}`,
+		`{"kind": "message", "text": "hello"}`,
+		`{"kind": "voice-rx"}`,
+		`{"kind": "status", "source": "1234567", "status": "xyz"}`,
+		`{"kind": "ai-mode", "ai_mode": "XMO"}`,
+	} {
+		_, err := ReadScenario(strings.NewReader("[" + entry + "]"))
+		if err == nil || !strings.Contains(err.Error(), "entry #1") {
+			t.Errorf("%s should be rejected, got %v", entry, err)
+		}
+	}
+}
+
+func TestDefaultScenario(t *testing.T) {
+	scenario := DefaultScenario(7 * time.Second)
+
+	kinds := make(map[EventKind]bool)
+	for i, scheduled := range scenario {
+		kinds[scheduled.Kind] = true
+		if scheduled.After != time.Duration(i+1)*time.Second || scheduled.Every != 7*time.Second {
+			t.Errorf("event #%d has the wrong schedule: %v, %v", i+1, scheduled.After, scheduled.Every)
+		}
+	}
+	if len(kinds) != 7 {
+		t.Errorf("the default scenario should contain every kind of event, got %v", kinds)
+	}
+}
+
+func TestScenario_Run(t *testing.T) {
+	scenario := Scenario{
+		{Event: Event{Kind: VoiceTx}, After: 20 * time.Millisecond, Every: 50 * time.Millisecond},
+		{Event: Event{Kind: TalkgroupIdle}, After: 10 * time.Millisecond},
+	}
+	var lock sync.Mutex
+	var emitted []EventKind
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	scenario.Run(ctx, func(event Event) {
+		lock.Lock()
+		defer lock.Unlock()
+		emitted = append(emitted, event.Kind)
+	})
+
+	if len(emitted) < 3 || emitted[0] != TalkgroupIdle || emitted[1] != VoiceTx || emitted[2] != VoiceTx {
+		t.Errorf("unexpected events %v", emitted)
+	}
+	for _, kind := range emitted[1:] {
+		if kind != VoiceTx {
+			t.Errorf("only voice-tx should be repeated, got %v", emitted)
+		}
+	}
+}
+
+func TestSimulator_EmitsTheScenario(t *testing.T) {
+	step := 50 * time.Millisecond
+	scenario := Scenario{
+		{Event: Event{Kind: TextMessage, Source: "1234567", Text: "hello"}, After: 4 * step},
+		{Event: Event{Kind: StatusMessage, Source: "1234567", Status: sds.Status(0x8003)}, After: 5 * step},
+		{Event: Event{Kind: VoiceTx}, After: 6 * step},
+		{Event: Event{Kind: VoiceRx, Source: "1234567"}, After: 7 * step},
+		{Event: Event{Kind: TalkgroupIdle}, After: 8 * step},
+		{Event: Event{Kind: TalkgroupInactive}, After: 9 * step},
+		{Event: Event{Kind: AIModeChanged, AIMode: ctrl.DMO}, After: 10 * step},
+	}
+	pei := serveTestPEI(t, Config{ISSI: "2345678", Scenario: scenario})
+	pei.expect("ATE0", "ATE0", "OK")
+	pei.expect("AT+CTSP=1,3,130", "OK")
+	pei.expect("AT+CTSP=2,2,20", "OK")
+	pei.expect("AT+CTSP=2,0,0", "OK")
+	start := time.Now()
+
+	expected := []string{
+		"+CTSDSR: 12,1234567,0,2345678,0,",
+		"",
+		"+CTSDSR: 13,1234567,0,2345678,0,16",
+		"8003",
+		"+CTXG: 1,1,0,0",
+		"+CTXG: 1,3,0,0,1,1234567",
+		"+CDTXC: 1,0",
+		"+CTCR: 1,1",
+		"+CTOM: 1",
+	}
+	for _, prefix := range expected {
+		line, ok := pei.next(time.Second)
+		if !ok {
+			t.Fatalf("%q was not emitted", prefix)
+		}
+		if !strings.HasPrefix(line, prefix) {
+			t.Errorf("expected %q, got %q", prefix, line)
+		}
+		if prefix == "" {
+			pdu, _ := tetra.HexToBinary(line)
+			payload, err := sds.ParseSDSTLPDU(pdu)
+			if transfer, ok := payload.(sds.SDSTransfer); err != nil || !ok || transfer.UserData.(sds.TextSDU).Text != "hello" {
+				t.Errorf("unexpected text message %q: %v", line, err)
+			}
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 6*step {
+		t.Errorf("the scenario was emitted too early: %v", elapsed)
+	}
+	pei.expect("AT+CTOM?", "+CTOM: 1", "OK")
+}
+
+func TestSimulator_EmitsOnlyRoutedIndications(t *testing.T) {
+	scenario := Scenario{
+		{Event: Event{Kind: VoiceTx}, After: 50 * time.Millisecond},
+		{Event: Event{Kind: StatusMessage, Source: "1234567", Status: sds.Status(0x8003)}, After: 100 * time.Millisecond},
+		{Event: Event{Kind: TalkgroupIdle}, After: 150 * time.Millisecond},
+	}
+	pei := serveTestPEI(t, Config{Scenario: scenario})
+	pei.expect("ATE0", "ATE0", "OK")
+	pei.expect("AT+CTSP=2,2,20", "OK")
+
+	line, _ := pei.next(time.Second)
+	if !strings.HasPrefix(line, "+CTSDSR: 13,") {
+		t.Errorf("only the status should be emitted, got %q", line)
+	}
+	pei.next(time.Second)
+	if line, ok := pei.next(200 * time.Millisecond); ok {
+		t.Errorf("unexpected line %q", line)
+	}
+}
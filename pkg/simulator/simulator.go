@@ -0,0 +1,448 @@
+// Package simulator emulates the PEI of a TETRA radio terminal. It answers the AT commands used by tetra-cli,
+// accepts outgoing SDS messages and emits unsolicited indications according to a scenario.
+package simulator
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ftl/tetra-pei/ctrl"
+	"github.com/ftl/tetra-pei/sds"
+	"github.com/ftl/tetra-pei/tetra"
+)
+
+const (
+	ctrlZ  = 0x1a
+	escape = 0x1b
+
+	// DefaultISSI is the ISSI of the simulated radio terminal, if nothing else is configured.
+	DefaultISSI = "1000001"
+
+	// reportDelay is the time until a simulated destination acknowledges an SDS message.
+	reportDelay = time.Second
+)
+
+// Config contains the configuration of a simulated radio terminal.
+type Config struct {
+	// ISSI of the simulated radio terminal.
+	ISSI string
+	// Scenario defines the unsolicited indications the simulated radio terminal emits.
+	Scenario Scenario
+}
+
+// Simulator emulates the PEI of a TETRA radio terminal.
+type Simulator struct {
+	issi     string
+	scenario Scenario
+
+	stateLock      sync.Mutex
+	echo           bool
+	routing        []string
+	aiMode         ctrl.AIMode
+	talkgroup      string
+	sdsService     string
+	batteryCharge  int
+	signalStrength int
+	latitude       float64
+	longitude      float64
+	satellites     int
+	tmoTalkgroups  []ctrl.TalkgroupInfo
+	dmoTalkgroups  []ctrl.TalkgroupInfo
+
+	writeLock sync.Mutex
+	device    io.Writer
+}
+
+// New creates a new simulated radio terminal with the given configuration.
+func New(config Config) *Simulator {
+	issi := config.ISSI
+	if issi == "" {
+		issi = DefaultISSI
+	}
+
+	return &Simulator{
+		issi:           issi,
+		scenario:       config.Scenario,
+		echo:           true,
+		aiMode:         ctrl.TMO,
+		talkgroup:      "2620001",
+		batteryCharge:  87,
+		signalStrength: 21,
+		latitude:       51.5074,
+		longitude:      7.4653,
+		satellites:     7,
+		tmoTalkgroups: []ctrl.TalkgroupInfo{
+			{GTSI: "2620001", Name: "TMO Group 1"},
+			{GTSI: "2620002", Name: "TMO Group 2"},
+			{GTSI: "2620003", Name: "TMO Group 3"},
+		},
+		dmoTalkgroups: []ctrl.TalkgroupInfo{
+			{GTSI: "2620101", Name: "DMO Group 1"},
+			{GTSI: "2620102", Name: "DMO Group 2"},
+		},
+	}
+}
+
+// Serve emulates the PEI on the given device until the context is done or the device is closed.
+func (s *Simulator) Serve(ctx context.Context, device io.ReadWriteCloser) error {
+	s.device = device
+
+	go func() {
+		<-ctx.Done()
+		device.Close()
+	}()
+
+	var scenarioGroup sync.WaitGroup
+	defer scenarioGroup.Wait()
+	scenarioCtx, cancelScenario := context.WithCancel(ctx)
+	defer cancelScenario()
+	scenarioGroup.Go(func() {
+		s.scenario.Run(scenarioCtx, s.emitEvent)
+	})
+
+	reader := bufio.NewReader(device)
+	var pending []byte
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		switch {
+		case b == escape:
+			pending = pending[:0]
+		case b == ctrlZ:
+			s.handle(ctx, string(pending))
+			pending = pending[:0]
+		case b == '\r' || b == '\n':
+			if len(pending) == 0 || pending[len(pending)-1] == '\n' {
+				continue
+			}
+			line := string(pending)
+			if isSendMessage(line) && !strings.Contains(line, "\n") {
+				// the PDU follows in the next line, terminated by Ctrl-Z
+				pending = append(pending, '\n')
+				continue
+			}
+			s.handle(ctx, line)
+			pending = pending[:0]
+		default:
+			pending = append(pending, b)
+		}
+	}
+}
+
+func isSendMessage(line string) bool {
+	upper := strings.ToUpper(line)
+	return strings.HasPrefix(upper, "AT+CMGS=") && upper != "AT+CMGS=?"
+}
+
+func (s *Simulator) write(lines ...string) {
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+
+	for _, line := range lines {
+		_, err := fmt.Fprintf(s.device, "\r\n%s\r\n", line)
+		if err != nil {
+			log.Printf("cannot write to PEI: %v", err)
+			return
+		}
+	}
+}
+
+func (s *Simulator) handle(ctx context.Context, request string) {
+	request = strings.TrimSpace(request)
+
+	s.stateLock.Lock()
+	echo := s.echo
+	s.stateLock.Unlock()
+	if echo {
+		s.write(strings.Split(request, "\n")[0])
+	}
+
+	response, err := s.respond(ctx, request)
+	if err != nil {
+		s.write(append(response, err.Error())...)
+		return
+	}
+	s.write(append(response, "OK")...)
+}
+
+type commandError string
+
+func (e commandError) Error() string {
+	return string(e)
+}
+
+const (
+	errNotSupported commandError = "+CME ERROR: 4"
+	errInvalidParam commandError = "+CME ERROR: 25"
+)
+
+var (
+	setRoutingRequest   = regexp.MustCompile(`^AT\+CTSP=(\d+),(\d+),(\d+)$`)
+	setAIModeRequest    = regexp.MustCompile(`^AT\+CTOM=(\d+)$`)
+	setTalkgroupRequest = regexp.MustCompile(`^AT\+CTGS=\d+,(\d+)$`)
+	talkgroupsRequest   = regexp.MustCompile(`^AT\+CNUM(S|D)(=\?|\?|=0,\d+,\d+)$`)
+	sendMessageRequest  = regexp.MustCompile(`^AT\+CMGS=(\d+),(\d+)\n([0-9A-F]*)$`)
+)
+
+func (s *Simulator) respond(ctx context.Context, request string) ([]string, error) {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	upper := strings.ToUpper(request)
+	switch {
+	case upper == "AT":
+		return nil, nil
+	case upper == "ATZ":
+		s.echo = true
+		s.routing = nil
+		return nil, nil
+	case upper == "ATE0":
+		s.echo = false
+		return nil, nil
+	case upper == "ATE1":
+		s.echo = true
+		return nil, nil
+	case upper == "ATI":
+		return []string{"tetra-cli simulator", "ISSI " + s.issi}, nil
+	case strings.HasPrefix(upper, "AT+CSCS="):
+		return nil, nil
+	case strings.HasPrefix(upper, "AT+CTSDS="):
+		s.sdsService = strings.TrimPrefix(upper, "AT+CTSDS=")
+		return nil, nil
+	case upper == "AT+CTSP?":
+		response := make([]string, 0, len(s.routing))
+		for _, route := range s.routing {
+			response = append(response, "+CTSP: "+route)
+		}
+		return response, nil
+	case setRoutingRequest.MatchString(upper):
+		route := strings.TrimPrefix(upper, "AT+CTSP=")
+		if !s.routed(route) {
+			s.routing = append(s.routing, route)
+		}
+		return nil, nil
+	case upper == "AT+CTOM?":
+		return []string{fmt.Sprintf("+CTOM: %d", s.aiMode)}, nil
+	case setAIModeRequest.MatchString(upper):
+		mode, _ := strconv.Atoi(setAIModeRequest.FindStringSubmatch(upper)[1])
+		if mode != int(ctrl.TMO) && mode != int(ctrl.DMO) {
+			return nil, errInvalidParam
+		}
+		s.aiMode = ctrl.AIMode(mode)
+		return nil, nil
+	case upper == "AT+CTGS?":
+		return []string{"+CTGS: 1," + s.talkgroup}, nil
+	case setTalkgroupRequest.MatchString(upper):
+		s.talkgroup = setTalkgroupRequest.FindStringSubmatch(upper)[1]
+		return nil, nil
+	case talkgroupsRequest.MatchString(upper):
+		return s.respondTalkgroups(talkgroupsRequest.FindStringSubmatch(upper))
+	case upper == "AT+CBC?":
+		return []string{fmt.Sprintf("+CBC: 0,%d", s.batteryCharge)}, nil
+	case upper == "AT+CSQ?":
+		return []string{fmt.Sprintf("+CSQ: %d,99", s.signalStrength)}, nil
+	case upper == "AT+GPSPOS?":
+		return []string{s.gpsPosition(time.Now().UTC())}, nil
+	case upper == "AT+CMGS=?":
+		return []string{"+CMGS: (0-99999999),(0-2047)"}, nil
+	case sendMessageRequest.MatchString(upper):
+		return s.receiveMessage(ctx, sendMessageRequest.FindStringSubmatch(upper))
+	default:
+		return nil, errNotSupported
+	}
+}
+
+func (s *Simulator) routed(route string) bool {
+	for _, r := range s.routing {
+		if r == route {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Simulator) respondTalkgroups(parts []string) ([]string, error) {
+	var talkgroups []ctrl.TalkgroupInfo
+	switch ctrl.TalkgroupKind(parts[1]) {
+	case ctrl.TalkgroupDynamic:
+		talkgroups = s.tmoTalkgroups
+	case ctrl.TalkgroupStatic:
+		talkgroups = s.dmoTalkgroups
+	}
+
+	switch {
+	case parts[2] == "=?":
+		return []string{fmt.Sprintf("+CNUM%s: (0),(1-%d),(1-%d)", parts[1], len(talkgroups), len(talkgroups))}, nil
+	case parts[2] == "?":
+		response := make([]string, 0, len(talkgroups))
+		for i, info := range talkgroups {
+			response = append(response, fmt.Sprintf("+CNUM%s: %d,%s,%s", parts[1], i+1, info.GTSI, info.Name))
+		}
+		return response, nil
+	default:
+		return nil, nil
+	}
+}
+
+func (s *Simulator) gpsPosition(now time.Time) string {
+	latDirection, lat := "N", s.latitude
+	if lat < 0 {
+		latDirection, lat = "S", -lat
+	}
+	lonDirection, lon := "E", s.longitude
+	if lon < 0 {
+		lonDirection, lon = "W", -lon
+	}
+	latDegrees := int(lat)
+	lonDegrees := int(lon)
+
+	return fmt.Sprintf("+GPSPOS: %s,%s: %02d_%07.4f,%s: %03d_%07.4f,%d",
+		now.Format("15:04:05"),
+		latDirection, latDegrees, (lat-float64(latDegrees))*60,
+		lonDirection, lonDegrees, (lon-float64(lonDegrees))*60,
+		s.satellites)
+}
+
+func (s *Simulator) receiveMessage(ctx context.Context, parts []string) ([]string, error) {
+	destination := tetra.Identity(parts[1])
+	pduBits, _ := strconv.Atoi(parts[2])
+	pdu, err := tetra.HexToBinary(parts[3])
+	if err != nil || len(pdu)*8 < pduBits {
+		return nil, errInvalidParam
+	}
+
+	if service, _, _ := strings.Cut(s.sdsService, ","); service == "13" {
+		log.Printf("status 0x%s sent to %s", parts[3], destination)
+		return []string{"+CMGS: 0"}, nil
+	}
+
+	payload, err := sds.ParseSDSTLPDU(pdu)
+	if err != nil {
+		log.Printf("cannot decode outgoing SDS to %s: %v", destination, err)
+		return []string{"+CMGS: 0"}, nil
+	}
+
+	switch message := payload.(type) {
+	case sds.SimpleTextMessage:
+		log.Printf("simple text message sent to %s: %s", destination, message.Text)
+	case sds.SDSTransfer:
+		log.Printf("SDS-TRANSFER 0x%02x sent to %s: %s", message.MessageReference, destination, transferText(message))
+		go s.acknowledge(ctx, destination, message)
+		return []string{fmt.Sprintf("+CMGS: 0,%d", message.MessageReference)}, nil
+	default:
+		log.Printf("SDS %T sent to %s", message, destination)
+	}
+
+	return []string{"+CMGS: 0"}, nil
+}
+
+func transferText(message sds.SDSTransfer) string {
+	switch sdu := message.UserData.(type) {
+	case sds.TextSDU:
+		return sdu.Text
+	case sds.ConcatenatedTextSDU:
+		return fmt.Sprintf("[%d/%d] %s", sdu.UserDataHeader.SequenceNumber, sdu.UserDataHeader.TotalNumber, sdu.Text)
+	default:
+		return fmt.Sprintf("%v", sdu)
+	}
+}
+
+// acknowledge simulates the delivery reports of the destination.
+func (s *Simulator) acknowledge(ctx context.Context, destination tetra.Identity, message sds.SDSTransfer) {
+	var statuses []sds.DeliveryStatus
+	if message.ReceivedReportRequested() {
+		statuses = append(statuses, sds.ReceiptAckByDestination)
+	}
+	if message.ConsumedReportRequested() {
+		statuses = append(statuses, sds.ConsumedByDestination)
+	}
+
+	for _, status := range statuses {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(reportDelay):
+		}
+
+		report := sds.NewSDSReport(message, false, status)
+		s.write(incomingSDS(sds.SDSTLService, destination, tetra.Identity(s.issi), report)...)
+	}
+}
+
+func incomingSDS(service sds.AIService, source tetra.Identity, destination tetra.Identity, pdu sds.Encoder) []string {
+	bytes, bits := pdu.Encode(make([]byte, 0, 256), 0)
+	return []string{
+		fmt.Sprintf("+CTSDSR: %s,%s,0,%s,0,%d", service, source, destination, bits),
+		tetra.BinaryToHex(bytes),
+	}
+}
+
+// emit writes the given indication lines to the PEI, if the PEI is configured to receive indications.
+func (s *Simulator) emit(lines ...string) {
+	s.stateLock.Lock()
+	connected := len(s.routing) > 0
+	s.stateLock.Unlock()
+	if !connected {
+		return
+	}
+
+	s.write(lines...)
+}
+
+func (s *Simulator) emitEvent(event Event) {
+	s.stateLock.Lock()
+	callsRouted := s.routed("2,0,0")
+	statusRouted := s.routed("2,2,20")
+	textRouted := s.routed("1,3,130") || s.routed("1,3,137")
+	destination := tetra.Identity(s.issi)
+	if event.Kind == AIModeChanged {
+		s.aiMode = event.AIMode
+	}
+	s.stateLock.Unlock()
+
+	switch event.Kind {
+	case TextMessage:
+		if !textRouted {
+			return
+		}
+		transfer := sds.NewTextMessageTransfer(sds.MessageReference(time.Now().Unix()), false, sds.NoReportRequested, sds.ISO8859_1, event.Text)
+		s.emit(incomingSDS(sds.SDSTLService, tetra.Identity(event.Source), destination, transfer)...)
+	case StatusMessage:
+		if !statusRouted {
+			return
+		}
+		s.emit(incomingSDS(sds.StatusService, tetra.Identity(event.Source), destination, event.Status)...)
+	case VoiceTx:
+		if callsRouted {
+			s.emit("+CTXG: 1,1,0,0")
+		}
+	case VoiceRx:
+		if callsRouted {
+			s.emit(fmt.Sprintf("+CTXG: 1,3,0,0,1,%s", event.Source))
+		}
+	case TalkgroupIdle:
+		if callsRouted {
+			s.emit("+CDTXC: 1,0")
+		}
+	case TalkgroupInactive:
+		if callsRouted {
+			s.emit("+CTCR: 1,1")
+		}
+	case AIModeChanged:
+		s.emit(fmt.Sprintf("+CTOM: %d", event.AIMode))
+	}
+}
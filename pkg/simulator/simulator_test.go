@@ -0,0 +1,178 @@
+package simulator
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ftl/tetra-pei/sds"
+	"github.com/ftl/tetra-pei/tetra"
+)
+
+// testPEI is the terminal side of a simulated radio, connected through a pipe.
+type testPEI struct {
+	t     *testing.T
+	conn  net.Conn
+	lines chan string
+}
+
+func serveTestPEI(t *testing.T, config Config) *testPEI {
+	t.Helper()
+	device, terminal := net.Pipe()
+	simulator := New(config)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		simulator.Serve(ctx, device)
+	}()
+
+	result := &testPEI{t: t, conn: terminal, lines: make(chan string, 100)}
+	go func() {
+		defer close(result.lines)
+		scanner := bufio.NewScanner(terminal)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line != "" {
+				result.lines <- line
+			}
+		}
+	}()
+	t.Cleanup(func() {
+		cancel()
+		terminal.Close()
+		<-done
+	})
+	return result
+}
+
+// next returns the next line the simulator writes.
+func (p *testPEI) next(timeout time.Duration) (string, bool) {
+	select {
+	case line, ok := <-p.lines:
+		return line, ok
+	case <-time.After(timeout):
+		return "", false
+	}
+}
+
+// request sends the given request and returns the response lines up to the final result, which is the last line.
+func (p *testPEI) request(request string) []string {
+	p.t.Helper()
+	_, err := p.conn.Write([]byte(request + "\r"))
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	var result []string
+	for {
+		line, ok := p.next(time.Second)
+		if !ok {
+			p.t.Fatalf("%s: no final result after %q", request, result)
+		}
+		result = append(result, line)
+		if line == "OK" || strings.HasPrefix(line, "+CME ERROR") {
+			return result
+		}
+	}
+}
+
+func (p *testPEI) expect(request string, response ...string) {
+	p.t.Helper()
+	got := p.request(request)
+	if strings.Join(got, "|") != strings.Join(response, "|") {
+		p.t.Errorf("%s: expected %q, got %q", request, response, got)
+	}
+}
+
+// expectIndication waits for the given indication and skips all other lines.
+func (p *testPEI) expectIndication(prefix string, timeout time.Duration) string {
+	p.t.Helper()
+	deadline := time.Now().Add(timeout)
+	for {
+		line, ok := p.next(time.Until(deadline))
+		if !ok {
+			p.t.Fatalf("no %s indication", prefix)
+		}
+		if strings.HasPrefix(line, prefix) {
+			return line
+		}
+	}
+}
+
+func TestSimulator_AnswersRequests(t *testing.T) {
+	pei := serveTestPEI(t, Config{ISSI: "2345678"})
+
+	pei.expect("ATI", "ATI", "tetra-cli simulator", "ISSI 2345678", "OK")
+	pei.expect("ATE0", "ATE0", "OK")
+	pei.expect("AT+CTSP?", "OK")
+	pei.expect("AT+CTSP=1,3,130", "OK")
+	pei.expect("AT+CTSP=1,3,130", "OK")
+	pei.expect("AT+CTSP=2,0,0", "OK")
+	pei.expect("AT+CTSP?", "+CTSP: 1,3,130", "+CTSP: 2,0,0", "OK")
+	pei.expect("AT+CTOM?", "+CTOM: 0", "OK")
+	pei.expect("AT+CTOM=1", "OK")
+	pei.expect("AT+CTOM?", "+CTOM: 1", "OK")
+	pei.expect("AT+CTOM=7", "+CME ERROR: 25")
+	pei.expect("AT+CTGS?", "+CTGS: 1,2620001", "OK")
+	pei.expect("AT+CTGS=1,2620002", "OK")
+	pei.expect("AT+CTGS?", "+CTGS: 1,2620002", "OK")
+	pei.expect("AT+CNUMS?", "+CNUMS: 1,2620101,DMO Group 1", "+CNUMS: 2,2620102,DMO Group 2", "OK")
+	pei.expect("AT+CBC?", "+CBC: 0,87", "OK")
+	pei.expect("AT+CSQ?", "+CSQ: 21,99", "OK")
+	pei.expect("AT+CMGS=?", "+CMGS: (0-99999999),(0-2047)", "OK")
+	pei.expect("AT+UNKNOWN", "+CME ERROR: 4")
+}
+
+func TestSimulator_AcceptsMessages(t *testing.T) {
+	pei := serveTestPEI(t, Config{})
+	pei.expect("ATE0", "ATE0", "OK")
+	pei.expect("AT+CTSDS=12,0,0,0,1", "OK")
+
+	transfer := sds.NewTextMessageTransfer(5, false, sds.NoReportRequested, sds.ISO8859_1, "hello")
+	pei.expect(sds.SendMessage("1234567", transfer), "+CMGS: 0,5", "OK")
+
+	simple := sds.NewSimpleTextMessage(false, sds.ISO8859_1, "hello")
+	pei.expect(sds.SendMessage("1234567", simple), "+CMGS: 0", "OK")
+
+	pei.expect("AT+CTSDS=13,0", "OK")
+	pei.expect(sds.SendMessage("1234567", sds.Status(0x8002)), "+CMGS: 0", "OK")
+
+	pei.expect("AT+CMGS=1234567,64\r\nXYZ\x1a", "+CME ERROR: 4")
+	pei.expect("AT+CMGS=1234567,64\r\n0102\x1a", "+CME ERROR: 25")
+}
+
+func TestSimulator_SendsDeliveryReports(t *testing.T) {
+	pei := serveTestPEI(t, Config{ISSI: "2345678"})
+	pei.expect("ATE0", "ATE0", "OK")
+	pei.expect("AT+CTSDS=12,0,0,0,1", "OK")
+
+	transfer := sds.NewTextMessageTransfer(7, false, sds.MessageReceivedReportRequested, sds.ISO8859_1, "hello")
+	pei.expect(sds.SendMessage("1234567", transfer), "+CMGS: 0,7", "OK")
+
+	header := pei.expectIndication("+CTSDSR: ", 3*reportDelay)
+	if !strings.HasPrefix(header, "+CTSDSR: 12,1234567,0,2345678,0,") {
+		t.Errorf("unexpected report header %q", header)
+	}
+	line, _ := pei.next(time.Second)
+	pdu, err := tetra.HexToBinary(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := sds.ParseSDSTLPDU(pdu)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, ok := payload.(sds.SDSReport)
+	if !ok || report.MessageReference != 7 || report.DeliveryStatus != sds.ReceiptAckByDestination {
+		t.Errorf("unexpected report %+v", payload)
+	}
+
+	// no consumed report was requested
+	if line, ok := pei.next(2 * reportDelay); ok {
+		t.Errorf("unexpected line %q", line)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package store
+
+import (
+	"os"
+)
+
+// lockFile is only supported on Unix systems. On other systems, logs should not be compacted while other processes
+// append to them.
+func lockFile(file *os.File, exclusive bool) error {
+	return nil
+}
@@ -0,0 +1,19 @@
+//go:build unix
+
+package store
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// lockFile acquires an advisory lock on the given file, which is released when the file is closed.
+// Appending processes share the lock, compacting a log requires the exclusive lock.
+func lockFile(file *os.File, exclusive bool) error {
+	how := unix.LOCK_SH
+	if exclusive {
+		how = unix.LOCK_EX
+	}
+	return unix.Flock(int(file.Fd()), how)
+}
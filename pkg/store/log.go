@@ -0,0 +1,363 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+// maxLineSize is the maximum size of a single line in a log file.
+const maxLineSize = 1024 * 1024
+
+// minCompactLines is the number of lines a log must have before outdated snapshots are removed automatically.
+const minCompactLines = 1000
+
+// Log is an append-only file with one JSON snapshot of a value per line. Each value is identified by a key;
+// a later snapshot with the same key replaces the earlier ones. Appending complete lines allows several processes
+// to use the same log at the same time. Log is safe for concurrent use.
+//
+// The log keeps the latest snapshots in memory and reads only the lines that were appended since the last access.
+// If more than half of the lines are outdated snapshots, the log is compacted: the file is replaced by a new file
+// with only the latest snapshots.
+type Log[T any] struct {
+	lock sync.Mutex
+	path string
+	key  func(T) string
+
+	// the latest snapshots read from the file
+	file   os.FileInfo
+	offset int64
+	lines  int
+	values []T
+	index  map[string]int
+}
+
+// OpenLog opens the log with the given path. The key function returns the key of a value.
+// The file and its directory are created if necessary.
+func OpenLog[T any](path string, key func(T) string) (*Log[T], error) {
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create directory for %s: %w", path, err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open %s: %w", path, err)
+	}
+	file.Close()
+
+	return &Log[T]{path: path, key: key, index: make(map[string]int)}, nil
+}
+
+// Path returns the path of the log's file.
+func (l *Log[T]) Path() string {
+	return l.path
+}
+
+// Append a snapshot of the given value.
+func (l *Log[T]) Append(value T) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	file, err := l.openCurrent(os.O_APPEND|os.O_CREATE|os.O_WRONLY, false)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// a single write of the complete line keeps the lines of concurrent writers intact
+	_, err = file.Write(data)
+	if err != nil {
+		return fmt.Errorf("cannot write to %s: %w", l.path, err)
+	}
+	return nil
+}
+
+// Modify appends the snapshot that the given function returns for the latest snapshot of the value with the given
+// key; ok is false if there is no value with this key yet. If the function returns false, nothing is appended.
+// Other processes cannot append to the log in the meantime, so the latest snapshot does not change between reading
+// and replacing it. Modify returns the resulting snapshot and if it was appended.
+func (l *Log[T]) Modify(key string, modify func(value T, ok bool) (T, bool)) (T, bool, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	var current T
+	file, err := l.openCurrent(os.O_APPEND|os.O_WRONLY, true)
+	if err != nil {
+		return current, false, err
+	}
+	defer file.Close()
+	err = l.read()
+	if err != nil {
+		return current, false, err
+	}
+
+	i, ok := l.index[key]
+	if ok {
+		current = l.values[i]
+	}
+	value, changed := modify(current, ok)
+	if !changed {
+		return current, false, nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return current, false, err
+	}
+	_, err = file.Write(append(data, '\n'))
+	if err != nil {
+		return current, false, fmt.Errorf("cannot write to %s: %w", l.path, err)
+	}
+	return value, true, nil
+}
+
+// openCurrent opens the log's file and locks it. If another process replaced the file while waiting for the lock,
+// the new file is opened.
+func (l *Log[T]) openCurrent(flag int, exclusive bool) (*os.File, error) {
+	for {
+		file, err := os.OpenFile(l.path, flag, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("cannot open %s: %w", l.path, err)
+		}
+		err = lockFile(file, exclusive)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("cannot lock %s: %w", l.path, err)
+		}
+		opened, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("cannot open %s: %w", l.path, err)
+		}
+		current, err := os.Stat(l.path)
+		if err == nil && os.SameFile(opened, current) {
+			return file, nil
+		}
+		file.Close()
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("cannot open %s: %w", l.path, err)
+		}
+	}
+}
+
+// Load returns the latest snapshot of all values in the order they were first appended.
+func (l *Log[T]) Load() ([]T, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	err := l.update()
+	if err != nil {
+		return nil, err
+	}
+	return slices.Clone(l.values), nil
+}
+
+// Get returns the latest snapshot of the value with the given key.
+func (l *Log[T]) Get(key string) (T, bool, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	var result T
+	err := l.update()
+	if err != nil {
+		return result, false, err
+	}
+	i, ok := l.index[key]
+	if !ok {
+		return result, false, nil
+	}
+	return l.values[i], true, nil
+}
+
+// Compact replaces the log's file with a new file that contains only the latest snapshot of each value that should
+// be kept. If keep is nil, all values are kept.
+func (l *Log[T]) Compact(keep func(T) bool) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return l.compact(keep)
+}
+
+// update reads the lines that were appended since the last update and compacts the log if necessary.
+func (l *Log[T]) update() error {
+	err := l.read()
+	if err != nil {
+		return err
+	}
+	if l.lines < minCompactLines || l.lines <= 2*len(l.values) {
+		return nil
+	}
+	err = l.compact(nil)
+	if err != nil {
+		// the snapshots are still valid, compacting can be tried again later
+		log.Printf("cannot compact %s: %v", l.path, err)
+	}
+	return nil
+}
+
+// read the lines that were appended since the last read. If the file was replaced, it is read again from the start.
+func (l *Log[T]) read() error {
+	file, err := os.Open(l.path)
+	if err != nil {
+		return fmt.Errorf("cannot open %s: %w", l.path, err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("cannot read %s: %w", l.path, err)
+	}
+	if l.file == nil || !os.SameFile(l.file, info) || info.Size() < l.offset {
+		l.reset(info)
+	}
+	if info.Size() == l.offset {
+		return nil
+	}
+	_, err = file.Seek(l.offset, io.SeekStart)
+	if err != nil {
+		return fmt.Errorf("cannot read %s: %w", l.path, err)
+	}
+
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadSlice('\n')
+		size := len(line)
+		if errors.Is(err, bufio.ErrBufferFull) {
+			line, size, err = readLongLine(reader, line)
+		}
+		if errors.Is(err, io.EOF) {
+			// an incomplete line is read again when it is complete
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("cannot read %s: %w", l.path, err)
+		}
+		l.offset += int64(size)
+
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		var value T
+		err = json.Unmarshal(line, &value)
+		if err != nil {
+			// skip invalid lines, e.g. after a crash
+			continue
+		}
+		l.apply(value)
+	}
+}
+
+// readLongLine completes a line that does not fit into the reader's buffer. It also returns the size of the line in
+// the file. A line that exceeds maxLineSize is read completely but returned empty, so that it is skipped like any
+// other invalid line.
+func readLongLine(reader *bufio.Reader, start []byte) ([]byte, int, error) {
+	line := slices.Clone(start)
+	size := len(start)
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		size += len(chunk)
+		if len(line) <= maxLineSize {
+			line = append(line, chunk...)
+		}
+		if errors.Is(err, bufio.ErrBufferFull) {
+			continue
+		}
+		if len(line) > maxLineSize {
+			line = nil
+		}
+		return line, size, err
+	}
+}
+
+func (l *Log[T]) reset(file os.FileInfo) {
+	l.file = file
+	l.offset = 0
+	l.lines = 0
+	l.values = nil
+	l.index = make(map[string]int)
+}
+
+func (l *Log[T]) apply(value T) {
+	l.lines++
+	key := l.key(value)
+	i, ok := l.index[key]
+	if ok {
+		l.values[i] = value
+		return
+	}
+	l.index[key] = len(l.values)
+	l.values = append(l.values, value)
+}
+
+// compact writes the latest snapshots to a new file and replaces the log's file with it. Other processes cannot
+// append to the log while it is compacted.
+func (l *Log[T]) compact(keep func(T) bool) error {
+	file, err := l.openCurrent(os.O_RDONLY, true)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	err = l.read()
+	if err != nil {
+		return err
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(l.path), filepath.Base(l.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("cannot compact %s: %w", l.path, err)
+	}
+	defer os.Remove(temp.Name())
+	defer temp.Close()
+
+	values := l.values
+	if keep != nil {
+		values = slices.DeleteFunc(slices.Clone(values), func(value T) bool { return !keep(value) })
+	}
+	writer := bufio.NewWriter(temp)
+	for _, value := range values {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return fmt.Errorf("cannot compact %s: %w", l.path, err)
+		}
+		writer.Write(data)
+		writer.WriteByte('\n')
+	}
+	err = writer.Flush()
+	if err == nil {
+		err = temp.Sync()
+	}
+	if err != nil {
+		return fmt.Errorf("cannot compact %s: %w", l.path, err)
+	}
+	err = os.Rename(temp.Name(), l.path)
+	if err != nil {
+		return fmt.Errorf("cannot compact %s: %w", l.path, err)
+	}
+
+	info, err := temp.Stat()
+	if err != nil {
+		// the file is read again with the next access
+		l.file = nil
+		return nil
+	}
+	l.reset(info)
+	for _, value := range values {
+		l.apply(value)
+	}
+	l.offset = info.Size()
+	return nil
+}
@@ -0,0 +1,219 @@
+package store
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type testValue struct {
+	Key   string `json:"key"`
+	Value int    `json:"value"`
+}
+
+func openTestLog(t *testing.T, path string) *Log[testValue] {
+	t.Helper()
+	result, err := OpenLog(path, func(value testValue) string { return value.Key })
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func countLines(t *testing.T, path string) int {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.Count(data, []byte("\n"))
+}
+
+func TestLog_ReadsTheLinesOfOtherWriters(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.jsonl")
+	reader := openTestLog(t, path)
+	writer := openTestLog(t, path)
+
+	writer.Append(testValue{"a", 1})
+	writer.Append(testValue{"b", 1})
+	values, err := reader.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 2 {
+		t.Fatalf("expected 2 values, got %v", values)
+	}
+
+	writer.Append(testValue{"a", 2})
+	value, ok, err := reader.Get("a")
+	if err != nil || !ok || value.Value != 2 {
+		t.Errorf("expected the latest snapshot, got %v %t %v", value, ok, err)
+	}
+	values, _ = reader.Load()
+	if len(values) != 2 || values[0] != (testValue{"a", 2}) || values[1] != (testValue{"b", 1}) {
+		t.Errorf("the values must keep the order they were first appended, got %v", values)
+	}
+}
+
+func TestLog_WaitsForIncompleteLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.jsonl")
+	log := openTestLog(t, path)
+	log.Append(testValue{"a", 1})
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	file.WriteString(`{"key": "b", `)
+	values, _ := log.Load()
+	if len(values) != 1 {
+		t.Errorf("the incomplete line must not be read, got %v", values)
+	}
+
+	file.WriteString("\"value\": 1}\n")
+	values, _ = log.Load()
+	if len(values) != 2 {
+		t.Errorf("the completed line must be read, got %v", values)
+	}
+}
+
+func TestLog_SkipsTooLongLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.jsonl")
+	log := openTestLog(t, path)
+	log.Append(testValue{"a", 1})
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	file.WriteString(`{"key": "` + strings.Repeat("b", 64*1024) + `", "value": 1}` + "\n")
+	file.WriteString(`{"key": "` + strings.Repeat("c", 2*maxLineSize) + `", "value": 1}`)
+	values, err := log.Load()
+	if err != nil || len(values) != 2 {
+		t.Errorf("a long line must be read, got %d values %v", len(values), err)
+	}
+
+	file.WriteString("\n")
+	log.Append(testValue{"d", 1})
+	values, err = log.Load()
+	if err != nil || len(values) != 3 || values[2] != (testValue{"d", 1}) {
+		t.Errorf("a line that exceeds %d bytes must be skipped, got %d values %v", maxLineSize, len(values), err)
+	}
+
+	log.Append(testValue{"a", 2})
+	value, ok, err := log.Get("a")
+	if err != nil || !ok || value.Value != 2 {
+		t.Errorf("the lines after a skipped line must be read, got %v %t %v", value, ok, err)
+	}
+}
+
+func TestLog_LoadReturnsACopy(t *testing.T) {
+	log := openTestLog(t, filepath.Join(t.TempDir(), "test.jsonl"))
+	log.Append(testValue{"a", 1})
+
+	values, _ := log.Load()
+	values[0].Value = 42
+
+	value, _, _ := log.Get("a")
+	if value.Value != 1 {
+		t.Error("changing the loaded values must not change the log")
+	}
+}
+
+func TestLog_CompactsOutdatedSnapshots(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.jsonl")
+	log := openTestLog(t, path)
+	other := openTestLog(t, path)
+	other.Load()
+
+	for i := range minCompactLines {
+		log.Append(testValue{"a", i})
+	}
+	log.Append(testValue{"b", 1})
+	values, err := log.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(values) != 2 || values[0].Value != minCompactLines-1 {
+		t.Errorf("unexpected values %v", values)
+	}
+	if lines := countLines(t, path); lines != 2 {
+		t.Errorf("expected 2 lines after compacting, got %d", lines)
+	}
+
+	other.Append(testValue{"c", 1})
+	values, _ = other.Load()
+	if len(values) != 3 || values[0].Value != minCompactLines-1 {
+		t.Errorf("the other log must read the compacted file, got %v", values)
+	}
+}
+
+func TestLog_Compact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.jsonl")
+	log := openTestLog(t, path)
+	log.Append(testValue{"a", 1})
+	log.Append(testValue{"b", 2})
+	log.Append(testValue{"a", 3})
+
+	err := log.Compact(func(value testValue) bool { return value.Key != "b" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	log.Append(testValue{"c", 4})
+
+	values, _ := log.Load()
+	if len(values) != 2 || values[0] != (testValue{"a", 3}) || values[1] != (testValue{"c", 4}) {
+		t.Errorf("unexpected values %v", values)
+	}
+	if lines := countLines(t, path); lines != 2 {
+		t.Errorf("expected 2 lines, got %d", lines)
+	}
+	if matches, _ := filepath.Glob(path + ".*"); len(matches) != 0 {
+		t.Errorf("temporary files are left: %v", matches)
+	}
+}
+
+func TestLog_ModifyUsesTheLatestSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.jsonl")
+	var logs []*Log[testValue]
+	for range 4 {
+		logs = append(logs, openTestLog(t, path))
+	}
+	increment := func(value testValue, ok bool) (testValue, bool) {
+		return testValue{"a", value.Value + 1}, true
+	}
+
+	var wg sync.WaitGroup
+	for _, l := range logs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 25 {
+				_, _, err := l.Modify("a", increment)
+				if err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	value, _, _ := logs[0].Get("a")
+	if value.Value != 100 {
+		t.Errorf("no increment may get lost, got %d", value.Value)
+	}
+
+	value, modified, err := logs[1].Modify("a", func(value testValue, ok bool) (testValue, bool) { return value, false })
+	if err != nil || modified || value.Value != 100 {
+		t.Errorf("expected the unchanged snapshot, got %v %t %v", value, modified, err)
+	}
+	if lines := countLines(t, path); lines != 100 {
+		t.Errorf("an unchanged value must not be appended, got %d lines", lines)
+	}
+}
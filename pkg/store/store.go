@@ -0,0 +1,308 @@
+// Package store records incoming and outgoing SDS text and status messages on disk.
+//
+// The store is an append-only file with one JSON object per line. Each line contains a complete snapshot of a
+// message; a later snapshot with the same ID replaces the earlier ones, e.g. when the delivery state of an
+// outgoing message changes. Appending complete lines allows several processes to use the same store
+// at the same time. The outdated snapshots are removed from time to time, see Log.
+package store
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ftl/tetra-pei/sds"
+
+	"github.com/ftl/tetra-cli/pkg/events"
+)
+
+// Direction of a message.
+type Direction string
+
+// All directions.
+const (
+	Incoming Direction = "in"
+	Outgoing Direction = "out"
+)
+
+// MessageType is the type of a message.
+type MessageType string
+
+// All message types.
+const (
+	TextMessage   MessageType = "text"
+	StatusMessage MessageType = "status"
+)
+
+// Message is a recorded text or status message.
+type Message struct {
+	ID               string      `json:"id"`
+	Time             time.Time   `json:"time"`
+	Direction        Direction   `json:"direction"`
+	Type             MessageType `json:"type"`
+	Source           string      `json:"source,omitempty"`
+	Destination      string      `json:"destination,omitempty"`
+	Group            bool        `json:"group,omitempty"`
+	Text             string      `json:"text,omitempty"`
+	Status           string      `json:"status,omitempty"`
+	MessageReference int         `json:"message_reference,omitempty"`
+	State            string      `json:"state,omitempty"`
+	Updated          time.Time   `json:"updated,omitzero"`
+}
+
+// Involves indicates if the given identity is the source or the destination of this message. The identity may be
+// the ISSI of a radio, while the message contains its full ITSI, and vice versa.
+func (m Message) Involves(identity string) bool {
+	return SameIdentity(m.Source, identity) || SameIdentity(m.Destination, identity)
+}
+
+// MaxSSIDigits is the maximum number of digits of a short subscriber identity (ISSI or GSSI). Longer identities are
+// TETRA subscriber identities (ITSI or GTSI), which end with the SSI.
+const MaxSSIDigits = 8
+
+// SSI returns the short subscriber identity of the given identity without leading zeros. For an ITSI or GTSI, this
+// is the SSI part in the last 8 digits.
+func SSI(identity string) string {
+	identity = strings.TrimSpace(identity)
+	if len(identity) > MaxSSIDigits {
+		identity = identity[len(identity)-MaxSSIDigits:]
+	}
+	identity = strings.TrimLeft(identity, "0")
+	if identity == "" {
+		return "0"
+	}
+	return identity
+}
+
+// SameIdentity indicates if the given identities belong to the same radio or group. One identity may be the full
+// ITSI, while the other one is only the ISSI; then the ISSI is compared with the SSI part of the ITSI.
+func SameIdentity(a, b string) bool {
+	a = strings.TrimSpace(a)
+	b = strings.TrimSpace(b)
+	switch {
+	case a == "" || b == "":
+		return false
+	case a == b:
+		return true
+	case !IsNumeric(a) || !IsNumeric(b):
+		return false
+	case len(a) > MaxSSIDigits && len(b) > MaxSSIDigits:
+		// two ITSIs of different networks may have the same SSI
+		return false
+	default:
+		return SSI(a) == SSI(b)
+	}
+}
+
+// IsNumeric indicates if the given value is not empty and consists only of the digits 0-9.
+func IsNumeric(value string) bool {
+	return value != "" && strings.Trim(value, "0123456789") == ""
+}
+
+// IncomingMessage returns the record of the given event, if it is a text or status message.
+func IncomingMessage(event events.Event) (Message, bool) {
+	switch e := event.(type) {
+	case events.TextMessage:
+		return Message{
+			Time:        e.Time,
+			Direction:   Incoming,
+			Type:        TextMessage,
+			Source:      string(e.Source),
+			Destination: string(e.Destination),
+			Text:        e.Text,
+		}, true
+	case events.StatusMessage:
+		return Message{
+			Time:        e.Time,
+			Direction:   Incoming,
+			Type:        StatusMessage,
+			Source:      string(e.Source),
+			Destination: string(e.Destination),
+			Status:      FormatStatus(e.Status),
+		}, true
+	default:
+		return Message{}, false
+	}
+}
+
+// FormatStatus returns the given status in the format used by the store.
+func FormatStatus(status sds.Status) string {
+	return events.FormatStatus(status)
+}
+
+// DefaultPath returns the default path of the message store. It is located in $XDG_DATA_HOME/tetra-cli,
+// or in ~/.local/share/tetra-cli, if $XDG_DATA_HOME is not set.
+func DefaultPath() string {
+	return filepath.Join(DataDir(), "messages.jsonl")
+}
+
+// DataDir returns the directory where tetra-cli keeps its data.
+func DataDir() string {
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if dataHome == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			home = os.TempDir()
+		}
+		dataHome = filepath.Join(home, ".local", "share")
+	}
+	return filepath.Join(dataHome, "tetra-cli")
+}
+
+// ConfigDir returns the directory where tetra-cli looks for its configuration files.
+func ConfigDir() string {
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			home = os.TempDir()
+		}
+		configHome = filepath.Join(home, ".config")
+	}
+	return filepath.Join(configHome, "tetra-cli")
+}
+
+// Store records messages in a file. It is safe for concurrent use.
+type Store struct {
+	log *Log[Message]
+}
+
+// Open the store with the given path. The file and its directory are created if necessary.
+func Open(path string) (*Store, error) {
+	messageLog, err := OpenLog(path, func(message Message) string { return message.ID })
+	if err != nil {
+		return nil, fmt.Errorf("cannot open message store: %w", err)
+	}
+	return &Store{log: messageLog}, nil
+}
+
+// Path returns the path of the store's file.
+func (s *Store) Path() string {
+	return s.log.Path()
+}
+
+// Add the given message to the store. If the message has no ID or no time, they are set.
+// The stored message is returned.
+func (s *Store) Add(message Message) (Message, error) {
+	if message.Time.IsZero() {
+		message.Time = time.Now()
+	}
+	if message.ID == "" {
+		message.ID = newID(message.Time)
+	}
+	return message, s.append(message)
+}
+
+// Update the given message. The message is stored as new snapshot that replaces the previous one.
+func (s *Store) Update(message Message) error {
+	if message.ID == "" {
+		return fmt.Errorf("cannot update a message without ID")
+	}
+	message.Updated = time.Now()
+	return s.append(message)
+}
+
+func (s *Store) append(message Message) error {
+	err := s.log.Append(message)
+	if err != nil {
+		return fmt.Errorf("cannot write to message store: %w", err)
+	}
+	return nil
+}
+
+// Record adds the text and status messages of the given events to the store. It can be used as subscriber of
+// an events.Listener.
+func (s *Store) Record(event events.Event) {
+	message, ok := IncomingMessage(event)
+	if !ok {
+		return
+	}
+	_, err := s.Add(message)
+	if err != nil {
+		log.Printf("cannot record incoming %s: %v", message.Type, err)
+	}
+}
+
+// Messages returns the latest snapshot of all messages in the order they were added.
+func (s *Store) Messages() ([]Message, error) {
+	result, err := s.log.Load()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read message store: %w", err)
+	}
+	return result, nil
+}
+
+// Message returns the latest snapshot of the message with the given ID.
+func (s *Store) Message(id string) (Message, bool, error) {
+	result, ok, err := s.log.Get(id)
+	if err != nil {
+		return Message{}, false, fmt.Errorf("cannot read message store: %w", err)
+	}
+	return result, ok, nil
+}
+
+// Query defines the criteria to select messages. Empty criteria match all messages.
+type Query struct {
+	// ISSI selects messages from or to the given ISSI or ITSI.
+	ISSI string
+	// From selects messages at or after the given time.
+	From time.Time
+	// To selects messages before the given time.
+	To time.Time
+	// Type selects messages of the given type.
+	Type MessageType
+	// Direction selects incoming or outgoing messages.
+	Direction Direction
+	// Text selects messages that contain the given text, ignoring case.
+	Text string
+	// Limit selects only the given number of the latest messages.
+	Limit int
+}
+
+// Matches indicates if the given message matches this query.
+func (q Query) Matches(message Message) bool {
+	switch {
+	case q.ISSI != "" && !message.Involves(q.ISSI):
+		return false
+	case !q.From.IsZero() && message.Time.Before(q.From):
+		return false
+	case !q.To.IsZero() && !message.Time.Before(q.To):
+		return false
+	case q.Type != "" && message.Type != q.Type:
+		return false
+	case q.Direction != "" && message.Direction != q.Direction:
+		return false
+	case q.Text != "" && !strings.Contains(strings.ToLower(message.Text), strings.ToLower(q.Text)):
+		return false
+	default:
+		return true
+	}
+}
+
+// Query returns the messages that match the given query, sorted by time.
+func (s *Store) Query(query Query) ([]Message, error) {
+	messages, err := s.Messages()
+	if err != nil {
+		return nil, err
+	}
+
+	result := slices.DeleteFunc(messages, func(message Message) bool {
+		return !query.Matches(message)
+	})
+	slices.SortStableFunc(result, func(a, b Message) int {
+		return a.Time.Compare(b.Time)
+	})
+	if query.Limit > 0 && len(result) > query.Limit {
+		result = result[len(result)-query.Limit:]
+	}
+	return result, nil
+}
+
+func newID(t time.Time) string {
+	return fmt.Sprintf("%s-%04x", t.UTC().Format("20060102150405.000000000"), rand.Intn(0x10000))
+}
@@ -0,0 +1,67 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSameIdentity(t *testing.T) {
+	tests := []struct {
+		a, b  string
+		valid bool
+	}{
+		{"1234567", "1234567", true},
+		{"1234567", "01234567", true},
+		{"2620010001234567", "1234567", true},
+		{"1234567", "2620010001234567", true},
+		{"1234", "51234", false},
+		{"51234", "1234", false},
+		{"2620010001234567", "234567", false},
+		{"2620010001234567", "2620020001234567", false},
+		{"", "", false},
+		{"Operations", "Operations", true},
+	}
+	for _, tt := range tests {
+		if got := SameIdentity(tt.a, tt.b); got != tt.valid {
+			t.Errorf("%q, %q: expected %t, got %t", tt.a, tt.b, tt.valid, got)
+		}
+	}
+}
+
+func TestStore_QueryByISSIFindsTheITSI(t *testing.T) {
+	store, err := Open(filepath.Join(t.TempDir(), "messages.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Add(Message{Direction: Incoming, Type: TextMessage, Source: "2620010001234567", Text: "by ITSI"})
+	store.Add(Message{Direction: Outgoing, Type: TextMessage, Destination: "1234567", Text: "by ISSI"})
+	store.Add(Message{Direction: Incoming, Type: TextMessage, Source: "51234567", Text: "other"})
+
+	for _, issi := range []string{"1234567", "2620010001234567"} {
+		messages, err := store.Query(Query{ISSI: issi})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(messages) != 2 || messages[0].Text != "by ITSI" || messages[1].Text != "by ISSI" {
+			t.Errorf("%s: unexpected messages %+v", issi, messages)
+		}
+	}
+}
+
+func TestStore_Message(t *testing.T) {
+	store, err := Open(filepath.Join(t.TempDir(), "messages.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	message, _ := store.Add(Message{Direction: Outgoing, Type: TextMessage, Destination: "1234567", State: "sent"})
+	message.State = "consumed"
+	store.Update(message)
+
+	got, ok, err := store.Message(message.ID)
+	if err != nil || !ok {
+		t.Fatalf("the message was not found: %v", err)
+	}
+	if got.State != "consumed" {
+		t.Errorf("expected the latest snapshot, got %+v", got)
+	}
+}
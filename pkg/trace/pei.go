@@ -0,0 +1,100 @@
+package trace
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/ftl/tetra-cli/pkg/radio"
+)
+
+// PEI traces all communication with the wrapped PEI.
+type PEI struct {
+	pei    radio.PEI
+	writer *Writer
+
+	lock               sync.Mutex
+	disconnectCallback func()
+}
+
+var _ radio.PEI = (*PEI)(nil)
+
+// Wrap the given PEI, so that all its communication is traced into the given writer. The writer is not closed
+// with the PEI, so that it can be shared by the sessions of several connections.
+func Wrap(pei radio.PEI, writer *Writer) *PEI {
+	writer.Write(Note, SessionStart)
+	result := &PEI{
+		pei:    pei,
+		writer: writer,
+	}
+	pei.OnDisconnect(result.disconnected)
+	return result
+}
+
+// Close the wrapped PEI and end the session in the trace.
+func (p *PEI) Close() {
+	p.pei.Close()
+	p.writer.Write(Note, SessionEnd)
+}
+
+func (p *PEI) Closed() bool {
+	return p.pei.Closed()
+}
+
+func (p *PEI) WaitUntilClosed(ctx context.Context) {
+	p.pei.WaitUntilClosed(ctx)
+}
+
+func (p *PEI) OnDisconnect(callback func()) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.disconnectCallback = callback
+}
+
+// disconnected notes the lost connection in the trace, whether or not a disconnect callback is set.
+func (p *PEI) disconnected() {
+	p.writer.Write(Note, Disconnected)
+
+	p.lock.Lock()
+	callback := p.disconnectCallback
+	p.lock.Unlock()
+	if callback != nil {
+		callback()
+	}
+}
+
+func (p *PEI) AddIndication(prefix string, trailingLines int, handler func(lines []string)) error {
+	return p.pei.AddIndication(prefix, trailingLines, func(lines []string) {
+		p.writer.Write(Indication, lines...)
+		handler(lines)
+	})
+}
+
+func (p *PEI) ClearSyntaxErrors(ctx context.Context) error {
+	return p.pei.ClearSyntaxErrors(ctx)
+}
+
+func (p *PEI) Request(ctx context.Context, request string) ([]string, error) {
+	return p.AT(ctx, request)
+}
+
+func (p *PEI) AT(ctx context.Context, request string) ([]string, error) {
+	p.writer.Write(Request, request)
+	response, err := p.pei.AT(ctx, request)
+	if err != nil {
+		p.writer.Write(Error, err.Error())
+	} else {
+		p.writer.Write(Response, response...)
+	}
+	return response, err
+}
+
+func (p *PEI) ATs(ctx context.Context, requests ...string) error {
+	for _, request := range requests {
+		_, err := p.AT(ctx, request)
+		if err != nil {
+			return fmt.Errorf("%s failed: %w", request, err)
+		}
+	}
+	return nil
+}
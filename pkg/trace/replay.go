@@ -0,0 +1,87 @@
+package trace
+
+import (
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/ftl/tetra-cli/pkg/fakepei"
+)
+
+// Replay returns a PEI that plays back the given session. Requests are answered with the recorded responses
+// in the recorded order, indications are emitted with the recorded delays after the preceding request.
+//
+// Replaying is lenient: a request that differs from the recorded one only in its parameters
+// (e.g. a different message reference) is answered like the recorded request. Requests that were not
+// recorded at all fail with fakepei.ErrUnexpectedRequest and do not advance the replay. Indications that were
+// recorded before the first request are dropped, because no handler can be registered at that time.
+func Replay(session Session) *fakepei.PEI {
+	var exchanges []fakepei.Exchange
+	var current *fakepei.Exchange
+	var lastRecord Record
+
+	for _, record := range session {
+		switch record.Direction {
+		case Request:
+			if current != nil {
+				exchanges = append(exchanges, *current)
+			}
+			current = newReplayExchange(strings.Join(record.Lines, ""))
+			lastRecord = record
+		case Response:
+			if current == nil {
+				continue
+			}
+			current.Response = record.Lines
+			lastRecord = record
+		case Error:
+			if current == nil {
+				continue
+			}
+			current.Err = errors.New(strings.Join(record.Lines, " "))
+			lastRecord = record
+		case Indication:
+			if current == nil {
+				log.Printf("replay: dropping indication before the first request: %q", record.Lines)
+				continue
+			}
+			delay := max(record.Time.Sub(lastRecord.Time), 0)
+			current.Indications = append(current.Indications, fakepei.Indication{
+				Delay: delay,
+				Lines: record.Lines,
+			})
+			lastRecord = record
+		}
+	}
+	if current != nil {
+		exchanges = append(exchanges, *current)
+	}
+
+	return fakepei.New(exchanges...)
+}
+
+func newReplayExchange(request string) *fakepei.Exchange {
+	recordedCommand := commandName(request)
+	return &fakepei.Exchange{
+		Request: request,
+		Match: func(actual string) bool {
+			if actual == request {
+				return true
+			}
+			if commandName(actual) != recordedCommand {
+				return false
+			}
+			log.Printf("replay: request %q differs from recorded request %q", actual, request)
+			return true
+		},
+	}
+}
+
+// commandName returns the name of the AT command in the given request, without any parameters.
+func commandName(request string) string {
+	end := strings.IndexAny(request, "=?\r\n")
+	if end == -1 {
+		return strings.ToUpper(request)
+	}
+	return strings.ToUpper(request[:end])
+}
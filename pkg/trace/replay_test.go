@@ -0,0 +1,64 @@
+package trace
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ftl/tetra-cli/pkg/fakepei"
+)
+
+const replayTrace = `2026-01-02T15:04:05Z # "SESSION START"
+2026-01-02T15:04:05.1Z > "ATZ"
+2026-01-02T15:04:05.2Z <
+2026-01-02T15:04:05.3Z > "AT+CTOM?"
+2026-01-02T15:04:05.4Z < "+CTOM: 0"
+`
+
+func readReplay(t *testing.T) *fakepei.PEI {
+	t.Helper()
+	sessions, err := Read(strings.NewReader(replayTrace))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 {
+		t.Fatalf("expected one session, got %d", len(sessions))
+	}
+	return Replay(sessions[0])
+}
+
+func TestReplay(t *testing.T) {
+	pei := readReplay(t)
+
+	_, err := pei.AT(context.Background(), "ATZ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := pei.AT(context.Background(), "AT+CTOM?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(response) != 1 || response[0] != "+CTOM: 0" {
+		t.Errorf("unexpected response %v", response)
+	}
+	if err := pei.Verify(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReplay_FailsUnrecordedRequests(t *testing.T) {
+	pei := readReplay(t)
+
+	_, err := pei.AT(context.Background(), "AT+CSQ?")
+	if !errors.Is(err, fakepei.ErrUnexpectedRequest) {
+		t.Errorf("expected ErrUnexpectedRequest, got %v", err)
+	}
+	_, err = pei.AT(context.Background(), "ATZ")
+	if err != nil {
+		t.Errorf("the unrecorded request must not advance the replay: %v", err)
+	}
+	if err := pei.Verify(); err == nil {
+		t.Error("Verify should report the unrecorded request")
+	}
+}
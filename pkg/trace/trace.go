@@ -0,0 +1,180 @@
+// Package trace records the communication with a PEI device in a structured, timestamped format and
+// allows to replay a recorded session.
+//
+// Each record is written as one line: the timestamp in RFC 3339 format with nanoseconds, a direction
+// marker, and the quoted lines of the record, separated by spaces:
+//
+//	2026-01-02T15:04:05.123456789Z > "AT+CTOM?"
+//	2026-01-02T15:04:05.234567891Z < "+CTOM: 0"
+//	2026-01-02T15:04:06.345678912Z * "+CTSDSR: 12,1234567,0,7654321,0,112" "8200010D..."
+package trace
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Direction marks the direction and the kind of a record.
+type Direction string
+
+// All direction markers.
+const (
+	// Request is sent to the PEI device.
+	Request Direction = ">"
+	// Response is received from the PEI device and completed with OK.
+	Response Direction = "<"
+	// Error is received from the PEI device instead of a response.
+	Error Direction = "!"
+	// Indication is received unsolicited from the PEI device.
+	Indication Direction = "*"
+	// Note is a comment, e.g. the start of a session.
+	Note Direction = "#"
+)
+
+// Notes that structure a trace.
+const (
+	SessionStart = "SESSION START"
+	SessionEnd   = "SESSION END"
+	Disconnected = "DISCONNECTED"
+)
+
+const timestampLayout = time.RFC3339Nano
+
+// Record is one entry in a trace.
+type Record struct {
+	Time      time.Time
+	Direction Direction
+	Lines     []string
+}
+
+func (r Record) String() string {
+	var builder strings.Builder
+	builder.WriteString(r.Time.UTC().Format(timestampLayout))
+	builder.WriteString(" ")
+	builder.WriteString(string(r.Direction))
+	for _, line := range r.Lines {
+		builder.WriteString(" ")
+		builder.WriteString(strconv.Quote(line))
+	}
+	return builder.String()
+}
+
+// ParseRecord parses a single line of a trace.
+func ParseRecord(line string) (Record, error) {
+	timestamp, rest, found := strings.Cut(line, " ")
+	if !found {
+		return Record{}, fmt.Errorf("missing direction: %s", line)
+	}
+	var result Record
+	var err error
+	result.Time, err = time.Parse(timestampLayout, timestamp)
+	if err != nil {
+		return Record{}, fmt.Errorf("invalid timestamp: %w", err)
+	}
+
+	direction, rest, _ := strings.Cut(rest, " ")
+	result.Direction = Direction(direction)
+	switch result.Direction {
+	case Request, Response, Error, Indication, Note:
+	default:
+		return Record{}, fmt.Errorf("invalid direction %q", direction)
+	}
+
+	for rest != "" {
+		quoted, err := strconv.QuotedPrefix(rest)
+		if err != nil {
+			return Record{}, fmt.Errorf("invalid line: %w", err)
+		}
+		unquoted, _ := strconv.Unquote(quoted)
+		result.Lines = append(result.Lines, unquoted)
+		rest = strings.TrimLeft(rest[len(quoted):], " ")
+	}
+
+	return result, nil
+}
+
+// Writer writes records to an underlying io.Writer. It is safe for concurrent use.
+type Writer struct {
+	lock sync.Mutex
+	w    io.Writer
+	now  func() time.Time
+}
+
+// NewWriter returns a new Writer that writes to the given io.Writer.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{
+		w:   w,
+		now: time.Now,
+	}
+}
+
+// Write a new record with the given direction and lines, using the current time.
+func (w *Writer) Write(direction Direction, lines ...string) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	record := Record{
+		Time:      w.now(),
+		Direction: direction,
+		Lines:     lines,
+	}
+	_, err := fmt.Fprintln(w.w, record.String())
+	return err
+}
+
+// Close the underlying io.Writer, if it implements io.Closer. All following records are discarded.
+func (w *Writer) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	closer, ok := w.w.(io.Closer)
+	w.w = io.Discard
+	if !ok {
+		return nil
+	}
+	return closer.Close()
+}
+
+// Session contains the records of one session, starting with the SESSION START note.
+type Session []Record
+
+// Read all sessions from the given trace. Records that are written before the first SESSION START note
+// are collected in a separate session.
+func Read(r io.Reader) ([]Session, error) {
+	var result []Session
+	var current Session
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		record, err := ParseRecord(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+
+		if record.Direction == Note && len(record.Lines) > 0 && record.Lines[0] == SessionStart && len(current) > 0 {
+			result = append(result, current)
+			current = nil
+		}
+		current = append(current, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(current) > 0 {
+		result = append(result, current)
+	}
+
+	return result, nil
+}
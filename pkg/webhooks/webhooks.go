@@ -0,0 +1,544 @@
+// Package webhooks posts events as JSON to HTTP endpoints. Each webhook may filter the events and sign the requests
+// with HMAC-SHA256. Deliveries that fail for a temporary reason are kept in a persistent queue and retried with an
+// increasing delay until they expire.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/ftl/tetra-cli/pkg/events"
+	"github.com/ftl/tetra-cli/pkg/store"
+)
+
+// Default parameters for the delivery of webhooks.
+const (
+	DefaultTimeout         = 10 * time.Second
+	DefaultRetryBackoff    = 5 * time.Second
+	DefaultRetryMaxBackoff = 5 * time.Minute
+	DefaultRetryMaxAge     = 24 * time.Hour
+	DefaultRetryInterval   = 5 * time.Second
+)
+
+// The HTTP headers of a webhook request.
+const (
+	EventHeader     = "X-Tetra-Event"
+	DeliveryHeader  = "X-Tetra-Delivery"
+	SignatureHeader = "X-Tetra-Signature"
+)
+
+// freshDeliveries is the number of new deliveries per webhook that wait for their first attempt. Further deliveries
+// are attempted with the retries.
+const freshDeliveries = 100
+
+// compactThreshold is the number of delivered, failed and expired deliveries in the queue file that are removed
+// together.
+const compactThreshold = 100
+
+// DefaultConfigPath returns the default path of the webhooks configuration.
+func DefaultConfigPath() string {
+	return filepath.Join(store.ConfigDir(), "webhooks.yaml")
+}
+
+// DefaultQueuePath returns the default path of the webhooks retry queue, next to the message store.
+func DefaultQueuePath() string {
+	return filepath.Join(store.DataDir(), "webhooks.jsonl")
+}
+
+// Webhook is an HTTP endpoint that receives the events as JSON.
+type Webhook struct {
+	URL string `json:"url" yaml:"url"`
+	// Secret is the key for the HMAC-SHA256 signature of the request body. Without a secret, the requests are not signed.
+	Secret string `json:"secret,omitempty" yaml:"secret,omitempty"`
+	// Events are the kinds of events that are posted to the URL. If it is empty, all events are posted.
+	Events []events.Kind `json:"events,omitempty" yaml:"events,omitempty"`
+	// Headers are additional HTTP headers of the requests, e.g. for authorization.
+	Headers map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`
+}
+
+// Matches indicates that events of the given kind are posted to this webhook.
+func (w Webhook) Matches(kind events.Kind) bool {
+	return len(w.Events) == 0 || slices.Contains(w.Events, kind)
+}
+
+// Config defines the webhooks and how failed deliveries are retried.
+type Config struct {
+	Webhooks []Webhook `json:"webhooks" yaml:"webhooks"`
+	// Timeout is the maximum time for a single request.
+	Timeout time.Duration `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+	// RetryBackoff is the time to wait before the first retry, doubled with every attempt.
+	RetryBackoff time.Duration `json:"retry_backoff,omitempty" yaml:"retry_backoff,omitempty"`
+	// RetryMaxBackoff is the maximum time to wait between two attempts.
+	RetryMaxBackoff time.Duration `json:"retry_max_backoff,omitempty" yaml:"retry_max_backoff,omitempty"`
+	// RetryMaxAge is the maximum time to retry a delivery.
+	RetryMaxAge time.Duration `json:"retry_max_age,omitempty" yaml:"retry_max_age,omitempty"`
+}
+
+// LoadConfig reads the webhooks configuration from the given YAML or JSON file. If the file does not exist, there
+// are no webhooks. Missing retry parameters are set to their defaults. Example:
+//
+//	webhooks:
+//	  - url: https://ims.example.com/tetra
+//	    secret: s3cr3t
+//	    events: [message, status]
+//	retry_max_age: 1h
+func LoadConfig(path string) (Config, error) {
+	result := Config{
+		Timeout:         DefaultTimeout,
+		RetryBackoff:    DefaultRetryBackoff,
+		RetryMaxBackoff: DefaultRetryMaxBackoff,
+		RetryMaxAge:     DefaultRetryMaxAge,
+	}
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return result, nil
+	}
+	if err != nil {
+		return Config{}, fmt.Errorf("cannot read the webhooks: %w", err)
+	}
+
+	// JSON is a subset of YAML, so both formats are read the same way.
+	err = yaml.Unmarshal(content, &result)
+	if err != nil {
+		return Config{}, fmt.Errorf("cannot read the webhooks from %s: %w", path, err)
+	}
+	for i, webhook := range result.Webhooks {
+		err = validateWebhook(webhook)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid webhook #%d in %s: %w", i+1, path, err)
+		}
+	}
+	return result, nil
+}
+
+func validateWebhook(webhook Webhook) error {
+	target, err := url.Parse(webhook.URL)
+	if err != nil {
+		return fmt.Errorf("invalid URL %q: %w", webhook.URL, err)
+	}
+	if (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return fmt.Errorf("invalid URL %q, use an http or https URL", webhook.URL)
+	}
+	for _, kind := range webhook.Events {
+		if !slices.Contains(events.Kinds, kind) {
+			return fmt.Errorf("unknown event %q for %s", kind, webhook.URL)
+		}
+	}
+	return nil
+}
+
+// DeliveryState is the state of a delivery.
+type DeliveryState string
+
+// All delivery states.
+const (
+	// Pending means that the delivery waits for its next attempt.
+	Pending DeliveryState = "pending"
+	// Delivered means that the webhook accepted the event.
+	Delivered DeliveryState = "delivered"
+	// Failed means that the webhook rejected the event, e.g. because the request was not authorized.
+	Failed DeliveryState = "failed"
+	// Expired means that the event could not be delivered within the maximum age.
+	Expired DeliveryState = "expired"
+)
+
+// Delivery is an event that is posted to a webhook.
+type Delivery struct {
+	ID          string          `json:"id"`
+	Created     time.Time       `json:"created"`
+	URL         string          `json:"url"`
+	Event       events.Kind     `json:"event"`
+	Payload     json.RawMessage `json:"payload"`
+	Expires     time.Time       `json:"expires"`
+	State       DeliveryState   `json:"state"`
+	Attempts    int             `json:"attempts"`
+	NextAttempt time.Time       `json:"next_attempt,omitzero"`
+	LastError   string          `json:"last_error,omitempty"`
+	Updated     time.Time       `json:"updated,omitzero"`
+}
+
+// Dispatcher posts the events to the webhooks and retries failed deliveries. Each webhook URL has its own worker, so
+// that a slow or unreachable webhook does not delay the others.
+type Dispatcher struct {
+	config  Config
+	client  *http.Client
+	queue   *store.Log[Delivery]
+	workers map[string]*worker
+
+	lock     sync.Mutex
+	inFlight map[string]bool
+	// pending keeps the deliveries that wait for a retry if there is no persistent queue
+	pending map[string]Delivery
+	// queued is the number of deliveries in the queue file when it was read the last time
+	queued int
+}
+
+// worker attempts the deliveries to one webhook URL, one after the other.
+type worker struct {
+	fresh   chan Delivery
+	retries chan []Delivery
+}
+
+// NewDispatcher returns a new dispatcher for the given configuration. The deliveries that wait for a retry are kept
+// in the queue file with the given path. If the path is empty, they are only kept in memory.
+func NewDispatcher(config Config, queuePath string) (*Dispatcher, error) {
+	result := &Dispatcher{
+		config:   config,
+		client:   &http.Client{Timeout: config.Timeout},
+		workers:  make(map[string]*worker),
+		inFlight: make(map[string]bool),
+		pending:  make(map[string]Delivery),
+	}
+	for _, webhook := range config.Webhooks {
+		result.workers[webhook.URL] = &worker{
+			fresh:   make(chan Delivery, freshDeliveries),
+			retries: make(chan []Delivery, 1),
+		}
+	}
+	if queuePath != "" {
+		queue, err := store.OpenLog(queuePath, func(delivery Delivery) string { return delivery.ID })
+		if err != nil {
+			return nil, fmt.Errorf("cannot open the webhooks queue: %w", err)
+		}
+		result.queue = queue
+	}
+	return result, nil
+}
+
+// Handle creates a delivery of the given event for each matching webhook. Handle never blocks on the webhooks,
+// the deliveries are attempted by Run.
+func (d *Dispatcher) Handle(record events.Record) {
+	payload, err := json.Marshal(record)
+	if err != nil {
+		log.Printf("cannot marshal the %s event for the webhooks: %v", record.Type, err)
+		return
+	}
+
+	now := time.Now()
+	for _, webhook := range d.config.Webhooks {
+		if !webhook.Matches(record.Type) {
+			continue
+		}
+		delivery := Delivery{
+			ID:          fmt.Sprintf("%s-%04x", now.UTC().Format("20060102150405.000000"), rand.Intn(0x10000)),
+			Created:     now,
+			URL:         webhook.URL,
+			Event:       record.Type,
+			Payload:     payload,
+			Expires:     now.Add(d.config.RetryMaxAge),
+			State:       Pending,
+			NextAttempt: now,
+		}
+
+		// the delivery is in flight before it is saved, so that a concurrent retry does not attempt it, too
+		d.lock.Lock()
+		d.inFlight[delivery.ID] = true
+		d.lock.Unlock()
+
+		err := d.save(delivery)
+		if err != nil {
+			log.Printf("cannot queue the %s event for %s: %v", record.Type, webhook.URL, err)
+		}
+
+		select {
+		case d.workers[webhook.URL].fresh <- delivery:
+		default:
+			// the delivery is attempted with the next retries
+			d.lock.Lock()
+			delete(d.inFlight, delivery.ID)
+			d.lock.Unlock()
+		}
+	}
+}
+
+// Run attempts the deliveries until the given context is done. Deliveries that wait for a retry are checked in the
+// given interval, including the deliveries that were left in the queue by an earlier run.
+func (d *Dispatcher) Run(ctx context.Context, interval time.Duration) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for _, w := range d.workers {
+		wg.Go(func() {
+			for {
+				select {
+				case delivery := <-w.fresh:
+					d.attemptFresh(ctx, delivery)
+				case deliveries := <-w.retries:
+					d.attemptRetries(ctx, deliveries)
+				case <-ctx.Done():
+					return
+				}
+			}
+		})
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	d.retry(ctx)
+	for {
+		select {
+		case <-ticker.C:
+			d.retry(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// retry hands the pending deliveries that are due to the workers of their webhooks. A worker that is still busy with
+// the retries of the last interval gets its retries with the next interval.
+func (d *Dispatcher) retry(ctx context.Context) {
+	deliveries, err := d.pendingDeliveries()
+	if err != nil {
+		log.Printf("cannot read the webhooks queue: %v", err)
+		return
+	}
+	d.compact(len(deliveries))
+
+	due := make(map[string][]Delivery)
+	for _, delivery := range deliveries {
+		d.lock.Lock()
+		inFlight := d.inFlight[delivery.ID]
+		d.lock.Unlock()
+		if inFlight || time.Now().Before(delivery.NextAttempt) {
+			continue
+		}
+		due[delivery.URL] = append(due[delivery.URL], delivery)
+	}
+	for url, deliveries := range due {
+		w, ok := d.workers[url]
+		if !ok {
+			// the webhook is no longer configured, the deliveries fail without a request
+			d.attemptRetries(ctx, deliveries)
+			continue
+		}
+		select {
+		case w.retries <- deliveries:
+		default:
+		}
+	}
+}
+
+// attemptFresh attempts a new delivery for the first time.
+func (d *Dispatcher) attemptFresh(ctx context.Context, delivery Delivery) {
+	d.attempt(ctx, delivery)
+	d.lock.Lock()
+	delete(d.inFlight, delivery.ID)
+	d.lock.Unlock()
+}
+
+// attemptRetries attempts the given deliveries to the same webhook, one after the other. If an attempt fails, the
+// webhook is probably not available, so the remaining deliveries wait for the next interval.
+func (d *Dispatcher) attemptRetries(ctx context.Context, deliveries []Delivery) {
+	for _, delivery := range deliveries {
+		if ctx.Err() != nil {
+			return
+		}
+		// the delivery may have been attempted since the queue was read
+		delivery, ok := d.current(delivery)
+		if !ok || delivery.State != Pending || time.Now().Before(delivery.NextAttempt) {
+			continue
+		}
+		if d.attempt(ctx, delivery).State == Pending {
+			return
+		}
+	}
+}
+
+// current returns the latest state of the given delivery.
+func (d *Dispatcher) current(delivery Delivery) (Delivery, bool) {
+	if d.queue != nil {
+		result, ok, err := d.queue.Get(delivery.ID)
+		if err != nil {
+			log.Printf("cannot read the webhooks queue: %v", err)
+			return Delivery{}, false
+		}
+		return result, ok
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	result, ok := d.pending[delivery.ID]
+	return result, ok
+}
+
+// attempt posts the given delivery to its webhook, saves the result and returns the updated delivery.
+func (d *Dispatcher) attempt(ctx context.Context, delivery Delivery) Delivery {
+	now := time.Now()
+	webhook, ok := d.webhook(delivery.URL)
+	switch {
+	case !ok:
+		delivery.State = Failed
+		delivery.LastError = "the webhook is no longer configured"
+	case now.After(delivery.Expires):
+		delivery.State = Expired
+	default:
+		delivery.Attempts++
+		retryable, err := d.post(ctx, webhook, delivery)
+		switch {
+		case err == nil:
+			delivery.State = Delivered
+			delivery.LastError = ""
+		case retryable:
+			delivery.LastError = err.Error()
+			delivery.NextAttempt = now.Add(d.retryDelay(delivery.Attempts))
+			if delivery.NextAttempt.After(delivery.Expires) {
+				delivery.State = Expired
+			}
+		default:
+			delivery.State = Failed
+			delivery.LastError = err.Error()
+		}
+	}
+
+	switch delivery.State {
+	case Pending:
+		log.Printf("cannot post the %s event to %s (attempt #%d), next attempt at %s: %s", delivery.Event, delivery.URL, delivery.Attempts, delivery.NextAttempt.Format(time.TimeOnly), delivery.LastError)
+	case Failed, Expired:
+		log.Printf("the %s event was not posted to %s, the delivery %s is %s: %s", delivery.Event, delivery.URL, delivery.ID, delivery.State, delivery.LastError)
+	}
+	err := d.save(delivery)
+	if err != nil {
+		log.Printf("cannot update the webhooks queue: %v", err)
+	}
+	return delivery
+}
+
+// post sends the delivery to the webhook. It returns if a failed request may succeed with another attempt.
+func (d *Dispatcher) post(ctx context.Context, webhook Webhook, delivery Delivery) (bool, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "tetra-cli")
+	request.Header.Set(EventHeader, string(delivery.Event))
+	request.Header.Set(DeliveryHeader, delivery.ID)
+	if webhook.Secret != "" {
+		request.Header.Set(SignatureHeader, Sign(webhook.Secret, delivery.Payload))
+	}
+	for name, value := range webhook.Headers {
+		request.Header.Set(name, value)
+	}
+
+	response, err := d.client.Do(request)
+	if err != nil {
+		return true, err
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(response.Body, 64*1024))
+
+	switch {
+	case response.StatusCode >= 200 && response.StatusCode < 300:
+		return false, nil
+	case response.StatusCode == http.StatusRequestTimeout, response.StatusCode == http.StatusTooManyRequests, response.StatusCode >= 500:
+		return true, fmt.Errorf("HTTP status %s", response.Status)
+	default:
+		return false, fmt.Errorf("HTTP status %s", response.Status)
+	}
+}
+
+// Sign returns the signature of the given payload for the signature header: "sha256=" followed by the hex encoded
+// HMAC-SHA256 of the payload with the given secret.
+func Sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// retryDelay returns the time to wait before the next attempt: the backoff is doubled with every attempt.
+func (d *Dispatcher) retryDelay(attempts int) time.Duration {
+	result := max(d.config.RetryBackoff, time.Second)
+	for i := 1; i < attempts; i++ {
+		result *= 2
+		if d.config.RetryMaxBackoff > 0 && result >= d.config.RetryMaxBackoff {
+			return d.config.RetryMaxBackoff
+		}
+	}
+	return result
+}
+
+func (d *Dispatcher) webhook(url string) (Webhook, bool) {
+	for _, webhook := range d.config.Webhooks {
+		if webhook.URL == url {
+			return webhook, true
+		}
+	}
+	return Webhook{}, false
+}
+
+func (d *Dispatcher) save(delivery Delivery) error {
+	delivery.Updated = time.Now()
+	if d.queue != nil {
+		return d.queue.Append(delivery)
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if delivery.State == Pending {
+		d.pending[delivery.ID] = delivery
+	} else {
+		delete(d.pending, delivery.ID)
+	}
+	return nil
+}
+
+func (d *Dispatcher) pendingDeliveries() ([]Delivery, error) {
+	var deliveries []Delivery
+	if d.queue != nil {
+		var err error
+		deliveries, err = d.queue.Load()
+		if err != nil {
+			return nil, err
+		}
+		d.queued = len(deliveries)
+	} else {
+		d.lock.Lock()
+		for _, delivery := range d.pending {
+			deliveries = append(deliveries, delivery)
+		}
+		d.lock.Unlock()
+		slices.SortFunc(deliveries, func(a, b Delivery) int { return a.Created.Compare(b.Created) })
+	}
+
+	result := deliveries[:0]
+	for _, delivery := range deliveries {
+		if delivery.State == Pending {
+			result = append(result, delivery)
+		}
+	}
+	return result, nil
+}
+
+// compact removes the delivered, failed and expired deliveries from the queue file, once there are more of them
+// than the given number of pending deliveries and at least compactThreshold.
+func (d *Dispatcher) compact(pending int) {
+	if d.queue == nil {
+		return
+	}
+	finished := d.queued - pending
+	if finished < compactThreshold || finished <= pending {
+		return
+	}
+	err := d.queue.Compact(func(delivery Delivery) bool { return delivery.State == Pending })
+	if err != nil {
+		log.Printf("cannot compact the webhooks queue: %v", err)
+	}
+}
@@ -0,0 +1,318 @@
+package webhooks
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ftl/tetra-cli/pkg/events"
+)
+
+// testWebhook records the requests and answers with the given status codes, one after the other. After the last
+// status code, the requests are accepted.
+type testWebhook struct {
+	*httptest.Server
+
+	lock     sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func newTestWebhook(t *testing.T, statuses ...int) *testWebhook {
+	t.Helper()
+	result := &testWebhook{statuses: statuses}
+	result.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		result.lock.Lock()
+		defer result.lock.Unlock()
+		result.requests = append(result.requests, r)
+		result.bodies = append(result.bodies, body)
+		status := http.StatusNoContent
+		if len(result.statuses) > 0 {
+			status = result.statuses[0]
+			result.statuses = result.statuses[1:]
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(result.Close)
+	return result
+}
+
+func (w *testWebhook) received() int {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return len(w.requests)
+}
+
+func testConfig(webhooks ...Webhook) Config {
+	return Config{
+		Webhooks:        webhooks,
+		Timeout:         time.Second,
+		RetryBackoff:    time.Second,
+		RetryMaxBackoff: time.Second,
+		RetryMaxAge:     time.Hour,
+	}
+}
+
+func statusRecord() events.Record {
+	return events.NewRecord(events.StatusMessage{Time: time.Now(), Source: "1234567", Status: 0x8005})
+}
+
+// deliverFresh attempts the fresh deliveries like the workers of Run.
+func deliverFresh(t *testing.T, dispatcher *Dispatcher) {
+	t.Helper()
+	for _, w := range dispatcher.workers {
+		for len(w.fresh) > 0 {
+			dispatcher.attemptFresh(context.Background(), <-w.fresh)
+		}
+	}
+}
+
+// retryNow attempts the pending deliveries that are due like Run.
+func retryNow(t *testing.T, dispatcher *Dispatcher) {
+	t.Helper()
+	dispatcher.retry(context.Background())
+	for _, w := range dispatcher.workers {
+		for len(w.retries) > 0 {
+			dispatcher.attemptRetries(context.Background(), <-w.retries)
+		}
+	}
+}
+
+func TestDispatcher_SignsTheRequests(t *testing.T) {
+	webhook := newTestWebhook(t)
+	dispatcher, err := NewDispatcher(testConfig(Webhook{URL: webhook.URL, Secret: "s3cr3t", Headers: map[string]string{"Authorization": "Bearer token"}}), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dispatcher.Handle(statusRecord())
+	deliverFresh(t, dispatcher)
+
+	if webhook.received() != 1 {
+		t.Fatalf("expected 1 request, got %d", webhook.received())
+	}
+	request, body := webhook.requests[0], webhook.bodies[0]
+	if got := request.Header.Get(SignatureHeader); got != Sign("s3cr3t", body) {
+		t.Errorf("invalid signature %q", got)
+	}
+	if got := request.Header.Get(EventHeader); got != "status" {
+		t.Errorf("unexpected event header %q", got)
+	}
+	if request.Header.Get(DeliveryHeader) == "" {
+		t.Error("the delivery header is missing")
+	}
+	if got := request.Header.Get("Authorization"); got != "Bearer token" {
+		t.Errorf("the additional header is missing, got %q", got)
+	}
+}
+
+func TestSign(t *testing.T) {
+	// RFC 4231, test case 2
+	expected := "sha256=5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843"
+	if got := Sign("Jefe", []byte("what do ya want for nothing?")); got != expected {
+		t.Errorf("unexpected signature %q", got)
+	}
+}
+
+func TestDispatcher_FiltersTheEvents(t *testing.T) {
+	statuses := newTestWebhook(t)
+	messages := newTestWebhook(t)
+	dispatcher, err := NewDispatcher(testConfig(
+		Webhook{URL: statuses.URL, Events: []events.Kind{events.StatusMessageKind}},
+		Webhook{URL: messages.URL, Events: []events.Kind{events.TextMessageKind}},
+	), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dispatcher.Handle(statusRecord())
+	deliverFresh(t, dispatcher)
+
+	if statuses.received() != 1 || messages.received() != 0 {
+		t.Errorf("the status must only be posted to the status webhook, got %d and %d requests", statuses.received(), messages.received())
+	}
+}
+
+func TestDispatcher_RetriesTemporaryFailures(t *testing.T) {
+	webhook := newTestWebhook(t, http.StatusServiceUnavailable)
+	queuePath := filepath.Join(t.TempDir(), "webhooks.jsonl")
+	dispatcher, err := NewDispatcher(testConfig(Webhook{URL: webhook.URL}), queuePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dispatcher.Handle(statusRecord())
+	deliverFresh(t, dispatcher)
+	pending, _ := dispatcher.pendingDeliveries()
+	if len(pending) != 1 || pending[0].Attempts != 1 {
+		t.Fatalf("the delivery should wait for a retry, got %+v", pending)
+	}
+
+	// a new dispatcher continues with the queue of the previous run
+	dispatcher, err = NewDispatcher(testConfig(Webhook{URL: webhook.URL}), queuePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	retryNow(t, dispatcher)
+	if webhook.received() != 1 {
+		t.Errorf("the retry must wait for the backoff, got %d requests", webhook.received())
+	}
+	time.Sleep(time.Second)
+	retryNow(t, dispatcher)
+
+	if webhook.received() != 2 {
+		t.Errorf("expected 2 requests, got %d", webhook.received())
+	}
+	pending, _ = dispatcher.pendingDeliveries()
+	if len(pending) != 0 {
+		t.Errorf("the delivery should be delivered, got %+v", pending)
+	}
+}
+
+func TestDispatcher_FailsOnPermanentErrors(t *testing.T) {
+	webhook := newTestWebhook(t, http.StatusUnauthorized)
+	dispatcher, err := NewDispatcher(testConfig(Webhook{URL: webhook.URL}), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dispatcher.Handle(statusRecord())
+	deliverFresh(t, dispatcher)
+
+	pending, _ := dispatcher.pendingDeliveries()
+	if webhook.received() != 1 || len(pending) != 0 {
+		t.Errorf("the delivery should fail without retry, got %d requests and %+v", webhook.received(), pending)
+	}
+}
+
+func TestDispatcher_ExpiresDeliveries(t *testing.T) {
+	webhook := newTestWebhook(t, http.StatusServiceUnavailable, http.StatusServiceUnavailable)
+	config := testConfig(Webhook{URL: webhook.URL})
+	config.RetryMaxAge = 1500 * time.Millisecond
+	dispatcher, err := NewDispatcher(config, filepath.Join(t.TempDir(), "webhooks.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dispatcher.Handle(statusRecord())
+	deliverFresh(t, dispatcher)
+	time.Sleep(time.Second)
+	retryNow(t, dispatcher)
+
+	if webhook.received() != 2 {
+		t.Errorf("expected 2 requests, got %d", webhook.received())
+	}
+	deliveries, _ := dispatcher.queue.Load()
+	if len(deliveries) != 1 || deliveries[0].State != Expired {
+		t.Errorf("the delivery should be expired, got %+v", deliveries)
+	}
+}
+
+func TestDispatcher_RetryDoesNotAttemptFreshDeliveries(t *testing.T) {
+	webhook := newTestWebhook(t)
+	dispatcher, err := NewDispatcher(testConfig(Webhook{URL: webhook.URL}), filepath.Join(t.TempDir(), "webhooks.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dispatcher.Handle(statusRecord())
+	retryNow(t, dispatcher)
+	if webhook.received() != 0 {
+		t.Errorf("the retry must not attempt the fresh delivery, got %d requests", webhook.received())
+	}
+	deliverFresh(t, dispatcher)
+	if webhook.received() != 1 {
+		t.Errorf("expected 1 request, got %d", webhook.received())
+	}
+}
+
+func TestDispatcher_CompactsTheQueue(t *testing.T) {
+	webhook := newTestWebhook(t)
+	dispatcher, err := NewDispatcher(testConfig(Webhook{URL: webhook.URL}), filepath.Join(t.TempDir(), "webhooks.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range compactThreshold {
+		dispatcher.Handle(statusRecord())
+		deliverFresh(t, dispatcher)
+	}
+	dispatcher.save(Delivery{ID: "pending", URL: webhook.URL, State: Pending, NextAttempt: time.Now().Add(time.Hour), Expires: time.Now().Add(time.Hour)})
+
+	retryNow(t, dispatcher)
+
+	deliveries, _ := dispatcher.queue.Load()
+	if len(deliveries) != 1 || deliveries[0].ID != "pending" {
+		t.Errorf("only the pending delivery should be kept, got %d deliveries", len(deliveries))
+	}
+}
+
+func TestDispatcher_SlowWebhooksDoNotDelayTheOthers(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	t.Cleanup(slow.Close)
+	t.Cleanup(func() { close(release) })
+	fast := newTestWebhook(t)
+	config := testConfig(Webhook{URL: slow.URL}, Webhook{URL: fast.URL})
+	config.Timeout = 5 * time.Second
+	dispatcher, err := NewDispatcher(config, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		dispatcher.Run(ctx, time.Hour)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	dispatcher.Handle(statusRecord())
+	dispatcher.Handle(statusRecord())
+
+	deadline := time.Now().Add(time.Second)
+	for fast.received() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("the slow webhook delays the other webhook, got %d requests", fast.received())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDispatcher_RetrySkipsTheWebhookAfterAFailure(t *testing.T) {
+	failing := newTestWebhook(t, http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable)
+	working := newTestWebhook(t)
+	dispatcher, err := NewDispatcher(testConfig(Webhook{URL: failing.URL}, Webhook{URL: working.URL}), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for i, url := range []string{failing.URL, failing.URL, failing.URL, working.URL, working.URL} {
+		dispatcher.save(Delivery{ID: fmt.Sprintf("%d", i), Created: now, URL: url, Event: events.StatusMessageKind, State: Pending, NextAttempt: now, Expires: now.Add(time.Hour)})
+	}
+
+	retryNow(t, dispatcher)
+
+	if failing.received() != 1 {
+		t.Errorf("the retries must stop after the first failure, got %d requests", failing.received())
+	}
+	if working.received() != 2 {
+		t.Errorf("expected 2 requests to the working webhook, got %d", working.received())
+	}
+	pending, _ := dispatcher.pendingDeliveries()
+	if len(pending) != 3 {
+		t.Errorf("the deliveries to the failing webhook should wait, got %d pending deliveries", len(pending))
+	}
+}